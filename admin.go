@@ -0,0 +1,438 @@
+package putter
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// adminPath is where NewHandler serves the admin dashboard.
+const adminPath = "/admin/"
+
+// maxRecentErrors is the number of failed saves shown on the admin dashboard.
+const maxRecentErrors = 10
+
+// activityError describes a failed save.
+type activityError struct {
+	Time    time.Time
+	Message string
+}
+
+// activity records recent saves and failures, fed by the server's events.
+type activity struct {
+	mu         sync.Mutex      // protects the following
+	lastSave   time.Time       // when the wiki was last saved
+	lastClient string          // who saved it
+	errors     []activityError // most recent failures, oldest first
+	panics     int             // requests whose handling panicked
+}
+
+// record updates the activity from an event, made by client if it was a
+// request.
+func (a *activity) record(e Event, client string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch e.Type {
+	case EventSaveCompleted:
+		a.lastSave = e.Time
+		switch {
+		case e.Request != nil:
+			a.lastClient = client
+		case e.Archive != "":
+			a.lastClient = "restore of " + e.Archive
+		default:
+			a.lastClient = ""
+		}
+	case EventSaveFailed:
+		message := http.StatusText(e.Status)
+		if e.Request != nil {
+			message = "save from " + client + " failed: " + message
+		}
+		a.addError(e.Time, message)
+	case EventVerificationFailed:
+		a.addError(e.Time, "saved wiki failed verification and was rolled back, check the storage")
+	}
+}
+
+// addError records a failure, forgetting the oldest past maxRecentErrors. The
+// caller must hold the lock.
+func (a *activity) addError(t time.Time, message string) {
+	a.errors = append(a.errors, activityError{t, message})
+	if len(a.errors) > maxRecentErrors {
+		a.errors = a.errors[len(a.errors)-maxRecentErrors:]
+	}
+}
+
+// SetMaintenance turns maintenance mode on or off. While it is on, saves are
+// refused with 503 Service Unavailable but the wiki can still be read.
+func (s *Server) SetMaintenance(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case on && !s.isMaintenance:
+		log.Println("entering maintenance mode, saves will be refused")
+	case !on && s.isMaintenance:
+		log.Println("leaving maintenance mode")
+	}
+	s.isMaintenance = on
+}
+
+// AdminHandler returns a handler serving the admin dashboard, protected by
+// the credentials given to WithAdmin. It must be mounted at a path ending in
+// "/", with that path stripped from requests.
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleAdmin)
+	mux.HandleFunc("/restore", s.handleAdminRestore)
+	mux.HandleFunc("/prune", s.handleAdminPrune)
+	mux.HandleFunc("/maintenance", s.handleAdminMaintenance)
+	mux.HandleFunc("/diff", s.handleAdminDiff)
+	mux.HandleFunc("/diff.json", s.handleAdminDiffJSON)
+	mux.HandleFunc("/logs", s.handleAdminLogs)
+	mux.HandleFunc("/held", s.handleAdminHeld)
+	mux.HandleFunc("/upgrade", s.handleAdminUpgrade)
+	mux.HandleFunc("/trash", s.handleAdminTrash)
+	mux.HandleFunc("/trash.json", s.handleAdminTrashJSON)
+
+	return s.requireAdmin(mux)
+}
+
+// requireAdmin decorates an http.Handler to require the admin credentials and,
+// for anything other than GET, a same-origin request.
+func (s *Server) requireAdmin(h http.Handler) http.Handler {
+	return requireCredentials(s.adminUser, s.adminPassword, h)
+}
+
+// requireCredentials decorates an http.Handler to require the given user name
+// and password, which must not be empty, and, for anything other than GET, a
+// same-origin request.
+func requireCredentials(adminUser, adminPassword string, h http.Handler) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		isUser := subtle.ConstantTimeCompare([]byte(user), []byte(adminUser)) == 1
+		isPassword := subtle.ConstantTimeCompare([]byte(password), []byte(adminPassword)) == 1
+		if !ok || !isUser || !isPassword || adminPassword == "" {
+			w.Header().Set(headerWwwAuthenticate, `Basic realm="putter admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !isSameOrigin(r) {
+			server.Logf(r.Context(), "refusing cross-origin admin request from %s", r.Header.Get(headerOrigin))
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(server.ContextWithUser(r.Context(), user)))
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// isSameOrigin reports whether the request's Origin, if any, matches its Host,
+// so that other sites can't submit the dashboard's forms.
+func isSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get(headerOrigin)
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+
+	return err == nil && u.Host == r.Host
+}
+
+// adminData is passed to adminTemplate.
+type adminData struct {
+	FileName      string
+	Size          ByteSize
+	ModTime       time.Time
+	ETag          string
+	LastSave      time.Time
+	LastClient    string
+	IsArchive     bool
+	ArchiveCount  int
+	ArchiveSize   ByteSize
+	ArchiveErr    string
+	Quota         *QuotaStatus
+	ReadOnlyErr   error
+	ReadOnlySince time.Time
+	IsMaintenance bool
+	IsTrash       bool
+	Held          *HeldSave
+	Lock          *LockStatus
+	Mirror        *MirrorStatus
+	Sync          *SyncStatus
+	Errors        []activityError
+	IsLogs        bool
+	Logs          []string      // recent log lines, oldest first
+	Charts        []statsChart  // charts of statistics, if kept
+	Clients       []statsClient // clients' usage, most recently seen first
+}
+
+// handleAdmin serves the admin dashboard.
+func (s *Server) handleAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	data := adminData{
+		FileName:      s.fileName,
+		Size:          ByteSize(s.fileInfo.Size()),
+		ModTime:       s.fileInfo.ModTime(),
+		ETag:          s.etag,
+		IsArchive:     s.isArchive,
+		ReadOnlyErr:   s.readOnlyErr,
+		ReadOnlySince: s.readOnlySince,
+		IsMaintenance: s.isMaintenance,
+		IsTrash:       s.trash != nil,
+	}
+	if s.held != nil {
+		held := *s.held
+		data.Held = &held
+	}
+	if lock := s.activeLock(); lock != nil {
+		lockStatus := lock.LockStatus
+		data.Lock = &lockStatus
+	}
+	if s.mirror != nil {
+		mirrorStatus := s.mirror.status
+		data.Mirror = &mirrorStatus
+	}
+	s.mu.RUnlock()
+	data.Sync = s.SyncStatus()
+	if s.logs != nil {
+		data.IsLogs = true
+		data.Logs = s.logs.Lines()
+	}
+	if s.isStats {
+		stats := s.Stats()
+		data.Charts = statsCharts(stats, time.Now())
+		data.Clients = statsClients(stats)
+	}
+
+	s.activity.mu.Lock()
+	data.LastSave = s.activity.lastSave
+	data.LastClient = s.activity.lastClient
+	for i := len(s.activity.errors) - 1; i >= 0; i-- {
+		data.Errors = append(data.Errors, s.activity.errors[i])
+	}
+	s.activity.mu.Unlock()
+
+	status := s.Status()
+	if archive := status.Archive; archive != nil {
+		data.ArchiveCount = archive.Count
+		data.ArchiveSize = ByteSize(archive.Size)
+		data.ArchiveErr = archive.Error
+	}
+	data.Quota = status.Quota
+
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err := adminTemplate.Execute(w, data)
+	if err != nil {
+		log.Printf("failed to render admin dashboard: %v", err)
+	}
+}
+
+// handleAdminPrune removes all but the newest archives, keeping the number
+// given in the "keep" form value.
+func (s *Server) handleAdminPrune(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	keep, err := strconv.Atoi(r.FormValue("keep"))
+	if err != nil || keep < 0 {
+		http.Error(w, "invalid number of archives to keep", http.StatusBadRequest)
+		return
+	}
+	err = s.pruneArchive(keep)
+	if err != nil {
+		server.Logf(r.Context(), "failed to prune archive: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectToAdmin(w)
+}
+
+// handleAdminMaintenance turns maintenance mode on or off according to the
+// "on" form value.
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	on, err := strconv.ParseBool(r.FormValue("on"))
+	if err != nil {
+		http.Error(w, "invalid maintenance mode", http.StatusBadRequest)
+		return
+	}
+	s.SetMaintenance(on)
+	redirectToAdmin(w)
+}
+
+// handleAdminHeld approves or discards the save held for approval, according
+// to the "action" form value.
+func (s *Server) handleAdminHeld(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch r.FormValue("action") {
+	case "approve":
+		err = s.ApproveHeld(r.Context())
+	case "discard":
+		err = s.DiscardHeld()
+	default:
+		http.Error(w, "invalid action for held save", http.StatusBadRequest)
+		return
+	}
+	if err == ErrNoHeldSave {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err == ErrHeldConflict {
+		http.Error(w, err.Error()+", discard the held save or save it again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		server.Logf(r.Context(), "failed to %s held save: %v", r.FormValue("action"), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	redirectToAdmin(w)
+}
+
+// redirectToAdmin sends the browser back to the dashboard after an action.
+// The location is relative because the dashboard's mount point isn't known.
+func redirectToAdmin(w http.ResponseWriter) {
+	w.Header().Set(headerLocation, "./")
+	w.WriteHeader(http.StatusSeeOther)
+}
+
+var adminTemplate = template.Must(template.New("admin").Funcs(template.FuncMap{
+	"subtract": func(a, b float64) float64 { return a - b },
+	"byteSize": func(size int64) ByteSize { return ByteSize(size) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+th { text-align: left; padding-right: 1em; }
+.warning { color: #a00; }
+form { display: inline; }
+.chart { width: 100%; height: 6em; background: #f4f4f4; }
+.chart rect { fill: #48c; }
+.chart rect.alert { fill: #c44; }
+#log { background: #f4f4f4; padding: 0.5em; max-height: 30em; overflow: auto; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<h1>putter admin</h1>
+{{if .IsMaintenance}}<p class="warning">Maintenance mode is on. Saves are refused.</p>{{end}}
+{{with .Held}}<p class="warning">A save{{with .Client}} from {{.}}{{end}} at {{.Time.Format "2006-01-02 15:04:05"}} was held for approval because it is only {{byteSize .Size}}{{if .PreviousSize}}, down from {{byteSize .PreviousSize}}{{end}}.
+<form method="post" action="held"><input type="hidden" name="action" value="approve"><button onclick="return confirm('Replace the live wiki with the held save?')">Approve</button></form>
+<form method="post" action="held"><input type="hidden" name="action" value="discard"><button>Discard</button></form></p>{{end}}
+{{with .Mirror}}<p{{if .Error}} class="warning"{{end}}>This wiki is a read-only mirror of {{.Upstream}}{{if not .LastCheck.IsZero}}, last checked at {{.LastCheck.Format "2006-01-02 15:04:05"}}{{end}}{{with .Error}}, which failed: {{.}}{{end}}.</p>{{end}}
+{{with .Sync}}<p{{if or .Error (eq .State "diverged")}} class="warning"{{end}}>Syncing with {{.Peer}}: {{if .LastSync.IsZero}}not yet synced{{else}}{{with .State}}{{.}}{{else}}failed{{end}} as of {{.LastSync.Format "2006-01-02 15:04:05"}}{{end}}{{with .Error}}, the last sync failed: {{.}}{{end}}.{{if eq .State "diverged"}} Both wikis have been saved since they were last in sync; the peer's live wiki has been archived, so compare it with the live wiki and save or restore a version to resolve it.{{end}}</p>{{end}}
+{{with .Lock}}<p class="warning">The wiki is being edited{{with .Owner}} by {{.}}{{end}} from {{.Client}}, locked since {{.Since.Format "2006-01-02 15:04:05"}} until {{.Expires.Format "2006-01-02 15:04:05"}} unless renewed.</p>{{end}}
+{{if .ReadOnlyErr}}<p class="warning">Read-only since {{.ReadOnlySince.Format "2006-01-02 15:04:05"}}: {{.ReadOnlyErr}}</p>{{end}}
+<h2>Wiki</h2>
+<table>
+<tr><th>File</th><td>{{.FileName}}</td></tr>
+<tr><th>Size</th><td>{{.Size}}</td></tr>
+<tr><th>Modified</th><td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>ETag</th><td><code>{{.ETag}}</code></td></tr>
+{{with .Quota}}<tr><th>Storage</th><td{{if .IsNearlyFull}} class="warning"{{end}}>{{byteSize .Used}} of its {{byteSize .Limit}} quota ({{printf "%.0f" .Percent}}%), including the archive</td></tr>
+{{end}}<tr><th>Last save</th><td>{{if .LastSave.IsZero}}none since startup{{else}}{{.LastSave.Format "2006-01-02 15:04:05"}}{{with .LastClient}} by {{.}}{{end}}{{end}}</td></tr>
+</table>
+<h2>Archive</h2>
+{{if .IsArchive}}
+{{if .ArchiveErr}}<p class="warning">{{.ArchiveErr}}</p>{{end}}
+<table>
+<tr><th>Versions</th><td>{{.ArchiveCount}}</td></tr>
+<tr><th>Disk usage</th><td>{{.ArchiveSize}}</td></tr>
+</table>
+<p><a href="diff">Compare versions</a></p>
+{{else}}
+<p>Archiving is disabled.</p>
+{{end}}
+<h2>Recent errors</h2>
+{{if .Errors}}
+<table>
+{{range .Errors}}<tr><th>{{.Time.Format "2006-01-02 15:04:05"}}</th><td>{{.Message}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>None since startup.</p>
+{{end}}
+{{if .Charts}}
+<h2>Statistics</h2>
+{{range .Charts}}
+<h3>{{.Title}}</h3>
+<svg class="chart" viewBox="0 0 {{len .Bars}} 100" preserveAspectRatio="none">
+{{range $i, $bar := .Bars}}<g><title>{{.Label}}</title><rect x="{{$i}}" y="{{printf "%.2f" (subtract 100 .Height)}}" width="0.8" height="{{printf "%.2f" .Height}}"/>{{if .Alert}}<rect class="alert" x="{{$i}}" y="{{printf "%.2f" (subtract 100 .Alert)}}" width="0.8" height="{{printf "%.2f" .Alert}}"/>{{end}}</g>
+{{end}}</svg>
+{{end}}
+{{with .Clients}}
+<h3>Clients</h3>
+<table>
+<tr><th>Client</th><th>Saves</th><th>Conflicts</th><th>Failures</th><th>Uploaded</th><th>Downloaded</th><th>Last seen</th></tr>
+{{range .}}<tr><td>{{.Name}}</td><td>{{.Saves}}</td><td>{{.Conflicts}}</td><td>{{.Failures}}</td><td>{{byteSize .Uploaded}}</td><td>{{byteSize .Downloaded}}</td><td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+{{end}}
+<p><a href="../stats">Download as JSON</a></p>
+{{end}}
+{{if .IsLogs}}
+<h2>Log</h2>
+<pre id="log">{{range .Logs}}{{.}}
+{{end}}</pre>
+<script>
+(function() {
+	var log = document.getElementById("log");
+	var source = new EventSource("logs");
+	// The stream starts with the recent lines, including after reconnecting
+	source.onopen = function() { log.textContent = ""; };
+	source.onmessage = function(e) {
+		var atBottom = log.scrollTop + log.clientHeight >= log.scrollHeight - 1;
+		log.textContent += e.data + "\n";
+		if (atBottom) {
+			log.scrollTop = log.scrollHeight;
+		}
+	};
+})();
+</script>
+{{end}}
+<h2>Actions</h2>
+<p>
+{{if .IsArchive}}
+<a href="restore">Restore latest</a>
+<form method="post" action="prune" onsubmit="return confirm('Delete all but the newest archived versions?')">
+<button>Prune</button> keeping <input name="keep" type="number" min="0" value="10" size="4"> newest
+</form>
+<a href="upgrade">Upgrade TiddlyWiki</a>
+{{end}}
+{{if .IsTrash}}<a href="trash">Trash</a>{{end}}
+<a href="../upload">Upload a copy</a>
+<form method="post" action="maintenance">
+<input type="hidden" name="on" value="{{not .IsMaintenance}}">
+<button>{{if .IsMaintenance}}Leave{{else}}Enter{{end}} maintenance mode</button>
+</form>
+</p>
+</body>
+</html>
+`))
@@ -0,0 +1,618 @@
+package putter_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/puttertest"
+)
+
+const (
+	testAdminUser     = "admin"
+	testAdminPassword = "secret"
+)
+
+// adminHeader returns headers authenticating as the admin, plus any others.
+func adminHeader(keyValues ...string) http.Header {
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(testAdminUser, testAdminPassword)
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		req.Header.Set(keyValues[i], keyValues[i+1])
+	}
+
+	return req.Header
+}
+
+// adminPost submits a dashboard form, expecting to be sent back to it.
+func (f *fixture) adminPost(action, form string) {
+	f.t.Helper()
+	header := adminHeader("Content-Type", "application/x-www-form-urlencoded")
+	res, body := f.do(http.MethodPost, "/admin/"+action, form, header)
+	if res.StatusCode != http.StatusSeeOther {
+		f.t.Fatalf("POST %s status = %d, want %d: %s", action, res.StatusCode, http.StatusSeeOther, body)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword))
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/admin/", "", nil)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	if res.Header.Get("WWW-Authenticate") == "" {
+		t.Error("anonymous response has no WWW-Authenticate header")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth(testAdminUser, "wrong")
+	res, _ = f.do(http.MethodGet, "/admin/", "", req.Header)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	res, body := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if etag := f.etag(); !strings.Contains(body, strings.Trim(etag, `"`)) {
+		t.Errorf("dashboard doesn't show ETag %s", etag)
+	}
+
+	res, _ = f.do(http.MethodPost, "/admin/maintenance", "on=true", adminHeader(
+		"Content-Type", "application/x-www-form-urlencoded",
+		"Origin", "http://example.com",
+	))
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("cross-origin status = %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAdminMaintenance(t *testing.T) {
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword))
+	defer f.close()
+
+	f.adminPost("maintenance", "on=true")
+	f.put(testUpdated, nil, http.StatusServiceUnavailable)
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want %q", content, testContent)
+	}
+
+	f.adminPost("maintenance", "on=false")
+	f.put(testUpdated, nil, http.StatusOK)
+}
+
+func TestAdminRestore(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	f.adminPost("restore", "")
+
+	_, body := f.do(http.MethodGet, "/", "", nil)
+	if body != testContent {
+		t.Errorf("body = %q, want %q", body, testContent)
+	}
+	// The version replaced by the restore is archived too
+	if archives := wiki.List("old"); len(archives) != 2 {
+		t.Errorf("archived %d versions, want 2", len(archives))
+	}
+}
+
+// testStore returns a TiddlyWiki 5.2 or later wiki storing tiddlers, which
+// are JSON objects.
+func testStore(tiddlers ...string) string {
+	return `<html><body><script class="tiddlywiki-tiddler-store" type="application/json">[` +
+		strings.Join(tiddlers, ",") + `]</script><div id="storeArea"></div></body></html>`
+}
+
+func TestAdminUpgrade(t *testing.T) {
+	release := testStore(
+		`{"title":"$:/core","plugin-type":"plugin","version":"5.3.0","text":"new core"}`,
+		`{"title":"$:/plugins/tiddlywiki/markdown","plugin-type":"plugin","version":"5.3.0","text":"new markdown"}`,
+	)
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, release)
+	}))
+	defer source.Close()
+	live := testStore(
+		`{"title":"$:/core","plugin-type":"plugin","version":"5.2.0","text":"old core"}`,
+		`{"title":"$:/plugins/tiddlywiki/markdown","plugin-type":"plugin","version":"5.2.0","text":"old markdown"}`,
+		`{"title":"$:/plugins/someone/custom","plugin-type":"plugin","version":"1.0.0","text":"custom"}`,
+		`{"title":"Note","text":"\u003c/script\u003e mine"}`,
+		`{"title":"$:/StoryList","list":"Note"}`,
+	)
+	wiki := puttertest.NewTempWiki(t, live)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+		putter.WithUpgradeSource(source.URL),
+	)
+	defer f.close()
+
+	_, body := f.do(http.MethodGet, "/admin/upgrade", "", adminHeader())
+	for _, want := range []string{"from 5.2.0 to 5.3.0", "$:/plugins/tiddlywiki/markdown", "2 tiddlers will be carried over"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("confirmation doesn't say %q: %s", want, body)
+		}
+	}
+	id := regexp.MustCompile(`name="id" value="([^"]+)"`).FindStringSubmatch(body)
+	if id == nil {
+		t.Fatal("confirmation has no upgrade to confirm")
+	}
+	f.adminPost("upgrade", "id="+id[1])
+
+	upgraded := wiki.Read()
+	for _, want := range []string{"new core", "new markdown", "custom", `\u003c/script\u003e mine`} {
+		if !strings.Contains(upgraded, want) {
+			t.Errorf("upgraded wiki lacks %q: %s", want, upgraded)
+		}
+	}
+	for _, unwanted := range []string{"old core", "old markdown", "$:/StoryList"} {
+		if strings.Contains(upgraded, unwanted) {
+			t.Errorf("upgraded wiki has %q: %s", unwanted, upgraded)
+		}
+	}
+	if archives := wiki.List("old"); len(archives) != 1 {
+		t.Errorf("archived %d versions, want the one before the upgrade", len(archives))
+	}
+
+	res, _ := f.do(http.MethodPost, "/admin/upgrade", "id="+id[1], adminHeader("Content-Type", "application/x-www-form-urlencoded"))
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("confirming again: status = %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+	_, body = f.do(http.MethodGet, "/admin/upgrade", "", adminHeader())
+	if !strings.Contains(body, "up to date") {
+		t.Errorf("upgraded wiki isn't up to date: %s", body)
+	}
+}
+
+func TestAdminHeld(t *testing.T) {
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword), putter.WithShrinkLimit(50))
+	defer f.close()
+
+	f.put("", nil, http.StatusConflict)
+	_, body := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	if !strings.Contains(body, "was held for approval") {
+		t.Error("dashboard doesn't show the held save")
+	}
+	f.adminPost("held", "action=approve")
+	_, body = f.do(http.MethodGet, "/", "", nil)
+	if body != "" {
+		t.Errorf("body = %q after approving a blank save", body)
+	}
+	if f.server.Held() != nil {
+		t.Error("save still held after approval")
+	}
+
+	f.put(testContent, nil, http.StatusOK)
+	f.put("<p></p>", nil, http.StatusConflict)
+	f.adminPost("held", "action=discard")
+	_, body = f.do(http.MethodGet, "/", "", nil)
+	if body != testContent || f.server.Held() != nil {
+		t.Errorf("body = %q, held = %+v after discarding", body, f.server.Held())
+	}
+	res, _ := f.do(http.MethodPost, "/admin/held", "action=discard", adminHeader("Content-Type", "application/x-www-form-urlencoded"))
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("discarding nothing: status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAdminPrune(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	for i := 0; i < 3; i++ {
+		f.put(testUpdated, nil, http.StatusOK)
+	}
+	events, unsubscribe := f.server.Events(10)
+	defer unsubscribe()
+
+	f.adminPost("prune", "keep=1")
+	if archives := wiki.List("old"); len(archives) != 1 {
+		t.Errorf("kept %d versions, want 1", len(archives))
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-events; e.Type != putter.EventArchivePruned {
+			t.Errorf("event %d = %v, want %v", i, e.Type, putter.EventArchivePruned)
+		}
+	}
+}
+
+func TestAdminTrash(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+		putter.WithTrash(wiki.Path("trash"), 0),
+	)
+	defer f.close()
+
+	oldEtag := f.etag()
+	f.put(testUpdated, nil, http.StatusOK)
+	f.put(testUpdated+"!", nil, http.StatusOK)
+	f.put("conflicting", http.Header{"If-Match": {oldEtag}}, http.StatusPreconditionFailed)
+	f.adminPost("prune", "keep=1")
+
+	items, err := f.server.Trash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Reason != "pruned" || items[1].Reason != "rejected" {
+		t.Fatalf("trash = %+v, want a pruned archive and a rejected upload", items)
+	}
+	res, body := f.do(http.MethodGet, "/admin/trash", "", adminHeader())
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "Recover to archive") {
+		t.Errorf("trash page = %d %q", res.StatusCode, body)
+	}
+
+	f.adminPost("trash", "name="+url.QueryEscape(items[0].Name))
+	f.adminPost("trash", "name="+url.QueryEscape(items[1].Name))
+	if archives := wiki.List("old"); len(archives) != 3 {
+		t.Errorf("archive after recovery = %v, want 3 versions", archives)
+	}
+	contents := make(map[string]bool)
+	for _, archive := range wiki.List("old") {
+		data, err := ioutil.ReadFile(wiki.Path("old", archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[string(data)] = true
+	}
+	if !contents[testContent] || !contents["conflicting"] {
+		t.Errorf("recovered versions = %v", contents)
+	}
+	res, body = f.do(http.MethodGet, "/admin/trash.json", "", adminHeader())
+	if res.StatusCode != http.StatusOK || strings.TrimSpace(body) != "[]" {
+		t.Errorf("trash after recovery = %d %q", res.StatusCode, body)
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	// Too little to load the wiki whole
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveCompression("gzip"),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+		putter.WithMaxMemory(40),
+	)
+	defer f.close()
+
+	originalEtag := f.etag()
+	f.put(testUpdated, nil, http.StatusOK)
+	archives, err := f.server.Archives()
+	if err != nil || len(archives) != 1 {
+		t.Fatalf("Archives() = %v, %v", archives, err)
+	}
+
+	// Versions are streamed, whether live or decompressed from the archive
+	for etag, content := range map[string]string{originalEtag: testContent, f.etag(): testUpdated} {
+		res, body := f.do(http.MethodGet, "/versions/"+strings.Trim(etag, `"`), "", nil)
+		if res.StatusCode != http.StatusOK || body != content {
+			t.Errorf("GET version %s = %d %q, want %q", etag, res.StatusCode, body, content)
+		}
+	}
+	res, body := f.do(http.MethodGet, "/admin/diff.json?from="+archives[0].Name, "", adminHeader())
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("diff status = %d %q, want %d", res.StatusCode, body, http.StatusForbidden)
+	}
+}
+
+func TestAdminDiff(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives, err := f.server.Archives()
+	if err != nil || len(archives) != 1 {
+		t.Fatalf("Archives() = %v, %v", archives, err)
+	}
+
+	res, body := f.do(http.MethodGet, "/admin/diff.json?from="+archives[0].Name, "", adminHeader())
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	var result struct {
+		Changes []struct {
+			Kind  string
+			Lines []struct{ Op, Text string }
+		}
+	}
+	err = json.Unmarshal([]byte(body), &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ Op, Text string }{{"-", testContent}, {"+", testUpdated}}
+	if len(result.Changes) != 1 || !reflect.DeepEqual(result.Changes[0].Lines, want) {
+		t.Errorf("diff = %s, want one change with lines %v", body, want)
+	}
+
+	res, body = f.do(http.MethodGet, "/admin/diff", "", adminHeader())
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("page status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if !strings.Contains(body, "(whole file)") {
+		t.Errorf("page doesn't show the whole-file diff: %s", body)
+	}
+
+	res, _ = f.do(http.MethodGet, "/admin/diff.json?from=../index.html", "", adminHeader())
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("traversal status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/upload", "", nil)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("anonymous status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("wiki", "backup.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(testUpdated))
+	mw.Close()
+
+	res, _ = f.do(http.MethodPost, "/upload", body.String(), adminHeader("Content-Type", mw.FormDataContentType()))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if etag := res.Header.Get("ETag"); etag != f.etag() {
+		t.Errorf("upload ETag %s doesn't match HEAD ETag %s", etag, f.etag())
+	}
+	if content := wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q, want %q", content, testUpdated)
+	}
+	if archives := wiki.List("old"); len(archives) != 1 {
+		t.Errorf("archived %d versions, want 1", len(archives))
+	}
+
+	res, _ = f.do(http.MethodPost, "/upload", "", adminHeader("Content-Type", mw.FormDataContentType()))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("empty upload status = %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestAdminRestoreVersion(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	f.put(testUpdated+"!", nil, http.StatusOK)
+	archives := wiki.List("old")
+	if len(archives) != 2 {
+		t.Fatalf("archives = %v, want two", archives)
+	}
+	oldest := archives[0]
+
+	_, body := f.do(http.MethodGet, "/old/", "", nil)
+	if !strings.Contains(body, "../admin/restore?name="+oldest) {
+		t.Errorf("archive listing has no restore link for %s: %s", oldest, body)
+	}
+
+	res, body := f.do(http.MethodGet, "/admin/restore?name="+oldest, "", adminHeader())
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, oldest) || !strings.Contains(body, "archived first") {
+		t.Errorf("confirmation = %d %s", res.StatusCode, body)
+	}
+	if content := wiki.Read(); content != testUpdated+"!" {
+		t.Errorf("confirmation changed the wiki to %q", content)
+	}
+
+	form := url.Values{"name": {oldest}, "etag": {`"stale"`}}.Encode()
+	res, _ = f.do(http.MethodPost, "/admin/restore", form, adminHeader("Content-Type", "application/x-www-form-urlencoded"))
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale restore status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+
+	f.adminPost("restore", url.Values{"name": {oldest}, "etag": {f.etag()}}.Encode())
+	if content := wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want %q", content, testContent)
+	}
+
+	res, _ = f.do(http.MethodGet, "/admin/restore?name=missing.html", "", adminHeader())
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("missing version status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAdminLogs(t *testing.T) {
+	logs := putter.NewLogBuffer(10)
+	fmt.Fprintln(logs, "before <b>")
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword), putter.WithLogs(logs))
+	defer f.close()
+
+	_, body := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	if !strings.Contains(body, "before &lt;b&gt;") {
+		t.Errorf("dashboard doesn't show the log: %s", body)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, f.http.URL+"/admin/logs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req = req.WithContext(ctx)
+	req.Header = adminHeader()
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if contentType := res.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", contentType)
+	}
+
+	events := bufio.NewReader(res.Body)
+	readEvent := func() string {
+		t.Helper()
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if blank, _ := events.ReadString('\n'); blank != "\n" {
+			t.Errorf("event ends with %q, want a blank line", blank)
+		}
+		return line
+	}
+	if event := readEvent(); event != "data: before <b>\n" {
+		t.Errorf("first event = %q", event)
+	}
+	fmt.Fprintln(logs, "after")
+	if event := readEvent(); event != "data: after\n" {
+		t.Errorf("second event = %q", event)
+	}
+}
+
+func TestAdminNoLogs(t *testing.T) {
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword))
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/admin/logs", "", adminHeader())
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	f := newFixture(t, putter.WithAdmin(testAdminUser, testAdminPassword), putter.WithStats())
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	_, body := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	for _, want := range []string{"Saves per hour", ": 1 saves, 0 conflicts, 0 failures</title>", "Size by day"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard lacks %q", want)
+		}
+	}
+}
+
+func TestMultiHandler(t *testing.T) {
+	wikis := make(map[string]*putter.Server)
+	for _, name := range []string{"notes", "recipes"} {
+		wiki := puttertest.NewTempWiki(t, testContent+name)
+		defer wiki.Close()
+		s, err := putter.NewServer(wiki.FileName,
+			putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+			putter.WithAdmin(testAdminUser, testAdminPassword),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		wikis[name] = s
+	}
+	wikis["recipes"].SetMaintenance(true)
+	f := &fixture{t: t, http: httptest.NewServer(putter.NewMultiHandler(wikis, "/old/", testAdminUser, testAdminPassword))}
+	defer f.http.Close()
+
+	for name := range wikis {
+		res, body := f.do(http.MethodGet, "/"+name+"/", "", nil)
+		if res.StatusCode != http.StatusOK || body != testContent+name {
+			t.Errorf("GET /%s/ = %d %q", name, res.StatusCode, body)
+		}
+		res, _ = f.do(http.MethodGet, "/"+name+"/admin/", "", adminHeader())
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("GET /%s/admin/ status = %d, want %d", name, res.StatusCode, http.StatusOK)
+		}
+	}
+
+	res, _ := f.do(http.MethodGet, "/admin/", "", nil)
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated status = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	res, body := f.do(http.MethodGet, "/admin/", "", adminHeader())
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("overview status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	for _, want := range []string{`href="../notes/admin/"`, `href="../recipes/admin/"`, "0 versions", "maintenance mode", ">ok<"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("overview doesn't contain %q: %s", want, body)
+		}
+	}
+}
+
+func TestRecompressArchives(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveRecompression(putter.RecompressGzip, 0),
+		putter.WithAdmin(testAdminUser, testAdminPassword),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	err := f.server.RecompressArchives(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	archives := wiki.List("old")
+	if len(archives) != 1 || !strings.HasSuffix(archives[0], ".html.gz") {
+		t.Fatalf("archives = %v", archives)
+	}
+
+	res, body := f.do(http.MethodGet, "/old/"+url.PathEscape(archives[0])+"?download", "", nil)
+	if body != testContent || !strings.Contains(res.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("archive = %q (%s)", body, res.Header.Get("Content-Type"))
+	}
+	if disposition := res.Header.Get("Content-Disposition"); !strings.HasSuffix(disposition, ".html") {
+		t.Errorf("Content-Disposition = %s, want the uncompressed name", disposition)
+	}
+
+	f.adminPost("restore", "name="+url.QueryEscape(archives[0]))
+	_, body = f.do(http.MethodGet, "/", "", nil)
+	if body != testContent {
+		t.Errorf("body = %q after restoring a recompressed archive", body)
+	}
+}
@@ -0,0 +1,57 @@
+package putter
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// Anonymization modes control how the addresses of clients are logged and
+// shown (see WithAnonymizedClients).
+const (
+	AnonymizeTruncate = "truncate" // the network only, e.g. 192.0.2.0 or 2001:db8:1::
+	AnonymizeHash     = "hash"     // a pseudonym, e.g. client-3f2a9c1b5e7d
+)
+
+// clientAddr returns the address of the client that made r as it may be
+// logged or shown, anonymized as WithAnonymizedClients said. The request's
+// RemoteAddr is left alone, so that middleware can still tell clients apart.
+func (s *Server) clientAddr(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	return s.anonymize(r.RemoteAddr)
+}
+
+// anonymize anonymizes a client's address, with or without its port.
+func (s *Server) anonymize(addr string) string {
+	if s.anonymizeMode == "" {
+		return addr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	switch s.anonymizeMode {
+	case AnonymizeTruncate:
+		if ip == nil {
+			// e.g. a Unix socket, which says nothing about the client
+			return host
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(24, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	default:
+		if ip != nil {
+			host = ip.String()
+		}
+		mac := hmac.New(sha256.New, s.anonymizeKey)
+		mac.Write([]byte(host))
+		return "client-" + hex.EncodeToString(mac.Sum(nil)[:6])
+	}
+}
@@ -0,0 +1,138 @@
+package putter
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// extensionAuthors is added to the wiki's file name for the record of who
+// saved each version.
+const extensionAuthors = ".authors"
+
+// authors records who saved each version of the wiki, by the identity they
+// authenticated with, so that archived versions can be attributed to them.
+type authors struct {
+	mu       sync.Mutex  // protects the following
+	fileName string      // where the record is kept
+	fileMode os.FileMode // permissions for the file
+	data     authorsData
+}
+
+// authorsData is the record of authors, as kept on disk.
+type authorsData struct {
+	// Live is who saved the live wiki, until it is archived
+	Live struct {
+		ETag    string `json:"etag"`
+		SavedBy string `json:"savedBy"`
+	} `json:"live"`
+	// Archives are who saved archived versions, by archive name
+	Archives map[string]string `json:"archives"`
+}
+
+// load reads the record of authors, starting afresh if there is none.
+func (a *authors) load(fileName string, fileMode os.FileMode) {
+	a.fileName = fileName
+	a.fileMode = fileMode
+	data, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		err = json.Unmarshal(data, &a.data)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to read authors of versions, starting afresh: %v", err)
+	}
+	if a.data.Archives == nil {
+		a.data.Archives = make(map[string]string)
+	}
+}
+
+// record follows saves, archiving, and pruning, fed by the server's events.
+// user is who authenticated the request that saved, if it did.
+func (a *authors) record(e Event, user string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch e.Type {
+	case EventSaveCompleted:
+		if e.Request == nil && e.Archive != "" {
+			// A restored version keeps its author
+			user = a.savedBy(e.Archive)
+		}
+		if user == "" && a.data.Live.SavedBy == "" {
+			return
+		}
+		a.data.Live.ETag = e.ETag
+		a.data.Live.SavedBy = user
+	case EventExternalChange:
+		if a.data.Live.SavedBy == "" {
+			return
+		}
+		a.data.Live.ETag = ""
+		a.data.Live.SavedBy = ""
+	case EventArchived:
+		if a.data.Live.SavedBy == "" || a.data.Live.ETag != e.ETag {
+			return
+		}
+		// Archived events give the archive's path
+		a.data.Archives[filepath.Base(e.Archive)] = a.data.Live.SavedBy
+	case EventArchivePruned:
+		original, _ := archive.IsCompressed(e.Archive)
+		if a.savedBy(e.Archive) == "" {
+			return
+		}
+		delete(a.data.Archives, e.Archive)
+		delete(a.data.Archives, original)
+	default:
+		return
+	}
+	a.write()
+}
+
+// savedBy returns who saved the named archive, if it is known. The caller
+// must hold a.mu.
+func (a *authors) savedBy(name string) string {
+	if user, ok := a.data.Archives[name]; ok {
+		return user
+	}
+	// Recompressed archives are renamed
+	original, _ := archive.IsCompressed(name)
+
+	return a.data.Archives[original]
+}
+
+// attribute fills in who saved each of entries, where it is known.
+func (a *authors) attribute(entries []ArchiveEntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range entries {
+		entries[i].SavedBy = a.savedBy(entries[i].Name)
+	}
+}
+
+// live returns who saved the live wiki, whose ETag is etag, if it is known.
+func (a *authors) live(etag string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.data.Live.ETag != etag {
+		return ""
+	}
+
+	return a.data.Live.SavedBy
+}
+
+// write writes the record to disk. Failure is logged but otherwise harmless.
+// The caller must hold a.mu.
+func (a *authors) write() {
+	data, err := json.Marshal(&a.data)
+	if err == nil {
+		err = storage.WriteFile(a.fileName, data, a.fileMode)
+	}
+	if err != nil {
+		log.Printf("failed to write authors of versions: %v", err)
+	}
+}
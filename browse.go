@@ -0,0 +1,376 @@
+package putter
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/compress"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// Archive listing pages hold archivePageSize entries unless the "per" query
+// parameter asks for more, up to archivePageMax.
+const (
+	archivePageSize = 100
+	archivePageMax  = 1000
+)
+
+// archiveSecurityPolicy lets archived wikis run in a browser, but only as a
+// sandboxed page with an origin of its own, so that it can't save over
+// anything, and only framed by putter's own pages.
+const archiveSecurityPolicy = "sandbox allow-scripts allow-modals allow-popups allow-downloads; frame-ancestors 'self'"
+
+// ArchiveHandler returns a handler serving the archive directory read-only,
+// with a sortable, paginated listing at its root. Archived wikis are served
+// sandboxed, so that they can be viewed but not saved; adding "?download" to
+// an archive's URL downloads it instead. Archives are served with ETags and
+// answer conditional requests, and are gzipped for clients that accept it if
+// compression is enabled.
+func (s *Server) ArchiveHandler() http.Handler {
+	return s.archiveHandler("")
+}
+
+// archiveHandler is ArchiveHandler, with links to restore each version if
+// adminLink, the URL of the admin dashboard relative to the archive, is set.
+func (s *Server) archiveHandler(adminLink string) http.Handler {
+	files := http.FileServer(http.Dir(s.archiver.Dir))
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" || r.URL.Path == "/" {
+			s.handleArchiveIndex(w, r, adminLink)
+			return
+		}
+		w.Header().Set(headerContentSecurityPolicy, archiveSecurityPolicy)
+		name := strings.TrimPrefix(r.URL.Path, "/")
+		original, isCompressed := archive.IsCompressed(name)
+		if _, ok := r.URL.Query()["download"]; ok {
+			disposition := mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(original)})
+			w.Header().Set(headerContentDisposition, disposition)
+		}
+		if s.contentType != "" {
+			w.Header().Set(headerContentType, s.contentType)
+		}
+		if s.archiveCacheControl != "" {
+			w = &cacheControlWriter{ResponseWriter: w, cacheControl: s.archiveCacheControl}
+		}
+		w, done := s.countDownload(w, r)
+		defer done()
+		if s.isCompress || isCompressed {
+			w.Header().Set(headerVary, headerAcceptEncoding)
+		}
+		fileInfo, err := s.statArchive(name)
+		if err != nil {
+			// Let the file server respond as it would
+			files.ServeHTTP(w, r)
+			return
+		}
+		// Archives never change once written, so their size and modification
+		// time identify them without hashing them
+		etag := fmt.Sprintf("\"%x-%x\"", fileInfo.ModTime().UnixNano(), fileInfo.Size())
+		w.Header().Set(headerEtag, etag)
+		if isCompressed && s.serveEncoded(w, r, name, original, fileInfo.ModTime()) {
+			return
+		}
+		// Not _technically_ the right way to check this, but...
+		isGzip := s.isCompress && strings.Contains(r.Header.Get(headerAcceptEncoding), compress.Encoding)
+		if isCompressed || isGzip {
+			s.serveArchive(w, r, name, original, etag, fileInfo.ModTime(), isGzip)
+			return
+		}
+		// The file server answers conditional requests with the ETag
+		files.ServeHTTP(w, r)
+	}
+
+	// TiddlyWiki sends an OPTIONS request that, unless blocked,
+	// will re-download the file and waste bandwidth.
+	return server.WhitelistMethods(http.HandlerFunc(handlerFunc), http.MethodGet, http.MethodHead)
+}
+
+// statArchive returns information about the named archive, failing if it
+// isn't a regular file.
+func (s *Server) statArchive(name string) (fileInfo os.FileInfo, err error) {
+	p, err := s.archiver.Path(name)
+	if err != nil {
+		return
+	}
+	fileInfo, err = os.Stat(p)
+	if err == nil && !fileInfo.Mode().IsRegular() {
+		return nil, fmt.Errorf("archive %s isn't a file", name)
+	}
+
+	return
+}
+
+// serveEncoded serves a compressed archive as it is, with a Content-Encoding
+// for the client to decompress it, if the client accepts its compression,
+// reporting whether it did.
+func (s *Server) serveEncoded(w http.ResponseWriter, r *http.Request, name, original string, modTime time.Time) bool {
+	p, err := s.archiver.Path(name)
+	if err != nil {
+		return false
+	}
+	encoding, err := archive.ContentEncoding(p)
+	// Not _technically_ the right way to check this either
+	if err != nil || encoding == "" || !strings.Contains(r.Header.Get(headerAcceptEncoding), encoding) {
+		return false
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	w.Header().Set(headerContentEncoding, encoding)
+	// The file server answers conditional and range requests, and takes the
+	// Content-Type from the uncompressed name
+	http.ServeContent(w, r, original, modTime, f)
+
+	return true
+}
+
+// serveArchive serves an archive as the file it was before it was
+// recompressed, if it was, gzipping it on the fly if isGzip is set. Since the
+// length isn't known up front, range requests are not supported.
+func (s *Server) serveArchive(
+	w http.ResponseWriter, r *http.Request,
+	name, original, etag string, modTime time.Time, isGzip bool,
+) {
+	if isNotModified(r, etag, modTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	f, err := s.archiver.Open(name)
+	if os.IsNotExist(err) {
+		s.writeError(w, r, http.StatusNotFound, "")
+		return
+	}
+	if err != nil {
+		log.Printf("failed to open archive: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	defer f.Close()
+
+	if w.Header().Get(headerContentType) == "" {
+		contentType := mime.TypeByExtension(path.Ext(original))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set(headerContentType, contentType)
+	}
+	w.Header().Set(headerLastModified, modTime.UTC().Format(http.TimeFormat))
+	if isGzip {
+		w.Header().Set(headerContentEncoding, compress.Encoding)
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	if isGzip {
+		err = compress.Stream(w, f, s.compressLevel)
+	} else {
+		_, err = io.Copy(w, f)
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Printf("failed to serve archive %s: %v", name, err)
+	}
+}
+
+// isNotModified reports whether the conditions of r show that the client
+// already has the version of a file whose ETag is etag, modified at modTime.
+func isNotModified(r *http.Request, etag string, modTime time.Time) bool {
+	if match := r.Header.Get(headerIfNoneMatch); match != "" {
+		return match == "*" || strings.Contains(match, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get(headerIfModifiedSince))
+
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// archiveIndex is passed to archiveIndexTemplate.
+type archiveIndex struct {
+	Entries []ArchiveEntry
+	Total   int
+	Sort    string // "date", "size", or "name"
+	Reverse bool   // whether the sort is descending
+	Page    int
+	Pages   int
+	Per     int
+	Admin   string // relative URL of the admin dashboard, if it is served
+}
+
+// Link returns the query string for the listing with the given changes.
+func (i archiveIndex) Link(sort string, reverse bool, page int) template.URL {
+	q := url.Values{}
+	q.Set("sort", sort)
+	if reverse {
+		q.Set("order", "desc")
+	} else {
+		q.Set("order", "asc")
+	}
+	q.Set("page", strconv.Itoa(page))
+	if i.Per != archivePageSize {
+		q.Set("per", strconv.Itoa(i.Per))
+	}
+
+	return template.URL("?" + q.Encode())
+}
+
+// Attributed reports whether any of the listed versions are known to have
+// been saved by someone, so that the listing names who.
+func (i archiveIndex) Attributed() bool {
+	for _, entry := range i.Entries {
+		if entry.SavedBy != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SortLink returns the query string for sorting by the given column, toggling
+// the order if the listing is already sorted by it.
+func (i archiveIndex) SortLink(sort string) template.URL {
+	reverse := sort != "name"
+	if sort == i.Sort {
+		reverse = !i.Reverse
+	}
+
+	return i.Link(sort, reverse, 1)
+}
+
+// handleArchiveIndex lists the archive, sorted and paginated according to
+// the "sort" (date, size, or name), "order" (asc or desc), "page", and "per"
+// query parameters. The newest archives are listed first by default.
+func (s *Server) handleArchiveIndex(w http.ResponseWriter, r *http.Request, adminLink string) {
+	entries, err := s.Archives()
+	if err != nil {
+		log.Printf("failed to list archive: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+
+	q := r.URL.Query()
+	index := archiveIndex{
+		Total:   len(entries),
+		Sort:    q.Get("sort"),
+		Reverse: q.Get("order") != "asc",
+		Per:     archivePageSize,
+		Admin:   adminLink,
+	}
+	if q.Get("order") == "" && index.Sort == "name" {
+		index.Reverse = false
+	}
+	var less func(i, j int) bool
+	switch index.Sort {
+	case "size":
+		less = func(i, j int) bool { return entries[i].Size < entries[j].Size }
+	case "name":
+		less = func(i, j int) bool { return entries[i].Name < entries[j].Name }
+	default:
+		// The archive lists them newest first, by sequence number if they
+		// have one
+		index.Sort = "date"
+		position := make(map[string]int, len(entries))
+		for i, entry := range entries {
+			position[entry.Name] = i
+		}
+		less = func(i, j int) bool { return position[entries[i].Name] > position[entries[j].Name] }
+	}
+	if index.Reverse {
+		ascending := less
+		less = func(i, j int) bool { return ascending(j, i) }
+	}
+	sort.SliceStable(entries, less)
+
+	if per, err := strconv.Atoi(q.Get("per")); err == nil && per > 0 {
+		index.Per = per
+		if per > archivePageMax {
+			index.Per = archivePageMax
+		}
+	}
+	index.Pages = (len(entries) + index.Per - 1) / index.Per
+	if index.Pages == 0 {
+		index.Pages = 1
+	}
+	index.Page, _ = strconv.Atoi(q.Get("page"))
+	if index.Page < 1 {
+		index.Page = 1
+	}
+	if index.Page > index.Pages {
+		index.Page = index.Pages
+	}
+	start := (index.Page - 1) * index.Per
+	end := start + index.Per
+	if end > len(entries) {
+		end = len(entries)
+	}
+	index.Entries = entries[start:end]
+
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err = archiveIndexTemplate.Execute(w, index)
+	if err != nil {
+		log.Printf("failed to render archive listing: %v", err)
+	}
+}
+
+var archiveIndexTemplate = template.Must(template.New("archive").Funcs(template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"add":        func(a, b int) int { return a + b },
+	"byteSize":   func(size int64) ByteSize { return ByteSize(size) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter - archive</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.2em 0.5em; }
+tr:nth-child(even) { background: #f4f4f4; }
+.size { text-align: right; }
+</style>
+</head>
+<body>
+<h1>Archive</h1>
+<p>{{.Total}} versions{{if gt .Pages 1}}, page {{.Page}} of {{.Pages}}{{end}}</p>
+<table>
+<tr>
+<th><a href="{{.SortLink "name"}}">Name</a></th>
+<th><a href="{{.SortLink "date"}}">Date</a></th>
+<th class="size"><a href="{{.SortLink "size"}}">Size</a></th>
+{{if .Attributed}}<th>Saved by</th>
+{{end}}<th></th>
+</tr>
+{{range .Entries}}<tr>
+<td><a href="./{{pathEscape .Name}}">{{.Name}}</a></td>
+<td>{{.ModTime.Format "2006-01-02 15:04:05"}}</td>
+<td class="size">{{byteSize .Size}}</td>
+{{if $.Attributed}}<td>{{.SavedBy}}</td>
+{{end}}<td><a href="./{{pathEscape .Name}}?download">download</a>{{if $.Admin}}
+<a href="{{$.Admin}}restore?name={{.Name}}">restore</a>{{end}}</td>
+</tr>
+{{end}}
+</table>
+<p>
+{{if gt .Page 1}}<a href="{{.Link .Sort .Reverse (add .Page -1)}}">&larr; previous</a>{{end}}
+{{if lt .Page .Pages}}<a href="{{.Link .Sort .Reverse (add .Page 1)}}">next &rarr;</a>{{end}}
+</p>
+</body>
+</html>
+`))
@@ -0,0 +1,78 @@
+package putter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// ContextWithClient returns a copy of ctx naming the client making a request,
+// such as the user that middleware in front of the server authenticated, by
+// which its saves and downloads are counted in the statistics and its
+// versions are attributed. Clients are only named by users they
+// authenticated as with the server's own authentication otherwise.
+func ContextWithClient(ctx context.Context, name string) context.Context {
+	return server.ContextWithUser(ctx, name)
+}
+
+// clientName identifies the client that made r in the statistics: by the
+// name given to ContextWithClient or the user it authenticated as, if any,
+// and otherwise by its address without the port, anonymized as
+// WithAnonymizedClients said.
+func (s *Server) clientName(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	if name := authenticatedName(r); name != "" {
+		return name
+	}
+	addr := s.clientAddr(r)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return addr
+}
+
+// authenticatedName returns the name of the user that made r, as given to
+// ContextWithClient or verified by the server's authentication, or an empty
+// name if it is anonymous. User names that clients send without them being
+// checked are ignored, so that nobody can save in someone else's name.
+func authenticatedName(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+
+	return server.UserFrom(r.Context())
+}
+
+// countDownload counts the bytes of the response written to the returned
+// writer as downloaded by the client that made r, if statistics are kept,
+// once done is called.
+func (s *Server) countDownload(w http.ResponseWriter, r *http.Request) (counted http.ResponseWriter, done func()) {
+	if !s.isStats {
+		return w, func() {}
+	}
+	counter := &countingWriter{ResponseWriter: w}
+
+	return counter, func() {
+		s.stats.recordDownload(s.clientName(r), counter.n, time.Now())
+	}
+}
+
+// countingWriter counts the bytes of a response's body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+// Write counts the data before passing it on.
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+
+	return n, err
+}
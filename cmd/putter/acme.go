@@ -0,0 +1,46 @@
+//go:build acme
+// +build acme
+
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeFlags registers the flags configuring ACME, which only builds with the
+// acme tag have.
+func acmeFlags(fs *flag.FlagSet) (domain, cache, email *string) {
+	domain = fs.String("acme-domain", "", "comma-separated public domain names of the server, for which certificates are obtained and renewed automatically from Let's Encrypt to serve HTTPS")
+	cache = fs.String("acme-cache", "", "directory in which --acme-domain keeps its account and certificates (empty for putter/acme in the user's config directory)")
+	email = fs.String("acme-email", "", "email address given to Let's Encrypt, to be told of problems with the certificates of --acme-domain")
+
+	return
+}
+
+// listenACME returns a TLS config that obtains and renews certificates for
+// domains from Let's Encrypt with ACME, kept in dir (by default in the user's
+// config directory), and middleware answering the challenges with which Let's
+// Encrypt checks the domains over plain HTTP, for the handler of port 80.
+func listenACME(domains []string, dir, email string) (*tls.Config, func(http.Handler) http.Handler, error) {
+	if dir == "" {
+		config, err := os.UserConfigDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		dir = filepath.Join(config, "putter", "acme")
+	}
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(dir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      email,
+	}
+
+	return m.TLSConfig(), m.HTTPHandler, nil
+}
@@ -0,0 +1,25 @@
+//go:build !acme
+// +build !acme
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"net/http"
+)
+
+// acmeFlags registers no flags, so that --acme-domain and the like are
+// refused as unknown rather than failing once putter starts, and leaves
+// ACME disabled.
+func acmeFlags(fs *flag.FlagSet) (domain, cache, email *string) {
+	return new(string), new(string), new(string)
+}
+
+// listenACME is unsupported unless putter is built with the acme tag, to keep
+// the ACME client's dependency out of the default build. It isn't reached, as
+// such builds have no --acme-domain.
+func listenACME(domains []string, dir, email string) (*tls.Config, func(http.Handler) http.Handler, error) {
+	return nil, nil, errors.New("putter was built without ACME support, rebuild it with -tags acme")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/bundle"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// extensionLock is appended to the wiki's name for the lock held by the
+// server that serves it.
+const extensionLock = ".lock"
+
+// runBackup writes the wiki, its archive, and the config file to a bundle
+// named for the time, compressed with zstd where it is installed and gzip
+// where it isn't. The wiki may be served meanwhile, as it is only ever
+// replaced whole.
+func runBackup(name string, args []string) {
+	f := newCommandFlags(name, "[flags] [dir]")
+	f.withWiki()
+	f.parse(args)
+	if f.NArg() > 1 {
+		f.fatal("more than one directory given to write the bundle to")
+	}
+	dir := f.Arg(0)
+	if dir == "" {
+		dir = "."
+	}
+
+	algorithm := archive.AlgorithmZstd
+	if !archive.Available(algorithm) {
+		log.Printf("zstd is not installed, compressing the bundle with gzip instead")
+		algorithm = archive.AlgorithmGzip
+	}
+	fileName := filepath.Join(dir, "putter-backup-"+time.Now().UTC().Format("2006-01-02-15-04-05")+".tar"+archive.Extension(algorithm))
+	n, err := writeBundle(fileName, algorithm, bundle.Paths{
+		Config:     *f.configFile,
+		Wiki:       *f.wiki,
+		ArchiveDir: *f.archiveDir,
+	}, os.FileMode(f.fileMode))
+	if err != nil {
+		log.Printf("failed to back up \"%s\": %v", *f.wiki, err)
+		os.Exit(exitFailure)
+	}
+	log.Printf("backed up %d files to %s", n, fileName)
+}
+
+// writeBundle writes a bundle of the files at paths to fileName, compressed
+// with algorithm, returning how many files it holds.
+func writeBundle(fileName, algorithm string, paths bundle.Paths, mode os.FileMode) (n int, err error) {
+	f, err := ioutil.TempFile(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	ctx := context.Background()
+	pr, pw := io.Pipe()
+	written := make(chan error, 1)
+	go func() {
+		var err error
+		n, err = bundle.Write(ctx, pw, paths)
+		pw.CloseWithError(err)
+		written <- err
+	}()
+	err = archive.Compress(ctx, f, pr, algorithm)
+	// Stop the bundle being written if compression failed
+	pr.CloseWithError(errors.New("compression failed"))
+	if writeErr := <-written; writeErr != nil {
+		err = writeErr
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), mode)
+	}
+	if err != nil {
+		return
+	}
+
+	return n, os.Rename(f.Name(), fileName)
+}
+
+// runRestoreBundle restores the files of a bundle written by runBackup, such
+// as on a new host. A config file in the bundle is restored first, and then
+// decides where the rest go, unless set on the command line. Existing files
+// are only replaced with --force, except for archives, which are named for
+// their contents' time and so kept as they are.
+func runRestoreBundle(name string, args []string) {
+	f := newCommandFlags(name, "[flags] bundle")
+	f.withWiki()
+	force := f.Bool("force", false, "whether existing files should be replaced")
+	f.parse(args)
+	if f.NArg() != 1 {
+		f.fatal("one bundle must be given to restore")
+	}
+	fileMode, dirMode := os.FileMode(f.fileMode), os.FileMode(f.dirMode)
+
+	in, err := archive.OpenFile(f.Arg(0))
+	if err != nil {
+		log.Fatalf("failed to open bundle: %v", err)
+	}
+	defer in.Close()
+
+	var n, skipped int
+	r := bundle.NewReader(in)
+	for {
+		var file *bundle.File
+		file, err = r.Next()
+		if err != nil {
+			break
+		}
+		var path string
+		switch file.Kind {
+		case bundle.KindConfig:
+			path = *f.configFile
+		case bundle.KindWiki:
+			// Make sure that the wiki isn't being served
+			var lock *os.File
+			lock, err = storage.LockFile(*f.wiki + extensionLock)
+			if err != nil {
+				log.Printf("is putter serving \"%s\"? %v", *f.wiki, err)
+				os.Exit(exitLocked)
+			}
+			defer lock.Close()
+			path = *f.wiki
+		case bundle.KindSidecar:
+			path = *f.wiki + file.Name
+		case bundle.KindArchive:
+			path = filepath.Join(*f.archiveDir, filepath.FromSlash(file.Name))
+		}
+
+		err = file.Extract(path, *force && file.Kind != bundle.KindArchive, fileMode, dirMode)
+		if errors.Is(err, bundle.ErrExist) && file.Kind == bundle.KindArchive {
+			skipped++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		n++
+		if file.Kind == bundle.KindConfig {
+			log.Printf("restored config file %s", path)
+			err = f.loadConfig()
+			if err != nil {
+				break
+			}
+		}
+		if file.Kind == bundle.KindWiki {
+			log.Printf("restored wiki %s", path)
+		}
+	}
+	if err != io.EOF {
+		log.Printf("failed to restore bundle: %v", err)
+		if errors.Is(err, bundle.ErrExist) {
+			log.Printf("use --force to replace existing files")
+		}
+		os.Exit(exitFailure)
+	}
+	log.Printf("restored %d files, skipping %d archives that already existed", n, skipped)
+}
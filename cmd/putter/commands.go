@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/config"
+)
+
+// commands are run instead of serving the wiki when named as putter's first
+// argument, e.g. putter import backups/. Each is given its name and the
+// arguments after it.
+var commands = map[string]func(name string, args []string){
+	"backup":         runBackup,
+	"diff":           runDiff,
+	"import":         runImport,
+	"list":           runList,
+	"recompress":     runRecompress,
+	"restore":        runRestore,
+	"restore-bundle": runRestoreBundle,
+	"trash":          runTrash,
+}
+
+// commandFlags are the flags shared by commands working on a wiki's archive
+// while the wiki may not be served. They have the same names and defaults
+// as the server's, and are read from the same config file.
+type commandFlags struct {
+	*flag.FlagSet
+	wiki            *string // only for commands that call withWiki
+	archiveDir      *string
+	archiveFormat   *string
+	archiveTimezone *string
+	archiveSequence *bool
+	configFile      *string
+	fileMode        config.OctalMode
+	dirMode         config.OctalMode
+	explicit        map[string]bool // flags set on the command line
+}
+
+// newCommandFlags returns the flags of the named command, whose usage is
+// printed after its flags (e.g. "[flags] dir").
+func newCommandFlags(name, usage string) *commandFlags {
+	f := &commandFlags{
+		FlagSet:  flag.NewFlagSet("putter "+name, flag.ExitOnError),
+		fileMode: 0644,
+		dirMode:  0755,
+	}
+	f.Usage = func() {
+		fmt.Fprintf(f.Output(), "Usage: putter %s %s\n", name, usage)
+		f.PrintDefaults()
+	}
+	f.archiveDir = f.String("archive-dir", "old", "directory in which edit history is preserved")
+	f.archiveFormat = f.String("archive-format", "2006-01-02-15-04-05.000.html", "format of archive filenames: a Go time layout such as the default, or iso8601, rfc3339, unix-epoch, or human")
+	f.archiveTimezone = f.String("archive-timezone", "UTC", "time zone in which archive filenames are formatted: UTC, local, or a name such as Europe/Paris")
+	f.archiveSequence = f.Bool("archive-sequence", false, "whether archive filenames begin with a sequence number")
+	f.Var(&f.fileMode, "file-mode", "permissions for created files, in octal")
+	f.Var(&f.dirMode, "dir-mode", "permissions for created directories, in octal")
+	f.configFile = f.String("config", "putter.conf", "config file of flag settings, which command line flags override")
+
+	return f
+}
+
+// withWiki adds the --wiki flag, for commands working on the wiki as well as
+// its archive.
+func (f *commandFlags) withWiki() {
+	f.wiki = f.String("wiki", "index.html", "wiki file")
+}
+
+// parse parses the command's arguments, the environment, and then the config
+// file, exiting if any is invalid.
+func (f *commandFlags) parse(args []string) {
+	f.Parse(args)
+	f.explicit = make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) { f.explicit[fl.Name] = true })
+	err := loadEnv(f.FlagSet, f.explicit)
+	if err != nil {
+		f.fatal(err.Error())
+	}
+	err = f.loadConfig()
+	if err != nil && (!os.IsNotExist(err) || f.explicit["config"]) {
+		f.fatal(err.Error())
+	}
+	_, err = parseTimezone(*f.archiveTimezone)
+	if err != nil {
+		f.fatal("invalid time zone provided to --archive-timezone: " + err.Error())
+	}
+}
+
+// archiveLocation returns the time zone in which archive filenames are
+// formatted.
+func (f *commandFlags) archiveLocation() *time.Location {
+	loc, _ := parseTimezone(*f.archiveTimezone)
+
+	return loc
+}
+
+// loadConfig sets the flags from the config file, except for those set on the
+// command line.
+func (f *commandFlags) loadConfig() error {
+	return loadConfig(f.FlagSet, *f.configFile, f.explicit, true)
+}
+
+// fatal reports an invalid command line and exits.
+func (f *commandFlags) fatal(message string) {
+	fmt.Fprintf(f.Output(), "%s\n", message)
+	f.Usage()
+	os.Exit(exitUsage)
+}
+
+// archiver returns the archiver of the wiki's archive.
+func (f *commandFlags) archiver() *archive.Archiver {
+	return &archive.Archiver{
+		Dir:      *f.archiveDir,
+		Format:   *f.archiveFormat,
+		Sequence: *f.archiveSequence,
+		Mode:     putter.ArchiveModeCopy,
+		FileMode: os.FileMode(f.fileMode),
+		DirMode:  os.FileMode(f.dirMode),
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/djcrock/putter/internal/diff"
+)
+
+// versionLive names the live wiki in place of an archive.
+const versionLive = "live"
+
+// runDiff compares two archived versions of the wiki, or one with the live
+// wiki, tiddler by tiddler like the admin dashboard, or line by line.
+func runDiff(name string, args []string) {
+	f := newCommandFlags(name, "[flags] from [to]\n\nfrom and to are archive names, or \""+versionLive+"\" for the live wiki, which to defaults to.")
+	f.withWiki()
+	raw := f.Bool("raw", false, "whether to compare the files line by line rather than tiddler by tiddler")
+	context := f.Int("context", 3, "number of unchanged lines shown around each change")
+	asJSON := f.Bool("json", false, "whether to write the differences as JSON, for scripts")
+	f.parse(args)
+	if f.NArg() < 1 || f.NArg() > 2 {
+		f.fatal("one or two versions must be given to compare")
+	}
+	from, to := f.Arg(0), versionLive
+	if f.NArg() == 2 {
+		to = f.Arg(1)
+	}
+
+	old, err := f.readVersion(from)
+	if err != nil {
+		log.Fatalf("failed to read %s: %v", from, err)
+	}
+	new, err := f.readVersion(to)
+	if err != nil {
+		log.Fatalf("failed to read %s: %v", to, err)
+	}
+	var changes []diff.Change
+	if *raw {
+		if string(old) != string(new) {
+			changes = []diff.Change{{Kind: diff.Changed, Lines: diff.Lines(string(old), string(new))}}
+		}
+	} else {
+		changes = diff.Wikis(old, new)
+	}
+	for i := range changes {
+		changes[i].Lines = diff.Context(changes[i].Lines, *context)
+	}
+
+	if *asJSON {
+		if changes == nil {
+			changes = []diff.Change{}
+		}
+		err = json.NewEncoder(os.Stdout).Encode(struct {
+			From    string        `json:"from"`
+			To      string        `json:"to"`
+			Changes []diff.Change `json:"changes"`
+		}{from, to, changes})
+		if err != nil {
+			log.Fatalf("failed to write diff: %v", err)
+		}
+		return
+	}
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", from, to)
+	for _, change := range changes {
+		// Files that aren't wikis are compared as a whole, without a title
+		if change.Title != "" {
+			fmt.Fprintf(w, "=== %s (%s)\n", change.Title, change.Kind)
+		}
+		for _, line := range change.Lines {
+			if line.Op == diff.Equal {
+				fmt.Fprintf(w, " %s\n", line.Text)
+			} else if line.Op == diff.Skip {
+				fmt.Fprintf(w, "@@ %s @@\n", line.Text)
+			} else {
+				fmt.Fprintf(w, "%s%s\n", line.Op, line.Text)
+			}
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		log.Fatalf("failed to write diff: %v", err)
+	}
+}
+
+// readVersion reads the named archive, or the live wiki if name is
+// versionLive.
+func (f *commandFlags) readVersion(name string) ([]byte, error) {
+	if name == versionLive {
+		return ioutil.ReadFile(*f.wiki)
+	}
+	in, err := f.archiver().Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	return ioutil.ReadAll(in)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/internal/eventlog"
+)
+
+// eventLog is the Windows Event Log, if the log is written to it.
+var eventLog *eventlog.Log
+
+// eventIDs are the Event Log IDs of the server's events that a desktop user
+// may want to see, which are reported in addition to the log lines about them
+// so that they can be filtered on in Event Viewer.
+var eventIDs = map[putter.EventType]struct {
+	kind eventlog.Kind
+	id   uint32
+}{
+	putter.EventSaveCompleted:      {eventlog.Information, 100},
+	putter.EventSaveFailed:         {eventlog.Error, 101},
+	putter.EventConflict:           {eventlog.Warning, 102},
+	putter.EventSaveHeld:           {eventlog.Warning, 103},
+	putter.EventExternalChange:     {eventlog.Information, 104},
+	putter.EventVerificationFailed: {eventlog.Error, 105},
+}
+
+// reportEvents reports the events of the server for a wiki to the Event Log.
+func reportEvents(s *putter.Server, wiki string) {
+	s.Subscribe(func(e putter.Event) {
+		event, ok := eventIDs[e.Type]
+		if !ok {
+			return
+		}
+		var message string
+		switch e.Type {
+		case putter.EventSaveCompleted:
+			message = fmt.Sprintf("saved wiki \"%s\" (%v)", wiki, putter.ByteSize(e.Size))
+		case putter.EventSaveFailed:
+			message = fmt.Sprintf("failed to save wiki \"%s\": status %d", wiki, e.Status)
+		case putter.EventConflict:
+			message = fmt.Sprintf("rejected a save of wiki \"%s\" that would have overwritten changes saved elsewhere", wiki)
+		case putter.EventSaveHeld:
+			message = fmt.Sprintf("held a save of wiki \"%s\" that shrank it too much for approval on the admin dashboard", wiki)
+		case putter.EventExternalChange:
+			message = fmt.Sprintf("wiki \"%s\" was modified outside of putter", wiki)
+		case putter.EventVerificationFailed:
+			message = fmt.Sprintf("a save of wiki \"%s\" read back differently from what was uploaded and was rolled back; the storage may be corrupting data", wiki)
+		}
+		if e.RequestID != "" {
+			message = "[" + e.RequestID + "] " + message
+		}
+		eventLog.Report(event.kind, event.id, message)
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+)
+
+// listenFastCGI returns the listener on which FastCGI requests arrive. A web
+// server that starts putter itself (e.g. Apache's mod_fcgid) passes a
+// listening socket as standard input; otherwise putter listens at addr for a
+// web server configured to connect to it (e.g. nginx's fastcgi_pass).
+func listenFastCGI(addr string) (net.Listener, error) {
+	ln, err := net.FileListener(os.Stdin)
+	if err == nil {
+		log.Printf("serving FastCGI on the socket provided by the web server")
+		return ln, nil
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("serving FastCGI at %s", addr)
+
+	return ln, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+)
+
+// runImport adds the wikis in directories of backups, such as those kept by
+// other savers, to the archive. Each is named and dated for the time in its
+// name, or failing that its modification time, and copies of versions that
+// are already archived are skipped.
+func runImport(name string, args []string) {
+	f := newCommandFlags(name, "[flags] dir|file ...")
+	dryRun := f.Bool("dry-run", false, "whether to only log what would be imported")
+	f.parse(args)
+	if f.NArg() == 0 {
+		f.fatal("no backups given to import")
+	}
+	a := f.archiver()
+
+	// Recognize versions by their contents, whatever they are named
+	archived := make(map[string]string)
+	entries, err := a.List()
+	if err != nil {
+		log.Fatalf("failed to list archive: %v", err)
+	}
+	for _, entry := range entries {
+		hash, err := a.Hash(entry.Name)
+		if err != nil {
+			log.Printf("failed to read archive %s: %v", entry.Name, err)
+			continue
+		}
+		archived[hash] = entry.Name
+	}
+
+	var found, imported, failed int
+	for _, dir := range f.Args() {
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !isBackup(info) {
+				return nil
+			}
+			found++
+
+			hash, err := archive.HashFile(path)
+			if err != nil {
+				log.Printf("failed to read %s: %v", path, err)
+				failed++
+				return nil
+			}
+			if other, ok := archived[hash]; ok {
+				log.Printf("skipping %s, already archived as %s", path, other)
+				return nil
+			}
+			t, ok := archive.BackupTime(info.Name(), f.archiveLocation())
+			if !ok {
+				t = info.ModTime().In(f.archiveLocation())
+			}
+			newName := t.Format(a.Format)
+			if !*dryRun {
+				newName, err = a.Import(context.Background(), path, t)
+				if err != nil {
+					log.Printf("failed to import %s: %v", path, err)
+					failed++
+					return nil
+				}
+			}
+			log.Printf("imported %s as %s (saved %s)", path, newName, t.Format(time.RFC3339))
+			archived[hash] = newName
+			imported++
+
+			return nil
+		})
+		if err != nil {
+			log.Printf("failed to search %s: %v", dir, err)
+			failed++
+		}
+	}
+
+	if *dryRun {
+		log.Printf("would import %d of %d backups into %s", imported, found, a.Dir)
+	} else {
+		log.Printf("imported %d of %d backups into %s", imported, found, a.Dir)
+	}
+	if failed > 0 {
+		os.Exit(exitFailure)
+	}
+}
+
+// isBackup reports whether the file is a wiki that may be imported.
+func isBackup(info os.FileInfo) bool {
+	if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(info.Name())) {
+	case ".html", ".htm":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/internal/archive"
+)
+
+// listEntry is an archived version as listed by runList.
+type listEntry struct {
+	Name     string    `json:"name"`
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	ETag     string    `json:"etag,omitempty"`
+	Sequence int64     `json:"sequence,omitempty"`
+}
+
+// runList lists the archived versions of the wiki, newest first as in the
+// archive browser, with the ETags they had when they were live.
+func runList(name string, args []string) {
+	f := newCommandFlags(name, "[flags]")
+	asJSON := f.Bool("json", false, "whether to list the versions as JSON, for scripts")
+	etags := f.Bool("etag", true, "whether to compute the versions' ETags, which reads each of them in full")
+	f.parse(args)
+	if f.NArg() > 0 {
+		f.fatal("unexpected arguments: " + fmt.Sprint(f.Args()))
+	}
+	a := f.archiver()
+	loc := f.archiveLocation()
+
+	entries, err := a.List()
+	if err != nil {
+		log.Fatalf("failed to list archive: %v", err)
+	}
+	list := make([]listEntry, 0, len(entries))
+	for _, entry := range entries {
+		e := listEntry{
+			Name:     entry.Name,
+			Time:     entry.ModTime.In(loc),
+			Size:     entry.Size,
+			Sequence: entry.Sequence,
+		}
+		if *etags {
+			e.ETag, err = archiveETag(a, entry.Name)
+			if err != nil {
+				log.Printf("failed to read archive %s: %v", entry.Name, err)
+			}
+		}
+		list = append(list, e)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(list)
+		if err != nil {
+			log.Fatalf("failed to write list: %v", err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDATE\tSIZE\tETAG")
+	for _, e := range list {
+		fmt.Fprintf(w, "%s\t%s\t%v\t%s\n", e.Name, e.Time.Format("2006-01-02 15:04:05"), putter.ByteSize(e.Size), e.ETag)
+	}
+	w.Flush()
+}
+
+// archiveETag returns the ETag that the server gave the named archive when it
+// was the live wiki, with the default MD5 hash.
+func archiveETag(a *archive.Archiver, name string) (string, error) {
+	in, err := a.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	hash := md5.New()
+	_, err = io.Copy(hash, in)
+	if err != nil {
+		return "", err
+	}
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", nil
+}
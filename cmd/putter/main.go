@@ -0,0 +1,1101 @@
+// Simple HTTP server for the TiddlyWiki PUT saver
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/config"
+	"github.com/djcrock/putter/internal/eventlog"
+	"github.com/djcrock/putter/internal/htpasswd"
+	"github.com/djcrock/putter/internal/ldap"
+	"github.com/djcrock/putter/internal/portmap"
+	"github.com/djcrock/putter/internal/proxyproto"
+	"github.com/djcrock/putter/internal/qr"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/syslog"
+	"github.com/djcrock/putter/lambda"
+)
+
+// Exit codes
+const (
+	exitFailure = 1 // failed to start or serve
+	exitUsage   = 2 // invalid command line
+	exitLocked  = 3 // the wiki is already being served
+)
+
+// envPrefix begins the names of the environment variables that set flags.
+const envPrefix = "PUTTER_"
+
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := commands[os.Args[1]]; ok {
+			run(os.Args[1], os.Args[2:])
+			return
+		}
+	}
+
+	bind := flag.String("bind", "127.0.0.1", "IPv4 or IPv6 address, hostname, or network interface to which the server will bind (:: binds to all addresses of both)")
+	port := flag.Int("port", 8080, "port on which the server will listen")
+	tlsCert := flag.String("tls-cert", "", "PEM file of the TLS certificate, with any intermediates, with which the server serves HTTPS instead of HTTP, reloaded when it changes (requires --tls-key)")
+	tlsKey := flag.String("tls-key", "", "PEM file of the private key of --tls-cert")
+	redirectHTTP := flag.Int("redirect-http", 0, "port on which plain HTTP is redirected to HTTPS, at --bind (e.g. 80, 0 disables; requires --tls-cert, or --acme-domain in builds with -tags acme)")
+	acmeDomain, acmeCache, acmeEmail := acmeFlags(flag.CommandLine)
+	wiki := flag.String("wiki", "index.html", "wiki file to serve")
+	wikisDir := flag.String("wikis-dir", "", "directory every .html file in which is served as a wiki under its name, as if each were given as an argument (empty disables)")
+	archive := flag.Bool("archive", true, "whether wiki edit history should be preserved in --archive-dir")
+	archiveDir := flag.String("archive-dir", "old", "directory in which edit history will be preserved")
+	archiveFormat := flag.String("archive-format", "2006-01-02-15-04-05.000.html", "format of archive filenames: a Go time layout such as the default, or iso8601, rfc3339, unix-epoch, or human")
+	archiveMode := flag.String("archive-mode", putter.ArchiveModeAuto, "how archives are written: copy, link, reflink, or auto")
+	var archiveWarnSize, archiveMaxSize, quota putter.ByteSize
+	flag.Var(&archiveWarnSize, "archive-warn-size", "archive directory size past which warnings are logged (e.g. 500MB, 0 disables)")
+	flag.Var(&archiveMaxSize, "archive-max-size", "archive directory size past which new archives are not created (e.g. 2GB, 0 disables)")
+	flag.Var(&quota, "quota", "storage each wiki may use, its file plus its archive, past which saves are refused with 507 Insufficient Storage (e.g. 1GB, 0 disables)")
+	trashDir := flag.String("trash-dir", "", "directory to which pruned archives, unsaved uploads, and discarded held saves are moved instead of being deleted, for recovery from the admin dashboard or with putter trash (empty disables)")
+	trashRetention := flag.Duration("trash-retention", 30*24*time.Hour, "how long files are kept in --trash-dir (0 keeps them for ever)")
+	archiveCompress := flag.String("archive-compress", "", "algorithm with which archives are compressed as they are written: gzip, xz, or zstd (empty writes them as they are, with --archive-mode)")
+	archiveRecompress := flag.String("archive-recompress", "", "algorithm with which archives older than --archive-recompress-age are recompressed every night: gzip, xz, or zstd (empty disables)")
+	archiveRecompressAge := flag.Duration("archive-recompress-age", 30*24*time.Hour, "age past which archives are recompressed")
+	archiveTimezone := flag.String("archive-timezone", "UTC", "time zone in which archive filenames are formatted: UTC, local, or a name such as Europe/Paris")
+	archiveMinChange := flag.Float64("archive-min-change", 0, "percentage of the wiki that must have changed since the newest archive for a save to be archived, so that small autosaves don't each add a version (0 archives every save)")
+	archiveKeepCount := flag.Int("archive-keep-count", 0, "number of the newest archives kept, older ones being pruned after each save and at startup (0 keeps any number)")
+	archiveKeepDays := flag.Int("archive-keep-days", 0, "number of days for which archives are kept before being pruned (0 keeps them for ever)")
+	archiveThin := flag.Bool("archive-thin", false, "whether older archives should be thinned, keeping every archive of the last day, the newest of each day for a month, and the newest of each month beyond that")
+	archiveSequence := flag.Bool("archive-sequence", false, "whether archive filenames should begin with a sequence number, so that their order survives changes to the clock")
+	gitHistory := flag.Bool("git", false, "whether the wiki should be committed to a git repository after each save, with the time and the client's address in the message, creating one in the wiki's directory if it isn't in one (requires git)")
+	gitRemote := flag.String("git-remote", "", "name or URL of a git repository to which commits are pushed in the background (requires --git; empty disables)")
+	serveArchive := flag.Bool("serve-archive", true, "whether wiki edit history should be served over HTTP at --archive-path")
+	archivePath := flag.String("archive-path", "/old/", "path at which edit history will be served over HTTP")
+	timeTravel := flag.Bool("time-travel", false, "whether past versions of the wiki should be served read-only at its own URL, e.g. /?version=2024-05-07 for the version live at the end of that day (requires --serve-archive)")
+	companions := flag.String("companions", "", "comma-separated files used alongside the wiki, such as tiddlywiki.info or a stylesheet, served at their names and saved with PUT as safely as the wiki")
+	wikiFolder := flag.String("wiki-folder", "", "TiddlyWiki wiki folder (a tiddlywiki.info file and tiddlers directory) rendered to the wiki, output/index.html within it unless --wiki is given, into whose tiddler files saves are split (empty disables)")
+	wikiFolderCommand := flag.String("wiki-folder-command", putter.DefaultFolderCommand, "command, with any arguments, with which --wiki-folder is rendered, e.g. \"npx tiddlywiki\"")
+	staticDir := flag.String("static-dir", "", "directory of static files, such as stylesheets or images, served at --static-path (empty disables)")
+	staticPath := flag.String("static-path", "/static/", "path at which --static-dir will be served over HTTP")
+	compress := flag.Bool("compress", true, "whether a gzipped version of the wiki (and of archived versions) should also be served")
+	compressLevel := flag.Int("compress-level", gzip.BestCompression, "gzip compression level, from 1 (fastest) to 9 (smallest)")
+	compressCache := flag.Bool("compress-cache", true, "whether the gzipped wiki should be stored on disk rather than compressed on the fly")
+	contentType := flag.String("content-type", "", "Content-Type with which the wiki and archives are served, e.g. \"text/html; charset=utf-8\" (empty uses their file extension)")
+	cacheControl := flag.String("cache-control", "", "Cache-Control header sent with the wiki, e.g. no-cache (empty sends none)")
+	archiveCacheControl := flag.String("archive-cache-control", "", "Cache-Control header sent with archived versions, e.g. \"public, max-age=31536000, immutable\" (empty sends none)")
+	watch := flag.Bool("watch", true, "whether changes made to the wiki outside of putter should be detected")
+	archiveExternal := flag.Bool("archive-external", false, "whether wikis modified outside of putter should also be archived")
+	verify := flag.Bool("verify", true, "whether each saved wiki should be read back and checked against the upload before the save is reported as successful, to catch storage that silently corrupts data")
+	verifyCompressed := flag.Bool("verify-compressed", false, "whether --verify should also check that the gzipped wiki decompresses to the upload")
+	readOnlyRetry := flag.Duration("read-only-retry", 5*time.Minute, "how long saves are refused after a storage failure before trying again (0 disables read-only mode)")
+	fileMode, dirMode := config.OctalMode(0644), config.OctalMode(0755)
+	flag.Var(&fileMode, "file-mode", "permissions for created files, in octal")
+	flag.Var(&dirMode, "dir-mode", "permissions for created directories, in octal")
+	shrinkLimit := flag.Int("shrink-limit", 0, "percentage by which a save may shrink the wiki before it is held for approval on the admin dashboard (0 disables)")
+	maxUploads := flag.Int("max-uploads", 0, "number of uploads received at once, across all wikis, past which uploads wait their turn (0 is unlimited)")
+	maxUploadsWait := flag.Duration("max-uploads-wait", 30*time.Second, "how long an upload past --max-uploads waits for its turn before being refused with 429 Too Many Requests (0 refuses it at once)")
+	resumable := flag.Bool("resumable", false, "whether to accept resumable uploads with the tus protocol at /resumable/")
+	resumableExpiry := flag.Duration("resumable-expiry", 24*time.Hour, "how long a resumable upload may take to complete before it is abandoned")
+	stallTimeout := flag.Duration("stall-timeout", 0, "how long an upload may stall before it is abandoned with 408 Request Timeout (0 disables)")
+	var stallRate putter.ByteSize
+	flag.Var(&stallRate, "stall-rate", "rate per second at or below which an upload counts as stalled (e.g. 1KB, 0 for nothing at all)")
+	var versionCache putter.ByteSize
+	var maxMemory putter.ByteSize
+	flag.Var(&maxMemory, "max-memory", "memory each wiki's server should stay within, however large the wiki, by compressing on fewer cores and declining to compare versions too large to load whole (e.g. 128MB, 0 disables)")
+	flag.Var(&versionCache, "version-cache", "bytes of recent versions kept in memory for instant restores, comparisons, and conflict details (e.g. 64MB, 0 disables)")
+	stats := flag.Bool("stats", false, "whether a history of saves should be kept alongside the wiki, served as JSON at /stats and charted on the admin dashboard")
+	status := flag.Bool("status", false, "whether the server's status should be served as JSON at /status")
+	manifest := flag.Bool("manifest", false, "whether a web app manifest should be served at /manifest.webmanifest, so that the wiki can be installed as an app on phones and tablets")
+	qrCode := flag.Bool("qr", false, "whether a QR code of the wiki's URL should be printed at startup, for opening it on a phone")
+	etagCache := flag.Bool("etag-cache", true, "whether the wiki's ETag should be cached on disk to skip hashing at startup")
+	weakETags := flag.Bool("weak-etags", false, "whether saves' If-Match headers should be compared weakly, ignoring a W/ prefix and suffixes such as -gzip added by proxies and CDNs that compress the wiki")
+	errorPages := flag.String("error-pages", "", "directory of HTML templates for error pages, named for their status (e.g. 412.html)")
+	adminUser := flag.String("admin-user", "admin", "user name for the admin dashboard")
+	authCommand := flag.String("auth-command", "", "command, with any arguments, that checks the user name and password of every request, given on its standard input, granting access if it exits with status 0 (empty disables)")
+	authUser := flag.String("auth-user", "", "user name that, with --auth-password, every request must carry with HTTP basic authentication (empty disables)")
+	authPassword := flag.String("auth-password", "", "password for --auth-user")
+	authHTPasswd := flag.String("auth-htpasswd", "", "password file written by Apache's htpasswd, with MD5 (-m) or SHA-1 (-s) hashes, as one of whose users every request must authenticate (empty disables)")
+	authReads := flag.Bool("auth-reads", false, "whether reading the wiki, and not only saving it, requires authentication with --auth-user, --auth-htpasswd, --auth-command, or --ldap-url")
+	authCache := flag.Duration("auth-cache", time.Minute, "how long credentials accepted by --auth-command or --ldap-url are remembered, for the same method and path, before they are checked again (0 checks them for every request)")
+	ldapURL := flag.String("ldap-url", "", "URL of an LDAP directory, such as Active Directory, against which the user name and password of every request are checked (e.g. ldaps://dc.example.com, empty disables)")
+	ldapBindDN := flag.String("ldap-bind-dn", "", "DN as which users bind to --ldap-url, with %s for their user name (e.g. uid=%s,ou=people,dc=example,dc=com, or %s@example.com for Active Directory)")
+	ldapBaseDN := flag.String("ldap-base-dn", "", "DN under which users' entries are searched for to find their groups (e.g. dc=example,dc=com)")
+	ldapUserAttribute := flag.String("ldap-user-attribute", "uid", "attribute of users' entries holding their user names (e.g. sAMAccountName for Active Directory)")
+	ldapGroupAttribute := flag.String("ldap-group-attribute", ldap.DefaultGroupAttribute, "attribute of users' entries listing the DNs of their groups")
+	ldapReaders := flag.String("ldap-readers", "", "groups, by DN or common name, separated by semicolons since DNs contain commas, whose members may read but not save the wiki")
+	ldapWriters := flag.String("ldap-writers", "", "groups, separated by semicolons, whose members may read and save the wiki (if neither this nor --ldap-readers is given, any user of the directory may)")
+	adminPassword := flag.String("admin-password", "", "password for the admin dashboard at /admin/ (empty disables the dashboard)")
+	upgradeSource := flag.String("upgrade-source", putter.DefaultUpgradeSource, "URL of the empty wiki of the latest TiddlyWiki release, to which the admin dashboard upgrades the wiki")
+	logTarget := flag.String("log-target", "stderr", "where the log is written: stderr, syslog for the local daemon, syslog://host:port or syslog+tcp://host:port for a remote one, or eventlog for the Windows Event Log")
+	mirror := flag.String("mirror", "", "URL of a wiki served by another putter, of which this one is kept as a read-only mirror (empty disables)")
+	mirrorInterval := flag.Duration("mirror-interval", time.Minute, "how often the wiki given to --mirror is checked for changes")
+	syncPeer := flag.String("sync-peer", "", "URL of a wiki served by another putter with the same --sync-secret, whose versions are pulled into the archive and whose saves fast-forward this wiki (empty only serves the sync protocol)")
+	syncSecret := flag.String("sync-secret", "", "secret shared by peers for the sync protocol at /sync/ (empty disables sync)")
+	syncInterval := flag.Duration("sync-interval", time.Minute, "how often the wiki syncs with --sync-peer")
+	anonymizeClients := flag.String("anonymize-clients", "", "how clients' addresses are anonymized in the log, the admin dashboard, and the status: truncate to their network, or hash to a pseudonym (empty disables)")
+	locks := flag.String("locks", "", "whether WebDAV clients can lock the wiki while editing it, with saves from others meanwhile warned about (advisory) or refused (enforce); empty disables")
+	logLines := flag.Int("log-lines", 200, "number of recent log lines shown on the admin dashboard (0 disables)")
+	tailscale := flag.String("tailscale", "", "machine name with which to join the tailnet, serving the wiki there instead of at --bind and --port (requires a build with -tags tsnet)")
+	upnp := flag.Bool("upnp", false, "whether the router should be asked to forward --port to this machine with NAT-PMP or UPnP, for access from the internet")
+	tunnel := flag.String("tunnel", "", "program with which to open a tunnel from a public HTTPS URL to the wiki: "+tunnelNames())
+	fastCGI := flag.Bool("fastcgi", false, "whether requests should be served with FastCGI, from a web server such as nginx or Apache, instead of HTTP")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "whether connections must begin with a PROXY protocol header giving the client's address, for use behind a TCP load balancer")
+	basePath := flag.String("base-path", "/", "path under which everything is served, for sharing a domain behind a reverse proxy")
+	wikiPath := flag.String("wiki-path", "/", "path under --base-path at which the wiki itself is served, leaving / to other handlers on the same host")
+	wikiPathRedirect := flag.Bool("wiki-path-redirect", false, "whether / should redirect to --wiki-path")
+	wikiAliases := flag.String("wiki-aliases", "", "comma-separated paths, such as /index.html, from which browsers are redirected to the wiki and at which saves are accepted as at the wiki")
+	inject := flag.String("inject", "", "file of HTML, such as a script or a banner, inserted into the wiki before its closing body tag as it is served, leaving the file itself unchanged (empty disables)")
+	configFile := flag.String("config", "putter.conf", "config file of flag settings, which command line flags override")
+	flag.Parse()
+
+	// Settings on the command line take precedence over the environment, and
+	// both over the config file
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	err := loadEnv(flag.CommandLine, explicit)
+	if err != nil {
+		usageFatal(err.Error())
+	}
+	err = loadConfig(flag.CommandLine, *configFile, explicit, false)
+	isConfig := !os.IsNotExist(err)
+	if err != nil && (isConfig || explicit["config"]) {
+		usageFatal(err.Error())
+	}
+	rules, err := readRules(*configFile)
+	if err != nil && !os.IsNotExist(err) {
+		usageFatal(err.Error())
+	}
+
+	// Accept IPv6 literals in brackets, as they appear in URLs
+	bindHost := strings.TrimSuffix(strings.TrimPrefix(*bind, "["), "]")
+	bindAddr, err := net.ResolveIPAddr("ip", bindHost)
+	if err != nil {
+		// An interface's address may change, so look it up now
+		ifaceIP, ifaceErr := server.InterfaceIP(bindHost)
+		if ifaceErr != nil {
+			usageFatal("invalid address provided to --bind: " + err.Error())
+		}
+		bindAddr = &net.IPAddr{IP: ifaceIP}
+		if ifaceIP.IsLinkLocalUnicast() {
+			bindAddr.Zone = bindHost
+		}
+	}
+	ip := bindAddr.IP
+	if *upnp && ip.IsLoopback() {
+		usageFatal("--upnp requires --bind to an address reachable from the router, e.g. 0.0.0.0")
+	}
+
+	switch *archiveMode {
+	case putter.ArchiveModeAuto, putter.ArchiveModeCopy, putter.ArchiveModeLink, putter.ArchiveModeReflink:
+	default:
+		usageFatal("invalid mode provided to --archive-mode")
+	}
+
+	archiveLocation, err := parseTimezone(*archiveTimezone)
+	if err != nil {
+		usageFatal("invalid time zone provided to --archive-timezone: " + err.Error())
+	}
+
+	var logOutput io.Writer = os.Stderr
+	if *logTarget == eventlog.Target {
+		eventLog, err = eventlog.Open()
+		if err != nil {
+			log.Fatalf("failed to open the Event Log: %v", err)
+		}
+		defer eventLog.Close()
+		logOutput = eventLog
+		log.SetOutput(logOutput)
+	} else if *logTarget != "stderr" {
+		if !syslog.ValidTarget(*logTarget) {
+			usageFatal("invalid target provided to --log-target")
+		}
+		w, err := syslog.Dial(*logTarget)
+		if err != nil {
+			log.Fatalf("failed to connect to syslog: %v", err)
+		}
+		defer w.Close()
+		logOutput = w
+		log.SetOutput(logOutput)
+	}
+
+	if *archiveMinChange < 0 || *archiveMinChange > 100 {
+		usageFatal("invalid percentage provided to --archive-min-change")
+	}
+	if *archiveKeepCount < 0 {
+		usageFatal("invalid number provided to --archive-keep-count")
+	}
+	if *archiveKeepDays < 0 {
+		usageFatal("invalid number provided to --archive-keep-days")
+	}
+
+	switch *archiveRecompress {
+	case "", putter.RecompressGzip, putter.RecompressXz, putter.RecompressZstd:
+	default:
+		usageFatal("invalid algorithm provided to --archive-recompress")
+	}
+	switch *archiveCompress {
+	case "", putter.RecompressGzip, putter.RecompressXz, putter.RecompressZstd:
+	default:
+		usageFatal("invalid algorithm provided to --archive-compress")
+	}
+
+	if *compressLevel < gzip.BestSpeed || *compressLevel > gzip.BestCompression {
+		usageFatal("invalid level provided to --compress-level")
+	}
+	if *shrinkLimit < 0 || *shrinkLimit >= 100 {
+		usageFatal("invalid percentage provided to --shrink-limit")
+	}
+	if u, err := url.Parse(*upgradeSource); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		usageFatal("invalid URL provided to --upgrade-source")
+	}
+	if *mirror != "" {
+		u, err := url.Parse(*mirror)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			usageFatal("invalid URL provided to --mirror")
+		}
+		if *mirrorInterval <= 0 {
+			usageFatal("invalid duration provided to --mirror-interval")
+		}
+		if flag.NArg() > 1 {
+			usageFatal("--mirror can only be used with a single wiki")
+		}
+	}
+	if *wikiFolder != "" {
+		if *mirror != "" {
+			usageFatal("--mirror can't be used with --wiki-folder")
+		}
+		if flag.NArg() > 1 {
+			usageFatal("--wiki-folder can only be used with a single wiki")
+		}
+		if strings.TrimSpace(*wikiFolderCommand) == "" {
+			usageFatal("invalid command provided to --wiki-folder-command")
+		}
+		if flag.NArg() == 0 && *wiki == flag.Lookup("wiki").DefValue {
+			*wiki = filepath.Join(*wikiFolder, "output", "index.html")
+		}
+	}
+	if *syncSecret != "" {
+		if !*archive {
+			usageFatal("--sync-secret requires --archive")
+		}
+		if *mirror != "" {
+			usageFatal("--mirror can't be used with --sync-secret")
+		}
+		if *syncInterval <= 0 {
+			usageFatal("invalid duration provided to --sync-interval")
+		}
+		if flag.NArg() > 1 {
+			usageFatal("--sync-secret can only be used with a single wiki")
+		}
+	} else if *syncPeer != "" {
+		usageFatal("--sync-peer requires --sync-secret")
+	}
+	if *gitRemote != "" && !*gitHistory {
+		usageFatal("--git-remote requires --git")
+	}
+	var wikis []string
+	if *wikisDir != "" {
+		if flag.NArg() > 0 {
+			usageFatal("--wikis-dir can't be combined with wikis given as arguments")
+		}
+		if *mirror != "" || *syncSecret != "" || *wikiFolder != "" || *companions != "" {
+			usageFatal("--wikis-dir can't be used with --mirror, --sync-secret, --wiki-folder, or --companions, which need a single wiki")
+		}
+		wikis, err = findWikis(*wikisDir)
+		if err != nil {
+			log.Fatalf("failed to read --wikis-dir: %v", err)
+		}
+		if len(wikis) == 0 {
+			log.Fatalf("no wikis found in --wikis-dir %s", *wikisDir)
+		}
+	}
+	if *syncPeer != "" {
+		u, err := url.Parse(*syncPeer)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			usageFatal("invalid URL provided to --sync-peer")
+		}
+	}
+	if *stallTimeout < 0 {
+		usageFatal("invalid duration provided to --stall-timeout")
+	}
+	if *authCache < 0 {
+		usageFatal("invalid duration provided to --auth-cache")
+	}
+	authModes := 0
+	for _, given := range []bool{*authUser != "", *authHTPasswd != "", strings.TrimSpace(*authCommand) != "", *ldapURL != ""} {
+		if given {
+			authModes++
+		}
+	}
+	if authModes > 1 {
+		usageFatal("only one of --auth-user, --auth-htpasswd, --auth-command, and --ldap-url can be given")
+	}
+	if authModes > 0 && hasRuleAuth(rules) {
+		usageFatal("auth in [[rule]] can't be combined with --auth-user, --auth-htpasswd, --auth-command, or --ldap-url, which use the same header")
+	}
+	if *authUser != "" && *authPassword == "" {
+		usageFatal("--auth-user needs --auth-password")
+	}
+	var passwords *htpasswd.File
+	if *authHTPasswd != "" {
+		var err error
+		passwords, err = htpasswd.Open(*authHTPasswd)
+		if err != nil {
+			log.Fatalf("failed to read --auth-htpasswd file: %v", err)
+		}
+	}
+	// The admin dashboard and upload form are behind the same authentication,
+	// which reads the same header, so it must accept their credentials too
+	if *adminPassword != "" && *authUser != "" && (*adminUser != *authUser || *adminPassword != *authPassword) {
+		usageFatal("--admin-user and --admin-password must be the same as --auth-user and --auth-password, as requests to the admin dashboard must pass both")
+	}
+	if *adminPassword != "" && passwords != nil && !passwords.Accepts(*adminUser, *adminPassword) {
+		usageFatal("--admin-user and --admin-password must also be in --auth-htpasswd, as requests to the admin dashboard must pass both")
+	}
+	var directory *ldap.Directory
+	if *ldapURL != "" {
+		u, err := url.Parse(*ldapURL)
+		if err != nil || u.Scheme != "ldap" && u.Scheme != "ldaps" || u.Host == "" {
+			usageFatal("invalid URL provided to --ldap-url")
+		}
+		if !strings.Contains(*ldapBindDN, "%s") {
+			usageFatal("invalid DN provided to --ldap-bind-dn")
+		}
+		directory = &ldap.Directory{
+			URL:            *ldapURL,
+			BindDN:         *ldapBindDN,
+			BaseDN:         *ldapBaseDN,
+			UserAttribute:  *ldapUserAttribute,
+			GroupAttribute: *ldapGroupAttribute,
+			Readers:        splitGroups(*ldapReaders),
+			Writers:        splitGroups(*ldapWriters),
+		}
+		if (len(directory.Readers) > 0 || len(directory.Writers) > 0) && (directory.BaseDN == "" || directory.UserAttribute == "") {
+			usageFatal("--ldap-readers and --ldap-writers need --ldap-base-dn and --ldap-user-attribute")
+		}
+	}
+	if !strings.HasPrefix(*wikiPath, "/") {
+		usageFatal("invalid path provided to --wiki-path")
+	}
+	*wikiPath = "/" + strings.Trim(*wikiPath, "/")
+	if *maxUploads < 0 {
+		usageFatal("invalid number provided to --max-uploads")
+	}
+	if *maxUploadsWait < 0 {
+		usageFatal("invalid duration provided to --max-uploads-wait")
+	}
+	if *resumableExpiry <= 0 {
+		usageFatal("invalid duration provided to --resumable-expiry")
+	}
+	if *trashRetention < 0 {
+		usageFatal("invalid duration provided to --trash-retention")
+	}
+	switch *anonymizeClients {
+	case "", putter.AnonymizeTruncate, putter.AnonymizeHash:
+	default:
+		usageFatal("invalid mode provided to --anonymize-clients")
+	}
+	switch *locks {
+	case "", putter.LockModeAdvisory, putter.LockModeEnforce:
+	default:
+		usageFatal("invalid mode provided to --locks")
+	}
+	if *contentType != "" {
+		if _, _, err := mime.ParseMediaType(*contentType); err != nil {
+			usageFatal("invalid media type provided to --content-type: " + err.Error())
+		}
+	}
+
+	if _, ok := tunnels[*tunnel]; *tunnel != "" && !ok {
+		usageFatal("invalid program provided to --tunnel")
+	}
+	if *tunnel != "" && *tailscale != "" {
+		usageFatal("--tunnel can't be used with --tailscale")
+	}
+	if *upnp && *tailscale != "" {
+		usageFatal("--upnp can't be used with --tailscale")
+	}
+	if *proxyProtocol && (*tailscale != "" || *tunnel != "" || *upnp) {
+		usageFatal("--proxy-protocol can't be used with --tailscale, --tunnel, or --upnp")
+	}
+	if *fastCGI && (*tailscale != "" || *tunnel != "" || *upnp || *proxyProtocol) {
+		usageFatal("--fastcgi can't be used with --tailscale, --tunnel, --upnp, or --proxy-protocol")
+	}
+	if (*tlsCert != "" || *tlsKey != "") && (*tlsCert == "" || *tlsKey == "") {
+		usageFatal("--tls-cert and --tls-key must be given together")
+	}
+	if *tlsCert != "" && *acmeDomain != "" {
+		usageFatal("--tls-cert can't be combined with --acme-domain")
+	}
+	isTLS := *tlsCert != "" || *acmeDomain != ""
+	if isTLS && (*tailscale != "" || *tunnel != "" || *fastCGI) {
+		usageFatal("--tls-cert and --acme-domain can't be used with --tailscale, --tunnel, or --fastcgi, which bring their own")
+	}
+	if *redirectHTTP < 0 || *redirectHTTP > 65535 || *redirectHTTP != 0 && *redirectHTTP == *port {
+		usageFatal("invalid port provided to --redirect-http")
+	}
+	if *redirectHTTP != 0 && !isTLS {
+		usageFatal("--redirect-http needs --tls-cert or --acme-domain")
+	}
+	var tlsConfig *tls.Config
+	var challenges func(http.Handler) http.Handler
+	switch {
+	case *acmeDomain != "":
+		var domains []string
+		for _, domain := range strings.Split(*acmeDomain, ",") {
+			if domain = strings.TrimSpace(domain); domain != "" {
+				domains = append(domains, domain)
+			}
+		}
+		tlsConfig, challenges, err = listenACME(domains, *acmeCache, *acmeEmail)
+		if err != nil {
+			log.Printf("failed to set up ACME: %v", err)
+			os.Exit(exitFailure)
+		}
+		if *port != 443 && *redirectHTTP != 80 {
+			log.Printf("warning: Let's Encrypt checks domains on port 443, or on port 80 with --redirect-http 80, so --acme-domain may fail to get certificates on port %d unless it is forwarded there", *port)
+		}
+	case *tlsCert != "":
+		cert, err := loadCertificate(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Printf("failed to load TLS certificate: %v", err)
+			os.Exit(exitFailure)
+		}
+		tlsConfig = &tls.Config{GetCertificate: cert.GetCertificate}
+	}
+
+	addr := net.JoinHostPort(bindAddr.String(), strconv.Itoa(*port))
+	if net.ParseIP(bindHost) == nil && !strings.Contains(bindHost, "%") {
+		log.Printf("--bind %s resolved to %s", bindHost, bindAddr)
+	}
+	scheme := "http"
+	if isTLS {
+		scheme = "https"
+	}
+	origin := scheme + "://" + addr
+	if *tailscale != "" {
+		origin = "http://" + *tailscale
+	}
+	// Only the web server or AWS knows the URL at which the wiki is served
+	isLambda := os.Getenv("AWS_LAMBDA_RUNTIME_API") != ""
+	if *fastCGI || isLambda {
+		origin = ""
+	}
+	base := server.FixPath(*basePath)
+	root := strings.TrimSuffix(base, "/")
+	// Where the wiki itself is, for the URLs logged and shown as QR codes
+	wikiBase := root + *wikiPath
+
+	if _, err := os.Stat(*wiki); os.IsNotExist(err) && !isConfig && flag.NArg() == 0 && !*fastCGI && !isLambda && *mirror == "" && *wikiFolder == "" && *wikisDir == "" {
+		err = runSetup(addr, base, *configFile, setupForm{
+			Wiki:       *wiki,
+			Source:     emptyWikiURL,
+			Archive:    *archive,
+			ArchiveDir: *archiveDir,
+			AdminUser:  *adminUser,
+		})
+		if err != nil {
+			log.Printf("failed to serve setup page: %v", err)
+			os.Exit(exitFailure)
+		}
+		err = loadConfig(flag.CommandLine, *configFile, explicit, false)
+		if err != nil {
+			log.Printf("failed to load new config: %v", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	options := []putter.Option{
+		putter.WithFileModes(os.FileMode(fileMode), os.FileMode(dirMode)),
+		putter.WithReadOnlyRetry(*readOnlyRetry),
+	}
+	if *archive {
+		options = append(options,
+			putter.WithArchiveMode(*archiveMode),
+			putter.WithArchiveLimits(archiveWarnSize, archiveMaxSize),
+			putter.WithArchiveTimezone(archiveLocation),
+		)
+		if *archiveSequence {
+			options = append(options, putter.WithArchiveSequence())
+		}
+		if *archiveMinChange > 0 {
+			options = append(options, putter.WithArchiveThreshold(*archiveMinChange))
+		}
+		if *archiveRecompress != "" {
+			options = append(options, putter.WithArchiveRecompression(*archiveRecompress, *archiveRecompressAge))
+		}
+		if *archiveCompress != "" {
+			options = append(options, putter.WithArchiveCompression(*archiveCompress))
+		}
+		if *archiveKeepCount > 0 || *archiveKeepDays > 0 || *archiveThin {
+			options = append(options, putter.WithArchiveRetention(*archiveKeepCount, time.Duration(*archiveKeepDays)*24*time.Hour, *archiveThin))
+		}
+	}
+	if *gitHistory {
+		options = append(options, putter.WithGit(*gitRemote))
+	}
+	if *verify {
+		options = append(options, putter.WithVerification(*verifyCompressed))
+	}
+	if *maxUploads > 0 {
+		options = append(options, putter.WithUploadLimiter(putter.NewUploadLimiter(*maxUploads, *maxUploadsWait)))
+	}
+	if maxMemory > 0 {
+		options = append(options, putter.WithMaxMemory(maxMemory))
+	}
+	if *weakETags {
+		options = append(options, putter.WithWeakETags())
+	}
+	// Companion files are saved as safely as the wiki, but are no wikis
+	companionOptions := options[:len(options):len(options)]
+	if *etagCache {
+		companionOptions = append(companionOptions, putter.WithETagCache())
+	}
+	if *watch {
+		companionOptions = append(companionOptions, putter.WithWatch())
+	}
+	if *compress && *compressCache {
+		options = append(options, putter.WithCompression(*compressLevel))
+	} else if *compress {
+		options = append(options, putter.WithDynamicCompression(*compressLevel))
+	}
+	if *etagCache {
+		options = append(options, putter.WithETagCache())
+	}
+	if *resumable {
+		options = append(options, putter.WithResumableUploads(*resumableExpiry))
+	}
+	if *wikiPath != "/" {
+		options = append(options, putter.WithWikiPath(*wikiPath, *wikiPathRedirect))
+	}
+	if *wikiAliases != "" {
+		options = append(options, putter.WithWikiAliases(strings.Split(*wikiAliases, ",")...))
+	}
+	if *timeTravel && *archive && *serveArchive {
+		options = append(options, putter.WithTimeTravel())
+	}
+	if *inject != "" {
+		html, err := ioutil.ReadFile(*inject)
+		if err != nil {
+			log.Fatalf("failed to read --inject file: %v", err)
+		}
+		options = append(options, putter.WithInjection(html))
+	}
+	if *contentType != "" {
+		options = append(options, putter.WithContentType(*contentType))
+	}
+	if *stallTimeout > 0 {
+		options = append(options, putter.WithStallTimeout(*stallTimeout, stallRate))
+	}
+	if versionCache > 0 {
+		options = append(options, putter.WithVersionCache(versionCache))
+	}
+	if *anonymizeClients != "" {
+		options = append(options, putter.WithAnonymizedClients(*anonymizeClients))
+	}
+	if *locks != "" {
+		options = append(options, putter.WithLocks(*locks))
+	}
+	if quota > 0 {
+		options = append(options, putter.WithQuota(quota))
+	}
+	if *mirror != "" {
+		options = append(options, putter.WithMirror(*mirror, *mirrorInterval))
+	}
+	if *wikiFolder != "" {
+		options = append(options, putter.WithWikiFolder(*wikiFolder, *wikiFolderCommand))
+	}
+	if *syncSecret != "" {
+		options = append(options, putter.WithSync(*syncPeer, *syncSecret, *syncInterval))
+	}
+	if *cacheControl != "" || *archiveCacheControl != "" {
+		options = append(options, putter.WithCacheControl(*cacheControl, *archiveCacheControl))
+	}
+	if *watch {
+		options = append(options, putter.WithWatch())
+	}
+	if *archiveExternal {
+		options = append(options, putter.WithArchiveExternal())
+	}
+	if *adminPassword != "" {
+		options = append(options, putter.WithAdmin(*adminUser, *adminPassword))
+		if *upgradeSource != putter.DefaultUpgradeSource {
+			options = append(options, putter.WithUpgradeSource(*upgradeSource))
+		}
+		if *logLines > 0 {
+			logs := putter.NewLogBuffer(*logLines)
+			log.SetOutput(io.MultiWriter(logOutput, logs))
+			options = append(options, putter.WithLogs(logs))
+		}
+	}
+	if *status {
+		options = append(options, putter.WithStatus())
+	}
+	if *manifest {
+		options = append(options, putter.WithManifest())
+	}
+	if *stats {
+		options = append(options, putter.WithStats())
+	}
+	if *shrinkLimit > 0 {
+		options = append(options, putter.WithShrinkLimit(*shrinkLimit))
+	}
+	if *errorPages != "" {
+		options = append(options, putter.WithErrorPages(*errorPages))
+	}
+
+	path := ""
+	if *archive && *serveArchive {
+		path = server.FixPath(*archivePath)
+	}
+	static := ""
+	if *staticDir != "" {
+		static = server.FixPath(*staticPath)
+		switch static {
+		case "/", path, "/admin/", "/status/", "/stats/", "/sync/", "/upload/", "/versions/":
+			usageFatal("--static-path can't be served at " + static + ", which is already in use")
+		}
+		options = append(options, putter.WithStatic(*staticDir, static))
+	}
+	var companionFiles []string
+	if *companions != "" {
+		if flag.NArg() > 1 {
+			usageFatal("--companions can only be used with one wiki")
+		}
+		names := make(map[string]bool)
+		for _, companion := range strings.Split(*companions, ",") {
+			companion = strings.TrimSpace(companion)
+			name := filepath.Base(companion)
+			switch "/" + name {
+			case "/status", "/stats", "/upload", "/manifest.webmanifest", "/manifest-icon":
+				usageFatal("companion \"" + companion + "\" can't be served at /" + name + ", which is already in use")
+			}
+			if companion == *wiki && flag.NArg() == 0 || companion == flag.Arg(0) {
+				usageFatal("companion \"" + companion + "\" is the wiki itself")
+			}
+			if names[name] {
+				usageFatal("two companions would be served at /" + name)
+			}
+			names[name] = true
+			companionFiles = append(companionFiles, companion)
+		}
+	}
+
+	var ln net.Listener
+	var identify func(http.Handler) http.Handler
+	if *tailscale != "" {
+		ln, identify, err = listenTailscale(*tailscale)
+		if err != nil {
+			log.Printf("failed to join tailnet: %v", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	if *wikisDir == "" {
+		wikis = flag.Args()
+	}
+	if len(wikis) == 0 {
+		wikis = []string{*wiki}
+	}
+	var handler http.Handler
+	if len(wikis) == 1 && *wikisDir == "" {
+		if *archive {
+			options = append(options, putter.WithArchive(*archiveDir, *archiveFormat))
+		}
+		if *trashDir != "" {
+			options = append(options, putter.WithTrash(*trashDir, *trashRetention))
+		}
+		url := origin + root
+		for _, companion := range companionFiles {
+			// Each is archived in a directory of its own within the archive
+			cOptions := companionOptions[:len(companionOptions):len(companionOptions)]
+			if *archive {
+				cOptions = append(cOptions, putter.WithArchive(filepath.Join(*archiveDir, filepath.Base(companion)), companionFormat(*archiveFormat, companion)))
+			}
+			if *trashDir != "" {
+				cOptions = append(cOptions, putter.WithTrash(filepath.Join(*trashDir, filepath.Base(companion)), *trashRetention))
+			}
+			c := startServer(companion, cOptions)
+			log.Printf("serving companion \"%s\" at %s%s", companion, url, putter.CompanionPath(c))
+			options = append(options, putter.WithCompanion(c))
+		}
+		s := startServer(wikis[0], options)
+		log.Printf("serving wiki \"%s\" at %s%s", wikis[0], url, *wikiPath)
+		logEndpoints(url, *archiveDir, path, *staticDir, static, *status, *stats, *adminPassword != "")
+		handler = putter.NewHandler(s, path)
+	} else {
+		// Each wiki is served under its name, with an archive of its own
+		names := make(map[string]string)
+		for _, wiki := range wikis {
+			name := strings.TrimSuffix(filepath.Base(wiki), filepath.Ext(wiki))
+			if other, ok := names[name]; ok {
+				usageFatal(fmt.Sprintf("wikis \"%s\" and \"%s\" would both be served at /%s/", other, wiki, name))
+			}
+			if name == "admin" {
+				usageFatal(fmt.Sprintf("wiki \"%s\" can't be served at /admin/, which is reserved for the dashboard", wiki))
+			}
+			names[name] = wiki
+		}
+		servers := make(map[string]*putter.Server)
+		for name, wiki := range names {
+			wikiOptions := options[:len(options):len(options)]
+			archiveDir := filepath.Join(*archiveDir, name)
+			if *archive {
+				wikiOptions = append(wikiOptions, putter.WithArchive(archiveDir, *archiveFormat))
+			}
+			if *trashDir != "" {
+				wikiOptions = append(wikiOptions, putter.WithTrash(filepath.Join(*trashDir, name), *trashRetention))
+			}
+			servers[name] = startServer(wiki, wikiOptions)
+			url := origin + root + "/" + name
+			log.Printf("serving wiki \"%s\" at %s%s", wiki, url, *wikiPath)
+			logEndpoints(url, archiveDir, path, *staticDir, static, *status, *stats, *adminPassword != "")
+		}
+		if *adminPassword != "" {
+			log.Printf("serving overview of all wikis at %s%s/admin/", origin, root)
+		}
+		handler = putter.NewMultiHandler(servers, path, *adminUser, *adminPassword)
+	}
+	if len(rules) > 0 {
+		handler = server.Rules(handler, rules)
+	}
+	var auth http.Handler
+	switch {
+	case *authUser != "":
+		auth = server.BasicAuth(handler, server.PasswordCheck(*authUser, *authPassword), 0)
+	case passwords != nil:
+		auth = server.BasicAuth(handler, passwords.Check, 0)
+	case strings.TrimSpace(*authCommand) != "":
+		auth = server.CommandAuth(handler, strings.Fields(*authCommand), *authCache)
+	case directory != nil:
+		auth = server.BasicAuth(handler, directory.Check, *authCache)
+	}
+	if auth != nil && !*authReads {
+		auth = server.AnonymousReads(handler, auth)
+	}
+	if auth != nil {
+		handler = auth
+	}
+	handler = server.BasePath(handler, base)
+	if ln != nil {
+		log.Fatal(http.Serve(ln, identify(handler)))
+	}
+	if isLambda {
+		log.Fatal(lambda.Serve(handler))
+	}
+	if *fastCGI {
+		ln, err = listenFastCGI(addr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal(fcgi.Serve(ln, handler))
+	}
+	if *qrCode && *tunnel == "" {
+		printQRCodes(scheme, ip, *port, wikiBase)
+	}
+
+	// Listen before the tunnel opens, so that it has something to connect to
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *proxyProtocol {
+		ln = &proxyproto.Listener{Listener: ln, Timeout: 10 * time.Second}
+	}
+	if *upnp {
+		forwardPort(scheme, *port, wikiBase, *qrCode)
+	}
+	if *tunnel != "" {
+		err = startTunnel(*tunnel, tunnelTarget(ip, *port), func(url string) {
+			log.Printf("serving wiki at %s%s through a tunnel, anyone with this URL can read and save the wiki", url, wikiBase)
+			if *qrCode {
+				printQRCode(url + wikiBase)
+			}
+		})
+		if err != nil {
+			log.Printf("failed to open tunnel: %v", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	if *redirectHTTP != 0 {
+		redirectAddr := net.JoinHostPort(bindAddr.String(), strconv.Itoa(*redirectHTTP))
+		redirectLn, err := net.Listen("tcp", redirectAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("redirecting http://%s to HTTPS", redirectAddr)
+		redirect := server.RedirectHTTPS(*port)
+		if challenges != nil {
+			redirect = challenges(redirect)
+		}
+		go func() {
+			log.Fatal(http.Serve(redirectLn, redirect))
+		}()
+	}
+	if tlsConfig != nil {
+		srv := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+		log.Fatal(srv.ServeTLS(ln, "", ""))
+	}
+	log.Fatal(http.Serve(ln, handler))
+}
+
+// startServer creates the server for a wiki, exiting if it can't.
+func startServer(wiki string, options []putter.Option) *putter.Server {
+	s, err := putter.NewServer(wiki, options...)
+	if errors.Is(err, putter.ErrLocked) {
+		log.Printf("is another putter already serving \"%s\"? %v", wiki, err)
+		os.Exit(exitLocked)
+	}
+	if err != nil {
+		log.Printf("failed to start \"%s\": %v", wiki, err)
+		os.Exit(exitFailure)
+	}
+	if eventLog != nil {
+		reportEvents(s, wiki)
+	}
+	// Write anything pending, such as statistics, before exiting
+	onShutdown(func() { s.Close() })
+
+	return s
+}
+
+// companionFormat returns the archive format for a companion file, with the
+// HTML extension of the wiki's archives, if they have one, replaced by its own.
+func companionFormat(format, companion string) string {
+	if preset, ok := archive.Presets[format]; ok {
+		format = preset
+	}
+	if ext := filepath.Ext(format); ext == ".html" || ext == ".htm" {
+		return strings.TrimSuffix(format, ext) + filepath.Ext(companion)
+	}
+
+	return format
+}
+
+// logEndpoints logs the URLs of the optional endpoints served for the wiki
+// at base.
+func logEndpoints(base, archiveDir, archivePath, staticDir, staticPath string, status, stats, admin bool) {
+	if archivePath != "" {
+		log.Printf("serving archive \"%s\" at %s%s", archiveDir, base, archivePath)
+	}
+	if staticPath != "" {
+		log.Printf("serving static files \"%s\" at %s%s", staticDir, base, staticPath)
+	}
+	if status {
+		log.Printf("serving status at %s/status", base)
+	}
+	if stats {
+		log.Printf("serving statistics at %s/stats", base)
+	}
+	if admin {
+		log.Printf("serving admin dashboard at %s/admin/", base)
+		log.Printf("serving upload form at %s/upload", base)
+	}
+}
+
+// loadEnv sets the flags of fs from the environment variables named for them,
+// e.g. PUTTER_ARCHIVE_DIR for --archive-dir, except for those set on the
+// command line, and marks them as explicit. Empty variables are ignored.
+func loadEnv(fs *flag.FlagSet, explicit map[string]bool) (err error) {
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		value := os.Getenv(name)
+		if err != nil || value == "" || explicit[f.Name] {
+			return
+		}
+		if e := fs.Set(f.Name, value); e != nil {
+			err = fmt.Errorf("invalid %s: %v", name, e)
+			return
+		}
+		explicit[f.Name] = true
+	})
+
+	return
+}
+
+// loadConfig sets the flags of fs named in the config file, except for those
+// in explicit, which were set on the command line. If partial is true, fs has
+// only some of the server's flags, and settings of the others are ignored.
+func loadConfig(fs *flag.FlagSet, name string, explicit map[string]bool, partial bool) error {
+	settings, err := config.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	for _, setting := range settings {
+		if setting.Table == ruleTable {
+			// Read by readRules
+			continue
+		}
+		if setting.Table != "" {
+			return fmt.Errorf("%s:%d: unknown table [[%s]]", name, setting.Line, setting.Table)
+		}
+		if setting.Name == "config" || fs.Lookup(setting.Name) == nil && !partial {
+			return fmt.Errorf("%s:%d: unknown setting %q", name, setting.Line, setting.Name)
+		}
+		if setting.Values != nil {
+			return fmt.Errorf("%s:%d: %s takes a single value, not an array", name, setting.Line, setting.Name)
+		}
+		if explicit[setting.Name] || fs.Lookup(setting.Name) == nil {
+			continue
+		}
+		err = fs.Set(setting.Name, setting.Value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: invalid %s: %v", name, setting.Line, setting.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseTimezone returns the time zone named UTC, local (the system's time
+// zone), or a name in the IANA database such as Europe/Paris.
+func parseTimezone(name string) (*time.Location, error) {
+	switch strings.ToLower(name) {
+	case "utc":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(name)
+}
+
+// splitGroups splits a list of LDAP groups separated by semicolons.
+func splitGroups(list string) (groups []string) {
+	for _, group := range strings.Split(list, ";") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+
+	return
+}
+
+// findWikis returns the .html files in dir, in order of name, leaving out
+// hidden ones.
+func findWikis(dir string) (wikis []string, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, ".") || !strings.EqualFold(filepath.Ext(name), ".html") {
+			continue
+		}
+		wikis = append(wikis, filepath.Join(dir, name))
+	}
+
+	return
+}
+
+// usageFatal reports an invalid command line and exits.
+func usageFatal(message string) {
+	fmt.Fprintf(flag.CommandLine.Output(), "%s\n", message)
+	flag.Usage()
+	os.Exit(exitUsage)
+}
+
+// forwardPort asks the router to forward the port to this machine until
+// putter is stopped, logging the resulting URL of the wiki at base.
+func forwardPort(scheme string, port int, base string, qrCode bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m, err := portmap.Map(ctx, port)
+	if err != nil {
+		log.Printf("failed to forward port %d on the router: %v", port, err)
+		return
+	}
+	onShutdown(func() {
+		err := m.Close()
+		if err != nil {
+			log.Printf("failed to remove port forwarding from the router: %v", err)
+		}
+	})
+	url := scheme + "://" + net.JoinHostPort(m.ExternalIP.String(), strconv.Itoa(m.ExternalPort)) + base
+	log.Printf("serving wiki at %s with %s, anyone with this URL can read and save the wiki", url, m)
+	if qrCode {
+		printQRCode(url)
+	}
+}
+
+// shutdownFuncs clean up after putter when it is interrupted or terminated.
+var (
+	shutdownMu    sync.Mutex
+	shutdownFuncs []func()
+)
+
+// onShutdown calls fn when putter is interrupted or terminated, before it
+// exits.
+func onShutdown(fn func()) {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if shutdownFuncs == nil {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-signals
+			shutdownMu.Lock()
+			for _, fn := range shutdownFuncs {
+				fn()
+			}
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		}()
+	}
+	shutdownFuncs = append(shutdownFuncs, fn)
+}
+
+// tunnelTarget is the local address to which a tunnel connects.
+func tunnelTarget(ip net.IP, port int) string {
+	if ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+
+	return net.JoinHostPort(ip.String(), strconv.Itoa(port))
+}
+
+// printQRCodes prints a QR code for each URL at which the wiki can be reached
+// under base.
+func printQRCodes(scheme string, ip net.IP, port int, base string) {
+	if ip.IsLoopback() {
+		log.Printf("warning: %s is only reachable from this machine, use --bind 0.0.0.0 (or :: for IPv6 too) to open the wiki on other devices", ip)
+	}
+	urls, err := server.URLs(scheme, ip, port)
+	if err != nil {
+		log.Printf("failed to find network addresses: %v", err)
+		return
+	}
+	for _, url := range urls {
+		printQRCode(strings.TrimSuffix(url, "/") + base)
+	}
+}
+
+// printQRCode prints a QR code for the URL.
+func printQRCode(url string) {
+	code, err := qr.Encode(url)
+	if err != nil {
+		log.Printf("failed to encode QR code for %s: %v", url, err)
+		return
+	}
+	fmt.Printf("\n%s\n", url)
+	code.WriteTerminal(os.Stdout)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/internal/archive"
+)
+
+// runRecompress recompresses existing archives with another algorithm, e.g.
+// to migrate gzip archives to zstd after turning on --archive-compress zstd.
+// It can run while the wiki is being served.
+func runRecompress(name string, args []string) {
+	f := newCommandFlags(name, "[flags]")
+	algorithm := f.String("algorithm", archive.AlgorithmZstd, "algorithm with which to recompress archives: gzip, xz, or zstd")
+	from := f.String("from", archive.AlgorithmGzip, "comma-separated algorithms of the archives to recompress: gzip, xz, zstd, or none for uncompressed ones")
+	f.parse(args)
+	if f.NArg() > 0 {
+		f.fatal("unexpected arguments: " + fmt.Sprint(f.Args()))
+	}
+	if !archive.ValidAlgorithm(*algorithm) {
+		f.fatal("invalid algorithm provided to --algorithm")
+	}
+	extensions := make(map[string]bool)
+	for _, from := range strings.Split(*from, ",") {
+		from = strings.TrimSpace(from)
+		switch {
+		case from == "none":
+			extensions[""] = true
+		case archive.ValidAlgorithm(from) && from != *algorithm:
+			extensions[archive.Extension(from)] = true
+		default:
+			f.fatal("invalid algorithm provided to --from")
+		}
+	}
+	if !archive.Available(*algorithm) {
+		log.Fatalf("%s is not installed", *algorithm)
+	}
+	a := f.archiver()
+
+	entries, err := a.List()
+	if err != nil {
+		log.Fatalf("failed to list archive: %v", err)
+	}
+	var before, after int64
+	count := 0
+	for _, entry := range entries {
+		ext := ""
+		if _, ok := archive.IsCompressed(entry.Name); ok {
+			ext = filepath.Ext(entry.Name)
+		}
+		if !extensions[ext] {
+			continue
+		}
+		newName, err := a.Recompress(context.Background(), entry.Name, *algorithm)
+		if os.IsNotExist(err) {
+			// Pruned in the meantime
+			continue
+		}
+		if err != nil {
+			log.Fatalf("failed to recompress %s: %v", entry.Name, err)
+		}
+		fileInfo, err := os.Stat(filepath.Join(a.Dir, newName))
+		if err == nil {
+			before += entry.Size
+			after += fileInfo.Size()
+		}
+		count++
+	}
+	log.Printf("recompressed %d archives with %s, from %v to %v", count, *algorithm, putter.ByteSize(before), putter.ByteSize(after))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/djcrock/putter"
+)
+
+// runRestore replaces the live wiki with an archived version while it isn't
+// being served, archiving the live wiki first as the admin dashboard does, so
+// that the restore can itself be undone.
+func runRestore(name string, args []string) {
+	f := newCommandFlags(name, "[flags]")
+	f.withWiki()
+	version := f.String("version", "", "name of the archived version to restore (default the most recent)")
+	f.parse(args)
+	if f.NArg() > 0 {
+		f.fatal("the version to restore must be given with --version")
+	}
+
+	options := []putter.Option{
+		putter.WithArchive(*f.archiveDir, *f.archiveFormat),
+		putter.WithArchiveTimezone(f.archiveLocation()),
+		putter.WithFileModes(os.FileMode(f.fileMode), os.FileMode(f.dirMode)),
+	}
+	if *f.archiveSequence {
+		options = append(options, putter.WithArchiveSequence())
+	}
+	s, err := putter.NewServer(*f.wiki, options...)
+	if errors.Is(err, putter.ErrLocked) {
+		log.Printf("is putter serving \"%s\"? stop it or restore from the admin dashboard instead: %v", *f.wiki, err)
+		os.Exit(exitLocked)
+	}
+	if err != nil {
+		log.Fatalf("failed to open \"%s\": %v", *f.wiki, err)
+	}
+	defer s.Close()
+
+	if *version == "" {
+		entries, err := s.Archives()
+		if err != nil {
+			log.Fatalf("failed to list archive: %v", err)
+		}
+		if len(entries) == 0 {
+			log.Fatalf("no archived versions of \"%s\" in %s", *f.wiki, *f.archiveDir)
+		}
+		*version = entries[0].Name
+	}
+	err = s.Restore(context.Background(), *version)
+	if err != nil {
+		s.Close()
+		log.Fatalf("failed to restore %s: %v", *version, err)
+	}
+}
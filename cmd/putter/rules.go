@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/djcrock/putter/internal/config"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// ruleTable is the table of the config file whose entries are rules for
+// paths.
+const ruleTable = "rule"
+
+// readRules reads the rules for paths in the config file, each an entry of
+// its [[rule]] table.
+func readRules(name string) (rules []server.Rule, err error) {
+	settings, err := config.ReadFile(name)
+	if err != nil {
+		return
+	}
+	for _, entry := range config.Entries(settings, ruleTable) {
+		if len(entry) == 0 {
+			continue
+		}
+		var rule server.Rule
+		for _, setting := range entry {
+			values := setting.Values
+			if values == nil {
+				values = []string{setting.Value}
+			}
+			switch setting.Name {
+			case "path":
+				rule.Path = setting.Value
+			case "methods":
+				for _, method := range values {
+					rule.Methods = append(rule.Methods, strings.ToUpper(method))
+				}
+			case "headers":
+				rule.Header = make(http.Header)
+				for _, header := range values {
+					i := strings.IndexByte(header, ':')
+					if i <= 0 {
+						return nil, fmt.Errorf("%s:%d: invalid header %q, expected Name: value", name, setting.Line, header)
+					}
+					rule.Header.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+				}
+			case "cache-control":
+				rule.CacheControl = setting.Value
+			case "auth":
+				i := strings.IndexByte(setting.Value, ':')
+				if i <= 0 {
+					return nil, fmt.Errorf("%s:%d: invalid auth, expected user:password", name, setting.Line)
+				}
+				rule.User, rule.Password = setting.Value[:i], setting.Value[i+1:]
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown setting %q in [[%s]]", name, setting.Line, setting.Name, ruleTable)
+			}
+		}
+		if !strings.HasPrefix(rule.Path, "/") {
+			return nil, fmt.Errorf("%s:%d: [[%s]] needs a path beginning with /", name, entry[0].Line, ruleTable)
+		}
+		rules = append(rules, rule)
+	}
+
+	return
+}
+
+// hasRuleAuth reports whether any of rules requires credentials of its own.
+func hasRuleAuth(rules []server.Rule) bool {
+	for _, rule := range rules {
+		if rule.User != "" {
+			return true
+		}
+	}
+
+	return false
+}
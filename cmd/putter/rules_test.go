@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter-rules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "putter.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("rule:\n  - path: /old/\n    methods: [get, HEAD]\n")
+	rules, err := readRules(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Path != "/old/" || len(rules[0].Methods) != 2 || rules[0].Methods[0] != "GET" {
+		t.Errorf("rules = %+v", rules)
+	}
+	if hasRuleAuth(rules) {
+		t.Error("rules without auth reported as having it")
+	}
+
+	// Rules with auth of their own can't be combined with the auth flags
+	write("rule:\n  - path: /old/\n  - path: /private/\n    auth: alice:secret\n")
+	rules, err = readRules(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasRuleAuth(rules) {
+		t.Error("rules with auth not reported as having it")
+	}
+
+	write("rule:\n  - path: /private/\n    auth: alice\n")
+	if _, err := readRules(name); err == nil {
+		t.Error("readRules accepted auth without a password")
+	}
+}
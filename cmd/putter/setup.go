@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/config"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// emptyWikiURL is where the setup page downloads a new, empty wiki from.
+const emptyWikiURL = "https://tiddlywiki.com/empty.html"
+
+// maxWikiDownload limits the size of the downloaded empty wiki.
+const maxWikiDownload = 64 << 20
+
+// setup is the first-run setup page, which creates a wiki and config file.
+type setup struct {
+	configFile string
+	token      string        // secret in the setup URL, so that only whoever started putter can use it
+	defaults   setupForm     // initial values of the form
+	mu         sync.Mutex    // protects the following
+	isDone     bool          // whether setup is complete
+	done       chan struct{} // closed once setup is complete
+}
+
+// setupForm is passed to setupTemplate.
+type setupForm struct {
+	Token         string
+	Wiki          string
+	Source        string
+	Archive       bool
+	ArchiveDir    string
+	AdminUser     string
+	AdminPassword string
+	Error         string
+	Done          bool
+}
+
+// runSetup serves the setup page at base on addr until the wiki and config
+// file have been created.
+func runSetup(addr, base, configFile string, defaults setupForm) error {
+	token := make([]byte, 16)
+	_, err := rand.Read(token)
+	if err != nil {
+		return err
+	}
+	s := &setup{
+		configFile: configFile,
+		token:      hex.EncodeToString(token),
+		defaults:   defaults,
+		done:       make(chan struct{}),
+	}
+
+	srv := &http.Server{Addr: addr, Handler: server.BasePath(s, base)}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	log.Printf("no wiki or config file found, open http://%s%s?token=%s to set up putter", addr, base, s.token)
+
+	select {
+	case err = <-errs:
+		return err
+	case <-s.done:
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return srv.Shutdown(ctx)
+}
+
+func (s *setup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	token := r.FormValue("token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "open the setup URL logged by putter", http.StatusForbidden)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	form := s.defaults
+	form.Token = token
+	form.Done = s.isDone
+	switch {
+	case s.isDone:
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+	case r.Method == http.MethodPost:
+		form.Wiki = strings.TrimSpace(r.FormValue("wiki"))
+		form.Source = strings.TrimSpace(r.FormValue("source"))
+		form.Archive = r.FormValue("archive") != ""
+		form.ArchiveDir = strings.TrimSpace(r.FormValue("archive-dir"))
+		form.AdminUser = strings.TrimSpace(r.FormValue("admin-user"))
+		form.AdminPassword = r.FormValue("admin-password")
+		err := s.create(form)
+		if err != nil {
+			log.Printf("setup failed: %v", err)
+			form.Error = err.Error()
+			break
+		}
+		form.Done = true
+		s.isDone = true
+		defer close(s.done)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := setupTemplate.Execute(w, form)
+	if err != nil {
+		log.Printf("failed to render setup page: %v", err)
+	}
+}
+
+// create downloads the empty wiki and writes the config file.
+func (s *setup) create(form setupForm) (err error) {
+	if form.Wiki == "" {
+		return errors.New("choose a name for the wiki file")
+	}
+	if form.Archive && form.ArchiveDir == "" {
+		return errors.New("choose a directory for the archive")
+	}
+	if _, err = os.Stat(form.Wiki); err == nil {
+		return fmt.Errorf("%s already exists", form.Wiki)
+	}
+
+	log.Printf("downloading empty wiki from %s", form.Source)
+	err = download(form.Source, form.Wiki)
+	if err != nil {
+		return fmt.Errorf("failed to download the empty wiki: %v", err)
+	}
+
+	settings := []config.Setting{
+		{Name: "wiki", Value: form.Wiki},
+		{Name: "archive", Value: strconv.FormatBool(form.Archive)},
+	}
+	if form.Archive {
+		settings = append(settings, config.Setting{Name: "archive-dir", Value: form.ArchiveDir})
+	}
+	if form.AdminPassword != "" {
+		settings = append(settings,
+			config.Setting{Name: "admin-user", Value: form.AdminUser},
+			config.Setting{Name: "admin-password", Value: form.AdminPassword},
+		)
+	}
+	err = config.WriteFile(s.configFile, settings)
+	if err != nil {
+		os.Remove(form.Wiki)
+		return fmt.Errorf("failed to write the config file: %v", err)
+	}
+	log.Printf("created wiki \"%s\" and config file \"%s\"", form.Wiki, s.configFile)
+
+	return
+}
+
+// download saves the wiki at url as the named file, checking that it's HTML.
+func download(url, name string) (err error) {
+	client := http.Client{Timeout: time.Minute}
+	res, err := client.Get(url)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New(res.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxWikiDownload+1))
+	if err != nil {
+		return
+	}
+	if len(data) > maxWikiDownload {
+		return errors.New("the file is too large")
+	}
+	if !bytes.Contains(bytes.ToLower(data[:min(len(data), 1024)]), []byte("<html")) {
+		return errors.New("the file isn't a wiki")
+	}
+
+	dir := filepath.Dir(name)
+	if dir != "." {
+		err = storage.Mkdir(dir, 0755)
+		if err != nil {
+			return
+		}
+	}
+
+	return storage.WriteFile(name, data, 0644)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+var setupTemplate = template.Must(template.New("setup").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter setup</title>
+{{if .Done}}<meta http-equiv="refresh" content="3; url=./">{{end}}
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+label { display: block; margin: 0.5em 0; }
+input[type=text], input[type=password] { width: 100%; }
+.warning { color: #a00; }
+.note { color: #555; font-size: smaller; }
+</style>
+</head>
+<body>
+<h1>putter setup</h1>
+{{if .Done}}
+<p>Your wiki is ready. It will open in a moment, or <a href="./">open it now</a>.</p>
+{{else}}
+{{with .Error}}<p class="warning">{{.}}</p>{{end}}
+<form method="post">
+<input type="hidden" name="token" value="{{.Token}}">
+<h2>1. Wiki</h2>
+<label>File name <input type="text" name="wiki" value="{{.Wiki}}"></label>
+<label>Download an empty TiddlyWiki from <input type="text" name="source" value="{{.Source}}"></label>
+<h2>2. History</h2>
+<label><input type="checkbox" name="archive" value="true"{{if .Archive}} checked{{end}}> Keep a copy of every version of the wiki</label>
+<label>in the directory <input type="text" name="archive-dir" value="{{.ArchiveDir}}"></label>
+<h2>3. Admin password</h2>
+<p class="note">The admin dashboard shows the wiki's history and log, and can restore old versions. Leave the password empty to turn it off.</p>
+<label>User name <input type="text" name="admin-user" value="{{.AdminUser}}"></label>
+<label>Password <input type="password" name="admin-password" autocomplete="new-password"></label>
+<p class="note">The settings are saved in a config file, which putter reads when it starts. Command line flags override them.</p>
+<p><button>Create wiki</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
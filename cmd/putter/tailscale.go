@@ -0,0 +1,67 @@
+//go:build tsnet
+// +build tsnet
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/djcrock/putter"
+	"tailscale.com/tsnet"
+)
+
+// Headers identifying the Tailscale user making a request, named as they are
+// by "tailscale serve"
+const (
+	headerTailscaleLogin = "Tailscale-User-Login"
+	headerTailscaleName  = "Tailscale-User-Name"
+)
+
+// listenTailscale joins the tailnet as a machine with the given hostname,
+// returning a listener for HTTP on it and middleware that identifies the
+// Tailscale user making each request. The machine's state is kept in the
+// user's config directory; the first run logs a URL to log in with, unless
+// the TS_AUTHKEY environment variable holds an auth key.
+func listenTailscale(hostname string) (net.Listener, func(http.Handler) http.Handler, error) {
+	srv := &tsnet.Server{
+		Hostname: hostname,
+		Logf:     log.New(ioutil.Discard, "", 0).Printf,
+		UserLogf: log.Printf,
+	}
+	ln, err := srv.Listen("tcp", ":80")
+	if err != nil {
+		return nil, nil, err
+	}
+	lc, err := srv.LocalClient()
+	if err != nil {
+		ln.Close()
+		return nil, nil, err
+	}
+
+	identify := func(h http.Handler) http.Handler {
+		handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+			// Never trust identity headers sent by the client
+			r.Header.Del(headerTailscaleLogin)
+			r.Header.Del(headerTailscaleName)
+			who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
+			if err != nil {
+				log.Printf("failed to identify Tailscale user at %s: %v", r.RemoteAddr, err)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			if who.UserProfile != nil {
+				r.Header.Set(headerTailscaleLogin, who.UserProfile.LoginName)
+				r.Header.Set(headerTailscaleName, who.UserProfile.DisplayName)
+				r = r.WithContext(putter.ContextWithClient(r.Context(), who.UserProfile.LoginName))
+			}
+			h.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(handlerFunc)
+	}
+
+	return ln, identify, nil
+}
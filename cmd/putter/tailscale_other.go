@@ -0,0 +1,16 @@
+//go:build !tsnet
+// +build !tsnet
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+)
+
+// listenTailscale is unsupported unless putter is built with the tsnet tag,
+// to keep Tailscale's dependencies out of the default build.
+func listenTailscale(hostname string) (net.Listener, func(http.Handler) http.Handler, error) {
+	return nil, nil, errors.New("putter was built without Tailscale support, rebuild it with -tags tsnet")
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificate is a TLS certificate loaded from files, reloaded when the
+// certificate file changes so that renewed certificates (e.g. by certbot)
+// are served without a restart.
+type certificate struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex       // protects the following
+	cert    *tls.Certificate // the certificate last loaded
+	modTime time.Time        // modification time of certFile when it was loaded
+}
+
+// loadCertificate loads a certificate and its key from PEM files.
+func loadCertificate(certFile, keyFile string) (c *certificate, err error) {
+	c = &certificate{certFile: certFile, keyFile: keyFile}
+	_, err = c.GetCertificate(nil)
+
+	return
+}
+
+// GetCertificate returns the certificate, reloading it if the certificate
+// file has changed, for tls.Config. If reloading fails, as it may while the
+// files are being replaced, the old certificate is served until it succeeds.
+func (c *certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fileInfo, err := os.Stat(c.certFile)
+	if err == nil && fileInfo.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+	if err == nil {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(c.certFile, c.keyFile)
+		if err == nil {
+			if c.cert != nil {
+				log.Printf("reloaded TLS certificate from %s", c.certFile)
+			}
+			c.cert, c.modTime = &cert, fileInfo.ModTime()
+			return c.cert, nil
+		}
+	}
+	if c.cert == nil {
+		return nil, err
+	}
+	log.Printf("failed to reload TLS certificate, serving the old one: %v", err)
+
+	return c.cert, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/djcrock/putter"
+)
+
+// runTrash lists the files in the wiki's trash, or recovers one of them into
+// its archive, while the wiki isn't being served.
+func runTrash(name string, args []string) {
+	f := newCommandFlags(name, "[flags]")
+	f.withWiki()
+	trashDir := f.String("trash-dir", "", "directory to which discarded files are moved")
+	trashRetention := f.Duration("trash-retention", 30*24*time.Hour, "how long files are kept in --trash-dir (0 keeps them for ever)")
+	asJSON := f.Bool("json", false, "whether to list the files as JSON, for scripts")
+	recoverName := f.String("recover", "", "name of the file to recover into the archive, as listed")
+	f.parse(args)
+	if f.NArg() > 0 {
+		f.fatal("unexpected arguments: " + fmt.Sprint(f.Args()))
+	}
+	if *trashDir == "" {
+		f.fatal("the trash must be given with --trash-dir")
+	}
+	if *trashRetention < 0 {
+		f.fatal("invalid duration provided to --trash-retention")
+	}
+
+	options := []putter.Option{
+		putter.WithArchive(*f.archiveDir, *f.archiveFormat),
+		putter.WithArchiveTimezone(f.archiveLocation()),
+		putter.WithFileModes(os.FileMode(f.fileMode), os.FileMode(f.dirMode)),
+		putter.WithTrash(*trashDir, *trashRetention),
+	}
+	if *f.archiveSequence {
+		options = append(options, putter.WithArchiveSequence())
+	}
+	s, err := putter.NewServer(*f.wiki, options...)
+	if errors.Is(err, putter.ErrLocked) {
+		log.Printf("is putter serving \"%s\"? stop it or use the trash on the admin dashboard instead: %v", *f.wiki, err)
+		os.Exit(exitLocked)
+	}
+	if err != nil {
+		log.Fatalf("failed to open \"%s\": %v", *f.wiki, err)
+	}
+	defer s.Close()
+
+	if *recoverName != "" {
+		archived, err := s.RecoverTrash(context.Background(), *recoverName)
+		if err != nil {
+			s.Close()
+			log.Fatalf("failed to recover %s: %v", *recoverName, err)
+		}
+		fmt.Println(archived)
+		return
+	}
+
+	items, err := s.Trash()
+	if err != nil {
+		s.Close()
+		log.Fatalf("failed to list trash: %v", err)
+	}
+	if *asJSON {
+		if items == nil {
+			items = []putter.TrashItem{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(items)
+		if err != nil {
+			s.Close()
+			log.Fatalf("failed to write list: %v", err)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDISCARDED\tREASON\tSIZE")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\n", item.Name, item.Time.In(f.archiveLocation()).Format("2006-01-02 15:04:05"), item.Reason, putter.ByteSize(item.Size))
+	}
+	w.Flush()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// tunnel is a program that opens a tunnel from a public HTTPS URL to a local
+// address, from behind NAT.
+type tunnel struct {
+	args func(target string) []string // command line tunnelling to target
+	url  *regexp.Regexp               // matches the public URL in the program's output
+}
+
+var tunnels = map[string]tunnel{
+	"cloudflared": {
+		args: func(target string) []string {
+			return []string{"cloudflared", "tunnel", "--no-autoupdate", "--url", "http://" + target}
+		},
+		url: regexp.MustCompile(`(https://[-a-z0-9]+\.trycloudflare\.com)`),
+	},
+	"ngrok": {
+		args: func(target string) []string {
+			return []string{"ngrok", "http", target, "--log", "stdout", "--log-format", "logfmt"}
+		},
+		url: regexp.MustCompile(`url=(https://[^\s"]+)`),
+	},
+}
+
+// tunnelNames lists the supported tunnel programs, for usage messages.
+func tunnelNames() string {
+	var names []string
+	for name := range tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, " or ")
+}
+
+// startTunnel runs the named tunnel program in the background, tunnelling to
+// target, and calls onURL with the public URL once the program reports it.
+// The program is stopped if putter is interrupted.
+func startTunnel(name, target string, onURL func(url string)) error {
+	t, ok := tunnels[name]
+	if !ok {
+		return fmt.Errorf("unknown tunnel %q", name)
+	}
+	args := t.args(target)
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return fmt.Errorf("%s must be installed to open a tunnel: %v", args[0], err)
+	}
+
+	// The URL may be reported on either stream
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return err
+	}
+	log.Printf("opening tunnel with %s...", args[0])
+
+	go func() {
+		defer r.Close()
+		found := false
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			if m := t.url.FindStringSubmatch(scanner.Text()); m != nil && !found {
+				found = true
+				onURL(m[1])
+			}
+		}
+		err := cmd.Wait()
+		log.Printf("tunnel closed, the wiki is no longer reachable through it: %v", err)
+	}()
+
+	onShutdown(func() { cmd.Process.Kill() })
+
+	return nil
+}
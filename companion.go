@@ -0,0 +1,29 @@
+package putter
+
+import (
+	"net/http"
+	"path/filepath"
+)
+
+// CompanionPath returns the path at which NewHandler serves companion, a
+// server given to WithCompanion: its file's base name, e.g.
+// "/tiddlywiki.info".
+func CompanionPath(companion *Server) string {
+	return "/" + filepath.Base(companion.fileName)
+}
+
+// companionHandler serves companion at CompanionPath, as if it were the
+// wiki at "/".
+func companionHandler(companion *Server) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = "/"
+		u.RawPath = ""
+		r2.URL = &u
+		companion.ServeHTTP(w, r2)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
@@ -0,0 +1,189 @@
+package putter
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/djcrock/putter/internal/diff"
+)
+
+// diffContext is the number of unchanged lines shown around each change on
+// the comparison page.
+const diffContext = 3
+
+// readVersion reads the named archive, or the live wiki if name is empty.
+func (s *Server) readVersion(name string) ([]byte, error) {
+	if name == "" {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if data := s.versions.find(s.etag, ""); data != nil {
+			return data, nil
+		}
+		return s.readFile(s.fileName)
+	}
+	if !s.isArchive {
+		return nil, ErrNoArchive
+	}
+	f, err := s.openVersion(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return s.readAll(f)
+}
+
+// compareVersions compares two versions, where an empty name is the live
+// wiki. Errors are written to w, in which case ok is false.
+func (s *Server) compareVersions(w http.ResponseWriter, from, to string) (changes []diff.Change, ok bool) {
+	old, err := s.readVersion(from)
+	if err == nil {
+		var new []byte
+		new, err = s.readVersion(to)
+		changes = diff.Wikis(old, new)
+	}
+	if err == errTooLarge {
+		http.Error(w, "The versions are too large to compare within the memory limit.", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("failed to compare versions %q and %q: %v", from, to, err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	return changes, true
+}
+
+// handleAdminDiffJSON serves the differences between two versions as JSON.
+func (s *Server) handleAdminDiffJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	from, to := r.FormValue("from"), r.FormValue("to")
+	changes, ok := s.compareVersions(w, from, to)
+	if !ok {
+		return
+	}
+	if changes == nil {
+		changes = []diff.Change{}
+	}
+
+	w.Header().Set(headerContentType, "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		From    string        `json:"from"`
+		To      string        `json:"to"`
+		Changes []diff.Change `json:"changes"`
+	}{from, to, changes})
+	if err != nil {
+		log.Printf("failed to write diff: %v", err)
+	}
+}
+
+// compareData is passed to compareTemplate.
+type compareData struct {
+	From     string
+	To       string
+	Archives []ArchiveEntry
+	Changes  []diff.Change
+}
+
+// handleAdminDiff serves a page for picking two versions and showing how
+// their tiddlers differ. With no versions given, it compares the newest
+// archive with the live wiki.
+func (s *Server) handleAdminDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	archives, err := s.Archives()
+	if err != nil {
+		log.Printf("failed to list archives: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	from, to := r.FormValue("from"), r.FormValue("to")
+	if _, ok := r.Form["from"]; !ok && len(archives) > 0 {
+		from = archives[0].Name
+	}
+	changes, ok := s.compareVersions(w, from, to)
+	if !ok {
+		return
+	}
+	for i := range changes {
+		changes[i].Lines = diff.Context(changes[i].Lines, diffContext)
+	}
+
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err = compareTemplate.Execute(w, compareData{
+		From:     from,
+		To:       to,
+		Archives: archives,
+		Changes:  changes,
+	})
+	if err != nil {
+		log.Printf("failed to render comparison: %v", err)
+	}
+}
+
+// versionOptions is passed to the "versions" template to list the versions
+// that can be compared.
+type versionOptions struct {
+	Archives []ArchiveEntry
+	Selected string
+}
+
+var compareTemplate = template.Must(template.New("compare").Funcs(template.FuncMap{
+	"versions": func(archives []ArchiveEntry, selected string) versionOptions {
+		return versionOptions{archives, selected}
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin - compare versions</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 1em auto; padding: 0 1em; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+.lines { border: 1px solid #ccc; margin-bottom: 1em; }
+.delete { background: #fdd; }
+.insert { background: #dfd; }
+.skip { background: #eee; color: #777; font-style: italic; }
+</style>
+</head>
+<body>
+<p><a href="./">&larr; admin</a></p>
+<h1>Compare versions</h1>
+{{define "versions"}}
+<option value=""{{if eq .Selected ""}} selected{{end}}>live wiki</option>
+{{range .Archives}}<option value="{{.Name}}"{{if eq .Name $.Selected}} selected{{end}}>{{.Name}}</option>
+{{end}}
+{{end}}
+<form method="get" action="diff">
+<select name="from">{{template "versions" (versions .Archives .From)}}</select>
+&rarr;
+<select name="to">{{template "versions" (versions .Archives .To)}}</select>
+<button>Compare</button>
+<a href="diff.json?from={{.From}}&amp;to={{.To}}">JSON</a>
+</form>
+{{if not .Changes}}
+<p>The versions are identical.</p>
+{{end}}
+{{range .Changes}}
+<h2>{{if .Title}}{{.Title}}{{else}}(whole file){{end}} <small>{{.Kind}}</small></h2>
+<div class="lines">
+{{range .Lines}}{{if eq .Op "-"}}<pre class="delete">- {{.Text}}</pre>
+{{else if eq .Op "+"}}<pre class="insert">+ {{.Text}}</pre>
+{{else if eq .Op "~"}}<pre class="skip">  {{.Text}}</pre>
+{{else}}<pre>  {{.Text}}</pre>
+{{end}}{{end}}
+</div>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,118 @@
+package putter
+
+import (
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// errorMessages explain error statuses to people using the wiki, who may not
+// know what to make of a bare status code.
+var errorMessages = map[int]string{
+	http.StatusBadRequest:          "The wiki was damaged on its way to the server and has not been saved. Please try saving again.",
+	http.StatusForbidden:           "You are not allowed to save this wiki.",
+	http.StatusNotFound:            "There is nothing here. The wiki is at the root of this site.",
+	http.StatusConflict:            "This save would delete most of the wiki, which usually means the wiki is damaged, so it has been held for approval rather than saved. Your changes are still in your browser.",
+	http.StatusPreconditionFailed:  "Your browser has an old copy of the wiki: it has been changed since you opened it, perhaps on another device. Reload the page before saving (copy anything you want to keep first).",
+	http.StatusLocked:              "Someone else is editing the wiki, so it has not been saved. Your changes are still in your browser: save them once they have finished and you have reloaded the page (copy anything you want to keep first).",
+	http.StatusTooManyRequests:     "The server is busy receiving other saves. Your changes are still in your browser, so keep the wiki open and try saving again in a few seconds.",
+	http.StatusInternalServerError: "Something went wrong on the server. If you were saving, your changes are still in your browser, so keep the wiki open and try saving again shortly.",
+	http.StatusServiceUnavailable:  "The wiki can't be saved at the moment. Your changes are still in your browser, so keep the wiki open and try saving again later.",
+	http.StatusInsufficientStorage: "The wiki has used up the storage it is allowed on the server, so it has not been saved. Your changes are still in your browser: keep the wiki open and ask the server's administrator to make room, e.g. by pruning its archive.",
+}
+
+// ErrorPage is passed to error page templates.
+type ErrorPage struct {
+	Status     int    // HTTP status code
+	StatusText string // standard text for the status, e.g. "Not Found"
+	Message    string // explanation of the status for people using the wiki
+	Detail     string // specifics of this error, if any
+}
+
+// loadErrorPages parses the error page templates in dir. Each is named for
+// the status it is used for, e.g. "412.html", and "error.html" is used for
+// any status without a page of its own.
+func loadErrorPages(dir string) (pages map[string]*template.Template, err error) {
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	pages = make(map[string]*template.Template)
+	for _, fileInfo := range fileInfos {
+		name := fileInfo.Name()
+		if !fileInfo.Mode().IsRegular() || filepath.Ext(name) != ".html" {
+			continue
+		}
+		page, err := template.ParseFiles(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse error page: %w", err)
+		}
+		pages[strings.TrimSuffix(name, ".html")] = page
+	}
+
+	return
+}
+
+// writeError responds with the given error status, explaining it as a web
+// page to browsers and as plain text to everything else (including the
+// TiddlyWiki saver, which shows it to the user). detail may be empty.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	data := ErrorPage{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Message:    errorMessages[status],
+		Detail:     detail,
+	}
+	if data.Message == "" {
+		data.Message = data.StatusText
+	}
+
+	if !strings.Contains(r.Header.Get(headerAccept), "text/html") {
+		w.Header().Set(headerContentType, "text/plain; charset=utf-8")
+		w.Header().Set(headerContentTypeOptions, "nosniff")
+		w.WriteHeader(status)
+		if detail != "" {
+			fmt.Fprintf(w, "%s\n\n%s\n", data.Message, detail)
+		} else {
+			fmt.Fprintln(w, data.Message)
+		}
+		return
+	}
+
+	page, ok := s.errorPages[strconv.Itoa(status)]
+	if !ok {
+		page, ok = s.errorPages["error"]
+	}
+	if !ok {
+		page = errorTemplate
+	}
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	err := page.Execute(w, data)
+	if err != nil {
+		log.Printf("failed to render error page: %v", err)
+	}
+}
+
+var errorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Status}} {{.StatusText}}</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+</style>
+</head>
+<body>
+<h1>{{.StatusText}}</h1>
+<p>{{.Message}}</p>
+{{with .Detail}}<p><small>{{.}}</small></p>{{end}}
+</body>
+</html>
+`))
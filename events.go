@@ -0,0 +1,139 @@
+package putter
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// EventType identifies what happened in an Event.
+type EventType int
+
+// Event types
+const (
+	EventSaveStarted        EventType = iota // a PUT request is being received
+	EventSaveCompleted                       // an upload replaced the live wiki
+	EventSaveFailed                          // a save failed for any reason but a conflict
+	EventConflict                            // a save was rejected with 412 Precondition Failed
+	EventArchived                            // the previous wiki was written to the archive
+	EventArchivePruned                       // an archive was removed by the retention policy
+	EventExternalChange                      // the wiki was modified outside of the server
+	EventSaveHeld                            // a save shrank the wiki too much and was held for approval
+	EventVerificationFailed                  // a saved wiki read back differently from its upload
+)
+
+var eventTypeNames = map[EventType]string{
+	EventSaveStarted:        "SaveStarted",
+	EventSaveCompleted:      "SaveCompleted",
+	EventSaveFailed:         "SaveFailed",
+	EventConflict:           "Conflict",
+	EventArchived:           "Archived",
+	EventArchivePruned:      "ArchivePruned",
+	EventExternalChange:     "ExternalChange",
+	EventSaveHeld:           "SaveHeld",
+	EventVerificationFailed: "VerificationFailed",
+}
+
+func (t EventType) String() string {
+	name, ok := eventTypeNames[t]
+	if !ok {
+		return "Unknown"
+	}
+
+	return name
+}
+
+// Event describes something that happened to the wiki. Fields that don't
+// apply to the event's type are left empty.
+type Event struct {
+	Type         EventType
+	Time         time.Time
+	Request      *http.Request // request that caused the event, if any
+	RequestID    string        // ID of the request, as in its X-Request-ID header and log lines
+	ETag         string        // ETag of the live wiki after the event
+	PreviousETag string        // ETag of the live wiki before the event
+	Size         int64         // size of the uploaded wiki in bytes
+	Status       int           // HTTP status of a failed or conflicting save
+	Archive      string        // name of the archive written, pruned, or restored
+	Duration     time.Duration // how long a completed, failed, or conflicting save took
+}
+
+// eventBus fans events out to subscribers.
+type eventBus struct {
+	mu          sync.Mutex // protects the following
+	nextID      int
+	subscribers map[int]func(Event)
+}
+
+// Subscribe registers fn to be called with every event, returning a function
+// that unsubscribes it. fn is called synchronously, often while the wiki is
+// locked, so it must be quick and must not make requests to the server.
+func (s *Server) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(Event))
+	}
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = fn
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// Events returns a channel receiving every event, buffered to hold size
+// events, and a function that unsubscribes and closes it. Events are dropped
+// rather than blocking the server if the buffer is full.
+func (s *Server) Events(size int) (<-chan Event, func()) {
+	c := make(chan Event, size)
+	var once sync.Once
+	var mu sync.Mutex
+	closed := false
+	unsubscribe := s.Subscribe(func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case c <- e:
+		default:
+		}
+	})
+
+	return c, func() {
+		once.Do(func() {
+			unsubscribe()
+			mu.Lock()
+			defer mu.Unlock()
+			closed = true
+			close(c)
+		})
+	}
+}
+
+// emit sends the event to all subscribers, stamping it with the current time.
+func (s *Server) emit(e Event) {
+	e.Time = time.Now()
+	if e.Request != nil {
+		e.RequestID = server.RequestIDFrom(e.Request.Context())
+	}
+	b := &s.events
+	b.mu.Lock()
+	subscribers := make([]func(Event), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
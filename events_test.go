@@ -0,0 +1,67 @@
+package putter_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/puttertest"
+)
+
+func TestEvents(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+
+	events, unsubscribe := f.server.Events(16)
+	oldEtag := f.etag()
+	res := f.put(testUpdated, nil, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {oldEtag}}, http.StatusPreconditionFailed)
+	f.put(testContent, http.Header{"X-Putter-Sha256": {"bogus"}}, http.StatusBadRequest)
+	unsubscribe()
+
+	want := []putter.EventType{
+		putter.EventSaveStarted,
+		putter.EventArchived,
+		putter.EventSaveCompleted,
+		putter.EventSaveStarted,
+		putter.EventConflict,
+		putter.EventSaveStarted,
+		putter.EventSaveFailed,
+	}
+	var got []putter.Event
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events %v, want %v", len(got), got, want)
+	}
+	for i, e := range got {
+		if e.Type != want[i] {
+			t.Errorf("event %d = %v, want %v", i, e.Type, want[i])
+		}
+	}
+
+	completed := got[2]
+	if completed.ETag != res.Header.Get("ETag") || completed.PreviousETag != oldEtag {
+		t.Errorf("SaveCompleted = %+v, want ETag %s and previous %s",
+			completed, res.Header.Get("ETag"), oldEtag)
+	}
+	if got[6].Status != http.StatusBadRequest {
+		t.Errorf("SaveFailed status = %d, want %d", got[6].Status, http.StatusBadRequest)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	count := 0
+	unsubscribe := f.server.Subscribe(func(e putter.Event) { count++ })
+	f.put(testUpdated, nil, http.StatusOK)
+	unsubscribe()
+	f.put(testContent, nil, http.StatusOK)
+	if count != 2 {
+		t.Errorf("received %d events, want 2", count)
+	}
+}
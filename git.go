@@ -0,0 +1,133 @@
+package putter
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/git"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// gitTimeout bounds how long committing or pushing the wiki may take.
+const gitTimeout = 5 * time.Minute
+
+// gitHistory is the git repository into which saves are committed.
+type gitHistory struct {
+	repo     *git.Repo
+	remote   string        // pushed to after each commit, if not empty
+	work     chan struct{} // signals gitLoop that there is a commit or push to make
+	mu       sync.Mutex    // protects next
+	next     *gitCommit    // commit waiting for gitLoop, if any
+	unpushed bool          // whether there are commits to push; only used by gitLoop
+}
+
+// gitCommit is a commit of the wiki waiting to be made.
+type gitCommit struct {
+	ctx     context.Context // of the save's request, for logging
+	message string
+	author  string
+}
+
+// initGit opens the git repository holding the wiki, creating one if there
+// is none, and commits any changes made while the server wasn't running.
+func (s *Server) initGit() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gitTimeout)
+	defer cancel()
+	repo, err := git.Open(ctx, s.fileName)
+	if err != nil {
+		return
+	}
+	s.git = &gitHistory{repo: repo, remote: s.gitRemote, work: make(chan struct{}, 1)}
+	committed, err := repo.Commit(ctx, "Wiki as found at startup "+time.Now().Format(time.RFC3339), "")
+	if committed {
+		s.git.unpushed = true
+		s.git.work <- struct{}{}
+	}
+
+	return
+}
+
+// commitGit queues a commit of the wiki after each save, with the time and
+// the address of the client that made it in the message, for gitLoop to
+// make without holding up the save. If saves come faster than git commits
+// them, only the latest is committed, holding the changes of the others.
+func (s *Server) commitGit(e Event) {
+	if e.Type != EventSaveCompleted {
+		return
+	}
+	ctx := context.Background()
+	if e.Request != nil {
+		ctx = e.Request.Context()
+	}
+	message := "Save"
+	if e.Archive != "" {
+		message = fmt.Sprintf("Restore of %s", e.Archive)
+	}
+	if addr := s.clientAddr(e.Request); addr != "" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			addr = host
+		}
+		message += " from " + addr
+	}
+	message += " at " + e.Time.Format(time.RFC3339)
+
+	s.git.mu.Lock()
+	s.git.next = &gitCommit{ctx: ctx, message: message, author: authenticatedName(e.Request)}
+	s.git.mu.Unlock()
+	select {
+	case s.git.work <- struct{}{}:
+	default:
+		// gitLoop hasn't picked up the last signal yet, and will see this commit
+	}
+}
+
+// gitLoop makes the commits queued by commitGit, and pushes them to the
+// remote if there is one, until stop is closed. Failures are only logged,
+// as the saves themselves succeeded; a failed push is retried after the
+// next commit.
+func (s *Server) gitLoop(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.git.work:
+		}
+		s.git.mu.Lock()
+		commit := s.git.next
+		s.git.next = nil
+		s.git.mu.Unlock()
+
+		if commit != nil {
+			commitCtx, cancelCommit := context.WithTimeout(ctx, gitTimeout)
+			committed, err := s.git.repo.Commit(commitCtx, commit.message, commit.author)
+			cancelCommit()
+			if err != nil && ctx.Err() == nil {
+				server.Logf(commit.ctx, "failed to commit wiki to git: %v", err)
+			}
+			s.git.unpushed = s.git.unpushed || committed
+		}
+		if s.git.remote == "" || !s.git.unpushed {
+			continue
+		}
+		pushCtx, cancelPush := context.WithTimeout(ctx, gitTimeout)
+		err := s.git.repo.Push(pushCtx, s.git.remote)
+		cancelPush()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("failed to push wiki to git remote: %v", err)
+			}
+			continue
+		}
+		s.git.unpushed = false
+	}
+}
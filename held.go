@@ -0,0 +1,152 @@
+package putter
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/trash"
+)
+
+// extensionHeld is appended to the wiki's name for a save held for approval.
+const extensionHeld = ".held"
+
+// extensionHeldBase is appended to the wiki's name for the ETag of the
+// version that a held save would replace.
+const extensionHeldBase = ".held-base"
+
+// headerConfirmShrink confirms a save that shrinks the wiki by more than the
+// limit given to WithShrinkLimit, so that it isn't held.
+const headerConfirmShrink = "X-Putter-Confirm-Shrink"
+
+// ErrNoHeldSave is returned when there is no save held for approval.
+var ErrNoHeldSave = errors.New("no save is held for approval")
+
+// ErrHeldConflict is returned when approving a held save after the wiki was
+// saved again, which approving it would silently undo.
+var ErrHeldConflict = errors.New("the wiki has been saved since the held save was made")
+
+// HeldSave describes a save held for approval because it shrank the wiki by
+// more than the limit given to WithShrinkLimit.
+type HeldSave struct {
+	Time         time.Time
+	Client       string // address of the client that made the save, if known
+	Size         int64  // size of the held wiki in bytes
+	PreviousSize int64  // size of the live wiki when the save was held, if known
+	BaseETag     string // ETag of the live wiki that the save would replace, if known
+}
+
+// isTooSmall reports whether a save of size bytes would shrink the wiki by
+// more than the limit. The caller must hold the lock.
+func (s *Server) isTooSmall(size int64) bool {
+	return s.shrinkLimit > 0 && size < s.fileInfo.Size()*int64(100-s.shrinkLimit)/100
+}
+
+// holdSave keeps the upload for approval, replacing any save already held.
+// The caller must hold the write lock.
+func (s *Server) holdSave(client, upload string, size int64) (err error) {
+	err = ioutil.WriteFile(s.fileName+extensionHeldBase, []byte(s.etag), s.fileMode)
+	if err != nil {
+		return
+	}
+	err = os.Rename(upload, s.fileName+extensionHeld)
+	if err != nil {
+		return
+	}
+	s.held = &HeldSave{
+		Time:         time.Now(),
+		Client:       client,
+		Size:         size,
+		PreviousSize: s.fileInfo.Size(),
+		BaseETag:     s.etag,
+	}
+
+	return
+}
+
+// loadHeld finds a save held before the server was restarted.
+func (s *Server) loadHeld() {
+	fileInfo, err := os.Stat(s.fileName + extensionHeld)
+	if err != nil {
+		return
+	}
+	log.Printf("a save is held for approval in %s", s.fileName+extensionHeld)
+	s.held = &HeldSave{Time: fileInfo.ModTime(), Size: fileInfo.Size()}
+	// Saves held by older versions have no base, and can't be checked
+	if base, err := ioutil.ReadFile(s.fileName + extensionHeldBase); err == nil {
+		s.held.BaseETag = string(base)
+	}
+}
+
+// Held returns the save held for approval, or nil if there is none.
+func (s *Server) Held() *HeldSave {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.held == nil {
+		return nil
+	}
+	held := *s.held
+
+	return &held
+}
+
+// ApproveHeld replaces the live wiki with the save held for approval. It
+// fails with ErrHeldConflict if the wiki was saved since, as a save with a
+// stale ETag would; the held save can then only be discarded.
+func (s *Server) ApproveHeld(ctx context.Context) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		return ErrNoHeldSave
+	}
+	if s.held.BaseETag != "" && s.held.BaseETag != s.etag {
+		server.Logf(ctx, "refusing to approve held save, conflicting ETag (held : %s, server : %s)", s.held.BaseETag, s.etag)
+		return ErrHeldConflict
+	}
+
+	name := s.fileName + extensionHeld
+	etag, err := s.hashFile(name)
+	if err != nil {
+		return
+	}
+	previousETag := s.etag
+	err = s.replaceWiki(ctx, name, etag)
+	if err != nil {
+		return
+	}
+	s.held = nil
+	os.Remove(s.fileName + extensionHeldBase)
+	server.Logf(ctx, "held save approved, wiki saved successfully")
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		ETag:         s.etag,
+		PreviousETag: previousETag,
+		Size:         s.fileInfo.Size(),
+	})
+
+	return
+}
+
+// DiscardHeld deletes the save held for approval, or moves it to the trash
+// if WithTrash was given.
+func (s *Server) DiscardHeld() (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		return ErrNoHeldSave
+	}
+	_, err = s.trash.Discard(s.fileName+extensionHeld, filepath.Base(s.fileName), trash.ReasonDiscarded)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	s.held = nil
+	os.Remove(s.fileName + extensionHeldBase)
+	log.Println("held save discarded")
+
+	return nil
+}
@@ -0,0 +1,219 @@
+package putter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+const (
+	versionsPath         = "/versions/"
+	headerArchiveVersion = "X-Putter-Archive"
+)
+
+// etagIndex maps the ETags of archived versions to their archives, so that a
+// client holding the ETag of an old version can fetch it.
+type etagIndex struct {
+	mu     sync.Mutex             // protects the following
+	byName map[string]indexedETag // ETags of archives, by name
+}
+
+// indexedETag is the ETag of an archive, valid while its size and
+// modification time are unchanged.
+type indexedETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+// record indexes archives as they are written, fed by the server's events,
+// sparing them from being hashed.
+func (x *etagIndex) record(e Event) {
+	if e.Type != EventArchived {
+		return
+	}
+	fileInfo, err := os.Stat(e.Archive)
+	if err != nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byName == nil {
+		x.byName = make(map[string]indexedETag)
+	}
+	// Archived events give the archive's path
+	x.byName[filepath.Base(e.Archive)] = indexedETag{fileInfo.Size(), fileInfo.ModTime(), e.ETag}
+}
+
+// findArchive returns the name of the newest archive whose ETag is etag, or
+// an empty name if there is none. Archives not yet indexed are hashed,
+// newest first, until it is found.
+func (s *Server) findArchive(etag string) (name string, err error) {
+	entries, err := s.archiver.List()
+	if err != nil {
+		return
+	}
+	x := &s.etags
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.byName == nil {
+		x.byName = make(map[string]indexedETag)
+	}
+	listed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		listed[entry.Name] = true
+	}
+	for name := range x.byName {
+		if !listed[name] {
+			delete(x.byName, name)
+		}
+	}
+
+	for _, entry := range entries {
+		indexed, ok := x.byName[entry.Name]
+		if !ok || indexed.size != entry.Size || !indexed.modTime.Equal(entry.ModTime) {
+			indexed = indexedETag{size: entry.Size, modTime: entry.ModTime}
+			indexed.etag, err = s.hashArchive(entry.Name)
+			if err != nil {
+				return "", err
+			}
+			x.byName[entry.Name] = indexed
+		}
+		if indexed.etag == etag {
+			return entry.Name, nil
+		}
+	}
+
+	return "", nil
+}
+
+// hashArchive computes the ETag the named archive had as the live wiki.
+func (s *Server) hashArchive(name string) (etag string, err error) {
+	f, err := s.archiver.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	hash := s.newHash()
+	_, err = io.Copy(hash, f)
+	if err != nil {
+		return
+	}
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", nil
+}
+
+// openETag opens the version whose ETag is etag if it is kept in memory or is
+// the live wiki, returning nil otherwise.
+func (s *Server) openETag(etag string) (io.ReadCloser, error) {
+	if data := s.versions.find(etag, ""); data != nil {
+		return memoryFile{bytes.NewReader(data)}, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if etag != s.etag {
+		return nil, nil
+	}
+
+	// Saves rename over the wiki, so what is opened stays this version
+	return os.Open(s.fileName)
+}
+
+// VersionsHandler returns a handler serving the version of the wiki whose
+// ETag follows its path, e.g. "/versions/<etag>", with or without quotes,
+// whether it is the live wiki or an archived version. A client whose save
+// was refused with 412 Precondition Failed can fetch the version it was
+// based on, to merge its changes or compare them with the live wiki. The
+// archive holding the version is named by the X-Putter-Archive header.
+func (s *Server) VersionsHandler() http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		etag := strings.TrimPrefix(r.URL.Path, "/")
+		if etag == "" || strings.Contains(etag, "/") {
+			s.writeError(w, r, http.StatusNotFound, "")
+			return
+		}
+		if !strings.HasPrefix(etag, `"`) {
+			etag = `"` + etag + `"`
+		}
+
+		s.refreshIfModified()
+		f, name, err := s.openETagOrArchive(etag)
+		if err != nil {
+			server.Logf(r.Context(), "failed to read version %s: %v", etag, err)
+			s.writeError(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		if f == nil {
+			s.writeError(w, r, http.StatusNotFound, "There is no version with that ETag in the archive.")
+			return
+		}
+		defer f.Close()
+		s.serveVersion(w, r, f, etag, name)
+	}
+
+	return server.WhitelistMethods(http.HandlerFunc(handlerFunc), http.MethodGet, http.MethodHead)
+}
+
+// openETagOrArchive opens the version whose ETag is etag, whether it is kept
+// in memory, the live wiki, or in the archive, returning the name of the
+// archive it was found in, if any, or nil if there is no such version.
+func (s *Server) openETagOrArchive(etag string) (f io.ReadCloser, name string, err error) {
+	f, err = s.openETag(etag)
+	if f == nil && err == nil {
+		name, err = s.findArchive(etag)
+		if err == nil && name != "" {
+			f, err = s.openVersion(name)
+		}
+	}
+
+	return
+}
+
+// serveVersion serves a version of the wiki read from f, sandboxed like the
+// archive, with its ETag, if known, and the name of its archive, if any.
+func (s *Server) serveVersion(w http.ResponseWriter, r *http.Request, f io.Reader, etag, name string) {
+	w.Header().Set(headerContentSecurityPolicy, archiveSecurityPolicy)
+	if etag != "" {
+		w.Header().Set(headerEtag, etag)
+	}
+	if name != "" {
+		w.Header().Set(headerArchiveVersion, name)
+	}
+	if s.contentType != "" {
+		w.Header().Set(headerContentType, s.contentType)
+	} else {
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	}
+	w, done := s.countDownload(w, r)
+	defer done()
+	// Versions never change, so the ETag alone answers conditional requests
+	if content, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", time.Time{}, content)
+		return
+	}
+	// Compressed archives are decompressed as they are sent, so their
+	// length isn't known and ranges aren't supported
+	if etag != "" && r.Header.Get(headerIfNoneMatch) != "" && isNotModified(r, etag, time.Time{}) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_, err := io.Copy(w, f)
+	if err != nil && name != "" {
+		server.Logf(r.Context(), "failed to serve version %s: %v", name, err)
+	} else if err != nil {
+		server.Logf(r.Context(), "failed to serve version %s: %v", etag, err)
+	}
+}
@@ -0,0 +1,107 @@
+package putter
+
+import (
+	"net/http"
+)
+
+// Middleware decorates the handler returned by NewHandler, e.g. to add
+// authentication or auditing. It sees every request, including archive ones.
+type Middleware func(http.Handler) http.Handler
+
+// SaveContext describes a save in progress to BeforeSave and AfterSave hooks.
+type SaveContext struct {
+	Request      *http.Request // the PUT request; its body has been consumed
+	Upload       string        // file holding the upload (the live wiki, after saving)
+	Size         int64         // size of the uploaded wiki in bytes
+	PreviousETag string        // ETag of the live wiki before the save
+	ETag         string        // ETag of the uploaded wiki
+	DryRun       bool          // whether the save is only being checked, so hooks should change nothing
+}
+
+// ConflictContext describes a save rejected because of a conflicting ETag.
+type ConflictContext struct {
+	Request    *http.Request // the PUT request; its body has been consumed
+	ClientETag string        // ETag the client expected (from If-Match)
+	ServerETag string        // ETag of the live wiki
+}
+
+// Hooks are called at points in the save pipeline. Any of them may be nil.
+// Hooks run while the wiki is locked, so they must not make requests to the
+// server they are registered on.
+type Hooks struct {
+	// BeforeSave is called once the upload has been received and checked,
+	// before the live wiki is touched. Returning an error aborts the save; the
+	// client receives the error's status if it is a *StatusError, otherwise
+	// 403 Forbidden. It is also called for dry runs, to check them.
+	BeforeSave func(*SaveContext) error
+	// AfterSave is called once the upload has replaced the live wiki.
+	AfterSave func(*SaveContext)
+	// OnConflict is called when a save is rejected with 412 Precondition Failed.
+	OnConflict func(*ConflictContext)
+}
+
+// StatusError is an error carrying the HTTP status with which a request
+// should be rejected.
+type StatusError struct {
+	Code    int    // HTTP status code
+	Message string // message sent to the client
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Use registers middleware to wrap the handler returned by NewHandler. The
+// first middleware registered is the outermost. Use must be called before
+// NewHandler.
+func (s *Server) Use(middleware ...Middleware) {
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// AddHooks registers hooks to be called during saves. It must be called
+// before the server starts handling requests.
+func (s *Server) AddHooks(hooks Hooks) {
+	s.hooks = append(s.hooks, hooks)
+}
+
+// wrap applies the registered middleware to h.
+func (s *Server) wrap(h http.Handler) http.Handler {
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+
+	return h
+}
+
+// beforeSave runs the BeforeSave hooks, stopping at the first error.
+func (s *Server) beforeSave(ctx *SaveContext) error {
+	for _, hooks := range s.hooks {
+		if hooks.BeforeSave == nil {
+			continue
+		}
+		err := hooks.BeforeSave(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// afterSave runs the AfterSave hooks.
+func (s *Server) afterSave(ctx *SaveContext) {
+	for _, hooks := range s.hooks {
+		if hooks.AfterSave != nil {
+			hooks.AfterSave(ctx)
+		}
+	}
+}
+
+// onConflict runs the OnConflict hooks.
+func (s *Server) onConflict(ctx *ConflictContext) {
+	for _, hooks := range s.hooks {
+		if hooks.OnConflict != nil {
+			hooks.OnConflict(ctx)
+		}
+	}
+}
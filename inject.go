@@ -0,0 +1,42 @@
+package putter
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// bodyEnd is the tag before which WithInjection's snippet is inserted.
+var bodyEnd = []byte("</body>")
+
+// inject returns the wiki read from f with the snippet given to WithInjection
+// inserted before its last closing body tag, or at the end if it has none.
+// If the wiki already holds the snippet, as TiddlyWiki Classic saves it, or is
+// too large to load within the memory limit, f is returned as it is.
+func (s *Server) inject(ctx context.Context, f *os.File, size int64) (io.ReadSeeker, int64, error) {
+	data, err := s.readAll(f)
+	if err == errTooLarge {
+		server.Logf(ctx, "serving wiki without injection: %v", err)
+		_, err = f.Seek(0, io.SeekStart)
+		return f, size, err
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if bytes.Contains(data, s.injection) {
+		return bytes.NewReader(data), int64(len(data)), nil
+	}
+	i := bytes.LastIndex(data, bodyEnd)
+	if i < 0 {
+		i = len(data)
+	}
+	injected := make([]byte, 0, len(data)+len(s.injection))
+	injected = append(injected, data[:i]...)
+	injected = append(injected, s.injection...)
+	injected = append(injected, data[i:]...)
+
+	return bytes.NewReader(injected), int64(len(injected)), nil
+}
@@ -0,0 +1,229 @@
+// Package archive preserves previous versions of the wiki in a directory.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/config"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+	"github.com/djcrock/putter/internal/trash"
+)
+
+// Modes control how files are written to the archive directory.
+const (
+	ModeAuto    = "auto"    // reflink where supported, otherwise copy
+	ModeCopy    = "copy"    // always copy
+	ModeLink    = "link"    // hard link, falling back to copy
+	ModeReflink = "reflink" // reflink, falling back to copy
+)
+
+// ValidMode reports whether mode is one of the archive modes. The empty string
+// is valid and equivalent to ModeCopy.
+func ValidMode(mode string) bool {
+	switch mode {
+	case "", ModeAuto, ModeCopy, ModeLink, ModeReflink:
+		return true
+	}
+
+	return false
+}
+
+// Archiver writes copies of a file into an archive directory, named for the
+// time at which they were archived. With Sequence, names also begin with a
+// number that increases with each archive, e.g. 000042_, so that their order
+// survives changes to the clock.
+type Archiver struct {
+	Dir      string          // directory to archive to
+	Format   string          // time format of archive filenames, or one of Presets
+	Mode     string          // how archives are written
+	WarnSize config.ByteSize // size past which warnings are logged
+	MaxSize  config.ByteSize // size past which archiving stops
+	FileMode os.FileMode     // permissions for archives
+	DirMode  os.FileMode     // permissions for the archive directory
+	Sequence bool            // whether names begin with a sequence number
+	Trash    *trash.Trash    // where pruned archives are moved, or nil to delete them
+	Procs    int             // cores on which archives are gzipped, or 0 for all
+
+	// Compression is the algorithm with which archives are compressed as
+	// they are written, in which case Mode doesn't apply, or empty to write
+	// them as they are.
+	Compression string
+
+	size config.ByteSize // total size of the archive directory
+	seq  int64           // last sequence number, or 0 if not yet known
+}
+
+// Archive writes a copy of src to the archive, returning its name. If the
+// archive directory is over its size limit, nothing is written and the
+// returned name is empty.
+func (a *Archiver) Archive(ctx context.Context, src string, t time.Time) (name string, err error) {
+	err = storage.Mkdir(a.Dir, a.DirMode)
+	if err != nil {
+		return
+	}
+
+	isGuard := a.WarnSize > 0 || a.MaxSize > 0
+	if isGuard {
+		var size int64
+		size, err = storage.DirSize(a.Dir)
+		if err != nil {
+			return
+		}
+		a.size = config.ByteSize(size)
+	}
+	if a.MaxSize > 0 && a.size >= a.MaxSize {
+		log.Printf(
+			"archive directory is over its size limit (%v of %v), not archiving",
+			a.size, a.MaxSize,
+		)
+		return
+	}
+
+	name = FormatName(a.Format, t)
+	if a.Sequence {
+		var seq int64
+		seq, err = a.nextSequence()
+		if err != nil {
+			return
+		}
+		name = fmt.Sprintf("%0*d_%s", sequenceDigits, seq, name)
+	}
+	name = filepath.Join(a.Dir, name+Extension(a.Compression))
+	err = a.write(ctx, src, name)
+	if err != nil || !isGuard {
+		return
+	}
+
+	fileInfo, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	a.size += config.ByteSize(fileInfo.Size())
+	if a.WarnSize > 0 && a.size >= a.WarnSize {
+		log.Printf(
+			"warning: archive directory has grown to %v, over the threshold of %v",
+			a.size, a.WarnSize,
+		)
+	}
+
+	return
+}
+
+// write writes a copy of src to name using the configured mode, falling back
+// to a plain copy where necessary.
+func (a *Archiver) write(ctx context.Context, src, name string) (err error) {
+	if a.Compression != "" {
+		return a.writeCompressed(ctx, src, name)
+	}
+	switch a.Mode {
+	case ModeLink:
+		// The live wiki is only ever replaced by renaming a new file over it,
+		// so the old contents can safely live on under a second name.
+		err = os.Link(src, name)
+		if err == nil {
+			server.Logf(ctx, "archived wiki to %s (hard link)", name)
+			return
+		}
+		server.Logf(ctx, "failed to hard link archive, falling back to copy: %v", err)
+	case ModeReflink, ModeAuto:
+		err = storage.Reflink(src, name, a.FileMode)
+		if err == nil {
+			server.Logf(ctx, "archived wiki to %s (reflink)", name)
+			return
+		}
+		if a.Mode == ModeReflink {
+			server.Logf(ctx, "failed to reflink archive, falling back to copy: %v", err)
+		}
+	}
+
+	err = storage.CopyFile(ctx, src, name, a.FileMode)
+	if err != nil {
+		return
+	}
+	server.Logf(ctx, "archived wiki to %s", name)
+
+	return
+}
+
+// Entry describes a file in the archive directory.
+type Entry struct {
+	Name     string    // base name of the archive
+	Size     int64     // size in bytes
+	ModTime  time.Time // when the archived version was written
+	Sequence int64     // sequence number at the start of its name, or 0 if it has none
+	SavedBy  string    // who saved the version, if the server knows
+}
+
+// List returns the archives in the archive directory, newest first (see
+// sortEntries). A missing archive directory is treated as empty.
+func (a *Archiver) List() (entries []Entry, err error) {
+	fileInfos, err := ioutil.ReadDir(a.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		// Hidden files are temporary, e.g. archives being recompressed
+		if !fileInfo.Mode().IsRegular() || strings.HasPrefix(fileInfo.Name(), ".") {
+			continue
+		}
+		entry := Entry{
+			Name:    fileInfo.Name(),
+			Size:    fileInfo.Size(),
+			ModTime: fileInfo.ModTime(),
+		}
+		if a.Sequence {
+			entry.Sequence = parseSequence(entry.Name)
+		}
+		entries = append(entries, entry)
+	}
+	sortEntries(entries)
+
+	return
+}
+
+// Path returns the path of the named archive, or an error if name does not
+// refer to a file directly inside the archive directory.
+func (a *Archiver) Path(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid archive name %q", name)
+	}
+
+	return filepath.Join(a.Dir, name), nil
+}
+
+// Prune removes all but the newest keep archives, moving them to the Trash if
+// there is one, and returns the names of the archives removed.
+func (a *Archiver) Prune(keep int) (removed []string, err error) {
+	entries, err := a.List()
+	if err != nil || len(entries) <= keep {
+		return
+	}
+
+	return a.remove(entries[keep:])
+}
+
+// remove removes the archives of entries, moving them to the Trash if there
+// is one, and returns their names.
+func (a *Archiver) remove(entries []Entry) (removed []string, err error) {
+	for _, entry := range entries {
+		_, err = a.Trash.Discard(filepath.Join(a.Dir, entry.Name), entry.Name, trash.ReasonPruned)
+		if err != nil {
+			return
+		}
+		log.Printf("pruned archive %s", entry.Name)
+		removed = append(removed, entry.Name)
+	}
+
+	return
+}
@@ -0,0 +1,418 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/djcrock/putter/internal/compress"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// Algorithms with which archives can be recompressed.
+const (
+	AlgorithmGzip = "gzip" // built in, for when neither program is installed
+	AlgorithmXz   = "xz"   // requires the xz program
+	AlgorithmZstd = "zstd" // requires the zstd program
+)
+
+// compressor compresses archives with an algorithm, identified by the
+// extension it adds to their names.
+type compressor struct {
+	extension  string
+	compress   []string // command compressing standard input to standard output
+	fast       []string // compress, but quick enough to run on every save
+	decompress []string // command decompressing standard input to standard output
+}
+
+// compressors are the external programs used for algorithms that aren't in
+// the standard library.
+var compressors = map[string]compressor{
+	AlgorithmXz: {
+		extension:  ".xz",
+		compress:   []string{"xz", "-9e", "-c"},
+		fast:       []string{"xz", "-3", "-c"},
+		decompress: []string{"xz", "-dc"},
+	},
+	AlgorithmZstd: {
+		extension:  ".zst",
+		compress:   []string{"zstd", "-19", "--long=27", "-q", "-c"},
+		fast:       []string{"zstd", "-9", "-q", "-c"}, // without --long, so browsers can decompress it
+		decompress: []string{"zstd", "-dqc", "--long=27"},
+	},
+}
+
+// gzipExtension is the extension of gzip-compressed archives.
+const gzipExtension = ".gz"
+
+// ValidAlgorithm reports whether algorithm is one of the recompression
+// algorithms.
+func ValidAlgorithm(algorithm string) bool {
+	_, ok := compressors[algorithm]
+
+	return ok || algorithm == AlgorithmGzip
+}
+
+// Extension returns the extension that algorithm adds to archive names.
+func Extension(algorithm string) string {
+	if algorithm == AlgorithmGzip {
+		return gzipExtension
+	}
+
+	return compressors[algorithm].extension
+}
+
+// IsCompressed reports whether the named archive is compressed, returning the
+// name it had before it was.
+func IsCompressed(name string) (original string, ok bool) {
+	ext := filepath.Ext(name)
+	if ext == gzipExtension {
+		return strings.TrimSuffix(name, ext), true
+	}
+	for _, c := range compressors {
+		if ext == c.extension {
+			return strings.TrimSuffix(name, ext), true
+		}
+	}
+
+	return name, false
+}
+
+// Available reports whether the program needed by algorithm is installed.
+func Available(algorithm string) bool {
+	c, ok := compressors[algorithm]
+	if !ok {
+		return algorithm == AlgorithmGzip
+	}
+	_, err := exec.LookPath(c.compress[0])
+
+	return err == nil
+}
+
+// Open opens the named archive for reading, decompressing it if it was
+// compressed.
+func (a *Archiver) Open(name string) (io.ReadCloser, error) {
+	path, err := a.Path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenFile(path)
+}
+
+// OpenFile opens the named file for reading, decompressing it if its
+// extension is that of one of the algorithms.
+func OpenFile(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	ext := filepath.Ext(name)
+	if ext == gzipExtension {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		return &multiCloser{Reader: gz, closers: []io.Closer{gz, f}}, nil
+	}
+	for _, c := range compressors {
+		if ext != c.extension {
+			continue
+		}
+		cmd := exec.Command(c.decompress[0], c.decompress[1:]...)
+		cmd.Stdin = f
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.StdoutPipe()
+		if err == nil {
+			err = cmd.Start()
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to decompress %s: %v", name, err)
+		}
+		return &commandReader{ReadCloser: out, cmd: cmd, file: f, stderr: &stderr}, nil
+	}
+
+	return f, nil
+}
+
+// multiCloser is a reader that closes several things when it is closed.
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() (err error) {
+	for _, c := range m.closers {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return
+}
+
+// commandReader reads the output of a decompression program.
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	file   *os.File
+	stderr *bytes.Buffer
+}
+
+// Close waits for the program to exit, reporting whether it failed.
+func (r *commandReader) Close() error {
+	// Unblock the program if the output wasn't read to the end
+	io.Copy(ioutil.Discard, r.ReadCloser)
+	err := r.cmd.Wait()
+	r.file.Close()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", r.cmd.Args[0], err, strings.TrimSpace(r.stderr.String()))
+	}
+
+	return nil
+}
+
+// Recompress compresses the named archive with algorithm, replacing it with
+// a file named with the algorithm's extension and the same modification time.
+// Archives that were already compressed are decompressed first.
+func (a *Archiver) Recompress(ctx context.Context, name, algorithm string) (newName string, err error) {
+	original, _ := IsCompressed(name)
+	newName = original + Extension(algorithm)
+	src, err := a.Path(name)
+	if err != nil {
+		return
+	}
+	fileInfo, err := os.Stat(src)
+	if err != nil {
+		return
+	}
+	in, err := a.Open(name)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	dst := filepath.Join(a.Dir, newName)
+	f, err := ioutil.TempFile(a.Dir, ".recompress-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = Compress(ctx, f, in, algorithm)
+	if err == nil {
+		err = in.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	err = os.Chmod(f.Name(), a.FileMode)
+	if err != nil {
+		return
+	}
+	// Keep the archive's place in the history
+	err = os.Chtimes(f.Name(), fileInfo.ModTime(), fileInfo.ModTime())
+	if err != nil {
+		return
+	}
+	// The archive may have been pruned in the meantime
+	_, err = os.Stat(src)
+	if err != nil {
+		return
+	}
+	err = os.Rename(f.Name(), dst)
+	if err != nil {
+		return
+	}
+	if dst != src {
+		err = os.Remove(src)
+	}
+
+	return
+}
+
+// writeCompressed writes a copy of src to name, compressed with Compression.
+func (a *Archiver) writeCompressed(ctx context.Context, src, name string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+	f, err := ioutil.TempFile(a.Dir, ".compress-*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	err = compressWith(ctx, f, in, a.Compression, true, a.Procs)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), a.FileMode)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		return
+	}
+	server.Logf(ctx, "archived wiki to %s (%s)", name, a.Compression)
+
+	return
+}
+
+// Compress writes the contents of src to dst, compressed with algorithm.
+func Compress(ctx context.Context, dst io.Writer, src io.Reader, algorithm string) (err error) {
+	return compressWith(ctx, dst, src, algorithm, false, 0)
+}
+
+// compressWith is Compress, trading size for speed if fast is set and
+// compressing gzip on up to procs cores, or all of them if procs is 0.
+func compressWith(ctx context.Context, dst io.Writer, src io.Reader, algorithm string, fast bool, procs int) (err error) {
+	if algorithm == AlgorithmGzip {
+		level := gzip.BestCompression
+		if fast {
+			level = gzip.DefaultCompression
+		}
+		gz, err := compress.NewParallelWriter(dst, level, procs)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(gz, src)
+		if err != nil {
+			gz.Close()
+			return err
+		}
+		return gz.Close()
+	}
+
+	c, ok := compressors[algorithm]
+	if !ok {
+		return fmt.Errorf("invalid algorithm %q", algorithm)
+	}
+	args := c.compress
+	if fast {
+		args = c.fast
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = src
+	cmd.Stdout = dst
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		err = fmt.Errorf("%s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return
+}
+
+// HTTP content codings of compressed archives.
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+// zstdMaxWindow is the largest window that HTTP clients must support to
+// decompress the zstd content coding (RFC 9659).
+const zstdMaxWindow = 8 << 20
+
+// ContentEncoding returns the HTTP content coding in which the named archive
+// can be sent as it is, for clients to decompress themselves, or "" if it
+// can't be: it isn't compressed, or not in a way that browsers support.
+func ContentEncoding(name string) (encoding string, err error) {
+	ext := filepath.Ext(name)
+	if ext != gzipExtension && ext != compressors[AlgorithmZstd].extension {
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	header := make([]byte, 18)
+	n, err := io.ReadFull(f, header)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+	// Every browser decompresses gzip, which putter writes as a single
+	// member whatever the level
+	if ext == gzipExtension && bytes.HasPrefix(header[:n], []byte{0x1f, 0x8b}) {
+		encoding = EncodingGzip
+	}
+	if window, ok := zstdWindow(header[:n]); ok && window <= zstdMaxWindow {
+		encoding = EncodingZstd
+	}
+
+	return
+}
+
+// zstdWindow returns the window size declared by the header of a zstd frame,
+// reporting whether it is one, without a dictionary, which HTTP clients
+// don't have.
+func zstdWindow(header []byte) (window uint64, ok bool) {
+	if len(header) < 6 || !bytes.Equal(header[:4], []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return
+	}
+	descriptor := header[4]
+	if descriptor&0x03 != 0 {
+		return
+	}
+	if descriptor&0x20 == 0 {
+		// The window descriptor gives an exponent and an eighth to add
+		exponent, mantissa := uint(header[5]>>3), uint64(header[5]&0x07)
+		base := uint64(1) << (10 + exponent)
+		return base + base/8*mantissa, true
+	}
+
+	// A single segment's window is its content size
+	var size int
+	switch descriptor >> 6 {
+	case 0:
+		size = 1
+	case 1:
+		size = 2
+	case 2:
+		size = 4
+	case 3:
+		size = 8
+	}
+	if len(header) < 5+size {
+		return
+	}
+	for i := size - 1; i >= 0; i-- {
+		window = window<<8 | uint64(header[5+i])
+	}
+	if size == 2 {
+		window += 256
+	}
+
+	return window, true
+}
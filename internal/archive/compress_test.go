@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := &Archiver{Dir: dir, FileMode: 0644}
+	content := strings.Repeat("<html>archived</html>\n", 100)
+	name := "2006-01-02.html"
+	err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	err = os.Chtimes(filepath.Join(dir, name), modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each algorithm recompresses the previous one's output
+	for _, algorithm := range []string{AlgorithmGzip, AlgorithmXz, AlgorithmZstd, AlgorithmGzip} {
+		if c, ok := compressors[algorithm]; ok {
+			if _, err := exec.LookPath(c.compress[0]); err != nil {
+				t.Logf("skipping %s: %v", algorithm, err)
+				continue
+			}
+		}
+		name, err = a.Recompress(context.Background(), name, algorithm)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if want := "2006-01-02.html" + Extension(algorithm); name != want {
+			t.Errorf("%s: name = %s, want %s", algorithm, name, want)
+		}
+		entries, err := a.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name != name || !entries[0].ModTime.Equal(modTime) {
+			t.Errorf("%s: entries = %+v", algorithm, entries)
+		}
+		if entries[0].Size >= int64(len(content)) {
+			t.Errorf("%s: compressed to %d bytes from %d", algorithm, entries[0].Size, len(content))
+		}
+
+		f, err := a.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(f)
+		if err == nil {
+			err = f.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: decompressed = %q", algorithm, data)
+		}
+	}
+}
+
+func TestIsCompressed(t *testing.T) {
+	for name, want := range map[string]string{
+		"a.html":     "",
+		"a.html.gz":  "a.html",
+		"a.html.xz":  "a.html",
+		"a.html.zst": "a.html",
+	} {
+		original, ok := IsCompressed(name)
+		if ok != (want != "") || (ok && original != want) {
+			t.Errorf("IsCompressed(%q) = %q, %v", name, original, ok)
+		}
+	}
+}
+
+func TestArchiveCompressed(t *testing.T) {
+	if !Available(AlgorithmZstd) {
+		t.Skip("zstd is not installed")
+	}
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := strings.Repeat("<html>archived</html>\n", 100)
+	src := filepath.Join(dir, "index.html")
+	err = ioutil.WriteFile(src, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{Dir: filepath.Join(dir, "old"), Format: "2006-01-02.html", FileMode: 0644, DirMode: 0755, Compression: AlgorithmZstd}
+
+	name, err := a.Archive(context.Background(), src, time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(a.Dir, "2006-01-02.html.zst"); name != want {
+		t.Errorf("archived to %s, want %s", name, want)
+	}
+	f, err := a.Open(filepath.Base(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil || string(data) != content {
+		t.Errorf("decompressed = %q, %v", data, err)
+	}
+	if encoding, err := ContentEncoding(name); encoding != EncodingZstd || err != nil {
+		t.Errorf("content encoding = %q, %v, want %q", encoding, err, EncodingZstd)
+	}
+
+	// Recompressing for size uses a window too large for browsers
+	recompressed, err := a.Recompress(context.Background(), filepath.Base(name), AlgorithmZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding, err := ContentEncoding(filepath.Join(a.Dir, recompressed)); encoding != "" || err != nil {
+		t.Errorf("content encoding of recompressed archive = %q, %v, want none", encoding, err)
+	}
+}
+
+func TestZstdWindow(t *testing.T) {
+	for _, test := range []struct {
+		header []byte
+		window uint64
+		ok     bool
+	}{
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x60}, 4 << 20, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x88}, 128 << 20, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x04, 0x89}, 144 << 20, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x24, 0x03}, 3, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x64, 0x00, 0x01}, 512, true},
+		{[]byte{0x28, 0xb5, 0x2f, 0xfd, 0x05, 0x60, 0x01}, 0, false},
+		{[]byte("<html>"), 0, false},
+	} {
+		window, ok := zstdWindow(test.header)
+		if ok != test.ok || ok && window != test.window {
+			t.Errorf("zstdWindow(%x) = %d, %v, want %d, %v", test.header, window, ok, test.window, test.ok)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package archive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UnixMilli in a format is replaced with the number of milliseconds since the
+// Unix epoch, which Go's time layouts can't express.
+const UnixMilli = "{unixmilli}"
+
+// Presets name formats that can be given instead of a Go time layout.
+const (
+	PresetISO8601   = "iso8601"
+	PresetRFC3339   = "rfc3339"
+	PresetUnixEpoch = "unix-epoch"
+	PresetHuman     = "human"
+)
+
+// Presets are the formats that presets stand for. Colons, which not every
+// file system allows, are left out.
+var Presets = map[string]string{
+	PresetISO8601:   "20060102T150405.000Z0700.html",
+	PresetRFC3339:   "2006-01-02T15-04-05.000Z0700.html",
+	PresetUnixEpoch: UnixMilli + ".html",
+	PresetHuman:     "2 Jan 2006 15.04.05.000.html",
+}
+
+// formatCheckBase is the time around which formats are checked, with every
+// field distinct so that no field can be mistaken for another.
+var formatCheckBase = time.Date(2001, time.February, 3, 4, 5, 6, 7e6, time.UTC)
+
+// formatCheckSteps are the smallest intervals between archives that every
+// format must be able to tell apart.
+var formatCheckSteps = []struct {
+	years, months int
+	step          time.Duration
+	name          string
+}{
+	{0, 0, time.Millisecond, "a millisecond"},
+	{0, 0, time.Second, "a second"},
+	{0, 0, time.Minute, "a minute"},
+	{0, 0, time.Hour, "an hour"},
+	{0, 0, 12 * time.Hour, "12 hours"},
+	{0, 0, 24 * time.Hour, "a day"},
+	{0, 1, 0, "a month"},
+	{1, 0, 0, "a year"},
+}
+
+// FormatName returns the name of the archive of a version archived at t,
+// according to format, which may be a Go time layout or one of Presets.
+func FormatName(format string, t time.Time) string {
+	if preset, ok := Presets[format]; ok {
+		format = preset
+	}
+	name := t.Format(format)
+
+	return strings.Replace(name, UnixMilli, strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10), -1)
+}
+
+// ParseName returns the time at which the named archive was archived, reading
+// its name as FormatName wrote it according to format, with any sequence
+// number and compression extension, and with times in loc unless the format
+// gives a zone. Names not written with format, such as those of imported
+// versions, aren't understood.
+func ParseName(format, name string, loc *time.Location) (t time.Time, ok bool) {
+	if preset, ok := Presets[format]; ok {
+		format = preset
+	}
+	if original, ok := IsCompressed(name); ok {
+		name = original
+	}
+	t, ok = parseName(format, name, loc)
+	if !ok && parseSequence(name) > 0 {
+		t, ok = parseName(format, name[strings.IndexByte(name, '_')+1:], loc)
+	}
+
+	return
+}
+
+// parseName parses a name without a sequence number or compression extension.
+func parseName(format, name string, loc *time.Location) (time.Time, bool) {
+	i := strings.Index(format, UnixMilli)
+	if i < 0 {
+		t, err := time.ParseInLocation(format, name, loc)
+		return t, err == nil
+	}
+	prefix, suffix := format[:i], format[i+len(UnixMilli):]
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(name[len(prefix):len(name)-len(suffix)], 10, 64)
+
+	return time.Unix(0, ms*int64(time.Millisecond)), err == nil
+}
+
+// CheckFormat returns an error if format would give versions archived
+// within a millisecond, second, minute, etc. of each other the same name,
+// which would make them collide. The error explains the interval.
+func CheckFormat(format string) error {
+	base := FormatName(format, formatCheckBase)
+	for _, check := range formatCheckSteps {
+		later := formatCheckBase.AddDate(check.years, check.months, 0).Add(check.step)
+		if FormatName(format, later) == base {
+			return fmt.Errorf("archive format %q gives versions archived %s apart the same name, so they may overwrite each other", format, check.name)
+		}
+	}
+
+	return nil
+}
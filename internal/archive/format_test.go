@@ -0,0 +1,60 @@
+package archive
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatName(t *testing.T) {
+	at := time.Date(2024, time.May, 1, 13, 45, 6, 123456789, time.UTC)
+	for format, want := range map[string]string{
+		PresetISO8601:                  "20240501T134506.123Z.html",
+		PresetRFC3339:                  "2024-05-01T13-45-06.123Z.html",
+		PresetUnixEpoch:                "1714571106123.html",
+		PresetHuman:                    "1 May 2024 13.45.06.123.html",
+		"2006-01-02-15-04-05.000.html": "2024-05-01-13-45-06.123.html",
+	} {
+		if name := FormatName(format, at); name != want {
+			t.Errorf("FormatName(%q) = %q, want %q", format, name, want)
+		}
+	}
+}
+
+func TestParseName(t *testing.T) {
+	at := time.Date(2024, time.May, 1, 13, 45, 6, 123e6, time.UTC)
+	for format, name := range map[string]string{
+		PresetISO8601:                  "20240501T134506.123Z.html",
+		PresetRFC3339:                  "000042_2024-05-01T13-45-06.123Z.html.gz",
+		PresetUnixEpoch:                "1714571106123.html.zst",
+		PresetHuman:                    "1 May 2024 13.45.06.123.html",
+		"2006-01-02-15-04-05.000.html": "2024-05-01-13-45-06.123.html",
+	} {
+		if parsed, ok := ParseName(format, name, time.UTC); !ok || !parsed.Equal(at) {
+			t.Errorf("ParseName(%q, %q) = %v, %v, want %v", format, name, parsed, ok, at)
+		}
+	}
+	if _, ok := ParseName(PresetRFC3339, "imported.html", time.UTC); ok {
+		t.Error("ParseName understood a name not written with its format")
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	for preset := range Presets {
+		if err := CheckFormat(preset); err != nil {
+			t.Errorf("preset %s: %v", preset, err)
+		}
+	}
+	for format, want := range map[string]string{
+		"2006-01-02-15-04-05.html":     "a millisecond",
+		"2006-01-02-3-04-05.000.html":  "12 hours",
+		"2006-01-02.html":              "a millisecond",
+		"01-02-15-04-05.000.html":      "a year",
+		"2006-01-02-15-04-05.000.html": "",
+	} {
+		err := CheckFormat(format)
+		if want == "" && err != nil || want != "" && (err == nil || !strings.Contains(err.Error(), want+" apart")) {
+			t.Errorf("CheckFormat(%q) = %v, want a collision %s apart", format, err, want)
+		}
+	}
+}
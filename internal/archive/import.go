@@ -0,0 +1,116 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// backupTime matches the time at which a backup was saved in its name, in the
+// forms used by putter and most other savers, e.g. 2024-05-01-13-45-00.000,
+// 20240501134500000 (TiddlyWiki), or 2024-05-01T13:45:00.
+var backupTime = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})(?:[-_.T ]?(\d{2})[-:.]?(\d{2})[-:.]?(\d{2})(?:[.,]?(\d{3}))?)?`)
+
+// BackupTime returns the time at which a backup was saved, as given in its
+// name in the time zone loc, or false if its name doesn't give one.
+func BackupTime(name string, loc *time.Location) (t time.Time, ok bool) {
+	for _, m := range backupTime.FindAllStringSubmatch(filepath.Base(name), -1) {
+		n := make([]int, len(m))
+		for i, s := range m[1:] {
+			n[i+1], _ = strconv.Atoi(s)
+		}
+		t = time.Date(n[1], time.Month(n[2]), n[3], n[4], n[5], n[6], n[7]*int(time.Millisecond), loc)
+		// Reject anything that isn't a real time, e.g. a version number
+		if t.Month() == time.Month(n[2]) && t.Day() == n[3] && t.Hour() == n[4] &&
+			t.Minute() == n[5] && t.Second() == n[6] && n[1] >= 1970 {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Hash returns the hex-encoded SHA-256 digest of the named archive's contents,
+// decompressed if it was compressed, so that copies of the same version can
+// be recognized whatever they are named.
+func (a *Archiver) Hash(name string) (string, error) {
+	in, err := a.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	return hashReader(in)
+}
+
+// HashFile is like Hash, for a file outside the archive.
+func HashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Import copies src into the archive as the version saved at t, named and
+// dated as though it had been archived then, so that it takes its place in
+// the history. If another archive already has that name, t is moved on a
+// millisecond at a time until the name is free, giving up after a second.
+func (a *Archiver) Import(ctx context.Context, src string, t time.Time) (name string, err error) {
+	err = storage.Mkdir(a.Dir, a.DirMode)
+	if err != nil {
+		return
+	}
+
+	var path string
+	for i := 0; ; i++ {
+		if i == 1000 {
+			return "", fmt.Errorf("no free archive name for %s", src)
+		}
+		name = FormatName(a.Format, t)
+		path, err = a.Path(name)
+		if err != nil {
+			return
+		}
+		_, err = os.Lstat(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return
+		}
+		t = t.Add(time.Millisecond)
+	}
+
+	err = storage.CopyFile(ctx, src, path, a.FileMode)
+	if err == nil {
+		err = os.Chtimes(path, t, t)
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return
+}
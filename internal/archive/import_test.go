@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupTime(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want time.Time
+		ok   bool
+	}{
+		{"2024-05-01-13-45-06.789.html", time.Date(2024, 5, 1, 13, 45, 6, 789e6, time.UTC), true},
+		{"index.20240501134506789.html", time.Date(2024, 5, 1, 13, 45, 6, 789e6, time.UTC), true},
+		{"empty.20110216.164033768.html", time.Date(2011, 2, 16, 16, 40, 33, 768e6, time.UTC), true},
+		{"wiki 2024-05-01T13:45:06.html", time.Date(2024, 5, 1, 13, 45, 6, 0, time.UTC), true},
+		{"backup-2024-05-01.html", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"tiddlywiki-5.3.3-2024-05-01.html", time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC), true},
+		{"index.html", time.Time{}, false},
+		{"1234-99-99.html", time.Time{}, false},
+	} {
+		got, ok := BackupTime(test.name, time.UTC)
+		if !got.Equal(test.want) || ok != test.ok {
+			t.Errorf("BackupTime(%q) = %v, %v, want %v, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	got, _ := BackupTime("2024-05-01-13-45-06.000.html", paris)
+	if want := time.Date(2024, 5, 1, 11, 45, 6, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("BackupTime in Europe/Paris = %v, want %v", got, want)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "backup.html")
+	err = ioutil.WriteFile(src, []byte("<html>backup</html>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Archiver{Dir: filepath.Join(dir, "old"), Format: "2006-01-02-15-04-05.000.html", FileMode: 0644, DirMode: 0755}
+
+	// The second import of the same time is moved on a millisecond
+	saved := time.Date(2024, 5, 1, 13, 45, 6, 0, time.UTC)
+	for _, want := range []string{"2024-05-01-13-45-06.000.html", "2024-05-01-13-45-06.001.html"} {
+		name, err := a.Import(context.Background(), src, saved)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != want {
+			t.Errorf("name = %s, want %s", name, want)
+		}
+	}
+	entries, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !entries[1].ModTime.Equal(saved) {
+		t.Fatalf("entries = %v, want two, the oldest saved at %v", entries, saved)
+	}
+
+	want, err := HashFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := a.Hash(entries[0].Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("hash = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package archive
+
+import (
+	"time"
+)
+
+// Ages past which Retention thins archives.
+const (
+	thinDaily   = 24 * time.Hour      // past which only the newest of each day is kept
+	thinMonthly = 31 * 24 * time.Hour // past which only the newest of each month is kept
+)
+
+// Retention is a policy for pruning archives automatically. The zero value
+// keeps every archive.
+type Retention struct {
+	Count int           // newest archives kept, or 0 for any number
+	Age   time.Duration // age past which archives are pruned, or 0 for never
+	Thin  bool          // whether older archives are thinned (see Expired)
+}
+
+// Expired returns the entries, newest first as List returns them, that the
+// policy doesn't keep at now. An archive is expired if it is older than Age,
+// or isn't one of the newest Count kept otherwise. With Thin, every archive
+// of the last day is kept, only the newest of each day (in loc) for the
+// month before that, and only the newest of each month beyond that, so that
+// a long history takes little room but recent work can still be undone
+// save by save.
+func (r Retention) Expired(entries []Entry, now time.Time, loc *time.Location) (expired []Entry) {
+	days := make(map[string]bool)
+	months := make(map[string]bool)
+	kept := 0
+	for _, entry := range entries {
+		age := now.Sub(entry.ModTime)
+		isKept := r.Age <= 0 || age <= r.Age
+		if isKept && r.Thin && age > thinDaily {
+			t := entry.ModTime.In(loc)
+			bucket, layout := days, "2006-01-02"
+			if age > thinMonthly {
+				bucket, layout = months, "2006-01"
+			}
+			isKept = !bucket[t.Format(layout)]
+			bucket[t.Format(layout)] = true
+		}
+		if isKept && r.Count > 0 && kept >= r.Count {
+			isKept = false
+		}
+		if !isKept {
+			expired = append(expired, entry)
+			continue
+		}
+		kept++
+	}
+
+	return
+}
+
+// Retain prunes the archives that r doesn't keep at now, in loc, as Prune
+// does, and returns the names of the archives removed.
+func (a *Archiver) Retain(r Retention, now time.Time, loc *time.Location) (removed []string, err error) {
+	entries, err := a.List()
+	if err != nil {
+		return
+	}
+
+	return a.remove(r.Expired(entries, now, loc))
+}
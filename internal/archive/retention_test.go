@@ -0,0 +1,90 @@
+package archive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	ages := []time.Duration{
+		time.Hour, 2 * time.Hour, 20 * time.Hour, // today, all kept by thinning
+		30 * time.Hour, 34 * time.Hour, // May 9, only the first kept
+		50 * time.Hour,                           // May 8
+		40 * 24 * time.Hour, 45 * 24 * time.Hour, // March 31 and 26, only the first kept
+		75 * 24 * time.Hour, // February 25
+		400 * 24 * time.Hour,
+	}
+	var entries []Entry
+	for i, age := range ages {
+		entries = append(entries, Entry{Name: string(rune('a' + i)), ModTime: now.Add(-age)})
+	}
+	names := func(entries []Entry) (names string) {
+		for _, entry := range entries {
+			names += entry.Name
+		}
+
+		return
+	}
+
+	for _, test := range []struct {
+		retention Retention
+		expired   string
+	}{
+		{Retention{}, ""},
+		{Retention{Count: 4}, "efghij"},
+		{Retention{Age: 48 * time.Hour}, "fghij"},
+		{Retention{Thin: true}, "eh"},
+		{Retention{Thin: true, Count: 6}, "ehij"},
+		{Retention{Thin: true, Age: 50 * 24 * time.Hour}, "ehij"},
+	} {
+		if expired := names(test.retention.Expired(entries, now, time.UTC)); expired != test.expired {
+			t.Errorf("%+v expired %q, want %q", test.retention, expired, test.expired)
+		}
+	}
+
+	// Days are those of the given time zone, in which the second archive of
+	// May 9 in UTC was on May 8
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	if expired := names((Retention{Thin: true}).Expired(entries, now, loc)); expired != "fh" {
+		t.Errorf("thinning in UTC-5 expired %q, want %q", expired, "fh")
+	}
+}
+
+func TestRetain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a := &Archiver{Dir: dir}
+	now := time.Now()
+	for i, name := range []string{"new.html", "old.html", "older.html"} {
+		name = filepath.Join(dir, name)
+		err = ioutil.WriteFile(name, []byte("<html></html>"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(-time.Duration(i) * 24 * time.Hour)
+		err = os.Chtimes(name, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := a.Retain(Retention{Age: 36 * time.Hour}, now, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"older.html"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %q, want %q", removed, want)
+	}
+	entries, _ := a.List()
+	if len(entries) != 2 {
+		t.Errorf("%d archives left, want 2", len(entries))
+	}
+}
@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// sequenceFile keeps the last sequence number in the archive directory, so
+// that numbers aren't reused even if every archive is pruned.
+const sequenceFile = ".sequence"
+
+// sequenceDigits is the minimum width of sequence numbers in archive names,
+// so that they sort by name too.
+const sequenceDigits = 6
+
+// parseSequence returns the sequence number at the start of an archive name
+// written with Sequence, e.g. 000042 in 000042_2024-05-01-13-45-06.000.html,
+// or 0 if it has none.
+func parseSequence(name string) int64 {
+	i := strings.IndexByte(name, '_')
+	if i <= 0 {
+		return 0
+	}
+	seq, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil || seq < 0 {
+		return 0
+	}
+
+	return seq
+}
+
+// nextSequence returns the next sequence number, recording it in the archive
+// directory. The numbers continue from the highest in the sequence file or
+// in the names of the archives, whichever is higher.
+func (a *Archiver) nextSequence() (seq int64, err error) {
+	name := filepath.Join(a.Dir, sequenceFile)
+	if a.seq == 0 {
+		data, err := ioutil.ReadFile(name)
+		if err == nil {
+			a.seq, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		} else if !os.IsNotExist(err) {
+			return 0, err
+		}
+		entries, err := a.List()
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if entry.Sequence > a.seq {
+				a.seq = entry.Sequence
+			}
+		}
+	}
+
+	a.seq++
+	err = storage.WriteFile(name, []byte(fmt.Sprintln(a.seq)), a.FileMode)
+	if err != nil {
+		a.seq--
+		return
+	}
+
+	return a.seq, nil
+}
+
+// sortEntries sorts entries newest first: by sequence number where both have
+// one, and otherwise by modification time, which may be wrong if the clock
+// changed between them.
+func sortEntries(entries []Entry) {
+	var numbered, dated []Entry
+	for _, entry := range entries {
+		if entry.Sequence > 0 {
+			numbered = append(numbered, entry)
+		} else {
+			dated = append(dated, entry)
+		}
+	}
+	sort.SliceStable(numbered, func(i, j int) bool {
+		return numbered[i].Sequence > numbered[j].Sequence
+	})
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].ModTime.After(dated[j].ModTime)
+	})
+
+	// Merge the two by time, keeping the order within each
+	entries = entries[:0]
+	for len(numbered) > 0 && len(dated) > 0 {
+		if dated[0].ModTime.After(numbered[0].ModTime) {
+			entries = append(entries, dated[0])
+			dated = dated[1:]
+		} else {
+			entries = append(entries, numbered[0])
+			numbered = numbered[1:]
+		}
+	}
+	entries = append(entries, numbered...)
+	entries = append(entries, dated...)
+}
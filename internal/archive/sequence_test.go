@@ -0,0 +1,84 @@
+package archive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSequence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "index.html")
+	err = ioutil.WriteFile(src, []byte("<html>wiki</html>"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newArchiver := func() *Archiver {
+		return &Archiver{
+			Dir:      filepath.Join(dir, "old"),
+			Format:   "2006-01-02-15-04-05.000.html",
+			FileMode: 0644,
+			DirMode:  0755,
+			Sequence: true,
+		}
+	}
+
+	// An archive from before sequence numbers were used, and one imported
+	a := newArchiver()
+	_, err = a.Import(context.Background(), src, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The clock goes back an hour between the archives, and the second
+	// archiver is restarted with the same directory
+	now := time.Now().UTC()
+	for i, a := range []*Archiver{a, a, newArchiver()} {
+		name, err := a.Archive(context.Background(), src, now.Add(-time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Archives are written, so the clock is that of the modification time
+		modTime := now.Add(-time.Duration(i) * time.Hour)
+		err = os.Chtimes(name, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := a.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int64
+	for _, entry := range entries {
+		got = append(got, entry.Sequence)
+	}
+	if len(got) != 4 || got[0] != 3 || got[1] != 2 || got[2] != 1 || got[3] != 0 {
+		t.Fatalf("sequence numbers = %v, want [3 2 1 0]", got)
+	}
+	if want := "000003_" + now.Add(-2*time.Hour).Format(a.Format); entries[0].Name != want {
+		t.Errorf("newest = %s, want %s", entries[0].Name, want)
+	}
+
+	// Numbers aren't reused once their archives are pruned
+	_, err = a.Prune(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = newArchiver()
+	name, err := a.Archive(context.Background(), src, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := parseSequence(filepath.Base(name)); seq != 4 {
+		t.Errorf("sequence number after pruning = %d, want 4", seq)
+	}
+}
@@ -0,0 +1,270 @@
+// Package bundle packs a wiki, its archive, and its config file into a single
+// tar file, for backing up putter or moving it to another host.
+package bundle
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// Names of the contents of a bundle. Files are bundled under these names
+// rather than their paths, so that they can be extracted wherever the new
+// host keeps them.
+const (
+	nameConfig = "putter.conf"
+	nameWiki   = "wiki"
+	dirArchive = "archive/"
+)
+
+// sidecars are the files kept alongside the wiki that are worth bundling,
+// named for their extensions. Caches, locks, and the like are left out, as
+// they are recreated when needed.
+var sidecars = []string{".stats", ".held", ".held-base", ".authors"}
+
+// isSidecar reports whether ext is the extension of one of the sidecars.
+func isSidecar(ext string) bool {
+	for _, sidecar := range sidecars {
+		if ext == sidecar {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Kinds of files in a bundle.
+const (
+	KindConfig  = "config"  // the config file
+	KindWiki    = "wiki"    // the live wiki
+	KindSidecar = "sidecar" // a file kept alongside the wiki, e.g. its statistics
+	KindArchive = "archive" // an archived version, or a directory of them
+)
+
+// Paths are where the files of a bundle are kept on disk.
+type Paths struct {
+	Config     string // config file, or empty if there is none
+	Wiki       string // live wiki, alongside which its sidecars are kept
+	ArchiveDir string // archive directory, or empty if the wiki isn't archived
+}
+
+// Write writes a tar file of the files at paths to w, returning how many it
+// wrote. The config file, sidecars, and archive directory are left out if
+// they don't exist, but the wiki must.
+func Write(ctx context.Context, w io.Writer, paths Paths) (n int, err error) {
+	tw := tar.NewWriter(w)
+	add := func(name, path string) error {
+		err := ctx.Err()
+		if err == nil {
+			err = writeFile(tw, name, path)
+		}
+		if err == nil {
+			n++
+		}
+		return err
+	}
+
+	if paths.Config != "" {
+		err = add(nameConfig, paths.Config)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	err = add(nameWiki, paths.Wiki)
+	if err != nil {
+		return
+	}
+	for _, ext := range sidecars {
+		err = add(nameWiki+ext, paths.Wiki+ext)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	if paths.ArchiveDir != "" {
+		err = filepath.Walk(paths.ArchiveDir, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == paths.ArchiveDir {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(paths.ArchiveDir, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			// Hidden files are temporary, e.g. archives being recompressed
+			if strings.HasPrefix(info.Name(), ".") {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return nil
+			}
+			return add(dirArchive+filepath.ToSlash(rel), path)
+		})
+		if err != nil {
+			return
+		}
+	}
+
+	return n, tw.Close()
+}
+
+// writeFile adds the file or directory at path to the tar file under name.
+func writeFile(tw *tar.Writer, name, path string) (err error) {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	hdr, err := tar.FileInfoHeader(fileInfo, "")
+	if err != nil {
+		return
+	}
+	hdr.Name = name
+	hdr.Uname, hdr.Gname = "", ""
+	// Keep the precise times by which archives are ordered
+	hdr.Format = tar.FormatPAX
+	if fileInfo.IsDir() {
+		hdr.Name += "/"
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return
+	}
+	_, err = io.CopyN(tw, f, hdr.Size)
+
+	return
+}
+
+// Reader reads the files of a bundle in turn.
+type Reader struct {
+	tr *tar.Reader
+}
+
+// NewReader returns a reader of the bundle in r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{tr: tar.NewReader(r)}
+}
+
+// File is a file in a bundle.
+type File struct {
+	Kind    string
+	Name    string // for sidecars, the extension; for archives, the path within the archive directory
+	ModTime time.Time
+	IsDir   bool
+	r       io.Reader
+}
+
+// Next returns the next file in the bundle, or io.EOF at its end.
+func (r *Reader) Next() (f *File, err error) {
+	hdr, err := r.tr.Next()
+	if err != nil {
+		return
+	}
+	f = &File{ModTime: hdr.ModTime, IsDir: hdr.Typeflag == tar.TypeDir, r: r.tr}
+	name := strings.TrimSuffix(hdr.Name, "/")
+	switch {
+	case name == nameConfig:
+		f.Kind = KindConfig
+	case name == nameWiki:
+		f.Kind = KindWiki
+	case strings.HasPrefix(name, nameWiki+"."):
+		f.Kind = KindSidecar
+		f.Name = strings.TrimPrefix(name, nameWiki)
+		// The name is appended to the wiki's, so only known ones are safe
+		if !isSidecar(f.Name) {
+			return nil, fmt.Errorf("unknown file %q in bundle", hdr.Name)
+		}
+	case strings.HasPrefix(name, dirArchive):
+		f.Kind = KindArchive
+		f.Name = path.Clean(strings.TrimPrefix(name, dirArchive))
+		if f.Name == ".." || strings.HasPrefix(f.Name, "../") || path.IsAbs(f.Name) {
+			return nil, fmt.Errorf("invalid archive name %q in bundle", hdr.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown file %q in bundle", hdr.Name)
+	}
+	if !f.IsDir && hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+		return nil, fmt.Errorf("%q in bundle isn't a regular file", hdr.Name)
+	}
+	if f.IsDir && f.Kind != KindArchive {
+		return nil, fmt.Errorf("%q in bundle is a directory", hdr.Name)
+	}
+
+	return
+}
+
+// ErrExist is returned by Extract when a file is in the way.
+var ErrExist = errors.New("file already exists")
+
+// Extract writes the file to path with its modification time, replacing any
+// file there only if overwrite is true. Files are written to a temporary file
+// and renamed into place, so that an interrupted extraction leaves nothing
+// half-written.
+func (f *File) Extract(path string, overwrite bool, fileMode, dirMode os.FileMode) (err error) {
+	if f.IsDir {
+		err = storage.Mkdir(path, dirMode)
+		if err == nil {
+			err = os.Chtimes(path, f.ModTime, f.ModTime)
+		}
+		return
+	}
+	_, err = os.Lstat(path)
+	if err == nil && !overwrite {
+		return fmt.Errorf("%s: %w", path, ErrExist)
+	}
+
+	err = storage.Mkdir(filepath.Dir(path), dirMode)
+	if err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	_, err = io.Copy(tmp, f.r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), fileMode)
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), f.ModTime, f.ModTime)
+	}
+	if err != nil {
+		return
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,143 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bundle-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := Paths{
+		Config:     filepath.Join(dir, "src", "putter.conf"),
+		Wiki:       filepath.Join(dir, "src", "index.html"),
+		ArchiveDir: filepath.Join(dir, "src", "old"),
+	}
+	saved := time.Date(2024, 5, 1, 13, 45, 6, 789e6, time.UTC)
+	files := map[string]string{
+		src.Config:          "port = 9000\n",
+		src.Wiki:            "<html>live</html>",
+		src.Wiki + ".stats": "{}",
+		src.Wiki + ".etag":  "cache",
+		filepath.Join(src.ArchiveDir, "2024-05-01-13-45-06.789.html"): "<html>old</html>",
+		filepath.Join(src.ArchiveDir, "notes", "a.html"):              "<html>notes</html>",
+		filepath.Join(src.ArchiveDir, ".recompress-1"):                "temporary",
+	}
+	for name, content := range files {
+		err = os.MkdirAll(filepath.Dir(name), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(name, []byte(content), 0644)
+		}
+		if err == nil {
+			err = os.Chtimes(name, saved, saved)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	n, err := Write(context.Background(), &buf, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The config, wiki, statistics, two archives, and the directory of one
+	if n != 6 {
+		t.Errorf("n = %d, want 6", n)
+	}
+
+	// Extract to paths of a different layout
+	dst := filepath.Join(dir, "dst")
+	var got []string
+	r := NewReader(&buf)
+	for {
+		f, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, f.Kind+" "+f.Name)
+		path := filepath.Join(dst, "wiki.html"+f.Name)
+		switch f.Kind {
+		case KindConfig:
+			path = filepath.Join(dst, "putter.conf")
+		case KindArchive:
+			path = filepath.Join(dst, "archive", filepath.FromSlash(f.Name))
+		}
+		err = f.Extract(path, false, 0600, 0700)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		"archive 2024-05-01-13-45-06.789.html", "archive notes", "archive notes/a.html",
+		"config ", "sidecar .stats", "wiki ",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+
+	path := filepath.Join(dst, "archive", "2024-05-01-13-45-06.789.html")
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "<html>old</html>" || !fileInfo.ModTime().Equal(saved) || fileInfo.Mode() != 0600 {
+		t.Errorf("archive = %q, %v, %v, want %q, %v, %v", content, fileInfo.ModTime(), fileInfo.Mode(), "<html>old</html>", saved, os.FileMode(0600))
+	}
+
+	// Existing files are only replaced when asked
+	buf.Reset()
+	_, err = Write(context.Background(), &buf, Paths{Wiki: src.Wiki})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Extract(filepath.Join(dst, "wiki.html"), false, 0600, 0700)
+	if !errors.Is(err, ErrExist) {
+		t.Errorf("err = %v, want ErrExist", err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	// Sidecars' names are appended to the wiki's, so unknown ones could point
+	// anywhere
+	for _, name := range []string{"archive/../../etc/passwd", "other", "/wiki", "wiki./../../x", "wiki.etag"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg})
+		if err == nil {
+			err = tw.Close()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewReader(&buf).Next()
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
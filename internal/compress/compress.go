@@ -0,0 +1,93 @@
+// Package compress produces the gzipped copies of the wiki served to clients.
+package compress
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+const (
+	// Encoding is the Content-Encoding of compressed responses.
+	Encoding = "gzip"
+	// Extension is appended to the wiki's name to name its compressed copy.
+	Extension = ".gz"
+
+	extensionTemp = ".tmp"
+)
+
+// ValidLevel reports whether level is a valid gzip compression level.
+func ValidLevel(level int) bool {
+	return level >= gzip.BestSpeed && level <= gzip.BestCompression
+}
+
+// File saves a compressed copy of src as dst, compressing up to procs blocks
+// of it at once, or GOMAXPROCS if procs is 0. The copy is written to a
+// temporary file first, so a failure never leaves a truncated dst.
+func File(ctx context.Context, src, dst string, level, procs int, mode os.FileMode) (err error) {
+	server.Logf(ctx, "compressing wiki...")
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	tmpName := dst + extensionTemp
+	out, err := storage.CreateFile(tmpName, mode)
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpName)
+	defer out.Close()
+
+	// The wiki may be large, so use every core
+	gz, err := NewParallelWriter(out, level, procs)
+	if err != nil {
+		return
+	}
+	_, err = io.Copy(gz, storage.ContextReader{Ctx: ctx, R: in})
+	if err == nil {
+		err = gz.Close()
+	} else {
+		gz.Close()
+	}
+	if err != nil {
+		return
+	}
+	err = out.Close()
+	if err != nil {
+		return
+	}
+	err = os.Rename(tmpName, dst)
+	if err != nil {
+		return
+	}
+	server.Logf(ctx, "wiki compressed")
+
+	return
+}
+
+// Stream compresses everything read from r into w.
+func Stream(w io.Writer, r io.Reader, level int) (err error) {
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return
+	}
+	defer gz.Close()
+
+	_, err = io.Copy(gz, r)
+	if err != nil {
+		return
+	}
+
+	return gz.Close()
+}
+
+// TempName is the name of the temporary file used while compressing to dst.
+func TempName(dst string) string {
+	return dst + extensionTemp
+}
@@ -0,0 +1,169 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"runtime"
+)
+
+// blockSize is how much a ParallelWriter compresses in each goroutine. Each
+// block is primed with the end of the one before, so splitting costs little.
+const blockSize = 1 << 20
+
+// BlockMemory is roughly how much memory each block being compressed by a
+// ParallelWriter holds: its input, its output, and the compressor's state.
+const BlockMemory = 4 << 20
+
+// dictSize is how much of the previous block primes each block, the most
+// that deflate can refer back to.
+const dictSize = 32 << 10
+
+// ParallelWriter is a gzip writer that compresses blocks of its input on
+// several cores at once, writing a single gzip member that any gzip reader
+// can decompress. Each block ends with a sync flush, so the output is a few
+// bytes larger per megabyte than gzip.Writer's.
+type ParallelWriter struct {
+	w       io.Writer
+	level   int
+	block   []byte           // input not yet compressed
+	dict    []byte           // end of the last block compressed
+	crc     uint32           // of the input so far
+	size    uint32           // of the input so far, modulo 2^32
+	pending chan chan []byte // compressed blocks, in order, not yet written
+	slots   chan struct{}    // limits the blocks compressed at once
+	done    chan struct{}    // closed when pending has been drained
+	err     error            // first error writing to w, set before done is closed
+	closed  bool
+}
+
+// NewParallelWriter returns a ParallelWriter writing to w at the given gzip
+// level, compressing up to procs blocks at once, or GOMAXPROCS if procs is 0.
+// Each block compressed at once holds about BlockMemory. The caller must
+// Close it.
+func NewParallelWriter(w io.Writer, level, procs int) (*ParallelWriter, error) {
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return nil, fmt.Errorf("gzip: invalid compression level: %d", level)
+	}
+	if procs <= 0 {
+		procs = runtime.GOMAXPROCS(0)
+	}
+	pw := &ParallelWriter{
+		w:       w,
+		level:   level,
+		block:   make([]byte, 0, blockSize),
+		pending: make(chan chan []byte, procs),
+		slots:   make(chan struct{}, procs),
+		done:    make(chan struct{}),
+	}
+	go pw.drain()
+
+	return pw, nil
+}
+
+// drain writes the gzip header and then the compressed blocks in order.
+func (pw *ParallelWriter) drain() {
+	defer close(pw.done)
+	// No name, no modification time, and an unknown OS, as gzip.Writer does
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	switch pw.level {
+	case gzip.BestCompression:
+		header[8] = 2
+	case gzip.BestSpeed:
+		header[8] = 4
+	}
+	_, pw.err = pw.w.Write(header)
+	for result := range pw.pending {
+		compressed := <-result
+		if pw.err == nil {
+			_, pw.err = pw.w.Write(compressed)
+		}
+	}
+}
+
+// Write compresses p, returning an error if writing what was compressed
+// before has failed.
+func (pw *ParallelWriter) Write(p []byte) (n int, err error) {
+	if pw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	pw.crc = crc32.Update(pw.crc, crc32.IEEETable, p)
+	pw.size += uint32(len(p))
+	for len(p) > 0 {
+		if pw.failed() {
+			return n, pw.err
+		}
+		written := copy(pw.block[len(pw.block):cap(pw.block)], p)
+		pw.block = pw.block[:len(pw.block)+written]
+		n += written
+		p = p[written:]
+		if len(pw.block) == cap(pw.block) {
+			pw.compress(false)
+		}
+	}
+
+	return
+}
+
+// failed reports whether writing to w has failed.
+func (pw *ParallelWriter) failed() bool {
+	select {
+	case <-pw.done:
+		return pw.err != nil
+	default:
+		return false
+	}
+}
+
+// compress compresses the buffered block in the background, ending the
+// deflate stream if it is the last.
+func (pw *ParallelWriter) compress(last bool) {
+	block, dict := pw.block, pw.dict
+	if !last {
+		// Only the last block can be shorter than the dictionary
+		pw.dict = block[len(block)-dictSize:]
+		pw.block = make([]byte, 0, blockSize)
+	}
+
+	result := make(chan []byte, 1)
+	pw.slots <- struct{}{}
+	pw.pending <- result
+	go func() {
+		defer func() { <-pw.slots }()
+		var buf bytes.Buffer
+		// The level was validated, so this can't fail
+		fw, _ := flate.NewWriterDict(&buf, pw.level, dict)
+		fw.Write(block)
+		if last {
+			fw.Close()
+		} else {
+			fw.Flush()
+		}
+		result <- buf.Bytes()
+	}()
+}
+
+// Close compresses what remains, writes the gzip trailer, and waits for
+// everything to be written.
+func (pw *ParallelWriter) Close() error {
+	if pw.closed {
+		return pw.err
+	}
+	pw.closed = true
+	pw.compress(true)
+	close(pw.pending)
+	<-pw.done
+	if pw.err != nil {
+		return pw.err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], pw.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], pw.size)
+	_, pw.err = pw.w.Write(trailer)
+
+	return pw.err
+}
@@ -0,0 +1,81 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestParallelWriter(t *testing.T) {
+	// Repetitive enough to compress, with matches across block boundaries
+	rng := rand.New(rand.NewSource(1))
+	words := []string{"<div class=\"tc-tiddler\">", "title", "TiddlyWiki ", "\n", "modified: 20240101"}
+	var input bytes.Buffer
+	for input.Len() < 3*blockSize+1234 {
+		input.WriteString(words[rng.Intn(len(words))])
+	}
+
+	for _, size := range []int{0, 100, blockSize, input.Len()} {
+		for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+			var out bytes.Buffer
+			pw, err := NewParallelWriter(&out, level, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Written in uneven pieces, as io.Copy might
+			data := input.Bytes()[:size]
+			for i := 0; i < len(data); i += 7777 {
+				end := i + 7777
+				if end > len(data) {
+					end = len(data)
+				}
+				_, err = pw.Write(data[i:end])
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = pw.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			gz, err := gzip.NewReader(&out)
+			if err != nil {
+				t.Fatalf("size %d level %d: %v", size, level, err)
+			}
+			got, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("size %d level %d: %v", size, level, err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("size %d level %d: decompressed %d bytes, want %d", size, level, len(got), len(data))
+			}
+		}
+	}
+
+	_, err := NewParallelWriter(ioutil.Discard, 10, 0)
+	if err == nil {
+		t.Error("NewParallelWriter accepted level 10")
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestParallelWriterError(t *testing.T) {
+	pw, err := NewParallelWriter(failingWriter{}, gzip.BestSpeed, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw.Write(make([]byte, 3*blockSize))
+	err = pw.Close()
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("Close error = %v, want disk full", err)
+	}
+}
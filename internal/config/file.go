@@ -0,0 +1,156 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// Setting is a value from a config file, named for the flag it sets.
+type Setting struct {
+	Name   string
+	Value  string
+	Values []string // values of an array of strings, if the setting is one
+	Line   int      // line of the config file it was read from, if any
+	Table  string   // name of the [[table]] it was read from, if any
+	Entry  int      // which of the entries of Table it was read from, from 0
+}
+
+// ReadFile reads the settings in a config file of "name = value" lines, with
+// string values quoted and arrays of them in brackets (a subset of TOML).
+// Settings after a "[[table]]" line belong to an entry of that table, up to
+// the next such line. Blank lines and comments starting with "#" are ignored.
+// Files named .yaml or .yml are read as YAML instead (see readYAML).
+func ReadFile(name string) (settings []Setting, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if isYAML(name) {
+		return readYAML(name, f)
+	}
+
+	table := ""
+	entries := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[[") && strings.HasSuffix(text, "]]") {
+			table = strings.TrimSpace(text[2 : len(text)-2])
+			entries[table]++
+			continue
+		}
+		i := strings.IndexByte(text, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected name = value", name, line)
+		}
+		setting := Setting{
+			Name:  strings.TrimSpace(text[:i]),
+			Value: strings.TrimSpace(text[i+1:]),
+			Line:  line,
+			Table: table,
+		}
+		if table != "" {
+			setting.Entry = entries[table] - 1
+		}
+		switch {
+		case strings.HasPrefix(setting.Value, `"`):
+			setting.Value, err = strconv.Unquote(setting.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid string: %v", name, line, err)
+			}
+		case strings.HasPrefix(setting.Value, "["):
+			setting.Values, err = parseArray(setting.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid array: %v", name, line, err)
+			}
+			setting.Value = ""
+		}
+		settings = append(settings, setting)
+	}
+	err = scanner.Err()
+
+	return
+}
+
+// parseArray parses an array of quoted strings, such as ["GET", "HEAD"].
+func parseArray(s string) (values []string, err error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New("missing ]")
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	values = []string{}
+	for s != "" {
+		if s[0] != '"' {
+			return nil, errors.New("expected a quoted string")
+		}
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) {
+			return nil, errors.New("unterminated string")
+		}
+		value, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		s = strings.TrimSpace(s[end+1:])
+		if s != "" {
+			if s[0] != ',' {
+				return nil, errors.New("expected a comma")
+			}
+			s = strings.TrimSpace(s[1:])
+		}
+	}
+
+	return
+}
+
+// Entries groups the settings read from the entries of a table, in order.
+func Entries(settings []Setting, table string) (entries [][]Setting) {
+	for _, setting := range settings {
+		if setting.Table != table {
+			continue
+		}
+		for len(entries) <= setting.Entry {
+			entries = append(entries, nil)
+		}
+		entries[setting.Entry] = append(entries[setting.Entry], setting)
+	}
+
+	return
+}
+
+// WriteFile writes the settings to a config file readable by ReadFile,
+// quoting values other than booleans and integers, in YAML if name says so.
+// The file may hold passwords, so only its owner can read it.
+func WriteFile(name string, settings []Setting) (err error) {
+	var b strings.Builder
+	b.WriteString("# putter configuration, in the form of its command line flags\n")
+	if isYAML(name) {
+		writeYAML(&b, settings)
+		return storage.WriteFile(name, []byte(b.String()), 0600)
+	}
+	for _, setting := range settings {
+		value := setting.Value
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil && value != "true" && value != "false" {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", setting.Name, value)
+	}
+
+	return storage.WriteFile(name, []byte(b.String()), 0600)
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "putter.conf")
+
+	settings := []Setting{
+		{Name: "wiki", Value: "my wiki.html"},
+		{Name: "archive", Value: "true"},
+		{Name: "port", Value: "8080"},
+		{Name: "admin-password", Value: `pa"ss = word`},
+	}
+	err = WriteFile(name, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("mode = %o, want 600", mode)
+	}
+
+	read, err := ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range read {
+		read[i].Line = 0
+	}
+	if !reflect.DeepEqual(read, settings) {
+		t.Errorf("read %+v, want %+v", read, settings)
+	}
+
+	err = ioutil.WriteFile(name, []byte(`port = 8080
+[[rule]]
+path = "/old/"
+methods = ["GET", "HEAD"]
+[[rule]]
+headers = ["X-Robots-Tag: noindex", "X-Note: \"quoted\", with a comma"]
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err = ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := Entries(read, "rule")
+	if len(entries) != 2 || len(entries[0]) != 2 || len(entries[1]) != 1 {
+		t.Fatalf("entries = %+v, want 2 with 2 and 1 settings", entries)
+	}
+	if want := []string{"GET", "HEAD"}; !reflect.DeepEqual(entries[0][1].Values, want) {
+		t.Errorf("methods = %q, want %q", entries[0][1].Values, want)
+	}
+	if want := []string{"X-Robots-Tag: noindex", `X-Note: "quoted", with a comma`}; !reflect.DeepEqual(entries[1][0].Values, want) {
+		t.Errorf("headers = %q, want %q", entries[1][0].Values, want)
+	}
+	if read[0].Table != "" {
+		t.Errorf("port read from table %q, want none", read[0].Table)
+	}
+
+	for _, content := range []string{"wiki\n", `wiki = "unterminated` + "\n", `methods = ["GET" "HEAD"]` + "\n"} {
+		err = ioutil.WriteFile(name, []byte("# comment\n\n"+content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadFile(name)
+		if err == nil || !strings.HasPrefix(err.Error(), name+":3:") {
+			t.Errorf("ReadFile of %q = %v, want an error on line 3", content, err)
+		}
+	}
+}
+
+func TestYAML(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "putter.yaml")
+
+	settings := []Setting{
+		{Name: "wiki", Value: "my wiki.html"},
+		{Name: "archive", Value: "true"},
+		{Name: "admin-password", Value: `pa"ss: # word`},
+	}
+	err = WriteFile(name, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range read {
+		read[i].Line = 0
+	}
+	if !reflect.DeepEqual(read, settings) {
+		t.Errorf("read %+v, want %+v", read, settings)
+	}
+
+	err = ioutil.WriteFile(name, []byte(`---
+# putter
+port: 8080  # the default
+archive-dir: 'don''t # delete'
+rule:
+  - path: /old/
+    methods: [GET, "HEAD"]
+  - headers:
+      - "X-Robots-Tag: noindex"
+      - X-Note: with a colon
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadFile(name)
+	if err == nil || !strings.HasPrefix(err.Error(), name+":10:") {
+		t.Errorf("ReadFile with a mapping in a sequence in a table = %v, want an error on line 10", err)
+	}
+
+	err = ioutil.WriteFile(name, []byte(`port: 8080
+archive-dir: 'don''t # delete' # history
+rule:
+- path: /old/
+  methods: [GET, "HEAD"]
+- headers:
+  - "X-Robots-Tag: noindex"
+  - 'X-Note: "quoted", with a comma'
+extra:
+-
+  path: /status
+wiki-aliases: ~
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err = ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read[0].Name != "port" || read[0].Value != "8080" || read[1].Value != "don't # delete" {
+		t.Errorf("read %+v, want port 8080 and archive-dir \"don't # delete\"", read[:2])
+	}
+	entries := Entries(read, "rule")
+	if len(entries) != 2 || len(entries[0]) != 2 || len(entries[1]) != 1 {
+		t.Fatalf("entries = %+v, want 2 with 2 and 1 settings", entries)
+	}
+	if want := []string{"GET", "HEAD"}; !reflect.DeepEqual(entries[0][1].Values, want) {
+		t.Errorf("methods = %q, want %q", entries[0][1].Values, want)
+	}
+	if entries[1][0].Line != 6 {
+		t.Errorf("headers read from line %d, want 6", entries[1][0].Line)
+	}
+	if extra := Entries(read, "extra"); len(extra) != 1 || extra[0][0].Value != "/status" {
+		t.Errorf("extra = %+v, want one entry with path /status", extra)
+	}
+	if last := read[len(read)-1]; last.Name != "wiki-aliases" || last.Value != "" || last.Table != "" {
+		t.Errorf("last setting = %+v, want an empty wiki-aliases", last)
+	}
+
+	for _, content := range []string{"wiki\n", "wiki: \"unterminated\n", "wiki: |\n", "  wiki: index.html\n", "wiki:\n  name: index.html\n"} {
+		err = ioutil.WriteFile(name, []byte("# comment\n\n"+content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ReadFile(name)
+		if err == nil || !strings.HasPrefix(err.Error(), name+":3:") && !strings.HasPrefix(err.Error(), name+":4:") {
+			t.Errorf("ReadFile of %q = %v, want an error on line 3 or 4", content, err)
+		}
+	}
+}
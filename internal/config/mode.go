@@ -0,0 +1,27 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strconv"
+)
+
+// OctalMode is a file permission mode that can be set from a flag in octal
+// (it implements flag.Value).
+type OctalMode os.FileMode
+
+// String formats the mode in octal.
+func (m OctalMode) String() string {
+	return "0" + strconv.FormatUint(uint64(m), 8)
+}
+
+// Set parses an octal mode such as "644" or "0755".
+func (m *OctalMode) Set(value string) error {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || os.FileMode(n)&^os.ModePerm != 0 {
+		return errors.New("invalid mode " + strconv.Quote(value))
+	}
+	*m = OctalMode(n)
+
+	return nil
+}
@@ -0,0 +1,56 @@
+// Package config provides types for parsing putter's configuration.
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a size in bytes that can be set from a flag (it implements
+// flag.Value) using binary unit
+// suffixes, e.g. "512", "64K", "1.5GB", or "2GiB".
+type ByteSize int64
+
+var ByteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// String formats the size using the largest unit that keeps it readable.
+func (b ByteSize) String() string {
+	for _, unit := range ByteSizeUnits {
+		if b >= unit.size {
+			value := strconv.FormatFloat(float64(b)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix + "B"
+		}
+	}
+
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
+
+// Set parses a size with an optional unit suffix.
+func (b *ByteSize) Set(value string) error {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := ByteSize(1)
+	for _, unit := range ByteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			multiplier = unit.size
+			s = strings.TrimSuffix(s, unit.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n < 0 {
+		return errors.New("invalid size " + strconv.Quote(value))
+	}
+	*b = ByteSize(n * float64(multiplier))
+
+	return nil
+}
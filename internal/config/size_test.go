@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		value  string
+		size   ByteSize
+		String string
+	}{
+		{"0", 0, "0B"},
+		{"512", 512, "512B"},
+		{"64K", 64 << 10, "64KB"},
+		{"64kb", 64 << 10, "64KB"},
+		{"1.5GB", 3 << 29, "1.5GB"},
+		{"2GiB", 2 << 30, "2GB"},
+		{" 10 MB ", 10 << 20, "10MB"},
+		{"1T", 1 << 40, "1TB"},
+	}
+	for _, test := range tests {
+		var size ByteSize
+		err := size.Set(test.value)
+		if err != nil {
+			t.Errorf("Set(%q) failed: %v", test.value, err)
+			continue
+		}
+		if size != test.size {
+			t.Errorf("Set(%q) = %d, want %d", test.value, size, test.size)
+		}
+		if size.String() != test.String {
+			t.Errorf("%d.String() = %q, want %q", size, size.String(), test.String)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "-1", "1X"} {
+		var size ByteSize
+		if err := size.Set(value); err == nil {
+			t.Errorf("Set(%q) = %d, want an error", value, size)
+		}
+	}
+}
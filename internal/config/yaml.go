@@ -0,0 +1,302 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// isYAML reports whether a config file is in YAML, by its extension.
+func isYAML(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlLine is a line of a YAML file, without its indentation or comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// yamlParser parses the subset of YAML understood in config files.
+type yamlParser struct {
+	name  string
+	lines []yamlLine
+	i     int // index of the next line to parse
+}
+
+// readYAML reads the settings in a YAML config file, of which a subset is
+// understood: a mapping of names to scalars or sequences of them, and to
+// sequences of such mappings, which are read as the entries of a table
+// named for their key, e.g.
+//
+//	archive-dir: history
+//	rule:
+//	  - path: /old/
+//	    methods: [GET, HEAD]
+//
+// Anchors, tags, block scalars, and flow mappings aren't supported.
+func readYAML(name string, r io.Reader) (settings []Setting, err error) {
+	p := &yamlParser{name: name}
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := stripComment(scanner.Text())
+		text := strings.TrimLeft(line, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("%s:%d: tabs can't be used for indentation", name, number)
+		}
+		text = strings.TrimSpace(text)
+		if text == "" || text == "---" {
+			continue
+		}
+		p.lines = append(p.lines, yamlLine{number: number, indent: len(line) - len(strings.TrimLeft(line, " ")), text: text})
+	}
+	err = scanner.Err()
+	if err != nil {
+		return
+	}
+
+	settings, err = p.mapping(0, "", 0)
+	if err == nil && p.i < len(p.lines) {
+		err = p.errorf(p.lines[p.i], "unexpected indentation")
+	}
+
+	return
+}
+
+// mapping parses the names and values of a mapping indented by indent, up
+// to the first line indented less, as settings of an entry of table.
+func (p *yamlParser) mapping(indent int, table string, entry int) (settings []Setting, err error) {
+	for p.i < len(p.lines) {
+		line := p.lines[p.i]
+		if line.indent < indent {
+			return
+		}
+		if line.indent > indent {
+			return nil, p.errorf(line, "unexpected indentation")
+		}
+		name, value, ok := splitKey(line.text)
+		if !ok {
+			return nil, p.errorf(line, "expected name: value")
+		}
+		p.i++
+		setting := Setting{Name: name, Line: line.number, Table: table, Entry: entry}
+		var next *yamlLine
+		if p.i < len(p.lines) {
+			next = &p.lines[p.i]
+		}
+		switch {
+		case value != "":
+			if strings.HasPrefix(value, "[") {
+				setting.Values, err = parseFlowSequence(value)
+			} else {
+				setting.Value, err = parseScalar(value)
+			}
+			if err != nil {
+				return nil, p.errorf(line, "invalid value: %v", err)
+			}
+		case next != nil && next.indent >= indent && isItem(next.text):
+			if table == "" && p.isMappingItem() {
+				var entries []Setting
+				entries, err = p.entries(name, next.indent)
+				if err != nil {
+					return
+				}
+				settings = append(settings, entries...)
+				continue
+			}
+			setting.Values, err = p.sequence(next.indent)
+			if err != nil {
+				return
+			}
+		case next != nil && next.indent > indent:
+			return nil, p.errorf(*next, "nested mappings are only supported in sequences")
+		}
+		settings = append(settings, setting)
+	}
+
+	return
+}
+
+// sequence parses the scalars of a sequence indented by indent.
+func (p *yamlParser) sequence(indent int) (values []string, err error) {
+	values = []string{}
+	for p.i < len(p.lines) && p.lines[p.i].indent == indent && isItem(p.lines[p.i].text) {
+		line := p.lines[p.i]
+		text := strings.TrimSpace(line.text[1:])
+		if _, _, ok := splitKey(text); ok {
+			return nil, p.errorf(line, "sequences of mappings are only supported at the top level")
+		}
+		value, err := parseScalar(text)
+		if err != nil {
+			return nil, p.errorf(line, "invalid value: %v", err)
+		}
+		values = append(values, value)
+		p.i++
+	}
+
+	return
+}
+
+// entries parses a sequence of mappings indented by indent as the entries
+// of table.
+func (p *yamlParser) entries(table string, indent int) (settings []Setting, err error) {
+	for entry := 0; p.i < len(p.lines) && p.lines[p.i].indent == indent && isItem(p.lines[p.i].text); entry++ {
+		line := &p.lines[p.i]
+		text := strings.TrimSpace(line.text[1:])
+		if text == "" {
+			// The mapping starts on the next line
+			p.i++
+			if p.i >= len(p.lines) || p.lines[p.i].indent <= indent {
+				return nil, p.errorf(*line, "expected a mapping")
+			}
+		} else {
+			// Parse the rest of the line as the first line of the mapping
+			line.indent += len(line.text) - len(text)
+			line.text = text
+		}
+		var s []Setting
+		s, err = p.mapping(p.lines[p.i].indent, table, entry)
+		if err != nil {
+			return
+		}
+		settings = append(settings, s...)
+	}
+
+	return
+}
+
+// isMappingItem reports whether the next line is an item of a sequence
+// holding a mapping.
+func (p *yamlParser) isMappingItem() bool {
+	line := p.lines[p.i]
+	text := strings.TrimSpace(line.text[1:])
+	if text == "" {
+		return p.i+1 < len(p.lines) && p.lines[p.i+1].indent > line.indent
+	}
+	_, _, ok := splitKey(text)
+
+	return ok
+}
+
+// errorf returns an error for a line.
+func (p *yamlParser) errorf(line yamlLine, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.name, line.number, fmt.Sprintf(format, args...))
+}
+
+// isItem reports whether a line is an item of a block sequence.
+func isItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitKey splits a line of a mapping into its key and value, reporting
+// whether it is one.
+func splitKey(text string) (key, value string, ok bool) {
+	if text == "" || isItem(text) || strings.ContainsRune(`"'[{`, rune(text[0])) {
+		return
+	}
+	i := strings.Index(text, ": ")
+	if i < 0 && strings.HasSuffix(text, ":") {
+		i = len(text) - 1
+	}
+	if i <= 0 {
+		return
+	}
+
+	return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+}
+
+// parseScalar parses a plain, single-quoted, or double-quoted scalar.
+func parseScalar(s string) (value string, err error) {
+	switch {
+	case s == "" || s == "~" || s == "null":
+		return "", nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", errors.New("unterminated string")
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.ContainsRune("|>&*!{%@`", rune(s[0])):
+		return "", fmt.Errorf("unsupported YAML %q", s)
+	}
+
+	return s, nil
+}
+
+// parseFlowSequence parses a sequence of scalars in brackets, such as
+// [GET, "HEAD"].
+func parseFlowSequence(s string) (values []string, err error) {
+	if !strings.HasSuffix(s, "]") {
+		return nil, errors.New("missing ]")
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+	values = []string{}
+	for s != "" {
+		end := 0
+		for quote := byte(0); end < len(s) && (quote != 0 || s[end] != ','); end++ {
+			switch {
+			case quote == '"' && s[end] == '\\':
+				end++
+			case quote == 0 && (s[end] == '"' || s[end] == '\'') && strings.TrimSpace(s[:end]) == "":
+				quote = s[end]
+			case s[end] == quote:
+				quote = 0
+			}
+		}
+		value, err := parseScalar(strings.TrimSpace(s[:end]))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if end < len(s) {
+			end++
+		}
+		s = strings.TrimSpace(s[end:])
+	}
+
+	return
+}
+
+// stripComment removes a comment from a line, leaving any "#" within quoted
+// strings or words.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '\'' && c == '\'' && i+1 < len(line) && line[i+1] == '\'':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" \t[,", line[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// writeYAML writes settings as a YAML mapping of their names to their
+// values, quoting those other than booleans and integers.
+func writeYAML(b *strings.Builder, settings []Setting) {
+	for _, setting := range settings {
+		value := setting.Value
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil && value != "true" && value != "false" {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(b, "%s: %s\n", setting.Name, value)
+	}
+}
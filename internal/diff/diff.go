@@ -0,0 +1,203 @@
+// Package diff compares versions of a TiddlyWiki tiddler by tiddler.
+package diff
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Op is the operation applied to a line.
+type Op string
+
+// Line operations.
+const (
+	Equal  Op = "="
+	Delete Op = "-"
+	Insert Op = "+"
+	Skip   Op = "~" // unchanged lines elided by Context
+)
+
+// Line is a line of a diff.
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Kinds of change to a tiddler.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change describes how a tiddler differs between two versions of a wiki.
+type Change struct {
+	Title string `json:"title"`
+	Kind  string `json:"kind"`
+	Lines []Line `json:"lines"`
+}
+
+// maxCells bounds the work done comparing two texts line by line. Texts
+// that differ by more than this are shown as entirely replaced.
+const maxCells = 1 << 22
+
+// Wikis compares two versions of a wiki, returning the tiddlers that were
+// added, removed, or changed, sorted by title. If neither version contains
+// tiddlers, the files are compared as a whole under an empty title.
+func Wikis(old, new []byte) []Change {
+	oldTiddlers := Parse(old)
+	newTiddlers := Parse(new)
+	if len(oldTiddlers) == 0 && len(newTiddlers) == 0 {
+		if string(old) == string(new) {
+			return nil
+		}
+		return []Change{{Kind: Changed, Lines: Lines(string(old), string(new))}}
+	}
+
+	var changes []Change
+	for title, oldTiddler := range oldTiddlers {
+		newTiddler, ok := newTiddlers[title]
+		switch {
+		case !ok:
+			changes = append(changes, Change{title, Removed, Lines(oldTiddler.String(), "")})
+		case oldTiddler.String() != newTiddler.String():
+			changes = append(changes, Change{title, Changed, Lines(oldTiddler.String(), newTiddler.String())})
+		}
+	}
+	for title, newTiddler := range newTiddlers {
+		if _, ok := oldTiddlers[title]; !ok {
+			changes = append(changes, Change{title, Added, Lines("", newTiddler.String())})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Title < changes[j].Title
+	})
+
+	return changes
+}
+
+// Lines compares two texts line by line.
+func Lines(old, new string) []Line {
+	a, b := split(old), split(new)
+
+	// Common prefixes and suffixes are cheap to find and usually most of a tiddler
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []Line
+	for _, text := range a[:prefix] {
+		lines = append(lines, Line{Equal, text})
+	}
+	lines = append(lines, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		lines = append(lines, Line{Equal, text})
+	}
+
+	return lines
+}
+
+// Context elides all but n lines of context around the changes in lines,
+// replacing each elided run with a single Skip line giving its length.
+func Context(lines []Line, n int) (context []Line) {
+	for i := 0; i < len(lines); {
+		if lines[i].Op != Equal {
+			context = append(context, lines[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(lines) && lines[end].Op == Equal {
+			end++
+		}
+		keepStart, keepEnd := n, n
+		if i == 0 {
+			keepStart = 0
+		}
+		if end == len(lines) {
+			keepEnd = 0
+		}
+		if end-i <= keepStart+keepEnd {
+			context = append(context, lines[i:end]...)
+		} else {
+			context = append(context, lines[i:i+keepStart]...)
+			skipped := end - i - keepStart - keepEnd
+			context = append(context, Line{Skip, strconv.Itoa(skipped) + " unchanged lines"})
+			context = append(context, lines[end-keepEnd:end]...)
+		}
+		i = end
+	}
+
+	return
+}
+
+// middle compares two runs of lines using their longest common subsequence.
+func middle(a, b []string) (lines []Line) {
+	if len(a)*len(b) > maxCells {
+		for _, text := range a {
+			lines = append(lines, Line{Delete, text})
+		}
+		for _, text := range b {
+			lines = append(lines, Line{Insert, text})
+		}
+		return
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, Line{Equal, a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, Line{Delete, a[i]})
+			i++
+		default:
+			lines = append(lines, Line{Insert, b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, Line{Delete, a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, Line{Insert, b[j]})
+	}
+
+	return
+}
+
+// split splits text into lines, treating the empty string as no lines.
+func split(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
@@ -0,0 +1,115 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	lines := Lines("a\nb\nc\nd\n", "a\nc\nx\nd\n")
+	want := []Line{
+		{Equal, "a"},
+		{Delete, "b"},
+		{Equal, "c"},
+		{Insert, "x"},
+		{Equal, "d"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("Lines = %v, want %v", lines, want)
+	}
+
+	if lines := Lines("", "a"); !reflect.DeepEqual(lines, []Line{{Insert, "a"}}) {
+		t.Errorf("Lines from empty = %v", lines)
+	}
+}
+
+func TestContext(t *testing.T) {
+	lines := []Line{
+		{Equal, "1"}, {Equal, "2"}, {Equal, "3"},
+		{Delete, "4"},
+		{Equal, "5"}, {Equal, "6"}, {Equal, "7"}, {Equal, "8"},
+		{Insert, "9"},
+		{Equal, "10"}, {Equal, "11"},
+	}
+	context := Context(lines, 1)
+	want := []Line{
+		{Skip, "2 unchanged lines"}, {Equal, "3"},
+		{Delete, "4"},
+		{Equal, "5"}, {Skip, "2 unchanged lines"}, {Equal, "8"},
+		{Insert, "9"},
+		{Equal, "10"}, {Skip, "1 unchanged lines"},
+	}
+	if !reflect.DeepEqual(context, want) {
+		t.Errorf("Context = %v, want %v", context, want)
+	}
+}
+
+const (
+	testJSONWiki = `<html><body>
+<script class="tiddlywiki-tiddler-store" type="application/json">[
+{"title":"Kept","text":"same"},
+{"title":"Edited","tags":"a","text":"one\ntwo"},
+{"title":"Deleted","text":"gone"}
+]</script>
+</body></html>`
+	testDivWiki = `<html><body>
+<div id="storeArea" style="display:none;">
+<div title="Kept">
+<pre>same</pre>
+</div>
+<div tags="a b" title="Edited">
+<pre>one
+&lt;b&gt;three&lt;/b&gt;</pre>
+</div>
+<div title="New">
+<pre>hello</pre>
+</div>
+</div>
+</body></html>`
+)
+
+func TestParse(t *testing.T) {
+	tiddlers := Parse([]byte(testJSONWiki))
+	if len(tiddlers) != 3 {
+		t.Fatalf("parsed %d JSON tiddlers, want 3", len(tiddlers))
+	}
+	if text := tiddlers["Edited"]["text"]; text != "one\ntwo" {
+		t.Errorf("JSON text = %q", text)
+	}
+
+	tiddlers = Parse([]byte(testDivWiki))
+	if len(tiddlers) != 3 {
+		t.Fatalf("parsed %d div tiddlers, want 3", len(tiddlers))
+	}
+	if text := tiddlers["Edited"]["text"]; text != "one\n<b>three</b>" {
+		t.Errorf("div text = %q", text)
+	}
+	if tags := tiddlers["Edited"]["tags"]; tags != "a b" {
+		t.Errorf("div tags = %q", tags)
+	}
+
+	if tiddlers := Parse([]byte("<html>not a wiki</html>")); len(tiddlers) != 0 {
+		t.Errorf("parsed %d tiddlers from a non-wiki", len(tiddlers))
+	}
+}
+
+func TestWikis(t *testing.T) {
+	changes := Wikis([]byte(testJSONWiki), []byte(testDivWiki))
+	var summary [][2]string
+	for _, change := range changes {
+		summary = append(summary, [2]string{change.Title, change.Kind})
+	}
+	want := [][2]string{
+		{"Deleted", Removed},
+		{"Edited", Changed},
+		{"New", Added},
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("changes = %v, want %v", summary, want)
+	}
+
+	changes = Wikis([]byte("a\nb"), []byte("a\nc"))
+	if len(changes) != 1 || changes[0].Title != "" {
+		t.Errorf("non-wiki changes = %v", changes)
+	}
+}
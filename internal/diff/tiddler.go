@@ -0,0 +1,91 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tiddler is a tiddler's fields, including its title and text.
+type Tiddler map[string]string
+
+// String formats the tiddler like a .tid file: its fields other than the text,
+// sorted by name, then a blank line and the text.
+func (t Tiddler) String() string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		if name != "text" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ": " + t[name] + "\n")
+	}
+	if text, ok := t["text"]; ok {
+		b.WriteString("\n" + text)
+	}
+
+	return b.String()
+}
+
+var (
+	// TiddlyWiki 5.2 and later store tiddlers as JSON in one or more scripts
+	jsonStore = regexp.MustCompile(`(?s)<script[^>]*class="tiddlywiki-tiddler-store"[^>]*>(.*?)</script>`)
+	// Earlier versions store each tiddler as a div in the store area
+	divStore   = regexp.MustCompile(`(?s)<div\s([^>]*)>\s*<pre>(.*?)</pre>\s*</div>`)
+	divField   = regexp.MustCompile(`([^\s=]+)="([^"]*)"`)
+	storeStart = `<div id="storeArea"`
+)
+
+// Parse extracts the tiddlers from a TiddlyWiki file, keyed by title. Stores
+// that can't be parsed are skipped, so the result is empty for files that
+// aren't wikis.
+func Parse(wiki []byte) map[string]Tiddler {
+	tiddlers := make(map[string]Tiddler)
+	for _, match := range jsonStore.FindAllSubmatch(wiki, -1) {
+		var store []map[string]interface{}
+		err := json.Unmarshal(match[1], &store)
+		if err != nil {
+			continue
+		}
+		for _, fields := range store {
+			tiddler := make(Tiddler, len(fields))
+			for name, value := range fields {
+				if s, ok := value.(string); ok {
+					tiddler[name] = s
+				} else {
+					tiddler[name] = fmt.Sprint(value)
+				}
+			}
+			// Later stores override earlier ones, as in TiddlyWiki itself
+			tiddlers[tiddler["title"]] = tiddler
+		}
+	}
+
+	start := strings.Index(string(wiki), storeStart)
+	if start < 0 {
+		return tiddlers
+	}
+	for _, match := range divStore.FindAllSubmatch(wiki[start:], -1) {
+		tiddler := make(Tiddler)
+		for _, field := range divField.FindAllSubmatch(match[1], -1) {
+			tiddler[string(field[1])] = html.UnescapeString(string(field[2]))
+		}
+		title, ok := tiddler["title"]
+		if !ok {
+			continue
+		}
+		tiddler["text"] = html.UnescapeString(string(match[2]))
+		if _, ok := tiddlers[title]; !ok {
+			tiddlers[title] = tiddler
+		}
+	}
+
+	return tiddlers
+}
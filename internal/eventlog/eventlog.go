@@ -0,0 +1,57 @@
+// Package eventlog writes putter's log to the Windows Event Log, where
+// desktop users can find problems in Event Viewer.
+package eventlog
+
+import (
+	"strings"
+	"time"
+)
+
+// Target names the Windows Event Log as a log target.
+const Target = "eventlog"
+
+// Source is the event source under which putter's events are reported.
+const Source = "putter"
+
+// Kind is the severity of an event.
+type Kind uint16
+
+// Kinds of events, as the Event Log numbers them.
+const (
+	Error       Kind = 0x0001
+	Warning     Kind = 0x0002
+	Information Kind = 0x0004
+)
+
+// IDLog is the event ID of lines of the log that aren't otherwise identified.
+const IDLog = 1
+
+// stdTime is the prefix that the standard logger's default flags give each
+// line, which the Event Log records for itself.
+const stdTime = "2006/01/02 15:04:05 "
+
+// Write reports each line written to it as an event with ID IDLog.
+func (l *Log) Write(p []byte) (n int, err error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(line) >= len(stdTime) {
+			_, err = time.Parse(stdTime, line[:len(stdTime)])
+			if err == nil {
+				line = line[len(stdTime):]
+			}
+		}
+		// Putter's messages don't have levels, but failures and warnings are
+		// consistently worded
+		kind := Information
+		if strings.Contains(line, "failed") {
+			kind = Error
+		} else if strings.Contains(line, "warning:") {
+			kind = Warning
+		}
+		err = l.Report(kind, IDLog, line)
+		if err != nil {
+			return
+		}
+	}
+
+	return len(p), nil
+}
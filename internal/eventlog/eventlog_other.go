@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package eventlog
+
+import "errors"
+
+// Log reports events to the Event Log, which is only available on Windows.
+type Log struct{}
+
+// Open returns an error, since the Event Log is only available on Windows.
+func Open() (*Log, error) {
+	return nil, errors.New("the Windows Event Log is only available on Windows")
+}
+
+// Report does nothing.
+func (l *Log) Report(kind Kind, id uint32, message string) error {
+	return nil
+}
+
+// Close does nothing.
+func (l *Log) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+package eventlog
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+// Log reports events to the Event Log of the local machine.
+type Log struct {
+	handle uintptr
+}
+
+// Open opens the Event Log to report events from Source. Event Viewer only
+// shows their messages properly once the source is registered, e.g. with
+// New-EventLog in PowerShell.
+func Open() (*Log, error) {
+	source, err := syscall.UTF16PtrFromString(Source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, errno := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(source)))
+	if h == 0 {
+		return nil, errno
+	}
+
+	return &Log{handle: h}, nil
+}
+
+// Report reports an event with the given kind, ID and message.
+func (l *Log) Report(kind Kind, id uint32, message string) error {
+	s, err := syscall.UTF16PtrFromString(strings.Replace(message, "\x00", "", -1))
+	if err != nil {
+		return err
+	}
+	strs := []*uint16{s}
+	r, _, errno := procReportEvent.Call(
+		l.handle,
+		uintptr(kind),
+		0,
+		uintptr(id),
+		0,
+		uintptr(len(strs)),
+		0,
+		uintptr(unsafe.Pointer(&strs[0])),
+		0,
+	)
+	if r == 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Close closes the Event Log.
+func (l *Log) Close() error {
+	r, _, errno := procDeregisterEventSource.Call(l.handle)
+	if r == 0 {
+		return errno
+	}
+
+	return nil
+}
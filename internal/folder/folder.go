@@ -0,0 +1,334 @@
+// Package folder serves a TiddlyWiki wiki folder, as used by TiddlyWiki on
+// Node.js, as a single-file wiki: rendering the folder to a single file with
+// the tiddlywiki command, and splitting saves of that file back into the
+// folder's tiddler files.
+package folder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/djcrock/putter/internal/diff"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+const (
+	// InfoFile marks a directory as a wiki folder.
+	InfoFile = "tiddlywiki.info"
+	// TiddlersDir is the directory of a wiki folder holding its tiddlers.
+	TiddlersDir = "tiddlers"
+)
+
+// renderPrefix is prepended to the name of the rendered wiki while it is
+// being rendered, so that the live wiki is replaced whole.
+const renderPrefix = ".render-"
+
+// IsFolder reports whether dir is a wiki folder.
+func IsFolder(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, InfoFile))
+
+	return err == nil
+}
+
+// Render renders the wiki folder dir to the single-file wiki output with
+// command, the tiddlywiki command and any arguments preceding the folder
+// (e.g. "npx tiddlywiki"). output is replaced whole once rendering succeeds.
+func Render(ctx context.Context, command, dir, output string) (err error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return errors.New("no tiddlywiki command")
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	output, err = filepath.Abs(output)
+	if err != nil {
+		return
+	}
+	outputDir, name := filepath.Split(output)
+	rendering := filepath.Join(outputDir, renderPrefix+name)
+	os.Remove(rendering)
+	defer os.Remove(rendering)
+
+	program, args := args[0], append(args[1:], dir, "--output", outputDir, "--render", "$:/core/save/all", renderPrefix+name, "text/plain")
+	out, err := exec.CommandContext(ctx, program, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", command, err, bytes.TrimSpace(out))
+	}
+
+	return os.Rename(rendering, output)
+}
+
+// Modified returns the latest modification time of the wiki folder dir's
+// info file and tiddlers, including the directories holding them, which
+// change when tiddlers are added or removed.
+func Modified(dir string) (modified time.Time, err error) {
+	fileInfo, err := os.Stat(filepath.Join(dir, InfoFile))
+	if err != nil {
+		return
+	}
+	modified = fileInfo.ModTime()
+	err = filepath.Walk(filepath.Join(dir, TiddlersDir), func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fileInfo.ModTime().After(modified) {
+			modified = fileInfo.ModTime()
+		}
+		return nil
+	})
+
+	return
+}
+
+// ignored reports whether the tiddler titled title is transient UI state,
+// which TiddlyWiki on Node.js doesn't save either.
+func ignored(title string, tiddler diff.Tiddler) bool {
+	if _, ok := tiddler["draft.of"]; ok {
+		return true
+	}
+	for _, prefix := range []string{"$:/temp/", "$:/state/popup/"} {
+		if strings.HasPrefix(title, prefix) {
+			return true
+		}
+	}
+
+	return title == "$:/HistoryList" || title == "$:/StoryList"
+}
+
+// Split writes the tiddlers that differ between old, the wiki as last
+// rendered or saved, and new, its replacement, to the wiki folder dir:
+// changed tiddlers are rewritten, new ones are added as .tid files (or .json
+// files, if they have fields spanning lines), and removed ones are deleted.
+// Tiddlers held in files that putter can't rewrite, like .meta files beside
+// binary tiddlers or .json files holding several, are left alone and reported
+// in skipped. changed is the number of tiddlers written or deleted. Files are
+// created with fileMode, and the tiddlers directory with dirMode.
+func Split(dir string, old, new []byte, fileMode, dirMode os.FileMode) (changed int, skipped []string, err error) {
+	newTiddlers := diff.Parse(new)
+	if len(newTiddlers) == 0 {
+		// Writing would delete every tiddler in the folder
+		return 0, nil, errors.New("the saved wiki has no tiddlers")
+	}
+	oldTiddlers := diff.Parse(old)
+	tiddlersDir := filepath.Join(dir, TiddlersDir)
+	err = os.MkdirAll(tiddlersDir, dirMode)
+	if err != nil {
+		return
+	}
+	files, err := index(tiddlersDir)
+	if err != nil {
+		return
+	}
+
+	titles := make([]string, 0, len(newTiddlers)+len(oldTiddlers))
+	for title := range newTiddlers {
+		titles = append(titles, title)
+	}
+	for title := range oldTiddlers {
+		if _, ok := newTiddlers[title]; !ok {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+
+	for _, title := range titles {
+		oldTiddler, wasOld := oldTiddlers[title]
+		newTiddler, isNew := newTiddlers[title]
+		if wasOld && isNew && oldTiddler.String() == newTiddler.String() {
+			continue
+		}
+		if ignored(title, newTiddler) || ignored(title, oldTiddler) {
+			continue
+		}
+		file, ok := files[title]
+		if ok && !file.rewritable {
+			skipped = append(skipped, title)
+			continue
+		}
+		if !isNew {
+			if !ok {
+				continue
+			}
+			err = os.Remove(file.path)
+			if err != nil && !os.IsNotExist(err) {
+				return
+			}
+			changed++
+			continue
+		}
+
+		data, extension := encode(newTiddler)
+		path := file.path
+		if !ok || filepath.Ext(path) != extension {
+			if ok {
+				// The tiddler changes format, e.g. a field now spans lines
+				err = os.Remove(file.path)
+				if err != nil && !os.IsNotExist(err) {
+					return
+				}
+			}
+			path = freeName(tiddlersDir, FileName(title), extension, files)
+			files[title] = tiddlerFile{path: path, rewritable: true}
+		}
+		err = storage.WriteFile(path, data, fileMode)
+		if err != nil {
+			return
+		}
+		changed++
+	}
+
+	return changed, skipped, nil
+}
+
+// encode returns tiddler as the contents of a tiddler file, and the
+// extension of its type: .tid, unless a field other than the text spans
+// lines, which .tid files can't hold.
+func encode(tiddler diff.Tiddler) ([]byte, string) {
+	for name, value := range tiddler {
+		if name != "text" && strings.ContainsAny(value, "\r\n") {
+			data, _ := json.MarshalIndent([]diff.Tiddler{tiddler}, "", "\t")
+			return data, ".json"
+		}
+	}
+
+	return []byte(tiddler.String()), ".tid"
+}
+
+// unsafeName matches characters that TiddlyWiki replaces in file names.
+var unsafeName = regexp.MustCompile(`[<>:"/\\|?*^\x00-\x1f]`)
+
+// maxName bounds the length of file names derived from titles.
+const maxName = 200
+
+// FileName returns the name, without extension, of the file that TiddlyWiki
+// on Node.js would give the tiddler titled title: characters that aren't
+// allowed in file names on some systems are replaced with underscores.
+func FileName(title string) string {
+	name := unsafeName.ReplaceAllString(title, "_")
+	if len(name) > maxName {
+		cut := maxName
+		for !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut]
+	}
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = "_"
+	}
+
+	return name
+}
+
+// freeName returns the path of a file in dir named name with extension that
+// holds no other tiddler, numbering it if it would.
+func freeName(dir, name, extension string, files map[string]tiddlerFile) string {
+	taken := make(map[string]bool, len(files))
+	for _, file := range files {
+		taken[file.path] = true
+	}
+	path := filepath.Join(dir, name+extension)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(path); !taken[path] && os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, name+" "+strconv.Itoa(i)+extension)
+	}
+}
+
+// tiddlerFile is the file holding a tiddler.
+type tiddlerFile struct {
+	path       string
+	rewritable bool // whether the file holds only this tiddler, in a form putter writes
+}
+
+// index returns the files holding the tiddlers in dir, by title.
+func index(dir string) (files map[string]tiddlerFile, err error) {
+	files = make(map[string]tiddlerFile)
+	err = filepath.Walk(dir, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fileInfo.IsDir() {
+			return nil
+		}
+		switch filepath.Ext(path) {
+		case ".tid":
+			title, err := headerTitle(path)
+			if err != nil || title == "" {
+				return err
+			}
+			files[title] = tiddlerFile{path: path, rewritable: true}
+		case ".meta":
+			title, err := headerTitle(path)
+			if err != nil || title == "" {
+				return err
+			}
+			files[title] = tiddlerFile{path: path}
+		case ".json":
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			var tiddlers []map[string]interface{}
+			if json.Unmarshal(data, &tiddlers) != nil {
+				// Not tiddlers, e.g. plugin.info
+				return nil
+			}
+			for _, tiddler := range tiddlers {
+				if title, ok := tiddler["title"].(string); ok {
+					files[title] = tiddlerFile{path: path, rewritable: len(tiddlers) == 1}
+				}
+			}
+		}
+		return nil
+	})
+
+	return
+}
+
+// headerTitle returns the title given in the header of the .tid or .meta
+// file path.
+func headerTitle(path string) (title string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+		if strings.HasPrefix(line, "title:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "title:")), nil
+		}
+	}
+
+	return "", scanner.Err()
+}
@@ -0,0 +1,160 @@
+package folder
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestFileName(t *testing.T) {
+	for title, want := range map[string]string{
+		"Hello World":       "Hello World",
+		"$:/SiteTitle":      "$__SiteTitle",
+		`a<b>c"d|e?f*g^h\i`: "a_b_c_d_e_f_g_h_i",
+		" .. ":              "_",
+	} {
+		if name := FileName(title); name != want {
+			t.Errorf("FileName(%q) = %q, want %q", title, name, want)
+		}
+	}
+}
+
+// testWiki returns a wiki storing tiddlers, which are JSON objects.
+func testWiki(tiddlers ...string) []byte {
+	wiki := `<html><body><script class="tiddlywiki-tiddler-store" type="application/json">[`
+	for i, tiddler := range tiddlers {
+		if i > 0 {
+			wiki += ","
+		}
+		wiki += tiddler
+	}
+
+	return []byte(wiki + `]</script></body></html>`)
+}
+
+func TestSplit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter-folder-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tiddlers := filepath.Join(dir, TiddlersDir)
+	for name, data := range map[string]string{
+		"Kept.tid":       "title: Kept\n\nsame",
+		"renamed.tid":    "created: 20240501\ntitle: Edited\n\nbefore",
+		"Deleted.tid":    "title: Deleted\n\ngone",
+		"image.png.meta": "title: image.png\ntype: image/png",
+		"several.json":   `[{"title":"One","text":"1"},{"title":"Two","text":"2"}]`,
+	} {
+		err = os.MkdirAll(tiddlers, 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(tiddlers, name), []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := testWiki(
+		`{"title":"Kept","text":"same"}`,
+		`{"title":"Edited","created":"20240501","text":"before"}`,
+		`{"title":"Deleted","text":"gone"}`,
+		`{"title":"image.png","type":"image/png","text":"AAAA"}`,
+		`{"title":"One","text":"1"}`,
+		`{"title":"$:/StoryList","list":"Kept"}`,
+	)
+	new := testWiki(
+		`{"title":"Kept","text":"same"}`,
+		`{"title":"Edited","created":"20240501","text":"after"}`,
+		`{"title":"$:/SiteTitle","text":"My Wiki"}`,
+		`{"title":"Multi","caption":"one\ntwo","text":"x"}`,
+		`{"title":"image.png","type":"image/png","text":"BBBB"}`,
+		`{"title":"One","text":"uno"}`,
+		`{"title":"$:/StoryList","list":"Edited"}`,
+		`{"title":"Draft of 'Kept'","draft.of":"Kept","text":"draft"}`,
+	)
+	changed, skipped, err := Split(dir, old, new, 0644, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 4 {
+		t.Errorf("changed %d tiddlers, want 4", changed)
+	}
+	if want := []string{"One", "image.png"}; !reflect.DeepEqual(skipped, want) {
+		t.Errorf("skipped %v, want %v", skipped, want)
+	}
+
+	infos, err := ioutil.ReadDir(tiddlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+	want := []string{"$__SiteTitle.tid", "Kept.tid", "Multi.json", "image.png.meta", "renamed.tid", "several.json"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("tiddler files %v, want %v", names, want)
+	}
+	for name, want := range map[string]string{
+		"renamed.tid":      "created: 20240501\ntitle: Edited\n\nafter",
+		"$__SiteTitle.tid": "title: $:/SiteTitle\n\nMy Wiki",
+		"several.json":     `[{"title":"One","text":"1"},{"title":"Two","text":"2"}]`,
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tiddlers, name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+
+	_, _, err = Split(dir, new, []byte("<html>not a wiki</html>"), 0644, 0755)
+	if err == nil {
+		t.Error("split a wiki with no tiddlers")
+	}
+	if _, err := os.Stat(filepath.Join(tiddlers, "Kept.tid")); err != nil {
+		t.Errorf("splitting a wiki with no tiddlers deleted them: %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "putter-folder-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Stands in for tiddlywiki, writing its arguments to the output
+	script := filepath.Join(dir, "tiddlywiki.sh")
+	err = ioutil.WriteFile(script, []byte(`echo "$@" > "$3/$6"`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "output", "index.html")
+	err = os.Mkdir(filepath.Dir(output), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Render(context.Background(), "sh "+script, dir, output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(output)
+	want := dir + " --output " + filepath.Dir(output) + "/ --render $:/core/save/all .render-index.html text/plain\n"
+	if err != nil || string(data) != want {
+		t.Errorf("rendered %q, %v, want %q", data, err, want)
+	}
+
+	err = Render(context.Background(), "false", dir, output)
+	if err == nil {
+		t.Error("failed render succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+// Package git keeps the history of a file in a git repository by running the
+// git command, so that its versions can be compared, restored, and pushed
+// elsewhere with git's own tools, and are stored as deltas once git packs
+// them.
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Identity with which commits are made if git has none configured.
+const (
+	defaultName  = "putter"
+	defaultEmail = "putter@localhost"
+)
+
+// Repo is a git repository into which versions of a file are committed.
+type Repo struct {
+	dir  string   // directory of the file, within the repository's work tree
+	name string   // name of the file within dir
+	env  []string // added to git's environment
+}
+
+// Open opens the git repository holding file, creating one in the file's
+// directory if there is none.
+func Open(ctx context.Context, file string) (r *Repo, err error) {
+	_, err = exec.LookPath("git")
+	if err != nil {
+		return nil, errors.New("git isn't installed")
+	}
+	file, err = filepath.Abs(file)
+	if err != nil {
+		return
+	}
+	r = &Repo{
+		dir:  filepath.Dir(file),
+		name: filepath.Base(file),
+		// Never wait for credentials that nobody is there to type
+		env: []string{"GIT_TERMINAL_PROMPT=0"},
+	}
+	_, err = r.run(ctx, "rev-parse", "--git-dir")
+	if err != nil {
+		_, err = r.run(ctx, "init", "--quiet")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if email, _ := r.run(ctx, "config", "user.email"); email == "" {
+		r.env = append(r.env,
+			"GIT_AUTHOR_NAME="+defaultName, "GIT_AUTHOR_EMAIL="+defaultEmail,
+			"GIT_COMMITTER_NAME="+defaultName, "GIT_COMMITTER_EMAIL="+defaultEmail)
+	}
+
+	return r, nil
+}
+
+// Commit commits the file as it is with message, by author if not empty,
+// leaving anything else staged alone. It reports whether the file had
+// changed since it was last committed; if it hadn't, nothing is committed.
+func (r *Repo) Commit(ctx context.Context, message, author string) (committed bool, err error) {
+	_, err = r.run(ctx, "add", "--", r.name)
+	if err != nil {
+		return
+	}
+	status, err := r.run(ctx, "status", "--porcelain", "--", r.name)
+	if err != nil || status == "" {
+		return
+	}
+	args := []string{"commit", "--quiet", "--no-verify", "--message", message}
+	if author != "" {
+		args = append(args, "--author", author+" <>")
+	}
+	_, err = r.run(ctx, append(args, "--", r.name)...)
+	if err != nil {
+		return
+	}
+
+	return true, nil
+}
+
+// Push pushes the current branch to remote, the name or URL of a repository.
+func (r *Repo) Push(ctx context.Context, remote string) (err error) {
+	_, err = r.run(ctx, "push", "--quiet", remote, "HEAD")
+
+	return
+}
+
+// run runs git in the file's directory, returning its trimmed output.
+func (r *Repo) run(ctx context.Context, args ...string) (out string, err error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(), r.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
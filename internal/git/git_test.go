@@ -0,0 +1,87 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	dir, err := ioutil.TempDir("", "git-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	name := filepath.Join(dir, "wiki", "index.html")
+	if err := os.Mkdir(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(content string) {
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit := func(r *Repo, message, author string, want bool) {
+		t.Helper()
+		committed, err := r.Commit(ctx, message, author)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if committed != want {
+			t.Errorf("Commit(%q) committed %v, want %v", message, committed, want)
+		}
+	}
+
+	write("<html>one</html>")
+	r, err := Open(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "wiki", ".git")); err != nil {
+		t.Fatalf("no repository was created: %v", err)
+	}
+	commit(r, "first", "", true)
+	commit(r, "unchanged", "", false)
+	write("<html>two</html>")
+	ioutil.WriteFile(filepath.Join(dir, "wiki", "other.txt"), []byte("not committed"), 0644)
+	commit(r, "second", "alice", true)
+
+	if log, _ := r.run(ctx, "log", "--format=%s"); log != "second\nfirst" {
+		t.Errorf("log is %q, want the two commits", log)
+	}
+	if author, _ := r.run(ctx, "log", "-1", "--format=%an"); author != "alice" {
+		t.Errorf("author is %q, want alice", author)
+	}
+	if files, _ := r.run(ctx, "ls-files"); files != "index.html" {
+		t.Errorf("committed files are %q, want only the wiki", files)
+	}
+
+	// A repository already holding the file is used as it is
+	r, err = Open(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit(r, "reopened", "", false)
+
+	remote := filepath.Join(dir, "remote.git")
+	if _, err := r.run(ctx, "init", "--quiet", "--bare", remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Push(ctx, remote); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "--git-dir", remote, "log", "--format=%s").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "second\nfirst\n" {
+		t.Errorf("remote log is %q, want the two commits", out)
+	}
+}
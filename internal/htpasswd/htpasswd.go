@@ -0,0 +1,201 @@
+// Package htpasswd checks users' credentials against a password file in the
+// format written by Apache's htpasswd, with MD5 ($apr1$) or SHA-1 ({SHA})
+// hashes. bcrypt hashes, which need a package outside the standard library,
+// aren't supported.
+package htpasswd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// Prefixes of the supported hashes.
+const (
+	prefixMD5  = "$apr1$"
+	prefixSHA1 = "{SHA}"
+)
+
+// itoa64 is the alphabet in which MD5 hashes are encoded.
+const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// File is a password file whose users may read and save the wiki. It is
+// read again when it changes, so that users can be added without a restart.
+type File struct {
+	name    string
+	mu      sync.Mutex        // protects the fields below
+	modTime time.Time         // of the file when it was last read
+	hashes  map[string]string // by user name
+}
+
+// Open reads the password file name.
+func Open(name string) (f *File, err error) {
+	f = &File{name: name}
+	info, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	f.hashes, err = read(name)
+	if err != nil {
+		return
+	}
+	f.modTime = info.ModTime()
+
+	return
+}
+
+// Check checks credentials for a request against the file, granting write
+// access if they match. If the file has changed and can no longer be read,
+// the users it last held are kept.
+func (f *File) Check(r *http.Request, user, password string) server.Access {
+	f.mu.Lock()
+	if info, err := os.Stat(f.name); err == nil && !info.ModTime().Equal(f.modTime) {
+		hashes, err := read(f.name)
+		if err != nil {
+			server.Logf(r.Context(), "failed to reread %s: %v", f.name, err)
+		} else {
+			f.hashes = hashes
+		}
+		f.modTime = info.ModTime()
+	}
+	f.mu.Unlock()
+
+	if !f.Accepts(user, password) {
+		server.Logf(r.Context(), "authentication failed for user \"%s\"", user)
+		return server.AccessNone
+	}
+
+	return server.AccessWrite
+}
+
+// Accepts reports whether the file, as it was last read, holds user with
+// password.
+func (f *File) Accepts(user, password string) bool {
+	f.mu.Lock()
+	hash, ok := f.hashes[user]
+	f.mu.Unlock()
+
+	return ok && Verify(hash, password)
+}
+
+// Verify reports whether password matches hash.
+func Verify(hash, password string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, prefixMD5):
+		salt := strings.SplitN(hash[len(prefixMD5):], "$", 2)[0]
+		computed = md5Crypt(password, salt)
+	case strings.HasPrefix(hash, prefixSHA1):
+		sum := sha1.Sum([]byte(password))
+		computed = prefixSHA1 + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// read reads the users and hashes of a password file, ignoring blank lines
+// and comments, and refusing hashes that aren't supported.
+func read(name string) (hashes map[string]string, err error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return
+	}
+	hashes = make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", name, n)
+		}
+		user, hash := line[:i], line[i+1:]
+		if !strings.HasPrefix(hash, prefixMD5) && !strings.HasPrefix(hash, prefixSHA1) {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for user \"%s\", only MD5 (htpasswd -m) and SHA-1 (htpasswd -s) are", name, n, user)
+		}
+		hashes[user] = hash
+	}
+
+	return hashes, scanner.Err()
+}
+
+// md5Crypt returns the $apr1$ hash of password with salt, Apache's variant
+// of the MD5-based crypt of FreeBSD.
+func md5Crypt(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+	h := md5.New()
+	h.Write([]byte(password + prefixMD5 + salt))
+	for i := len(pw); i > 0; i -= md5.Size {
+		if i > md5.Size {
+			h.Write(alt[:])
+		} else {
+			h.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	sum := h.Sum(nil)
+
+	// Stretch the hash, as the original does to slow down guessing
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(sum)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(sum)
+		} else {
+			h.Write(pw)
+		}
+		sum = h.Sum(nil)
+	}
+
+	var b strings.Builder
+	b.WriteString(prefixMD5 + salt + "$")
+	encode := func(v uint, n int) {
+		for ; n > 0; n-- {
+			b.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint(sum[i[0]])<<16|uint(sum[i[1]])<<8|uint(sum[i[2]]), 4)
+	}
+	encode(uint(sum[11]), 2)
+
+	return b.String()
+}
@@ -0,0 +1,83 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+func TestVerify(t *testing.T) {
+	for _, test := range []struct {
+		hash, password string
+		ok             bool
+	}{
+		// From openssl passwd -apr1
+		{"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", "secret", true},
+		{"$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0", "Secret", false},
+		{"$apr1$ab$ZgbyBttfAvWjwKDroS41O1", "a much longer password than sixteen bytes", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "secret", true},
+		{"{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", "", false},
+		{"secret", "secret", false},
+	} {
+		if ok := Verify(test.hash, test.password); ok != test.ok {
+			t.Errorf("Verify(%q, %q) = %v, want %v", test.hash, test.password, ok, test.ok)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, ".htpasswd")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(name, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(f *File, user, password string, want server.Access) {
+		t.Helper()
+		r := httptest.NewRequest("PUT", "/", nil)
+		if access := f.Check(r, user, password); access != want {
+			t.Errorf("Check(%q, %q) = %v, want %v", user, password, access, want)
+		}
+	}
+
+	write("# editors\nalice:$apr1$saltsalt$LrttParrLPdxvgutaSXWJ0\n\nbob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", time.Now().Add(-time.Hour))
+	f, err := Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(f, "alice", "secret", server.AccessWrite)
+	check(f, "bob", "secret", server.AccessWrite)
+	check(f, "alice", "wrong", server.AccessNone)
+	check(f, "carol", "secret", server.AccessNone)
+	if !f.Accepts("alice", "secret") || f.Accepts("alice", "wrong") {
+		t.Error("Accepts disagrees with Check")
+	}
+
+	// Changes are picked up, and broken ones ignored
+	write("bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n", time.Now())
+	check(f, "alice", "secret", server.AccessNone)
+	check(f, "bob", "secret", server.AccessWrite)
+	write("bob:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC\n", time.Now().Add(time.Hour))
+	check(f, "bob", "secret", server.AccessWrite)
+
+	if _, err := Open(name); err == nil {
+		t.Error("Open succeeded with a bcrypt hash, want an error")
+	}
+	write("alice\n", time.Now())
+	if _, err := Open(name); err == nil {
+		t.Error("Open succeeded with a line without a hash, want an error")
+	}
+}
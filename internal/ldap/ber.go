@@ -0,0 +1,177 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BER tags of the types and LDAP operations used.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest      = 0x60 // [APPLICATION 0], constructed
+	tagBindResponse     = 0x61 // [APPLICATION 1], constructed
+	tagUnbindRequest    = 0x42 // [APPLICATION 2], primitive
+	tagSearchRequest    = 0x63 // [APPLICATION 3], constructed
+	tagSearchResultItem = 0x64 // [APPLICATION 4], constructed
+	tagSearchResultDone = 0x65 // [APPLICATION 5], constructed
+	tagSearchReference  = 0x73 // [APPLICATION 19], constructed
+
+	tagSimpleAuth    = 0x80 // [0], primitive, in a bind request
+	tagEqualityMatch = 0xa3 // [3], constructed, in a filter
+)
+
+// maxElement bounds the size of an element read, so that a broken or
+// malicious server can't make us allocate without limit.
+const maxElement = 1 << 20
+
+// element is a BER element: a tag and its contents, which for constructed
+// types are further elements.
+type element struct {
+	tag      byte
+	value    []byte
+	children []element
+}
+
+// encode returns the BER encoding of a primitive element.
+func encode(tag byte, value []byte) []byte {
+	b := []byte{tag}
+	n := len(value)
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	case n <= 0xffff:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(b, value...)
+}
+
+// construct returns the BER encoding of a constructed element holding the
+// given encoded elements.
+func construct(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+
+	return encode(tag, value)
+}
+
+// encodeInt returns the BER encoding of a small non-negative integer.
+func encodeInt(tag byte, n int) []byte {
+	var value []byte
+	for {
+		value = append([]byte{byte(n)}, value...)
+		n >>= 8
+		if n == 0 {
+			break
+		}
+	}
+	if value[0]&0x80 != 0 {
+		value = append([]byte{0}, value...)
+	}
+
+	return encode(tag, value)
+}
+
+// encodeString returns the BER encoding of an octet string.
+func encodeString(s string) []byte {
+	return encode(tagOctetString, []byte(s))
+}
+
+// readElement reads a BER element, parsing constructed elements.
+func readElement(r *bufio.Reader) (e element, err error) {
+	e.tag, err = r.ReadByte()
+	if err != nil {
+		return
+	}
+	length, err := r.ReadByte()
+	if err != nil {
+		return
+	}
+	n := int(length)
+	if length&0x80 != 0 {
+		octets := int(length &^ 0x80)
+		if octets == 0 || octets > 4 {
+			return e, fmt.Errorf("unsupported BER length of %d octets", octets)
+		}
+		n = 0
+		for i := 0; i < octets; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return e, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > maxElement {
+		return e, fmt.Errorf("BER element of %d bytes is too large", n)
+	}
+	e.value = make([]byte, n)
+	_, err = io.ReadFull(r, e.value)
+	if err != nil {
+		return
+	}
+	if e.tag&0x20 != 0 {
+		e.children, err = parseChildren(e.value)
+	}
+
+	return
+}
+
+// parseChildren parses the elements within a constructed element.
+func parseChildren(value []byte) (children []element, err error) {
+	for len(value) > 0 {
+		if len(value) < 2 {
+			return nil, errors.New("truncated BER element")
+		}
+		tag, n, header := value[0], int(value[1]), 2
+		if value[1]&0x80 != 0 {
+			octets := int(value[1] &^ 0x80)
+			if octets == 0 || octets > 4 || len(value) < 2+octets {
+				return nil, errors.New("invalid BER length")
+			}
+			n = 0
+			for _, b := range value[2 : 2+octets] {
+				n = n<<8 | int(b)
+			}
+			header += octets
+		}
+		if n < 0 || len(value) < header+n {
+			return nil, errors.New("truncated BER element")
+		}
+		child := element{tag: tag, value: value[header : header+n]}
+		if tag&0x20 != 0 {
+			child.children, err = parseChildren(child.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		children = append(children, child)
+		value = value[header+n:]
+	}
+
+	return
+}
+
+// int returns the value of an integer or enumerated element.
+func (e element) int() int {
+	n := 0
+	for _, b := range e.value {
+		n = n<<8 | int(b)
+	}
+
+	return n
+}
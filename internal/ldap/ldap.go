@@ -0,0 +1,294 @@
+// Package ldap checks users' credentials against an LDAP directory, such as
+// Active Directory, by binding as them, and finds the groups they belong to.
+// It speaks just enough of LDAP version 3 for that.
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// DefaultGroupAttribute is the attribute of a user's entry listing the groups
+// they belong to, in Active Directory and OpenLDAP with the memberOf overlay.
+const DefaultGroupAttribute = "memberOf"
+
+// timeout bounds how long checking a user's credentials may take.
+const timeout = 10 * time.Second
+
+// resultInvalidCredentials is the result of a bind with the wrong password.
+const resultInvalidCredentials = 49
+
+// ErrInvalidCredentials is returned when the directory rejects a password.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Directory is an LDAP directory whose users may use the wiki.
+type Directory struct {
+	URL            string   // ldap://host[:port] or ldaps://host[:port]
+	BindDN         string   // DN to bind as, with %s for the user name, e.g. uid=%s,ou=people,dc=example,dc=com or %s@example.com
+	BaseDN         string   // where users' entries are searched for, to find their groups
+	UserAttribute  string   // attribute of users' entries holding their user names, e.g. uid or sAMAccountName
+	GroupAttribute string   // attribute of users' entries listing their groups, DefaultGroupAttribute if empty
+	Readers        []string // groups, by DN or common name, whose members may read the wiki
+	Writers        []string // groups whose members may read and save the wiki
+
+	// TLSConfig configures ldaps:// connections, if not nil.
+	TLSConfig *tls.Config
+}
+
+// Check checks credentials for a request by binding as the user, granting
+// write access if no groups are configured, and otherwise the access of the
+// groups they belong to.
+func (d *Directory) Check(r *http.Request, user, password string) server.Access {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	groups, err := d.Authenticate(ctx, user, password)
+	if err != nil {
+		if err != ErrInvalidCredentials {
+			server.Logf(r.Context(), "failed to check credentials with LDAP: %v", err)
+		}
+		server.Logf(r.Context(), "authentication failed for user \"%s\"", user)
+		return server.AccessNone
+	}
+	if len(d.Readers) == 0 && len(d.Writers) == 0 {
+		return server.AccessWrite
+	}
+	if inGroups(groups, d.Writers) {
+		return server.AccessWrite
+	}
+	if inGroups(groups, d.Readers) {
+		return server.AccessRead
+	}
+	server.Logf(r.Context(), "user \"%s\" is in none of the groups allowed to use the wiki", user)
+
+	return server.AccessNone
+}
+
+// Authenticate binds as the user with password, returning the DNs of the
+// groups they belong to if any groups are configured, or
+// ErrInvalidCredentials if the directory rejects them.
+func (d *Directory) Authenticate(ctx context.Context, user, password string) (groups []string, err error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if user == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	c, err := d.dial(ctx)
+	if err != nil {
+		return
+	}
+	defer c.close()
+
+	bindDN := user
+	if strings.Contains(d.BindDN, "=") {
+		bindDN = escapeDN(user)
+	}
+	bindDN = strings.Replace(d.BindDN, "%s", bindDN, -1)
+	result, err := c.request(tagBindResponse, construct(tagBindRequest,
+		encodeInt(tagInteger, 3),
+		encodeString(bindDN),
+		encode(tagSimpleAuth, []byte(password)),
+	))
+	if err != nil {
+		return
+	}
+	code, message := ldapResult(result[0])
+	if code == resultInvalidCredentials {
+		return nil, ErrInvalidCredentials
+	}
+	if code != 0 {
+		return nil, fmt.Errorf("bind failed with result %d: %s", code, message)
+	}
+	if len(d.Readers) == 0 && len(d.Writers) == 0 {
+		return
+	}
+
+	return d.groups(c, user)
+}
+
+// groups searches for the user's entry, returning the groups it lists.
+func (d *Directory) groups(c *conn, user string) (groups []string, err error) {
+	attribute := d.GroupAttribute
+	if attribute == "" {
+		attribute = DefaultGroupAttribute
+	}
+	results, err := c.request(tagSearchResultDone, construct(tagSearchRequest,
+		encodeString(d.BaseDN),
+		encodeInt(tagEnumerated, 2), // the whole subtree
+		encodeInt(tagEnumerated, 0), // never dereferencing aliases
+		encodeInt(tagInteger, 2),    // entries
+		encodeInt(tagInteger, int(timeout/time.Second)),
+		encode(tagBoolean, []byte{0}),
+		construct(tagEqualityMatch, encodeString(d.UserAttribute), encodeString(user)),
+		construct(tagSequence, encodeString(attribute)),
+	))
+	if err != nil {
+		return
+	}
+	var entries []element
+	for _, result := range results {
+		switch result.tag {
+		case tagSearchResultItem:
+			entries = append(entries, result)
+		case tagSearchResultDone:
+			if code, message := ldapResult(result); code != 0 {
+				return nil, fmt.Errorf("search failed with result %d: %s", code, message)
+			}
+		}
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("found %d entries for user \"%s\" under %s, want 1", len(entries), user, d.BaseDN)
+	}
+	entry := entries[0]
+	if len(entry.children) < 2 {
+		return nil, errors.New("malformed search result")
+	}
+	for _, attr := range entry.children[1].children {
+		if len(attr.children) < 2 || !strings.EqualFold(string(attr.children[0].value), attribute) {
+			continue
+		}
+		for _, value := range attr.children[1].children {
+			groups = append(groups, string(value.value))
+		}
+	}
+
+	return
+}
+
+// inGroups reports whether any of the groups, by DN, is one of wanted, by
+// DN or common name.
+func inGroups(groups, wanted []string) bool {
+	for _, group := range groups {
+		cn := ""
+		if rdn := strings.SplitN(group, ",", 2)[0]; strings.HasPrefix(strings.ToLower(rdn), "cn=") {
+			cn = rdn[len("cn="):]
+		}
+		for _, w := range wanted {
+			if strings.EqualFold(w, group) || cn != "" && strings.EqualFold(w, cn) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// escapeDN escapes a user name for use as a value in a DN (RFC 4514).
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(s)-1 && r == ' ':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// ldapResult returns the result code and diagnostic message of an
+// LDAPResult.
+func ldapResult(e element) (code int, message string) {
+	if len(e.children) < 3 {
+		return -1, "malformed result"
+	}
+
+	return e.children[0].int(), string(e.children[2].value)
+}
+
+// conn is a connection to an LDAP server.
+type conn struct {
+	net.Conn
+	r  *bufio.Reader
+	id int // ID of the last message sent
+}
+
+// dial connects to the directory.
+func (d *Directory) dial(ctx context.Context) (c *conn, err error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return
+	}
+	host := u.Host
+	var nc net.Conn
+	var dialer net.Dialer
+	switch u.Scheme {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		nc, err = dialer.DialContext(ctx, "tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		config := d.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: u.Hostname()}
+		}
+		nc, err = dialer.DialContext(ctx, "tcp", host)
+		if err == nil {
+			nc = tls.Client(nc, config)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LDAP URL %q", d.URL)
+	}
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+
+	return &conn{Conn: nc, r: bufio.NewReader(nc)}, nil
+}
+
+// request sends a request and reads the responses to it, up to and
+// including one tagged last.
+func (c *conn) request(last byte, op []byte) (responses []element, err error) {
+	c.id++
+	_, err = c.Write(construct(tagSequence, encodeInt(tagInteger, c.id), op))
+	if err != nil {
+		return
+	}
+	for {
+		var message element
+		message, err = readElement(c.r)
+		if err != nil {
+			return
+		}
+		if message.tag != tagSequence || len(message.children) < 2 || message.children[0].int() != c.id {
+			return nil, errors.New("unexpected LDAP message")
+		}
+		op := message.children[1]
+		if op.tag == tagSearchReference {
+			continue
+		}
+		responses = append(responses, op)
+		if op.tag == last {
+			return
+		}
+	}
+}
+
+// close unbinds and closes the connection.
+func (c *conn) close() {
+	c.id++
+	c.Write(construct(tagSequence, encodeInt(tagInteger, c.id), encode(tagUnbindRequest, nil)))
+	c.Close()
+}
@@ -0,0 +1,142 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// fakeDirectory serves an LDAP directory of users with the password
+// "secret", each in the listed groups, until ln is closed.
+func fakeDirectory(ln net.Listener, users map[string][]string) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer c.Close()
+			r := bufio.NewReader(c)
+			for {
+				message, err := readElement(r)
+				if err != nil {
+					return
+				}
+				id := encodeInt(tagInteger, message.children[0].int())
+				op := message.children[1]
+				respond := func(ops ...[]byte) {
+					for _, op := range ops {
+						c.Write(construct(tagSequence, id, op))
+					}
+				}
+				result := func(tag byte, code int) []byte {
+					return construct(tag, encodeInt(tagEnumerated, code), encodeString(""), encodeString(""))
+				}
+				switch op.tag {
+				case tagBindRequest:
+					dn, password := string(op.children[1].value), string(op.children[2].value)
+					code := resultInvalidCredentials
+					for user := range users {
+						if dn == "uid="+user+",ou=people,dc=example,dc=com" && password == "secret" {
+							code = 0
+						}
+					}
+					respond(result(tagBindResponse, code))
+				case tagSearchRequest:
+					filter := op.children[6]
+					groups, ok := users[string(filter.children[1].value)]
+					if string(filter.children[0].value) != "uid" || !ok {
+						respond(result(tagSearchResultDone, 0))
+						continue
+					}
+					var values [][]byte
+					for _, group := range groups {
+						values = append(values, encodeString(group))
+					}
+					respond(
+						construct(tagSearchResultItem,
+							encodeString("uid=someone,ou=people,dc=example,dc=com"),
+							construct(tagSequence, construct(tagSequence,
+								encodeString("memberOf"),
+								construct(tagSet, values...),
+							)),
+						),
+						result(tagSearchResultDone, 0),
+					)
+				default:
+					return
+				}
+			}
+		}()
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go fakeDirectory(ln, map[string][]string{
+		"alice":   {"cn=editors,ou=groups,dc=example,dc=com"},
+		"bob":     {"cn=viewers,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		"mallory": {"cn=staff,ou=groups,dc=example,dc=com"},
+	})
+	d := &Directory{
+		URL:           "ldap://" + ln.Addr().String(),
+		BindDN:        "uid=%s,ou=people,dc=example,dc=com",
+		BaseDN:        "dc=example,dc=com",
+		UserAttribute: "uid",
+		Readers:       []string{"viewers"},
+		Writers:       []string{"cn=editors,ou=groups,dc=example,dc=com"},
+	}
+
+	for _, test := range []struct {
+		user, password string
+		access         server.Access
+	}{
+		{"alice", "secret", server.AccessWrite},
+		{"bob", "secret", server.AccessRead},
+		{"mallory", "secret", server.AccessNone},
+		{"alice", "wrong", server.AccessNone},
+		{"alice", "", server.AccessNone},
+		{"eve", "secret", server.AccessNone},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if access := d.Check(r, test.user, test.password); access != test.access {
+			t.Errorf("Check(%q, %q) = %v, want %v", test.user, test.password, access, test.access)
+		}
+	}
+
+	groups, err := d.Authenticate(context.Background(), "bob", "secret")
+	if err != nil || len(groups) != 2 {
+		t.Errorf("Authenticate(bob) = %q, %v, want his 2 groups", groups, err)
+	}
+	_, err = d.Authenticate(context.Background(), "bob", "wrong")
+	if err != ErrInvalidCredentials {
+		t.Errorf("Authenticate with the wrong password = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	// Without groups, anyone who can bind may write
+	d.Readers, d.Writers = nil, nil
+	if access := d.Check(httptest.NewRequest("GET", "/", nil), "mallory", "secret"); access != server.AccessWrite {
+		t.Errorf("Check(mallory) without groups = %v, want %v", access, server.AccessWrite)
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	for in, want := range map[string]string{
+		"alice":         "alice",
+		"doe, john":     `doe\, john`,
+		"#admin ":       `\#admin\ `,
+		`a+b="c"<d>;\e`: `a\+b\=\"c\"\<d\>\;\\e`,
+	} {
+		if got := escapeDN(in); got != want {
+			t.Errorf("escapeDN(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Package logbuf keeps the most recent lines of a log in memory, so that they
+// can be shown to users who can't read the process's output.
+package logbuf
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Buffer is an io.Writer holding the last lines written to it, for use as
+// (part of) a log.Logger's output. It is safe for concurrent use.
+type Buffer struct {
+	mu          sync.Mutex // protects the following
+	lines       []string   // ring of complete lines
+	next        int        // index in lines of the next line to write
+	full        bool       // whether lines has wrapped around
+	partial     []byte     // start of a line awaiting its newline
+	nextID      int
+	subscribers map[int]chan string
+}
+
+// New returns a buffer holding the last size lines.
+func New(size int) *Buffer {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Buffer{lines: make([]string, size)}
+}
+
+// Write adds each complete line in p to the buffer, holding on to any
+// trailing partial line until the rest of it is written.
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		b.add(string(b.partial[:i]))
+		b.partial = b.partial[i+1:]
+	}
+	if len(b.partial) == 0 {
+		b.partial = nil
+	}
+
+	return len(p), nil
+}
+
+// add records a line and sends it to subscribers. The caller must hold b.mu.
+func (b *Buffer) add(line string) {
+	b.lines[b.next] = line
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	for _, c := range b.subscribers {
+		// Drop lines for slow readers rather than blocking the logger
+		select {
+		case c <- line:
+		default:
+		}
+	}
+}
+
+// Lines returns the buffered lines, oldest first.
+func (b *Buffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.snapshot()
+}
+
+// snapshot copies the buffered lines. The caller must hold b.mu.
+func (b *Buffer) snapshot() []string {
+	if !b.full {
+		return append([]string(nil), b.lines[:b.next]...)
+	}
+
+	return append(append([]string(nil), b.lines[b.next:]...), b.lines[:b.next]...)
+}
+
+// Subscribe returns the buffered lines and a channel receiving every line
+// written after them, buffered to hold size lines, along with a function that
+// unsubscribes and closes the channel. Lines are dropped rather than blocking
+// writers if the channel is full.
+func (b *Buffer) Subscribe(size int) (lines []string, c <-chan string, unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]chan string)
+	}
+	id := b.nextID
+	b.nextID++
+	ch := make(chan string, size)
+	b.subscribers[id] = ch
+
+	var once sync.Once
+	unsubscribe = func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+			close(ch)
+		})
+	}
+
+	return b.snapshot(), ch, unsubscribe
+}
@@ -0,0 +1,45 @@
+package logbuf
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+	b := New(3)
+	if lines := b.Lines(); len(lines) != 0 {
+		t.Errorf("new buffer has lines %q", lines)
+	}
+
+	fmt.Fprint(b, "one\ntw")
+	if lines, want := b.Lines(), []string{"one"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+	fmt.Fprint(b, "o\nthree\nfour\n")
+	if lines, want := b.Lines(), []string{"two", "three", "four"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %q, want %q", lines, want)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	b := New(2)
+	fmt.Fprintln(b, "before")
+	lines, c, unsubscribe := b.Subscribe(1)
+	if want := []string{"before"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("backlog = %q, want %q", lines, want)
+	}
+
+	fmt.Fprintln(b, "after")
+	fmt.Fprintln(b, "dropped")
+	if line := <-c; line != "after" {
+		t.Errorf("received %q, want %q", line, "after")
+	}
+
+	unsubscribe()
+	unsubscribe()
+	fmt.Fprintln(b, "unsubscribed")
+	if line, ok := <-c; ok {
+		t.Errorf("received %q after unsubscribing", line)
+	}
+}
@@ -0,0 +1,159 @@
+package portmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// natPMPPort is the port on which routers listen for NAT-PMP requests.
+const natPMPPort = 5351
+
+// NAT-PMP opcodes (RFC 6886). Responses add 128 to the request's opcode.
+const (
+	natPMPOpExternalAddress = 0
+	natPMPOpMapTCP          = 2
+)
+
+// natPMPResults explains NAT-PMP result codes.
+var natPMPResults = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// natPMP maps ports with NAT-PMP, which is supported by many home routers
+// (and PCP routers, which answer NAT-PMP requests).
+type natPMP struct {
+	gateway net.IP
+	port    int
+}
+
+func (n *natPMP) name() string {
+	return "NAT-PMP"
+}
+
+// request sends a request to the gateway, retrying with the timeouts
+// recommended by the RFC, and returns the response.
+func (n *natPMP) request(ctx context.Context, req []byte, size int) (res []byte, err error) {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: n.gateway, Port: n.port})
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	res = make([]byte, 16)
+	timeout := 250 * time.Millisecond
+	for try := 0; try < 4; try++ {
+		_, err = conn.Write(req)
+		if err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		var read int
+		read, err = conn.Read(res)
+		if err == nil && read >= size && res[1] == req[1]+128 {
+			if result := binary.BigEndian.Uint16(res[2:]); result != 0 {
+				return nil, fmt.Errorf("router refused: %s", natPMPResult(result))
+			}
+			return res[:read], nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		timeout *= 2
+	}
+	if err == nil {
+		err = errors.New("invalid response")
+	}
+
+	return nil, fmt.Errorf("no response from %s: %v", n.gateway, err)
+}
+
+func natPMPResult(code uint16) string {
+	if message, ok := natPMPResults[code]; ok {
+		return message
+	}
+
+	return "result " + strconv.Itoa(int(code))
+}
+
+func (n *natPMP) externalIP(ctx context.Context) (net.IP, error) {
+	res, err := n.request(ctx, []byte{0, natPMPOpExternalAddress}, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.IP(res[8:12]), nil
+}
+
+func (n *natPMP) addMapping(ctx context.Context, port, externalPort int, lifetime time.Duration) (int, time.Duration, error) {
+	req := make([]byte, 12)
+	req[1] = natPMPOpMapTCP
+	binary.BigEndian.PutUint16(req[4:], uint16(port))
+	binary.BigEndian.PutUint16(req[6:], uint16(externalPort))
+	binary.BigEndian.PutUint32(req[8:], uint32(lifetime/time.Second))
+	res, err := n.request(ctx, req, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	granted := time.Duration(binary.BigEndian.Uint32(res[12:])) * time.Second
+
+	return int(binary.BigEndian.Uint16(res[10:])), granted, nil
+}
+
+func (n *natPMP) deleteMapping(ctx context.Context, port, externalPort int) error {
+	// A lifetime of zero deletes the mapping for the internal port
+	_, _, err := n.addMapping(ctx, port, 0, 0)
+
+	return err
+}
+
+// Gateway returns the address of the default IPv4 gateway, which is read from
+// the routing table on Linux and otherwise guessed to be the first address of
+// the local network.
+func Gateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			// Iface Destination Gateway ..., with addresses in little-endian hex
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 3 || fields[1] != "00000000" {
+				continue
+			}
+			gw, err := strconv.ParseUint(fields[2], 16, 32)
+			if err != nil || gw == 0 {
+				continue
+			}
+			ip := make(net.IP, 4)
+			binary.LittleEndian.PutUint32(ip, uint32(gw))
+			return ip, nil
+		}
+	}
+
+	ip, err := localIP("192.0.2.1")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the gateway: %v", err)
+	}
+	ip = ip.To4()
+	if ip == nil || ip.IsLoopback() {
+		return nil, errors.New("failed to find the gateway: no network")
+	}
+
+	return net.IPv4(ip[0], ip[1], ip[2], 1), nil
+}
@@ -0,0 +1,140 @@
+// Package portmap asks the home router to forward a port to this machine,
+// using NAT-PMP or UPnP, so that the wiki can be reached from the internet.
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lifetime is how long mappings are requested for. They are renewed halfway
+// through, so that a mapping left behind by a crash soon expires.
+const lifetime = time.Hour
+
+// protocol is a way of asking a router to forward a port.
+type protocol interface {
+	name() string
+	externalIP(ctx context.Context) (net.IP, error)
+	// addMapping forwards TCP connections to externalPort to the local
+	// port, returning the external port and lifetime that were granted.
+	addMapping(ctx context.Context, port, externalPort int, lifetime time.Duration) (int, time.Duration, error)
+	deleteMapping(ctx context.Context, port, externalPort int) error
+}
+
+// Mapping is a port forwarded by the router, renewed until it is closed.
+type Mapping struct {
+	ExternalIP   net.IP // the router's public address
+	ExternalPort int    // the port forwarded on the router
+	Protocol     string // "NAT-PMP" or "UPnP"
+
+	port  int
+	proto protocol
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// Map asks the router to forward TCP connections on the same port to the
+// local port, trying NAT-PMP and then UPnP.
+func Map(ctx context.Context, port int) (*Mapping, error) {
+	var failures []string
+	gateway, err := Gateway()
+	if err == nil {
+		var m *Mapping
+		m, err = newMapping(ctx, &natPMP{gateway: gateway, port: natPMPPort}, port)
+		if err == nil {
+			return m, nil
+		}
+	}
+	failures = append(failures, "NAT-PMP: "+err.Error())
+
+	igd, err := discoverUPnP(ctx, ssdpAddr)
+	if err == nil {
+		var m *Mapping
+		m, err = newMapping(ctx, igd, port)
+		if err == nil {
+			return m, nil
+		}
+	}
+	failures = append(failures, "UPnP: "+err.Error())
+
+	return nil, errors.New(strings.Join(failures, "; "))
+}
+
+// newMapping adds a mapping with the protocol and keeps it renewed.
+func newMapping(ctx context.Context, proto protocol, port int) (m *Mapping, err error) {
+	ip, err := proto.externalIP(ctx)
+	if err != nil {
+		return
+	}
+	externalPort, granted, err := proto.addMapping(ctx, port, port, lifetime)
+	if err != nil {
+		return
+	}
+	m = &Mapping{
+		ExternalIP:   ip,
+		ExternalPort: externalPort,
+		Protocol:     proto.name(),
+		port:         port,
+		proto:        proto,
+		stop:         make(chan struct{}),
+	}
+	go m.renew(granted)
+
+	return
+}
+
+// renew renews the mapping halfway through each lifetime until it's closed.
+func (m *Mapping) renew(granted time.Duration) {
+	for {
+		if granted <= 0 {
+			granted = lifetime
+		}
+		select {
+		case <-m.stop:
+			return
+		case <-time.After(granted / 2):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		var err error
+		_, granted, err = m.proto.addMapping(ctx, m.port, m.ExternalPort, lifetime)
+		cancel()
+		if err != nil {
+			log.Printf("failed to renew %s port mapping, retrying: %v", m.Protocol, err)
+			granted = time.Minute
+		}
+	}
+}
+
+// Close stops renewing the mapping and asks the router to remove it.
+func (m *Mapping) Close() (err error) {
+	m.once.Do(func() {
+		close(m.stop)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = m.proto.deleteMapping(ctx, m.port, m.ExternalPort)
+	})
+
+	return
+}
+
+// String describes the mapping.
+func (m *Mapping) String() string {
+	return fmt.Sprintf("%s port %d forwarded by %s", m.ExternalIP, m.ExternalPort, m.Protocol)
+}
+
+// localIP returns the address of this machine used to reach the host.
+func localIP(host string) (net.IP, error) {
+	conn, err := net.Dial("udp4", net.JoinHostPort(host, "9"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
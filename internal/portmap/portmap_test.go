@@ -0,0 +1,198 @@
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNATPMP answers NAT-PMP requests like a router with the external address
+// 203.0.113.7, recording the mappings it holds.
+func fakeNATPMP(t *testing.T) (*natPMP, map[int]int, *sync.Mutex, func()) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mappings := make(map[int]int)
+	var mu sync.Mutex
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			res := make([]byte, 16)
+			res[1] = buf[1] + 128
+			switch {
+			case n == 2 && buf[1] == natPMPOpExternalAddress:
+				copy(res[8:], net.IPv4(203, 0, 113, 7).To4())
+				res = res[:12]
+			case n == 12 && buf[1] == natPMPOpMapTCP:
+				port := binary.BigEndian.Uint16(buf[4:])
+				external := binary.BigEndian.Uint16(buf[6:])
+				lifetime := binary.BigEndian.Uint32(buf[8:])
+				mu.Lock()
+				if lifetime == 0 {
+					delete(mappings, int(port))
+				} else {
+					mappings[int(port)] = int(external)
+				}
+				mu.Unlock()
+				copy(res[8:], buf[4:12])
+			default:
+				binary.BigEndian.PutUint16(res[2:], 5)
+			}
+			conn.WriteTo(res, addr)
+		}
+	}()
+	n := &natPMP{gateway: net.IPv4(127, 0, 0, 1), port: conn.LocalAddr().(*net.UDPAddr).Port}
+
+	return n, mappings, &mu, func() { conn.Close() }
+}
+
+func TestNATPMP(t *testing.T) {
+	n, mappings, mu, stop := fakeNATPMP(t)
+	defer stop()
+
+	m, err := newMapping(context.Background(), n, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.String(); got != "203.0.113.7 port 8080 forwarded by NAT-PMP" {
+		t.Errorf("mapping = %s", got)
+	}
+	mu.Lock()
+	if mappings[8080] != 8080 {
+		t.Errorf("router mappings = %v, want 8080 forwarded", mappings)
+	}
+	mu.Unlock()
+
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if len(mappings) != 0 {
+		t.Errorf("router mappings = %v after closing, want none", mappings)
+	}
+	mu.Unlock()
+}
+
+func TestNATPMPNoResponse(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	n := &natPMP{gateway: net.IPv4(127, 0, 0, 1), port: conn.LocalAddr().(*net.UDPAddr).Port}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = n.externalIP(ctx)
+	if err == nil {
+		t.Error("request to a silent router succeeded")
+	}
+}
+
+const testDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+<device>
+<deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+<deviceList><device>
+<deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+<serviceList><service>
+<serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+<controlURL>/ctl/IPConn</controlURL>
+</service></serviceList>
+</device></deviceList>
+</device></deviceList>
+</device>
+</root>`
+
+func TestUPnP(t *testing.T) {
+	var mu sync.Mutex
+	var actions []string
+	arg := regexp.MustCompile(`<NewExternalPort>(\d+)</NewExternalPort>.*<NewInternalClient>([^<]+)</NewInternalClient>`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/desc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		action := r.Header.Get("SOAPAction")
+		mu.Lock()
+		defer mu.Unlock()
+		switch action {
+		case `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"`:
+			actions = append(actions, "GetExternalIPAddress")
+			fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">`+
+				`<NewExternalIPAddress>203.0.113.9</NewExternalIPAddress>`+
+				`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+		case `"urn:schemas-upnp-org:service:WANIPConnection:1#AddPortMapping"`:
+			m := arg.FindStringSubmatch(string(body))
+			if m == nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `<s:Envelope><s:Body><s:Fault><detail><UPnPError><errorDescription>Invalid Args</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+				return
+			}
+			actions = append(actions, "AddPortMapping "+m[1]+" to "+m[2])
+		case `"urn:schemas-upnp-org:service:WANIPConnection:1#DeletePortMapping"`:
+			actions = append(actions, "DeletePortMapping")
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// Answer the search as a router would
+	ssdp, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ssdp.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		n, addr, err := ssdp.ReadFrom(buf)
+		if err != nil || !strings.Contains(string(buf[:n]), "InternetGatewayDevice") {
+			return
+		}
+		ssdp.WriteTo([]byte("HTTP/1.1 200 OK\r\nLOCATION: "+srv.URL+"/desc.xml\r\n\r\n"), addr)
+	}()
+
+	igd, err := discoverUPnP(context.Background(), ssdp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newMapping(context.Background(), igd, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.String(); got != "203.0.113.9 port 8080 forwarded by UPnP" {
+		t.Errorf("mapping = %s", got)
+	}
+	err = m.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := "GetExternalIPAddress, AddPortMapping 8080 to 127.0.0.1, DeletePortMapping"
+	if got := strings.Join(actions, ", "); got != want {
+		t.Errorf("actions = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ssdpAddr is the multicast address on which UPnP devices are discovered.
+const ssdpAddr = "239.255.255.250:1900"
+
+// igdSearch is the SSDP search for internet gateway devices.
+const igdSearch = "M-SEARCH * HTTP/1.1\r\n" +
+	"HOST: 239.255.255.250:1900\r\n" +
+	"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+	"MAN: \"ssdp:discover\"\r\n" +
+	"MX: 2\r\n\r\n"
+
+// upnp maps ports with the WANIPConnection (or WANPPPConnection) service of
+// a UPnP internet gateway device.
+type upnp struct {
+	controlURL  string // where the service's SOAP actions are posted
+	serviceType string
+	client      net.IP // this machine's address on the router's network
+}
+
+func (u *upnp) name() string {
+	return "UPnP"
+}
+
+// discoverUPnP finds the router's UPnP service by searching for it on the
+// local network, at ssdp.
+func discoverUPnP(ctx context.Context, ssdp string) (*upnp, error) {
+	addr, err := net.ResolveUDPAddr("udp4", ssdp)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_, err = conn.WriteTo([]byte(igdSearch), addr)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, errors.New("no internet gateway device found")
+		}
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := res.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		u, err := newUPnP(ctx, location)
+		if err == nil {
+			return u, nil
+		}
+	}
+}
+
+// igdDevice is the part of a UPnP device description needed to find the
+// connection service, which is nested within the device's subdevices.
+type igdDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []igdDevice `xml:"deviceList>device"`
+}
+
+// findService returns the first WAN connection service of the device or its
+// subdevices.
+func (d *igdDevice) findService() (serviceType, controlURL string) {
+	for _, s := range d.Services {
+		if strings.Contains(s.ServiceType, ":WANIPConnection:") || strings.Contains(s.ServiceType, ":WANPPPConnection:") {
+			return s.ServiceType, s.ControlURL
+		}
+	}
+	for i := range d.Devices {
+		serviceType, controlURL = d.Devices[i].findService()
+		if controlURL != "" {
+			return
+		}
+	}
+
+	return
+}
+
+// newUPnP reads the device description at location to find its connection
+// service.
+func newUPnP(ctx context.Context, location string) (*upnp, error) {
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var desc struct {
+		URLBase string    `xml:"URLBase"`
+		Device  igdDevice `xml:"device"`
+	}
+	err = xml.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&desc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device description: %v", err)
+	}
+	serviceType, controlURL := desc.Device.findService()
+	if controlURL == "" {
+		return nil, errors.New("device has no WAN connection service")
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if desc.URLBase != "" {
+		base, err = url.Parse(desc.URLBase)
+		if err != nil {
+			return nil, err
+		}
+	}
+	control, err := base.Parse(controlURL)
+	if err != nil {
+		return nil, err
+	}
+	client, err := localIP(control.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	return &upnp{controlURL: control.String(), serviceType: serviceType, client: client}, nil
+}
+
+// soapArg is an argument to a SOAP action.
+type soapArg struct {
+	name, value string
+}
+
+// call invokes a SOAP action on the service, returning the response body.
+func (u *upnp) call(ctx context.Context, action string, args ...soapArg) ([]byte, error) {
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, html.EscapeString(u.serviceType))
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>%s</%s>", arg.name, html.EscapeString(arg.value), arg.name)
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	req, err := http.NewRequest(http.MethodPost, u.controlURL, strings.NewReader(body.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+u.serviceType+"#"+action+`"`)
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		var fault struct {
+			Description string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		xml.Unmarshal(data, &fault)
+		if fault.Description != "" {
+			return nil, fmt.Errorf("router refused: %s", fault.Description)
+		}
+		return nil, fmt.Errorf("router refused: %s", res.Status)
+	}
+
+	return data, nil
+}
+
+func (u *upnp) externalIP(ctx context.Context) (net.IP, error) {
+	data, err := u.call(ctx, "GetExternalIPAddress")
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	err = xml.Unmarshal(data, &res)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(res.IP)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid external address %q", res.IP)
+	}
+
+	return ip, nil
+}
+
+func (u *upnp) addMapping(ctx context.Context, port, externalPort int, lifetime time.Duration) (int, time.Duration, error) {
+	_, err := u.call(ctx, "AddPortMapping",
+		soapArg{"NewRemoteHost", ""},
+		soapArg{"NewExternalPort", strconv.Itoa(externalPort)},
+		soapArg{"NewProtocol", "TCP"},
+		soapArg{"NewInternalPort", strconv.Itoa(port)},
+		soapArg{"NewInternalClient", u.client.String()},
+		soapArg{"NewEnabled", "1"},
+		soapArg{"NewPortMappingDescription", "putter"},
+		soapArg{"NewLeaseDuration", strconv.Itoa(int(lifetime / time.Second))},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return externalPort, lifetime, nil
+}
+
+func (u *upnp) deleteMapping(ctx context.Context, port, externalPort int) error {
+	_, err := u.call(ctx, "DeletePortMapping",
+		soapArg{"NewRemoteHost", ""},
+		soapArg{"NewExternalPort", strconv.Itoa(externalPort)},
+		soapArg{"NewProtocol", "TCP"},
+	)
+
+	return err
+}
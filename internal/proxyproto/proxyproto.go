@@ -0,0 +1,216 @@
+// Package proxyproto accepts connections from a proxy or load balancer that
+// speaks HAProxy's PROXY protocol, making the address of the real client
+// available as the connection's remote address.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// signature begins a version 2 (binary) header.
+var signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxV1Header is the longest possible version 1 (text) header.
+const maxV1Header = 107
+
+// Listener is a net.Listener whose connections must begin with a PROXY
+// protocol header, version 1 or 2. Connections without one fail on their
+// first read.
+type Listener struct {
+	net.Listener
+	Timeout time.Duration // how long to wait for the header, or 0 for no limit
+}
+
+// Accept waits for the next connection. Its header is read on first use, in
+// the connection's own goroutine, so that a slow client doesn't hold up
+// others.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Conn{Conn: c, timeout: l.Timeout}, nil
+}
+
+// Conn is a connection accepted by a Listener.
+type Conn struct {
+	net.Conn
+	timeout time.Duration
+	once    sync.Once
+	r       *bufio.Reader
+	remote  net.Addr // client address from the header, if any
+	local   net.Addr // address the client connected to, if any
+	err     error    // why the header couldn't be read
+}
+
+// init reads the header.
+func (c *Conn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		}
+		c.r = bufio.NewReader(c.Conn)
+		c.remote, c.local, c.err = readHeader(c.r)
+		if c.timeout > 0 {
+			c.Conn.SetReadDeadline(time.Time{})
+		}
+		if c.err != nil {
+			log.Printf("refusing connection from %s: invalid PROXY protocol header: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+// Read reads data following the header.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.r.Read(p)
+}
+
+// RemoteAddr returns the address of the client, as reported by the proxy.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address that the client connected to, as reported by
+// the proxy.
+func (c *Conn) LocalAddr() net.Addr {
+	c.init()
+	if c.local != nil {
+		return c.local
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// readHeader reads a header of either version. The addresses are nil if the
+// proxy didn't report them, e.g. for its own health checks.
+func readHeader(r *bufio.Reader) (remote, local net.Addr, err error) {
+	start, err := r.Peek(len(signature))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if bytes.Equal(start, signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return readV1(r)
+	}
+
+	return nil, nil, errors.New("missing header")
+}
+
+// readV1 reads a text header, e.g. "PROXY TCP4 192.0.2.1 192.0.2.2 5678 80".
+func readV1(r *bufio.Reader) (remote, local net.Addr, err error) {
+	var line []byte
+	for len(line) < maxV1Header {
+		var b byte
+		b, err = r.ReadByte()
+		if err != nil {
+			return
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("header too long")
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid header %q", strings.TrimSpace(string(line)))
+	}
+	remote, err = tcpAddr(fields[2], fields[4])
+	if err != nil {
+		return
+	}
+	local, err = tcpAddr(fields[3], fields[5])
+
+	return
+}
+
+// tcpAddr parses an address and port from a text header.
+func tcpAddr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("invalid address %s port %s", host, port)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readV2 reads a binary header.
+func readV2(r *bufio.Reader) (remote, local net.Addr, err error) {
+	head := make([]byte, len(signature)+4)
+	_, err = io.ReadFull(r, head)
+	if err != nil {
+		return
+	}
+	verCmd, family := head[12], head[13]
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return
+	}
+	if verCmd>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported version %d", verCmd>>4)
+	}
+
+	// LOCAL connections come from the proxy itself, and other families
+	// (UDP, Unix sockets) have no address to report
+	const cmdProxy = 1
+	if verCmd&0xF != cmdProxy {
+		return
+	}
+	var size int
+	switch family {
+	case 0x11: // TCP over IPv4
+		size = net.IPv4len
+	case 0x21: // TCP over IPv6
+		size = net.IPv6len
+	default:
+		return
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("header too short for its addresses")
+	}
+	remote = &net.TCPAddr{
+		IP:   net.IP(body[:size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	local = &net.TCPAddr{
+		IP:   net.IP(body[size : 2*size]),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+
+	return
+}
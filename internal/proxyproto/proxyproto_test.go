@@ -0,0 +1,129 @@
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve accepts one connection on a Listener, sends it data and returns the
+// remote address and what was read after the header.
+func serve(t *testing.T, data []byte) (remote, local string, body string, err error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &Listener{Listener: ln, Timeout: time.Second}
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		c.Write(data)
+		c.Close()
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	remote = c.RemoteAddr().String()
+	local = c.LocalAddr().String()
+	b, err := ioutil.ReadAll(c)
+
+	return remote, local, string(b), err
+}
+
+func TestV1(t *testing.T) {
+	remote, local, body, err := serve(t, []byte("PROXY TCP4 192.0.2.1 198.51.100.2 5678 80\r\nGET / HTTP/1.1\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote != "192.0.2.1:5678" || local != "198.51.100.2:80" {
+		t.Errorf("addresses = %s, %s", remote, local)
+	}
+	if body != "GET / HTTP/1.1\r\n" {
+		t.Errorf("body = %q", body)
+	}
+
+	remote, _, _, err = serve(t, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 5678 80\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote != "[2001:db8::1]:5678" {
+		t.Errorf("remote = %s", remote)
+	}
+}
+
+func TestV1Unknown(t *testing.T) {
+	remote, _, body, err := serve(t, []byte("PROXY UNKNOWN\r\nhello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+		t.Errorf("remote = %s, want the proxy's address", remote)
+	}
+	if body != "hello" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+// v2 builds a binary header.
+func v2(verCmd, family byte, addrs []byte) []byte {
+	var b bytes.Buffer
+	b.Write(signature)
+	b.WriteByte(verCmd)
+	b.WriteByte(family)
+	binary.Write(&b, binary.BigEndian, uint16(len(addrs)))
+	b.Write(addrs)
+
+	return b.Bytes()
+}
+
+func TestV2(t *testing.T) {
+	addrs := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x16, 0x2e, 0, 80}
+	remote, local, body, err := serve(t, append(v2(0x21, 0x11, addrs), "hello"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote != "192.0.2.1:5678" || local != "198.51.100.2:80" {
+		t.Errorf("addresses = %s, %s", remote, local)
+	}
+	if body != "hello" {
+		t.Errorf("body = %q", body)
+	}
+
+	// LOCAL, as sent by health checks
+	remote, _, body, err = serve(t, append(v2(0x20, 0x00, nil), "hello"...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != "127.0.0.1" {
+		t.Errorf("remote = %s, want the proxy's address", remote)
+	}
+	if body != "hello" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, data := range []string{
+		"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n",
+		"PROXY TCP4 192.0.2.1\r\nhello world",
+		"PROXY TCP4 192.0.2.1 198.51.100.2 99999 80\r\n",
+		"PROXY",
+		string(v2(0x31, 0x11, make([]byte, 12))),
+		string(v2(0x21, 0x11, make([]byte, 4))),
+	} {
+		_, _, _, err := serve(t, []byte(data))
+		if err == nil {
+			t.Errorf("reading after %q succeeded", data)
+		}
+	}
+}
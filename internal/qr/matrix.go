@@ -0,0 +1,298 @@
+package qr
+
+// builder is a code under construction.
+type builder struct {
+	Code
+	version    int
+	isFunction [][]bool // modules that are part of function patterns, not data
+}
+
+// newCode returns a code of the given version with its function patterns
+// drawn, and placeholders for its format information.
+func newCode(version int) *builder {
+	size := version*4 + 17
+	c := &builder{
+		Code:       Code{Size: size, modules: grid(size)},
+		version:    version,
+		isFunction: grid(size),
+	}
+
+	for i := 0; i < size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+	c.drawFinder(3, 3)
+	c.drawFinder(size-4, 3)
+	c.drawFinder(3, size-4)
+
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// The finder patterns are in these corners
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	c.drawFormatBits(0)
+	c.drawVersion()
+
+	return c
+}
+
+// grid allocates a size by size grid of modules.
+func grid(size int) [][]bool {
+	g := make([][]bool, size)
+	for i := range g {
+		g[i] = make([]bool, size)
+	}
+
+	return g
+}
+
+// setFunction sets a module that is part of a function pattern.
+func (c *builder) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+// drawFinder draws a finder pattern and its separator centred on (x, y).
+func (c *builder) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			c.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centred on (x, y).
+func (c *builder) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the format information for the mask.
+func (c *builder) drawFormatBits(mask int) {
+	data := eccLevelBits<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>uint(i))&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+// drawVersion draws both copies of the version information, which only codes
+// of version 7 and up have.
+func (c *builder) drawVersion() {
+	if c.version < 7 {
+		return
+	}
+	rem := c.version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 != 0
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords fills the data modules with data in the standard zigzag.
+func (c *builder) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		// Skip the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i/8]>>uint(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask. Applying the same
+// mask twice undoes it.
+func (c *builder) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.isFunction[y][x] && masked(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// masked reports whether the mask inverts the module at (x, y).
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores how hard the code is to read, following the rules in the
+// QR code specification: lower is better.
+func (c *builder) penalty() (score int) {
+	// Rows and columns are scored alike
+	line := func(i int, vertical bool) []bool {
+		l := make([]bool, c.Size)
+		for j := range l {
+			if vertical {
+				l[j] = c.modules[j][i]
+			} else {
+				l[j] = c.modules[i][j]
+			}
+		}
+		return l
+	}
+	finderLike := []bool{true, false, true, true, true, false, true}
+	for i := 0; i < c.Size; i++ {
+		for _, vertical := range []bool{false, true} {
+			l := line(i, vertical)
+
+			// Long runs of one colour
+			run := 1
+			for j := 1; j <= len(l); j++ {
+				if j < len(l) && l[j] == l[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+
+			// Patterns that look like finders, with four light modules to
+			// one side
+			for j := 0; j+len(finderLike) <= len(l); j++ {
+				if !matches(l[j:], finderLike) {
+					continue
+				}
+				if isLight(l, j-4, j) || isLight(l, j+len(finderLike), j+len(finderLike)+4) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// Blocks of one colour
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				m := c.modules[y][x]
+				if m == c.modules[y][x+1] && m == c.modules[y+1][x] && m == c.modules[y+1][x+1] {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// Imbalance between dark and light
+	total := c.Size * c.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	score += k * 10
+
+	return
+}
+
+// matches reports whether l begins with pattern.
+func matches(l, pattern []bool) bool {
+	for i, p := range pattern {
+		if l[i] != p {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isLight reports whether l[from:to] is light, treating modules beyond the
+// edge of the code as light.
+func isLight(l []bool, from, to int) bool {
+	for i := from; i < to; i++ {
+		if i >= 0 && i < len(l) && l[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
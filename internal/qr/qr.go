@@ -0,0 +1,275 @@
+// Package qr encodes short text, such as URLs, as QR codes and renders them
+// for the terminal.
+package qr
+
+import (
+	"errors"
+	"io"
+	"strings"
+)
+
+// maxVersion is the largest QR code version supported, which holds 213 bytes
+// at error correction level M: plenty for a URL.
+const maxVersion = 10
+
+// Error correction level M recovers from about 15% damage, a good trade-off
+// for a code read off a screen. These tables are indexed by version.
+var (
+	eccCodewordsPerBlock = [maxVersion + 1]int{0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26}
+	eccBlocks            = [maxVersion + 1]int{0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5}
+)
+
+// eccLevelBits is the format information value for error correction level M.
+const eccLevelBits = 0
+
+// ErrTooLong is returned by Encode for text that doesn't fit in a QR code of
+// the largest supported version.
+var ErrTooLong = errors.New("text is too long for a QR code")
+
+// Code is an encoded QR code.
+type Code struct {
+	Size    int      // width and height in modules
+	modules [][]bool // dark modules, indexed by y then x
+}
+
+// Dark reports whether the module at (x, y) is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode encodes text in byte mode as a QR code of the smallest version that
+// will hold it.
+func Encode(text string) (*Code, error) {
+	version := 1
+	for ; version <= maxVersion; version++ {
+		if 4+countBits(version)+8*len(text) <= 8*numDataCodewords(version) {
+			break
+		}
+	}
+	if version > maxVersion {
+		return nil, ErrTooLong
+	}
+
+	// Byte mode segment, terminator, and padding
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(text), countBits(version))
+	for i := 0; i < len(text); i++ {
+		bits.append(int(text[i]), 8)
+	}
+	capacity := 8 * numDataCodewords(version)
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	bits.append(0, terminator)
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	c := newCode(version)
+	c.drawCodewords(addECCAndInterleave(bits.bytes(), version))
+
+	// Use the mask that makes the code easiest to read
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		penalty := c.penalty()
+		if bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+
+	return &c.Code, nil
+}
+
+// WriteTerminal draws the code with Unicode block characters, two rows of
+// modules to a line, surrounded by a quiet zone. Light modules are drawn as
+// blocks so that the code reads correctly on the usual dark terminal.
+func (c *Code) WriteTerminal(w io.Writer) error {
+	const quiet = 2
+	light := func(x, y int) bool {
+		if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+			return true
+		}
+		return !c.modules[y][x]
+	}
+
+	var b strings.Builder
+	for y := -quiet; y < c.Size+quiet; y += 2 {
+		for x := -quiet; x < c.Size+quiet; x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// countBits is the width of the character count in a byte mode segment.
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+
+	return 16
+}
+
+// numRawDataModules is the number of modules available for data and error
+// correction in a code of the given version.
+func numRawDataModules(version int) int {
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		numAlign := version/7 + 2
+		n -= (25*numAlign-10)*numAlign - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+
+	return n
+}
+
+// numDataCodewords is the number of data codewords held by a code of the
+// given version.
+func numDataCodewords(version int) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[version]*eccBlocks[version]
+}
+
+// alignmentPositions returns the coordinates of the centres of alignment
+// patterns in a code of the given version.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	numAlign := version/7 + 2
+	step := (version*4 + numAlign*2 + 1) / (numAlign*2 - 2) * 2
+	positions := make([]int, numAlign)
+	positions[0] = 6
+	for i, pos := numAlign-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// bitBuffer is a sequence of bits, most significant first.
+type bitBuffer []bool
+
+// append appends the low n bits of value.
+func (b *bitBuffer) append(value, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*b = append(*b, (value>>uint(i))&1 != 0)
+	}
+}
+
+// bytes packs the bits into bytes.
+func (b bitBuffer) bytes() []byte {
+	data := make([]byte, (len(b)+7)/8)
+	for i, bit := range b {
+		if bit {
+			data[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+
+	return data
+}
+
+// addECCAndInterleave splits data into blocks, appends error correction to
+// each, and interleaves the blocks.
+func addECCAndInterleave(data []byte, version int) []byte {
+	numBlocks := eccBlocks[version]
+	eccLen := eccCodewordsPerBlock[version]
+	rawCodewords := numRawDataModules(version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLen := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortBlockLen - eccLen
+		if i >= numShortBlocks {
+			n++
+		}
+		block := append([]byte(nil), data[k:k+n]...)
+		k += n
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0)
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	var result []byte
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			// Skip the padding in short blocks
+			if i != shortBlockLen-eccLen || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	return result
+}
+
+// reedSolomonDivisor returns the generator polynomial of the given degree,
+// without its leading term.
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+
+	return result
+}
+
+// reedSolomonRemainder computes the error correction codewords for data.
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, d := range divisor {
+			result[i] ^= gfMultiply(d, factor)
+		}
+	}
+
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
@@ -0,0 +1,170 @@
+package qr
+
+import (
+	"strings"
+	"testing"
+)
+
+// decode reads the text back out of a code, checking its error correction
+// along the way. It's the inverse of Encode, relying on newCode for the
+// layout of function patterns.
+func decode(t *testing.T, code *Code) string {
+	t.Helper()
+	version := (code.Size - 17) / 4
+	c := newCode(version)
+
+	// Find the mask from the first copy of the format information
+	mask := -1
+	for m := 0; m < 8; m++ {
+		c.drawFormatBits(m)
+		same := true
+		for i := 0; i < 9; i++ {
+			if c.modules[i][8] != code.modules[i][8] || c.modules[8][i] != code.modules[8][i] {
+				same = false
+			}
+		}
+		if same {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		t.Fatal("no mask matches the format information")
+	}
+
+	c.modules = code.modules
+	c.applyMask(mask)
+	defer c.applyMask(mask)
+	var bits bitBuffer
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < c.Size; vert++ {
+			for j := 0; j < 2; j++ {
+				x, y := right-j, vert
+				if (right+1)&2 == 0 {
+					y = c.Size - 1 - vert
+				}
+				if !c.isFunction[y][x] {
+					bits = append(bits, c.modules[y][x])
+				}
+			}
+		}
+	}
+	codewords := bits.bytes()[:numRawDataModules(version)/8]
+
+	// Undo the interleaving, checking each block's error correction
+	numBlocks := eccBlocks[version]
+	eccLen := eccCodewordsPerBlock[version]
+	numShortBlocks := numBlocks - len(codewords)%numBlocks
+	shortDataLen := len(codewords)/numBlocks - eccLen
+	blocks := make([][]byte, numBlocks)
+	k := 0
+	for i := 0; i < shortDataLen+1; i++ {
+		for j := range blocks {
+			if i < shortDataLen || j >= numShortBlocks {
+				blocks[j] = append(blocks[j], codewords[k])
+				k++
+			}
+		}
+	}
+	var data []byte
+	divisor := reedSolomonDivisor(eccLen)
+	for j, block := range blocks {
+		var want []byte
+		for i := 0; i < eccLen; i++ {
+			want = append(want, codewords[k+i*numBlocks+j])
+		}
+		if got := reedSolomonRemainder(block, divisor); string(got) != string(want) {
+			t.Errorf("block %d has error correction %x, want %x", j, want, got)
+		}
+		data = append(data, block...)
+	}
+
+	// Parse the byte mode segment
+	if data[0]>>4 != 0x4 {
+		t.Fatalf("mode = %x, want byte mode", data[0]>>4)
+	}
+	var n, offset int
+	if countBits(version) == 8 {
+		n = int(data[0]&0xF)<<4 | int(data[1]>>4)
+		offset = 1
+	} else {
+		n = int(data[0]&0xF)<<12 | int(data[1])<<4 | int(data[2]>>4)
+		offset = 2
+	}
+	text := make([]byte, n)
+	for i := range text {
+		text[i] = data[offset+i]<<4 | data[offset+i+1]>>4
+	}
+
+	return string(text)
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		text    string
+		version int
+	}{
+		{"http://192.168.1.2:8080/", 2},
+		{"x", 1},
+		{strings.Repeat("a", 60), 4},
+		{"http://" + strings.Repeat("wiki.", 30) + "example.com/", 9},
+		{strings.Repeat("b", 200), 10},
+	}
+	for _, test := range tests {
+		code, err := Encode(test.text)
+		if err != nil {
+			t.Fatalf("Encode(%q): %v", test.text, err)
+		}
+		if version := (code.Size - 17) / 4; version != test.version {
+			t.Errorf("Encode(%q) is version %d, want %d", test.text, version, test.version)
+		}
+		if text := decode(t, code); text != test.text {
+			t.Errorf("decoded %q, want %q", text, test.text)
+		}
+	}
+
+	_, err := Encode(strings.Repeat("c", 300))
+	if err != ErrTooLong {
+		t.Errorf("Encode of long text = %v, want ErrTooLong", err)
+	}
+}
+
+func TestVersionBits(t *testing.T) {
+	// The version information for version 7 from the specification
+	const want = 0x07C94
+	c := newCode(7)
+	got := 0
+	for i := 17; i >= 0; i-- {
+		got <<= 1
+		if c.modules[i/3][c.Size-11+i%3] {
+			got |= 1
+		}
+	}
+	if got != want {
+		t.Errorf("version bits = %05x, want %05x", got, want)
+	}
+}
+
+func TestWriteTerminal(t *testing.T) {
+	code, err := Encode("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	err = code.WriteTerminal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+	// 21 modules plus a quiet zone of 2 either side, two rows to a line
+	if len(lines) != 13 {
+		t.Errorf("drew %d lines, want 13", len(lines))
+	}
+	for _, line := range lines {
+		if n := len([]rune(line)); n != 25 {
+			t.Errorf("line is %d characters, want 25", n)
+		}
+	}
+}
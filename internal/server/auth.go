@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authTimeout bounds how long an authentication command may run.
+const authTimeout = 10 * time.Second
+
+// Access is what a user may do.
+type Access int
+
+// Levels of access.
+const (
+	AccessNone  Access = iota // may do nothing
+	AccessRead                // may only read, with GET, HEAD, and OPTIONS
+	AccessWrite               // may read and write
+)
+
+type userKey struct{}
+
+// ContextWithUser returns a copy of ctx naming the user whose credentials
+// were checked for a request.
+func ContextWithUser(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, userKey{}, name)
+}
+
+// UserFrom returns the name given to ContextWithUser, or the empty string if
+// the request wasn't authenticated.
+func UserFrom(ctx context.Context) string {
+	name, _ := ctx.Value(userKey{}).(string)
+
+	return name
+}
+
+// CheckFunc checks a user's credentials for a request, returning their
+// access. When BasicAuth remembers what it grants, it may depend on the
+// request's method and path, which are part of what is remembered, but on
+// nothing else about the request, such as its headers or address.
+type CheckFunc func(r *http.Request, user, password string) Access
+
+// BasicAuth decorates an http.Handler to require HTTP basic authentication
+// with credentials that check grants access for the request, naming the user
+// in its context (see UserFrom): users with AccessRead are refused anything
+// but reading with 403 Forbidden. What check grants is remembered for cache,
+// for the same credentials, method, and path, so that it isn't called for
+// every request, or not at all if cache is 0; see CheckFunc for what check
+// may then depend on.
+func BasicAuth(h http.Handler, check CheckFunc, cache time.Duration) http.Handler {
+	c := &accessCache{ttl: cache, granted: make(map[[sha256.Size]byte]grant)}
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		access := AccessNone
+		if ok {
+			access = c.check(r, user, password, check)
+		}
+		if access == AccessNone {
+			w.Header().Set("WWW-Authenticate", `Basic realm="putter", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if access == AccessRead && !isRead(r) {
+			Logf(r.Context(), "refusing %s from \"%s\", who may only read", r.Method, user)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r.WithContext(ContextWithUser(r.Context(), user)))
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// AnonymousReads routes requests that only read, with GET, HEAD, or OPTIONS,
+// to h, so that anyone may read, and others to auth, which is h decorated to
+// require authentication.
+func AnonymousReads(h, auth http.Handler) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if isRead(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		auth.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// isRead reports whether a request only reads.
+func isRead(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+}
+
+// PasswordCheck returns a CheckFunc granting write access to a single user
+// with password.
+func PasswordCheck(user, password string) CheckFunc {
+	return func(r *http.Request, u, p string) Access {
+		isUser := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		isPassword := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !isUser || !isPassword {
+			Logf(r.Context(), "authentication failed for user \"%s\"", u)
+			return AccessNone
+		}
+
+		return AccessWrite
+	}
+}
+
+// CommandAuth decorates an http.Handler with BasicAuth, granting write
+// access to credentials that command accepts. The command is run with the
+// user name and password on separate lines of its standard input, and the
+// user name, request method, and path in the PUTTER_USER, PUTTER_METHOD, and
+// PUTTER_PATH environment variables, and accepts them if it exits with status
+// 0, so that system accounts (e.g. through PAM with a helper such as pwauth)
+// or any other source of users can be used. Since the command can accept a
+// user for some methods or paths and not others, what it accepted is only
+// remembered for the same method and path.
+func CommandAuth(h http.Handler, command []string, cache time.Duration) http.Handler {
+	return BasicAuth(h, func(r *http.Request, user, password string) Access {
+		ctx, cancel := context.WithTimeout(r.Context(), authTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Stdin = strings.NewReader(user + "\n" + password + "\n")
+		cmd.Env = append(os.Environ(), "PUTTER_USER="+user, "PUTTER_METHOD="+r.Method, "PUTTER_PATH="+r.URL.Path)
+		err := cmd.Run()
+		if _, rejected := err.(*exec.ExitError); err != nil && !rejected {
+			Logf(r.Context(), "failed to run authentication command: %v", err)
+		}
+		if err != nil {
+			Logf(r.Context(), "authentication failed for user \"%s\"", user)
+			return AccessNone
+		}
+
+		return AccessWrite
+	}, cache)
+}
+
+// accessCache remembers the access granted to credentials.
+type accessCache struct {
+	ttl     time.Duration               // how long access is remembered
+	mu      sync.Mutex                  // protects granted
+	granted map[[sha256.Size]byte]grant // by hash of the credentials, method, and path
+}
+
+// grant is access granted to credentials.
+type grant struct {
+	access Access
+	time   time.Time
+}
+
+// check returns the access granted to the credentials for the request's
+// method and path, calling check unless it is remembered.
+func (c *accessCache) check(r *http.Request, user, password string, check CheckFunc) Access {
+	key := sha256.Sum256([]byte(user + "\x00" + password + "\x00" + r.Method + "\x00" + r.URL.Path))
+	if c.ttl > 0 {
+		c.mu.Lock()
+		g, ok := c.granted[key]
+		c.mu.Unlock()
+		if ok && time.Since(g.time) < c.ttl {
+			return g.access
+		}
+	}
+
+	access := check(r, user, password)
+	if c.ttl > 0 && access != AccessNone {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		now := time.Now()
+		for key, g := range c.granted {
+			if now.Sub(g.time) >= c.ttl {
+				delete(c.granted, key)
+			}
+		}
+		c.granted[key] = grant{access: access, time: now}
+	}
+
+	return access
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// Recover decorates an http.Handler to recover from panics, so that a bug in
+// one request's handling can't take down the whole process, as it would when
+// serving with FastCGI or from a goroutine of its own. The panic is logged
+// with the request's ID and stack, onPanic (if not nil) is called with the
+// request, and the client gets 500 Internal Server Error, or has its
+// connection closed if the response had already begun. http.ErrAbortHandler
+// is passed through, as net/http handles it quietly.
+func Recover(h http.Handler, onPanic func(r *http.Request)) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		pw := &panicWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			Logf(r.Context(), "panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, debug.Stack())
+			if onPanic != nil {
+				onPanic(r)
+			}
+			if pw.wrote {
+				// A truncated response mustn't pass for a complete one
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		h.ServeHTTP(pw, r)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// panicWriter remembers whether the response has begun.
+type panicWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+// WriteHeader notes that the response has begun before passing the status on.
+func (w *panicWriter) WriteHeader(status int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write notes that the response has begun before passing the data on.
+func (w *panicWriter) Write(p []byte) (int, error) {
+	w.wrote = true
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes on flushes, for streamed responses.
+func (w *panicWriter) Flush() {
+	w.wrote = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the ResponseWriter being watched.
+func (w *panicWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// HeaderRequestID carries a request's ID, from a proxy in front of putter if
+// it set one, and back to the client.
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestID bounds the length of IDs accepted from clients.
+const maxRequestID = 128
+
+type requestIDKey struct{}
+
+// RequestID decorates an http.Handler to give every request an ID, taken from
+// its X-Request-ID header if it has a sensible one and generated otherwise,
+// which is sent back in the response's header and can be retrieved from its
+// context with RequestIDFrom. Requests that already have an ID keep it.
+func RequestID(h http.Handler) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFrom(r.Context()) != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// RequestIDFrom returns the ID given to the request by RequestID, or the empty
+// string if there is none.
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+
+	return id
+}
+
+// validRequestID reports whether id is safe to include in logs.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestID {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random ID.
+func newRequestID() string {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		log.Printf("failed to generate request ID: %v", err)
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// Logf logs like log.Printf, prefixed with the ID of the request whose
+// context ctx is, if any, so that the log lines of a request can be found.
+func Logf(ctx context.Context, format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if id := RequestIDFrom(ctx); id != "" {
+		message = "[" + id + "] " + message
+	}
+	log.Output(2, message)
+}
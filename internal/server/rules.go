@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// Rule changes how requests for a path are handled.
+type Rule struct {
+	Path         string      // path the rule applies to, and everything below it if it ends with "/"
+	Methods      []string    // methods allowed, or nil for any
+	Header       http.Header // headers set on every response
+	CacheControl string      // Cache-Control set on successful responses, if any
+	User         string      // user name required with HTTP basic authentication, if any
+	Password     string      // password required along with User
+}
+
+// Match reports whether the rule applies to path.
+func (rule *Rule) Match(path string) bool {
+	if strings.HasSuffix(rule.Path, "/") {
+		return strings.HasPrefix(path, rule.Path) || path == strings.TrimSuffix(rule.Path, "/")
+	}
+
+	return path == rule.Path
+}
+
+// Rules decorates an http.Handler to apply the rules matching each request's
+// path, in order, so that later rules override what earlier ones set.
+func Rules(h http.Handler, rules []Rule) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		var matched Rule
+		header := make(http.Header)
+		for i := range rules {
+			rule := &rules[i]
+			if !rule.Match(r.URL.Path) {
+				continue
+			}
+			if rule.Methods != nil {
+				matched.Methods = rule.Methods
+			}
+			for name, values := range rule.Header {
+				header[name] = values
+			}
+			if rule.CacheControl != "" {
+				matched.CacheControl = rule.CacheControl
+			}
+			if rule.User != "" {
+				matched.User, matched.Password = rule.User, rule.Password
+			}
+		}
+
+		if matched.User != "" {
+			user, password, ok := r.BasicAuth()
+			isUser := subtle.ConstantTimeCompare([]byte(user), []byte(matched.User)) == 1
+			isPassword := subtle.ConstantTimeCompare([]byte(password), []byte(matched.Password)) == 1
+			if !ok || !isUser || !isPassword {
+				w.Header().Set("WWW-Authenticate", `Basic realm="putter", charset="UTF-8"`)
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(ContextWithUser(r.Context(), user))
+		}
+		if matched.Methods != nil && !containsMethod(matched.Methods, r.Method) {
+			w.Header().Set("Allow", strings.Join(matched.Methods, ", "))
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if len(header) > 0 || matched.CacheControl != "" {
+			w = &ruleWriter{ResponseWriter: w, header: header, cacheControl: matched.CacheControl}
+		}
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// containsMethod reports whether method is one of methods.
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ruleWriter sets the headers of the rules matching a request on its
+// response, over any the handler set.
+type ruleWriter struct {
+	http.ResponseWriter
+	header       http.Header
+	cacheControl string
+	wroteHeader  bool
+}
+
+// WriteHeader sets the headers before passing the status on, leaving errors
+// without the Cache-Control so that they are fetched afresh.
+func (w *ruleWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		for name, values := range w.header {
+			w.Header()[name] = append([]string(nil), values...)
+		}
+		if w.cacheControl != "" && status < http.StatusBadRequest {
+			w.Header().Set("Cache-Control", w.cacheControl)
+		}
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sets the headers for an implicit 200 OK before passing the data on.
+func (w *ruleWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush passes on flushes, for streamed responses.
+func (w *ruleWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the ResponseWriter whose headers are set.
+func (w *ruleWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
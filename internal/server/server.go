@@ -0,0 +1,251 @@
+// Package server provides HTTP plumbing shared by putter's handlers.
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// FixPath ensures that the given string begins and ends with '/'
+func FixPath(p string) string {
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	if p[len(p)-1] != '/' {
+		p = p + "/"
+	}
+
+	return p
+}
+
+// WhitelistMethods decorates an http.Handler to only allow certain methods
+func WhitelistMethods(h http.Handler, methods ...string) http.Handler {
+	allow := make(map[string]bool)
+	for _, method := range methods {
+		allow[method] = true
+	}
+
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if allow[r.Method] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// StatusRecorder remembers the status written to a ResponseWriter.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+// WriteHeader records the status before passing it on.
+func (r *StatusRecorder) WriteHeader(status int) {
+	if r.Status == 0 {
+		r.Status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 OK before passing the data on.
+func (r *StatusRecorder) Write(p []byte) (int, error) {
+	if r.Status == 0 {
+		r.Status = http.StatusOK
+	}
+
+	return r.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the ResponseWriter being recorded.
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Abort responds with status and closes the connection, taking it over so
+// that a read of the request's body blocked on a client that stopped sending
+// is abandoned rather than blocking the response too. Where the connection
+// can't be taken over, such as with HTTP/2, it responds as usual. Statuses
+// are recorded by any StatusRecorders that w wraps.
+func Abort(w http.ResponseWriter, status int) {
+	for inner := w; inner != nil; {
+		if rec, ok := inner.(*StatusRecorder); ok && rec.Status == 0 {
+			rec.Status = status
+		}
+		if hj, ok := inner.(http.Hijacker); ok {
+			conn, buf, err := hj.Hijack()
+			if err != nil {
+				break
+			}
+			fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+			buf.Flush()
+			conn.Close()
+			return
+		}
+		unwrapper, ok := inner.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		inner = unwrapper.Unwrap()
+	}
+	w.WriteHeader(status)
+}
+
+// BasePath serves h under base, which must begin and end with '/', as if it
+// were at the root: requests outside of base are not found, base without its
+// trailing slash is redirected to base, and the path-absolute redirects made
+// by h are moved under base.
+func BasePath(h http.Handler, base string) http.Handler {
+	if base == "/" {
+		return h
+	}
+	prefix := strings.TrimSuffix(base, "/")
+
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			location := base
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			w.Header().Set("Location", location)
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, base) {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+		h.ServeHTTP(&basePathWriter{ResponseWriter: w, prefix: prefix}, r2)
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// basePathWriter moves path-absolute redirects under a prefix.
+type basePathWriter struct {
+	http.ResponseWriter
+	prefix string
+}
+
+// WriteHeader adds the prefix to a path-absolute Location before passing the
+// status on.
+func (w *basePathWriter) WriteHeader(status int) {
+	location := w.Header().Get("Location")
+	if strings.HasPrefix(location, "/") && !strings.HasPrefix(location, "//") {
+		w.Header().Set("Location", w.prefix+location)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes on flushes, for streamed responses.
+func (w *basePathWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// RedirectHTTPS returns a handler redirecting requests to the same URL over
+// HTTPS on port, for serving plain HTTP alongside it. Browsers are sent there
+// with 301 Moved Permanently, and other requests, such as saves, with 308
+// Permanent Redirect, which keeps their method and body.
+func RedirectHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// InterfaceIP returns the address of the named network interface (e.g.
+// "eth0"), preferring IPv4 to IPv6 and global addresses to link-local ones.
+func InterfaceIP(name string) (ip net.IP, err error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return
+	}
+	best := -1
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		rank := 0
+		if ipNet.IP.To4() != nil {
+			rank++
+		}
+		if !ipNet.IP.IsLinkLocalUnicast() {
+			rank += 2
+		}
+		if rank > best {
+			ip, best = ipNet.IP, rank
+		}
+	}
+	if ip == nil {
+		err = fmt.Errorf("interface %s has no IP address", name)
+	}
+
+	return
+}
+
+// URLs returns the URLs at which a server listening on ip and port can be
+// reached. For an unspecified IP (listening on all interfaces) these are the
+// addresses of the machine's network interfaces, other than loopback and
+// link-local ones.
+func URLs(scheme string, ip net.IP, port int) (urls []string, err error) {
+	ips := []net.IP{ip}
+	if ip.IsUnspecified() {
+		ips = nil
+		var addrs []net.Addr
+		addrs, err = net.InterfaceAddrs()
+		if err != nil {
+			return
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			// Listening on 0.0.0.0 doesn't accept IPv6 connections
+			if ip.To4() != nil && ipNet.IP.To4() == nil {
+				continue
+			}
+			ips = append(ips, ipNet.IP)
+		}
+	}
+	for _, ip := range ips {
+		urls = append(urls, scheme+"://"+net.JoinHostPort(ip.String(), strconv.Itoa(port))+"/")
+	}
+
+	return
+}
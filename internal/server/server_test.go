@@ -0,0 +1,386 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBasePath(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	mux.HandleFunc("/dir/", func(w http.ResponseWriter, r *http.Request) {})
+	h := BasePath(mux, "/wiki/")
+
+	tests := []struct {
+		path     string
+		status   int // or 0 for any redirect
+		body     string
+		location string
+	}{
+		{"/wiki/", http.StatusOK, "/", ""},
+		{"/wiki/admin/", http.StatusOK, "/admin/", ""},
+		{"/wiki", http.StatusMovedPermanently, "", "/wiki/"},
+		{"/wiki?x=1", http.StatusMovedPermanently, "", "/wiki/?x=1"},
+		// Redirected by the mux, with a status that depends on the Go version
+		{"/wiki/dir", 0, "", "/wiki/dir/"},
+		{"/other/", http.StatusNotFound, "", ""},
+		{"/wikiother", http.StatusNotFound, "", ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if test.status == 0 && (w.Code < 300 || w.Code >= 400) {
+			t.Errorf("GET %s status = %d, want a redirect", test.path, w.Code)
+		} else if test.status != 0 && w.Code != test.status {
+			t.Errorf("GET %s status = %d, want %d", test.path, w.Code, test.status)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("GET %s served %q, want %q", test.path, w.Body.String(), test.body)
+		}
+		if location := w.Header().Get("Location"); location != test.location {
+			t.Errorf("GET %s Location = %q, want %q", test.path, location, test.location)
+		}
+	}
+}
+
+func TestURLs(t *testing.T) {
+	tests := []struct {
+		ip  string
+		url string
+	}{
+		{"127.0.0.1", "http://127.0.0.1:8080/"},
+		{"::1", "http://[::1]:8080/"},
+		{"fd00::2", "http://[fd00::2]:8080/"},
+	}
+	for _, test := range tests {
+		urls, err := URLs("http", net.ParseIP(test.ip), 8080)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(urls) != 1 || urls[0] != test.url {
+			t.Errorf("URLs(%s) = %q, want %q", test.ip, urls, test.url)
+		}
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		method, host string
+		port         int
+		status       int
+		location     string
+	}{
+		{http.MethodGet, "wiki.example.com", 443, http.StatusMovedPermanently, "https://wiki.example.com/notes/?x=1"},
+		{http.MethodGet, "wiki.example.com:8080", 8443, http.StatusMovedPermanently, "https://wiki.example.com:8443/notes/?x=1"},
+		{http.MethodGet, "[::1]:8080", 443, http.StatusMovedPermanently, "https://[::1]/notes/?x=1"},
+		{http.MethodPut, "192.168.1.2", 8443, http.StatusPermanentRedirect, "https://192.168.1.2:8443/notes/?x=1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/notes/?x=1", nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		RedirectHTTPS(test.port).ServeHTTP(w, r)
+		if location := w.Header().Get("Location"); w.Code != test.status || location != test.location {
+			t.Errorf("%s %s = %d to %q, want %d to %q", test.method, test.host, w.Code, location, test.status, test.location)
+		}
+	}
+}
+
+func TestInterfaceIP(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		ip, err := InterfaceIP(iface.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ip.IsLoopback() {
+			t.Errorf("InterfaceIP(%s) = %s, want a loopback address", iface.Name, ip)
+		}
+	}
+
+	_, err = InterfaceIP("no-such-interface")
+	if err == nil {
+		t.Error("InterfaceIP of a missing interface succeeded")
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	h := RequestID(RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Logf(r.Context(), "handled")
+	})))
+
+	for _, test := range []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"bad id\n", false},
+		{strings.Repeat("a", 200), false},
+	} {
+		logs.Reset()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if test.header != "" {
+			r.Header.Set(HeaderRequestID, test.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		id := w.Header().Get(HeaderRequestID)
+		if test.keep && id != test.header || !test.keep && (id == test.header || len(id) != 16) {
+			t.Errorf("ID for header %q = %q", test.header, id)
+		}
+		if !strings.Contains(logs.String(), "["+id+"] handled") {
+			t.Errorf("log for header %q = %q, want it prefixed with %s", test.header, logs.String(), id)
+		}
+	}
+}
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	panics := 0
+	h := RequestID(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/partial" {
+			w.Write([]byte("half a response"))
+		}
+		panic("oops")
+	}), func(r *http.Request) { panics++ }))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(HeaderRequestID, "abc")
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if panics != 1 {
+		t.Errorf("onPanic called %d times, want 1", panics)
+	}
+	if !strings.Contains(logs.String(), "[abc] panic serving GET /: oops") || !strings.Contains(logs.String(), "goroutine") {
+		t.Errorf("log = %q, want the panic with its request ID and stack", logs.String())
+	}
+
+	// A response already under way is aborted instead
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("partial response panicked with %v, want http.ErrAbortHandler", v)
+		}
+		if panics != 2 {
+			t.Errorf("onPanic called %d times, want 2", panics)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/partial", nil))
+}
+
+func TestCommandAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	runs := filepath.Join(dir, "runs")
+	// Accepts alice with her password for anything but DELETE, counting runs
+	script := `echo >> "$0"; read user; read password; [ "$user" = alice ] && [ "$password" = secret ] && [ "$PUTTER_METHOD" != DELETE ]`
+	h := CommandAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := UserFrom(r.Context()); user != "alice" {
+			t.Errorf("authenticated user = %q, want alice", user)
+		}
+	}), []string{"sh", "-c", script, runs}, 0)
+
+	for _, test := range []struct {
+		method, user, password string
+		status                 int
+	}{
+		{http.MethodGet, "alice", "secret", http.StatusOK},
+		{http.MethodGet, "alice", "wrong", http.StatusUnauthorized},
+		{http.MethodGet, "", "", http.StatusUnauthorized},
+		{http.MethodDelete, "alice", "secret", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(test.method, "/", nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s as %q:%q = %d, want %d", test.method, test.user, test.password, w.Code, test.status)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s as %q has no WWW-Authenticate header", test.method, test.user)
+		}
+	}
+
+	// Accepted credentials are remembered
+	h = CommandAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), []string{"sh", "-c", script, runs}, time.Minute)
+	os.Remove(runs)
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+		}
+	}
+	data, _ := ioutil.ReadFile(runs)
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("command ran %d times, want once", n)
+	}
+
+	// A read accepted by a command that refuses writes doesn't let the same
+	// credentials write while it is remembered
+	readOnly := `read user; read password; [ "$PUTTER_METHOD" = GET ]`
+	h = CommandAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), []string{"sh", "-c", readOnly}, time.Minute)
+	for _, test := range []struct {
+		method string
+		status int
+	}{
+		{http.MethodPut, http.StatusUnauthorized},
+		{http.MethodGet, http.StatusOK},
+		{http.MethodPut, http.StatusUnauthorized},
+		{http.MethodDelete, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(test.method, "/", nil)
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s after an accepted GET = %d, want %d", test.method, w.Code, test.status)
+		}
+	}
+}
+
+func TestBasicAuthCache(t *testing.T) {
+	// Grants writing only under /drafts/, counting checks
+	checks := 0
+	h := BasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), func(r *http.Request, user, password string) Access {
+		checks++
+		if strings.HasPrefix(r.URL.Path, "/drafts/") {
+			return AccessWrite
+		}
+		return AccessRead
+	}, time.Minute)
+
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPut, "/drafts/", http.StatusOK},
+		{http.MethodPut, "/drafts/", http.StatusOK},
+		{http.MethodPut, "/", http.StatusForbidden},
+		{http.MethodGet, "/", http.StatusOK},
+	} {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.SetBasicAuth("alice", "secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s = %d, want %d", test.method, test.path, w.Code, test.status)
+		}
+	}
+	if checks != 3 {
+		t.Errorf("checked %d times, want 3", checks)
+	}
+}
+
+func TestAnonymousReads(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	auth := BasicAuth(h, PasswordCheck("alice", "secret"), 0)
+	anonymous := AnonymousReads(h, auth)
+
+	for _, test := range []struct {
+		method, user, password  string
+		status, anonymousStatus int
+	}{
+		{http.MethodGet, "", "", http.StatusUnauthorized, http.StatusOK},
+		{http.MethodHead, "", "", http.StatusUnauthorized, http.StatusOK},
+		{http.MethodPut, "", "", http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.MethodPut, "alice", "wrong", http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.MethodPut, "bob", "secret", http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.MethodPut, "alice", "secret", http.StatusOK, http.StatusOK},
+	} {
+		for _, h := range []struct {
+			handler http.Handler
+			status  int
+		}{{auth, test.status}, {anonymous, test.anonymousStatus}} {
+			r := httptest.NewRequest(test.method, "/", nil)
+			if test.user != "" {
+				r.SetBasicAuth(test.user, test.password)
+			}
+			w := httptest.NewRecorder()
+			h.handler.ServeHTTP(w, r)
+			if w.Code != h.status {
+				t.Errorf("%s as %q:%q = %d, want %d", test.method, test.user, test.password, w.Code, h.status)
+			}
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	h := Rules(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		w.Write([]byte("ok"))
+	}), []Rule{
+		{Path: "/", Header: http.Header{"X-Frame-Options": {"DENY"}}},
+		{Path: "/old/", Methods: []string{http.MethodGet}, CacheControl: "max-age=31536000"},
+		{Path: "/missing", CacheControl: "max-age=60"},
+		{Path: "/status", User: "ops", Password: "secret"},
+	})
+
+	tests := []struct {
+		method, path string
+		auth         bool
+		status       int
+		cacheControl string
+	}{
+		{http.MethodGet, "/", false, http.StatusOK, "no-cache"},
+		{http.MethodGet, "/old/a.html", false, http.StatusOK, "max-age=31536000"},
+		{http.MethodGet, "/old", false, http.StatusOK, "max-age=31536000"},
+		{http.MethodDelete, "/old/a.html", false, http.StatusMethodNotAllowed, ""},
+		{http.MethodGet, "/oldest", false, http.StatusOK, "no-cache"},
+		{http.MethodGet, "/missing", false, http.StatusNotFound, "no-cache"},
+		{http.MethodGet, "/status", false, http.StatusUnauthorized, ""},
+		{http.MethodGet, "/status", true, http.StatusOK, "no-cache"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		if test.auth {
+			r.SetBasicAuth("ops", "secret")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s status = %d, want %d", test.method, test.path, w.Code, test.status)
+		}
+		if w.Code < http.StatusBadRequest && w.Header().Get("Cache-Control") != test.cacheControl {
+			t.Errorf("%s %s Cache-Control = %q, want %q", test.method, test.path, w.Header().Get("Cache-Control"), test.cacheControl)
+		}
+		if w.Header().Get("X-Frame-Options") != "DENY" && w.Code != http.StatusMethodNotAllowed && w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s X-Frame-Options = %q, want DENY", test.method, test.path, w.Header().Get("X-Frame-Options"))
+		}
+	}
+}
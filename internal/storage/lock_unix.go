@@ -0,0 +1,26 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// LockFile opens (creating if necessary) the named file and takes an exclusive
+// advisory lock on it, failing immediately if another process holds the lock.
+// The lock is held for as long as the returned file remains open.
+func LockFile(name string) (f *os.File, err error) {
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	lockfileFailImmediately = 0x00000001
+	lockfileExclusiveLock   = 0x00000002
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+// LockFile opens (creating if necessary) the named file and takes an exclusive
+// lock on it, failing immediately if another process holds the lock.
+// The lock is held for as long as the returned file remains open.
+func LockFile(name string) (f *os.File, err error) {
+	f, err = os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	var overlapped syscall.Overlapped
+	r, _, errno := procLockFileEx.Call(
+		f.Fd(),
+		lockfileExclusiveLock|lockfileFailImmediately,
+		0,
+		1,
+		0,
+		uintptr(unsafe.Pointer(&overlapped)),
+	)
+	if r == 0 {
+		f.Close()
+		return nil, errno
+	}
+
+	return
+}
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// ioctlFiclone is FICLONE from linux/fs.h
+const ioctlFiclone = 0x40049409
+
+// Reflink creates dst as a copy-on-write clone of src. This only succeeds
+// on filesystems that support sharing extents, such as btrfs and XFS.
+func Reflink(src, dst string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	err = out.Chmod(mode)
+	if err != nil {
+		return
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, out.Fd(), ioctlFiclone, in.Fd())
+	if errno != 0 {
+		out.Close()
+		os.Remove(dst)
+		return errno
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package storage
+
+import (
+	"errors"
+	"os"
+)
+
+// Reflink is unsupported outside of Linux, so archives are always copied.
+func Reflink(src, dst string, mode os.FileMode) error {
+	return errors.New("reflink not supported on this platform")
+}
@@ -0,0 +1,117 @@
+// Package storage provides the file operations underlying putter's saves:
+// permission-explicit file creation, cancellable copies, and locking.
+package storage
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ContextReader is an io.Reader that fails once its context is done, so that
+// long copies can be abandoned.
+type ContextReader struct {
+	Ctx context.Context
+	R   io.Reader
+}
+
+func (c ContextReader) Read(p []byte) (int, error) {
+	err := c.Ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+
+	return c.R.Read(p)
+}
+
+// CreateFile creates (or truncates) the named file for writing, explicitly
+// setting its permissions so that they don't depend on the umask.
+func CreateFile(name string, mode os.FileMode) (f *os.File, err error) {
+	f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return
+	}
+	err = f.Chmod(mode)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// WriteFile is like ioutil.WriteFile, but with permissions set as CreateFile.
+func WriteFile(name string, data []byte, mode os.FileMode) (err error) {
+	f, err := CreateFile(name, mode)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return
+	}
+
+	return f.Close()
+}
+
+// Mkdir creates the named directory and any missing parents, if they don't
+// already exist, explicitly setting their permissions so that they don't
+// depend on the umask.
+func Mkdir(name string, mode os.FileMode) (err error) {
+	err = os.Mkdir(name, mode)
+	if os.IsNotExist(err) {
+		err = Mkdir(filepath.Dir(name), mode)
+		if err == nil {
+			err = os.Mkdir(name, mode)
+		}
+	}
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	return os.Chmod(name, mode)
+}
+
+// CopyFile copies the contents of the file src to a newly created file dst.
+func CopyFile(ctx context.Context, src, dst string, mode os.FileMode) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	out, err := CreateFile(dst, mode)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, ContextReader{ctx, in})
+	if err != nil {
+		return
+	}
+
+	return out.Close()
+}
+
+// DirSize sums the sizes of the regular files in the named directory.
+func DirSize(name string) (size int64, err error) {
+	fileInfos, err := ioutil.ReadDir(name)
+	if err != nil {
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.Mode().IsRegular() {
+			size += fileInfo.Size()
+		}
+	}
+
+	return
+}
@@ -0,0 +1,192 @@
+// Package syslog writes putter's log to a syslog daemon, either the local one
+// or a remote one that accepts RFC 5424 messages over UDP or TCP.
+package syslog
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Target names the local syslog daemon as a log target.
+const Target = "syslog"
+
+// Priorities of messages: the daemon facility, with these severities.
+const (
+	facilityDaemon  = 3
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// tag identifies putter's messages.
+const tag = "putter"
+
+// stdTime is the prefix that the standard logger's default flags give each
+// line, which becomes the message's timestamp.
+const stdTime = "2006/01/02 15:04:05 "
+
+// localSockets are where the local daemon listens on various systems.
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Writer writes each line written to it to syslog as a message.
+type Writer struct {
+	mu       sync.Mutex
+	network  string // network of the daemon, e.g. unixgram or tcp
+	addr     string // address of the daemon
+	remote   bool   // whether messages are formatted per RFC 5424 for a remote daemon
+	hostname string
+	conn     net.Conn
+}
+
+// ValidTarget reports whether target is the local daemon (Target) or a remote
+// one, e.g. syslog://logs.example.com:514 (UDP) or
+// syslog+tcp://logs.example.com:514.
+func ValidTarget(target string) bool {
+	_, _, err := parseTarget(target)
+
+	return err == nil
+}
+
+// parseTarget returns the network and address of the daemon named by target,
+// or an empty address for the local daemon.
+func parseTarget(target string) (network, addr string, err error) {
+	if target == Target {
+		return "unixgram", "", nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+	switch u.Scheme {
+	case "syslog", "syslog+udp":
+		network = "udp"
+	case "syslog+tcp":
+		network = "tcp"
+	default:
+		return "", "", fmt.Errorf("invalid syslog target %q", target)
+	}
+	if u.Host == "" {
+		return "", "", fmt.Errorf("invalid syslog target %q", target)
+	}
+	addr = u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "514")
+	}
+
+	return
+}
+
+// Dial connects to the daemon named by target (see ValidTarget).
+func Dial(target string) (w *Writer, err error) {
+	network, addr, err := parseTarget(target)
+	if err != nil {
+		return
+	}
+	w = &Writer{network: network, addr: addr, remote: addr != ""}
+	w.hostname, _ = os.Hostname()
+	if w.hostname == "" {
+		w.hostname = "-"
+	}
+	err = w.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// connect (re)connects to the daemon. The caller must hold the lock, if any.
+func (w *Writer) connect() (err error) {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+	if w.remote {
+		w.conn, err = net.DialTimeout(w.network, w.addr, 10*time.Second)
+		return
+	}
+	for _, socket := range localSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			w.conn, err = net.Dial(network, socket)
+			if err == nil {
+				w.network, w.addr = network, socket
+				return
+			}
+		}
+	}
+
+	return fmt.Errorf("no syslog daemon found: %v", err)
+}
+
+// Write sends each line of p as a message, reconnecting once if sending
+// fails, e.g. because the daemon was restarted.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		message := w.format(line, time.Now())
+		_, err = w.conn.Write(message)
+		if err != nil {
+			err = w.connect()
+			if err == nil {
+				_, err = w.conn.Write(message)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	return len(p), nil
+}
+
+// format formats a line of the log as a message. A timestamp at the start of
+// the line, as the standard logger writes, is used as the message's.
+func (w *Writer) format(line string, now time.Time) []byte {
+	if len(line) >= len(stdTime) {
+		t, err := time.ParseInLocation(stdTime, line[:len(stdTime)], time.Local)
+		if err == nil {
+			now = t
+			line = line[len(stdTime):]
+		}
+	}
+	// Putter's messages don't have levels, but failures and warnings are
+	// consistently worded
+	severity := severityInfo
+	if strings.Contains(line, "failed") {
+		severity = severityError
+	} else if strings.Contains(line, "warning:") {
+		severity = severityWarning
+	}
+	priority := facilityDaemon*8 + severity
+
+	var b bytes.Buffer
+	if !w.remote {
+		fmt.Fprintf(&b, "<%d>%s %s[%d]: %s\n", priority, now.Format(time.Stamp), tag, os.Getpid(), line)
+		return b.Bytes()
+	}
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - - %s", priority, now.Format(time.RFC3339Nano), w.hostname, tag, os.Getpid(), line)
+	if w.network == "tcp" {
+		// Octet counting, as described by RFC 6587
+		return append([]byte(fmt.Sprintf("%d ", b.Len())), b.Bytes()...)
+	}
+
+	return b.Bytes()
+}
+
+// Close closes the connection to the daemon.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+
+	return w.conn.Close()
+}
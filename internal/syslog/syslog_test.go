@@ -0,0 +1,102 @@
+package syslog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidTarget(t *testing.T) {
+	for target, want := range map[string]bool{
+		"syslog":                         true,
+		"syslog://logs.example.com":      true,
+		"syslog+udp://127.0.0.1:5514":    true,
+		"syslog+tcp://[::1]:6514":        true,
+		"syslog:":                        false,
+		"udp://logs.example.com:514":     false,
+		"stderr":                         false,
+		"syslog+tcp://":                  false,
+		"syslog+tls://logs.example.com:": false,
+	} {
+		if got := ValidTarget(target); got != want {
+			t.Errorf("ValidTarget(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestRemoteUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	w, err := Dial("syslog://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	_, err = fmt.Fprintln(w, "2024/03/05 14:30:00 saved wiki\n2024/03/05 14:30:01 failed to archive wiki: disk full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Date(2024, 3, 5, 14, 30, 0, 0, time.Local).Format(time.RFC3339Nano)
+	want := []string{
+		fmt.Sprintf("<30>1 %s %s putter %d - - saved wiki", stamp, w.hostname, os.Getpid()),
+		"<27>1 ",
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for i, prefix := range want {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(buf[:n])
+		if i == 0 && got != prefix || !strings.HasPrefix(got, prefix) {
+			t.Errorf("message %d = %q, want %q", i, got, prefix)
+		}
+	}
+}
+
+func TestRemoteTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	w, err := Dial("syslog+tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	_, err = w.Write([]byte("warning: archive is large\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(c)
+	var length int
+	_, err = fmt.Fscanf(r, "%d ", &length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := make([]byte, length)
+	_, err = r.Read(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(message), "<28>1 ") || !strings.HasSuffix(string(message), " - - warning: archive is large") {
+		t.Errorf("message = %q", message)
+	}
+}
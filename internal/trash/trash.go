@@ -0,0 +1,175 @@
+// Package trash keeps files that putter would otherwise delete, like pruned
+// archives and uploads that weren't saved, in a directory of their own for a
+// while, so that they can be recovered.
+package trash
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// Reasons for which files are discarded.
+const (
+	ReasonPruned    = "pruned"    // archives removed to make room
+	ReasonRejected  = "rejected"  // uploads that weren't saved, e.g. conflicting ones
+	ReasonDiscarded = "discarded" // saves held for approval that were discarded
+)
+
+// timeFormat is the format of the time at which a file was discarded, with
+// which the names of files in the trash begin.
+const timeFormat = "20060102T150405.000000000Z"
+
+// Trash is a directory of discarded files, each kept for Retention.
+type Trash struct {
+	Dir       string        // directory to which files are moved
+	Retention time.Duration // how long discarded files are kept, or 0 for ever
+	FileMode  os.FileMode   // permissions for files copied into the trash
+	DirMode   os.FileMode   // permissions for the trash directory
+}
+
+// Item is a discarded file in the trash.
+type Item struct {
+	Name     string    `json:"name"`     // name in the trash
+	Original string    `json:"original"` // name of the file before it was discarded
+	Reason   string    `json:"reason"`   // why it was discarded, e.g. ReasonPruned
+	Time     time.Time `json:"time"`     // when it was discarded
+	Size     int64     `json:"size"`
+}
+
+// Discard moves the file at path into the trash, recording original as its
+// name and reason as why it was discarded, and returns its name in the
+// trash. Files whose retention has passed are then removed. A nil Trash
+// removes the file instead.
+func (t *Trash) Discard(path, original, reason string) (name string, err error) {
+	if t == nil {
+		return "", os.Remove(path)
+	}
+	err = storage.Mkdir(t.Dir, t.DirMode)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	name = now.UTC().Format(timeFormat) + "_" + reason + "_" + filepath.Base(original)
+	dst := filepath.Join(t.Dir, name)
+	err = os.Rename(path, dst)
+	if err != nil {
+		// The trash may be on another file system
+		err = storage.CopyFile(context.Background(), path, dst, t.FileMode)
+		if err == nil {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			os.Remove(dst)
+			return "", err
+		}
+	}
+	_, err = t.Purge(now)
+	if err != nil {
+		log.Printf("failed to empty expired trash: %v", err)
+	}
+
+	return name, nil
+}
+
+// parseName parses the name of a file in the trash, reporting whether it is
+// one.
+func parseName(name string) (item Item, ok bool) {
+	parts := strings.SplitN(name, "_", 3)
+	if len(parts) != 3 || parts[2] == "" {
+		return
+	}
+	discarded, err := time.Parse(timeFormat, parts[0])
+	if err != nil {
+		return
+	}
+
+	return Item{Name: name, Original: parts[2], Reason: parts[1], Time: discarded}, true
+}
+
+// List lists the files in the trash, most recently discarded first.
+func (t *Trash) List() (items []Item, err error) {
+	fileInfos, err := ioutil.ReadDir(t.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		item, ok := parseName(fileInfo.Name())
+		if !ok || !fileInfo.Mode().IsRegular() {
+			continue
+		}
+		item.Size = fileInfo.Size()
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Time.After(items[j].Time) })
+
+	return
+}
+
+// Path returns the path of the named file in the trash, or an error if there
+// is no such file.
+func (t *Trash) Path(name string) (path string, err error) {
+	if _, ok := parseName(name); !ok || name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid trash name %q", name)
+	}
+	path = filepath.Join(t.Dir, name)
+	_, err = os.Stat(path)
+
+	return
+}
+
+// Recover moves the named file out of the trash to dst, which must not
+// exist.
+func (t *Trash) Recover(name, dst string) (err error) {
+	path, err := t.Path(name)
+	if err != nil {
+		return
+	}
+	if _, err = os.Lstat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+	err = os.Rename(path, dst)
+	if err != nil {
+		err = storage.CopyFile(context.Background(), path, dst, t.FileMode)
+		if err == nil {
+			err = os.Remove(path)
+		}
+	}
+
+	return
+}
+
+// Purge removes the files discarded longer than Retention before now,
+// returning their names.
+func (t *Trash) Purge(now time.Time) (purged []string, err error) {
+	if t.Retention <= 0 {
+		return
+	}
+	items, err := t.List()
+	if err != nil {
+		return
+	}
+	for _, item := range items {
+		if now.Sub(item.Time) < t.Retention {
+			continue
+		}
+		err = os.Remove(filepath.Join(t.Dir, item.Name))
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+		purged = append(purged, item.Name)
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,92 @@
+package trash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "putter-trash-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trash := &Trash{Dir: filepath.Join(dir, "trash"), Retention: time.Hour, FileMode: 0644, DirMode: 0755}
+
+	upload := filepath.Join(dir, ".upload-1")
+	err = ioutil.WriteFile(upload, []byte("edits"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := trash.Discard(upload, "index.html", ReasonRejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(upload); !os.IsNotExist(err) {
+		t.Errorf("discarded file still exists: %v", err)
+	}
+
+	items, err := trash.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Name != name || items[0].Original != "index.html" || items[0].Reason != ReasonRejected || items[0].Size != 5 {
+		t.Fatalf("trash = %+v", items)
+	}
+
+	if _, err := trash.Path("../" + name); err == nil {
+		t.Error("path outside the trash accepted")
+	}
+	recovered := filepath.Join(dir, "recovered.html")
+	err = trash.Recover(name, recovered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(recovered)
+	if err != nil || string(data) != "edits" {
+		t.Errorf("recovered %q, %v", data, err)
+	}
+	if items, _ := trash.List(); len(items) != 0 {
+		t.Errorf("trash after recovery = %+v", items)
+	}
+
+	err = ioutil.WriteFile(upload, []byte("more edits"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err = trash.Discard(upload, "index.html", ReasonRejected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.Recover(name, recovered); err == nil {
+		t.Error("recovery overwrote an existing file")
+	}
+	purged, err := trash.Purge(time.Now().Add(30 * time.Minute))
+	if err != nil || len(purged) != 0 {
+		t.Errorf("purged %v, %v before retention passed", purged, err)
+	}
+	purged, err = trash.Purge(time.Now().Add(2 * time.Hour))
+	if err != nil || len(purged) != 1 || purged[0] != name {
+		t.Errorf("purged %v, %v, want %s", purged, err, name)
+	}
+}
+
+func TestDiscardNil(t *testing.T) {
+	f, err := ioutil.TempFile("", "putter-trash-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	var trash *Trash
+	_, err = trash.Discard(f.Name(), "index.html", ReasonPruned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		os.Remove(f.Name())
+		t.Errorf("nil trash kept file: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package putter
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/djcrock/putter/internal/compress"
+)
+
+const extensionJournal = ".journal"
+
+// journalEntry records a save in progress, so that a crash part way through
+// can be completed or cleaned up on the next startup.
+type journalEntry struct {
+	Upload string `json:"upload"` // name of the fully received upload
+	Etag   string `json:"etag"`   // ETag of the upload's contents
+}
+
+// writeJournal durably records the entry before the live wiki is touched,
+// returning the name of the journal file.
+func (s *Server) writeJournal(entry journalEntry) (name string, err error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	name = s.fileName + extensionJournal
+	f, err := os.Create(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return
+	}
+	err = f.Sync()
+	if err != nil {
+		return
+	}
+
+	return name, f.Close()
+}
+
+// recoverJournal replays a save that was interrupted by a crash. If the upload
+// is still present and intact, the live wiki is archived and replaced with it;
+// otherwise the leftovers are cleaned up. Either way, the journal is removed.
+func (s *Server) recoverJournal() (err error) {
+	name := s.fileName + extensionJournal
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return
+	}
+	log.Println("recovering interrupted save...")
+
+	var entry journalEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		log.Printf("discarding unreadable journal: %v", err)
+		return os.Remove(name)
+	}
+	os.Remove(s.fileName + extensionBackup)
+
+	etag, err := s.hashFile(entry.Upload)
+	switch {
+	case os.IsNotExist(err):
+		// The upload was already renamed over the live wiki
+		log.Println("interrupted save had already replaced the wiki")
+	case err != nil:
+		return
+	case etag != entry.Etag:
+		log.Printf("discarding corrupt upload %s", entry.Upload)
+		os.Remove(entry.Upload)
+	default:
+		err = s.archiveWiki(context.Background())
+		if err != nil {
+			return
+		}
+		err = os.Rename(entry.Upload, s.fileName)
+		if err != nil {
+			return
+		}
+		err = os.Chmod(s.fileName, s.fileMode)
+		if err != nil {
+			return
+		}
+		log.Printf("completed interrupted save of %s", entry.Upload)
+	}
+	// Don't trust the cached ETag of a wiki that was mid-save
+	os.Remove(s.fileName + extensionEtag)
+
+	return os.Remove(name)
+}
+
+// uploadPrefix is the prefix of temporary files receiving uploads. It includes
+// the wiki's name so that wikis sharing a directory don't clean up each other.
+func (s *Server) uploadPrefix() string {
+	return uploadPrefix + filepath.Base(s.fileName) + "-"
+}
+
+// recoverOrphans cleans up temporary uploads, backups, and partial compressed
+// files left behind by a crashed run, restoring the live wiki from its backup
+// if it has gone missing. Must be called after recoverJournal.
+func (s *Server) recoverOrphans() (err error) {
+	dir := filepath.Dir(s.fileName)
+	fileInfos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var orphans []string
+	for _, fileInfo := range fileInfos {
+		if strings.HasPrefix(fileInfo.Name(), s.uploadPrefix()) {
+			orphans = append(orphans, filepath.Join(dir, fileInfo.Name()))
+		}
+	}
+	orphans = append(orphans, compress.TempName(s.fileName+compress.Extension))
+
+	backup := s.fileName + extensionBackup
+	_, err = os.Stat(s.fileName)
+	if os.IsNotExist(err) {
+		err = os.Rename(backup, s.fileName)
+		if err != nil {
+			return
+		}
+		log.Printf("restored missing wiki from %s", backup)
+		// Force the restored wiki to be rehashed
+		os.Remove(s.fileName + extensionEtag)
+	} else {
+		orphans = append(orphans, backup)
+	}
+
+	for _, orphan := range orphans {
+		err = os.Remove(orphan)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		log.Printf("removed leftover file %s", orphan)
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+// Package lambda serves an http.Handler, such as putter's, as an AWS Lambda
+// function behind a function URL or an API Gateway HTTP API, using the Lambda
+// runtime API directly so that no SDK is needed.
+package lambda
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponse is the largest response Lambda accepts from a function.
+const maxResponse = 6 << 20
+
+// request is an HTTP request event, in version 2.0 of the payload format used
+// by function URLs and HTTP APIs.
+type request struct {
+	RawPath        string            `json:"rawPath"`
+	RawQueryString string            `json:"rawQueryString"`
+	Cookies        []string          `json:"cookies"`
+	Headers        map[string]string `json:"headers"`
+	RequestContext struct {
+		DomainName string `json:"domainName"`
+		HTTP       struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"`
+	} `json:"requestContext"`
+	Body            string `json:"body"`
+	IsBase64Encoded bool   `json:"isBase64Encoded"`
+}
+
+// response is the function's reply to a request event.
+type response struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers"`
+	Cookies         []string          `json:"cookies,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// Serve handles the function's invocations with h until the runtime API fails.
+// It must be called from a function deployed with a custom runtime (e.g.
+// provided.al2023), whose bootstrap is the program calling it.
+func Serve(h http.Handler) error {
+	api := os.Getenv("AWS_LAMBDA_RUNTIME_API")
+	if api == "" {
+		return errors.New("not running in AWS Lambda, AWS_LAMBDA_RUNTIME_API is not set")
+	}
+
+	return serve("http://"+api+"/2018-06-01/runtime", h)
+}
+
+// serve handles invocations from the runtime API at base.
+func serve(base string, h http.Handler) error {
+	for {
+		res, err := http.Get(base + "/invocation/next")
+		if err != nil {
+			return err
+		}
+		event, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to get next invocation: %s", res.Status)
+		}
+		id := res.Header.Get("Lambda-Runtime-Aws-Request-Id")
+
+		ctx := context.Background()
+		cancel := func() {}
+		ms, err := strconv.ParseInt(res.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64)
+		if err == nil {
+			ctx, cancel = context.WithDeadline(ctx, time.Unix(0, ms*int64(time.Millisecond)))
+		}
+		out, err := invoke(ctx, h, event)
+		cancel()
+		if err != nil {
+			out, _ = json.Marshal(map[string]string{
+				"errorType":    "InvalidEvent",
+				"errorMessage": err.Error(),
+			})
+			err = post(base+"/invocation/"+id+"/error", out)
+		} else {
+			err = post(base+"/invocation/"+id+"/response", out)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// post sends the result of an invocation to the runtime API.
+func post(url string, body []byte) error {
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to send invocation result: %s", res.Status)
+	}
+
+	return nil
+}
+
+// invoke passes a request event to h, returning the response event.
+func invoke(ctx context.Context, h http.Handler, event []byte) ([]byte, error) {
+	var e request
+	err := json.Unmarshal(event, &e)
+	if err != nil {
+		return nil, err
+	}
+	if e.RequestContext.HTTP.Method == "" {
+		return nil, errors.New("not an HTTP request event, is the function behind a function URL or an HTTP API?")
+	}
+	r, err := newRequest(ctx, &e)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &responseWriter{header: make(http.Header)}
+	h.ServeHTTP(w, r)
+	res := w.response()
+	out, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxResponse {
+		out, err = json.Marshal(&response{
+			StatusCode: http.StatusBadGateway,
+			Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			Body:       "Response is too large for AWS Lambda.\n",
+		})
+	}
+
+	return out, err
+}
+
+// newRequest builds the HTTP request described by an event.
+func newRequest(ctx context.Context, e *request) (*http.Request, error) {
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		body, err = base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body: %v", err)
+		}
+	}
+	u := &url.URL{
+		Scheme:   "https",
+		Host:     e.RequestContext.DomainName,
+		RawQuery: e.RawQueryString,
+	}
+	path, err := url.PathUnescape(e.RawPath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path: %v", err)
+	}
+	u.Path, u.RawPath = path, e.RawPath
+
+	r, err := http.NewRequest(e.RequestContext.HTTP.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	r = r.WithContext(ctx)
+	r.RequestURI = u.RequestURI()
+	r.RemoteAddr = net.JoinHostPort(e.RequestContext.HTTP.SourceIP, "0")
+	for name, value := range e.Headers {
+		r.Header.Set(name, value)
+	}
+	if len(e.Cookies) > 0 {
+		r.Header.Set("Cookie", strings.Join(e.Cookies, "; "))
+	}
+	if host := r.Header.Get("Host"); host != "" {
+		r.Host = host
+	}
+
+	return r, nil
+}
+
+// responseWriter collects a handler's response.
+type responseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+
+	return w.body.Write(p)
+}
+
+// response converts the collected response to an event. The body is always
+// base64 encoded, as wikis are served compressed.
+func (w *responseWriter) response() *response {
+	w.WriteHeader(http.StatusOK)
+	res := &response{
+		StatusCode:      w.status,
+		Headers:         make(map[string]string),
+		Body:            base64.StdEncoding.EncodeToString(w.body.Bytes()),
+		IsBase64Encoded: true,
+	}
+	for name, values := range w.header {
+		if name == "Set-Cookie" {
+			res.Cookies = values
+			continue
+		}
+		res.Headers[name] = strings.Join(values, ", ")
+	}
+
+	return res
+}
@@ -0,0 +1,89 @@
+package lambda
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRuntime is a runtime API that invokes the function with each event in
+// turn, then fails, recording the function's replies.
+type fakeRuntime struct {
+	mu      sync.Mutex
+	events  []string
+	replies []string
+}
+
+func (f *fakeRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/2018-06-01/runtime/invocation/next" {
+		if len(f.events) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Lambda-Runtime-Aws-Request-Id", fmt.Sprint(len(f.replies)))
+		fmt.Fprint(w, f.events[0])
+		f.events = f.events[1:]
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	f.replies = append(f.replies, strings.TrimPrefix(r.URL.Path, "/2018-06-01/runtime/invocation/")+" "+string(body))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestServe(t *testing.T) {
+	runtime := &fakeRuntime{events: []string{
+		`{"version":"2.0","rawPath":"/wiki/a%2Fb","rawQueryString":"x=1","cookies":["a=1","b=2"],` +
+			`"headers":{"host":"example.lambda-url.us-east-1.on.aws","content-type":"text/plain"},` +
+			`"requestContext":{"domainName":"example.lambda-url.us-east-1.on.aws","http":{"method":"PUT","sourceIp":"192.0.2.1"}},` +
+			`"body":"aGVsbG8=","isBase64Encoded":true}`,
+		`{"source":"aws.events"}`,
+	}}
+	api := httptest.NewServer(runtime)
+	defer api.Close()
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		cookie, _ := r.Cookie("b")
+		w.Header().Add("Set-Cookie", "c=3")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%s %s %s %s %s %s %s", r.Method, r.Host, r.URL.EscapedPath(), r.URL.RawQuery, r.RemoteAddr, cookie.Value, body)
+	})
+	err := serve(api.URL+"/2018-06-01/runtime", h)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Errorf("err = %v, want the failure of the runtime API", err)
+	}
+
+	if len(runtime.replies) != 2 {
+		t.Fatalf("replies = %q", runtime.replies)
+	}
+	var res response
+	reply := strings.SplitN(runtime.replies[0], " ", 2)
+	if reply[0] != "0/response" {
+		t.Errorf("reply sent to %s", reply[0])
+	}
+	err = json.Unmarshal([]byte(reply[1]), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := base64.StdEncoding.DecodeString(res.Body)
+	want := "PUT example.lambda-url.us-east-1.on.aws /wiki/a%2Fb x=1 192.0.2.1:0 2 hello"
+	if res.StatusCode != http.StatusCreated || string(body) != want {
+		t.Errorf("response = %d %q, want %d %q", res.StatusCode, body, http.StatusCreated, want)
+	}
+	if res.Headers["Content-Type"] != "text/plain" || len(res.Cookies) != 1 || res.Cookies[0] != "c=3" {
+		t.Errorf("headers = %v, cookies = %v", res.Headers, res.Cookies)
+	}
+
+	if !strings.HasPrefix(runtime.replies[1], "1/error ") {
+		t.Errorf("reply to a non-HTTP event = %s, want an error", runtime.replies[1])
+	}
+}
@@ -0,0 +1,252 @@
+package putter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// Lock modes control what happens to saves while another client holds a
+// WebDAV lock on the wiki (see WithLocks).
+const (
+	LockModeAdvisory = "advisory" // saves succeed, with a warning
+	LockModeEnforce  = "enforce"  // saves are refused with 423 Locked
+)
+
+const (
+	methodLock   = "LOCK"
+	methodUnlock = "UNLOCK"
+
+	headerIf        = "If"
+	headerLockToken = "Lock-Token"
+	headerTimeout   = "Timeout"
+	headerWarning   = "Warning"
+
+	// defaultLockTimeout is how long a lock lasts if the client doesn't say,
+	// and maxLockTimeout the longest it can last without being refreshed.
+	defaultLockTimeout = 10 * time.Minute
+	maxLockTimeout     = time.Hour
+
+	// maxLockInfo bounds the size of a LOCK request's body.
+	maxLockInfo = 64 << 10
+)
+
+// LockStatus describes the WebDAV lock on the wiki, held by a client that is
+// editing it.
+type LockStatus struct {
+	Owner   string    `json:"owner,omitempty"` // as the client described its user, if it did
+	Client  string    `json:"client"`          // address of the client
+	Since   time.Time `json:"since"`
+	Expires time.Time `json:"expires"` // unless it is refreshed
+}
+
+// holder describes who holds the lock, for messages.
+func (l LockStatus) holder() string {
+	if l.Owner != "" {
+		return l.Owner + " (" + l.Client + ")"
+	}
+
+	return l.Client
+}
+
+// davLock is an exclusive WebDAV write lock on the wiki.
+type davLock struct {
+	LockStatus
+	token   string // identifies the lock to its holder, e.g. urn:uuid:...
+	timeout time.Duration
+}
+
+// lockInfo is the body of a LOCK request that takes a new lock.
+type lockInfo struct {
+	XMLName   xml.Name  `xml:"lockinfo"`
+	Exclusive *struct{} `xml:"lockscope>exclusive"`
+	Shared    *struct{} `xml:"lockscope>shared"`
+	Write     *struct{} `xml:"locktype>write"`
+	Owner     *struct {
+		InnerXML string `xml:",innerxml"`
+	} `xml:"owner"`
+}
+
+// activeLock returns the unexpired lock on the wiki, if any. The caller must
+// hold s.mu.
+func (s *Server) activeLock() *davLock {
+	if s.davLock != nil && time.Now().After(s.davLock.Expires) {
+		return nil
+	}
+
+	return s.davLock
+}
+
+// otherLock returns the lock on the wiki, if there is one and r doesn't
+// present its token in an If header.
+func (s *Server) otherLock(r *http.Request) *LockStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lock := s.activeLock()
+	if lock == nil || hasLockToken(r, lock.token) {
+		return nil
+	}
+	status := lock.LockStatus
+
+	return &status
+}
+
+// hasLockToken reports whether r presents token in its If header, e.g.
+// If: (<urn:uuid:...>).
+func hasLockToken(r *http.Request, token string) bool {
+	return strings.Contains(r.Header.Get(headerIf), "<"+token+">")
+}
+
+// handleLock takes a lock on the wiki, or refreshes the lock that r presents
+// the token of if it has no body, as RFC 4918 describes. Only exclusive write
+// locks are supported.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	if s.lockMode == "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxLockInfo))
+	if err != nil {
+		server.Logf(r.Context(), "failed to read lock request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	timeout := lockTimeout(r.Header.Get(headerTimeout))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock := s.activeLock()
+	if len(strings.TrimSpace(string(body))) == 0 {
+		if lock == nil || !hasLockToken(r, lock.token) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		lock.timeout = timeout
+		lock.Expires = time.Now().Add(timeout)
+		writeLockDiscovery(w, lock, http.StatusOK)
+		return
+	}
+
+	var info lockInfo
+	err = xml.Unmarshal(body, &info)
+	if err != nil || info.Write == nil || info.Exclusive == nil && info.Shared == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if info.Shared != nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+	if lock != nil {
+		server.Logf(r.Context(), "refusing lock from %s, wiki is locked by %s", s.clientAddr(r), lock.holder())
+		s.writeError(w, r, http.StatusLocked, "locked by "+lock.holder())
+		return
+	}
+	token := make([]byte, 16)
+	_, err = rand.Read(token)
+	if err != nil {
+		server.Logf(r.Context(), "failed to generate lock token: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	// Version 4 (random) UUID
+	token[6] = token[6]&0x0f | 0x40
+	token[8] = token[8]&0x3f | 0x80
+	hexToken := hex.EncodeToString(token)
+	now := time.Now()
+	lock = &davLock{
+		LockStatus: LockStatus{Client: s.clientAddr(r), Since: now, Expires: now.Add(timeout)},
+		token:      "urn:uuid:" + hexToken[:8] + "-" + hexToken[8:12] + "-" + hexToken[12:16] + "-" + hexToken[16:20] + "-" + hexToken[20:],
+		timeout:    timeout,
+	}
+	if info.Owner != nil {
+		lock.Owner = xmlText(info.Owner.InnerXML)
+	}
+	s.davLock = lock
+	server.Logf(r.Context(), "wiki locked by %s for %v", lock.holder(), timeout)
+	w.Header().Set(headerLockToken, "<"+lock.token+">")
+	writeLockDiscovery(w, lock, http.StatusOK)
+}
+
+// handleUnlock releases the lock whose token r presents in its Lock-Token
+// header.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	if s.lockMode == "" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(r.Header.Get(headerLockToken)), "<"), ">")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock := s.activeLock()
+	if lock == nil || token != lock.token {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	s.davLock = nil
+	server.Logf(r.Context(), "wiki unlocked by %s", lock.holder())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lockTimeout returns the first timeout in a Timeout header that putter
+// allows, e.g. 300 seconds for "Second-300, Infinite".
+func lockTimeout(header string) time.Duration {
+	for _, timeout := range strings.Split(header, ",") {
+		seconds, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(timeout), "Second-"), 10, 64)
+		if err == nil && seconds > 0 && seconds <= int64(maxLockTimeout/time.Second) {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+
+	return defaultLockTimeout
+}
+
+// xmlText returns the text within a fragment of XML, such as the owner of a
+// lock, which is usually a name or an href element.
+func xmlText(fragment string) string {
+	var text strings.Builder
+	d := xml.NewDecoder(strings.NewReader(fragment))
+	for {
+		token, err := d.Token()
+		if err != nil {
+			break
+		}
+		if data, ok := token.(xml.CharData); ok {
+			text.Write(data)
+		}
+	}
+
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+// writeLockDiscovery responds with the lockdiscovery property of the wiki,
+// describing lock.
+func writeLockDiscovery(w http.ResponseWriter, lock *davLock, status int) {
+	w.Header().Set(headerContentType, "application/xml; charset=utf-8")
+	w.WriteHeader(status)
+	owner := ""
+	if lock.Owner != "" {
+		owner = "<D:owner>" + html.EscapeString(lock.Owner) + "</D:owner>"
+	}
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?>
+<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock>
+<D:locktype><D:write/></D:locktype>
+<D:lockscope><D:exclusive/></D:lockscope>
+<D:depth>0</D:depth>%s
+<D:timeout>Second-%d</D:timeout>
+<D:locktoken><D:href>%s</D:href></D:locktoken>
+<D:lockroot><D:href>/</D:href></D:lockroot>
+</D:activelock></D:lockdiscovery></D:prop>
+`, owner, int64(lock.timeout/time.Second), lock.token)
+}
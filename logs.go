@@ -0,0 +1,54 @@
+package putter
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// logStreamBuffer is the number of log lines buffered for each reader of the
+// log stream before lines are dropped.
+const logStreamBuffer = 100
+
+// handleAdminLogs streams the recent log lines, then each new line, as
+// server-sent events until the client goes away.
+func (s *Server) handleAdminLogs(w http.ResponseWriter, r *http.Request) {
+	if s.logs == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, lines, unsubscribe := s.logs.Subscribe(logStreamBuffer)
+	defer unsubscribe()
+	w.Header().Set(headerContentType, "text/event-stream")
+	w.Header().Set(headerCacheControl, "no-store")
+	for _, line := range backlog {
+		_, err := fmt.Fprintf(w, "data: %s\n\n", line)
+		if err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	for {
+		select {
+		case line := <-lines:
+			_, err := fmt.Fprintf(w, "data: %s\n\n", line)
+			if err != nil {
+				// Logging this would only feed the stream another line
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package putter
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"  // for icon sizes
+	_ "image/jpeg" // for icon sizes
+	_ "image/png"  // for icon sizes
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/diff"
+	"github.com/djcrock/putter/internal/server"
+)
+
+const (
+	manifestPath     = "/manifest.webmanifest"
+	manifestIconPath = "/manifest-icon"
+	headerLink       = "Link"
+)
+
+// Tiddlers from which the manifest is generated.
+const (
+	tiddlerSiteTitle    = "$:/SiteTitle"
+	tiddlerSiteSubtitle = "$:/SiteSubtitle"
+	tiddlerFavicon      = "$:/favicon.ico"
+)
+
+// webManifest is a web app manifest, with which a browser installs the wiki
+// as an app.
+type webManifest struct {
+	Name        string         `json:"name"`
+	ShortName   string         `json:"short_name"`
+	Description string         `json:"description,omitempty"`
+	StartURL    string         `json:"start_url"`
+	Scope       string         `json:"scope"`
+	Display     string         `json:"display"`
+	Icons       []manifestIcon `json:"icons,omitempty"`
+}
+
+// manifestIcon is an icon listed in a web app manifest.
+type manifestIcon struct {
+	Src   string `json:"src"`
+	Type  string `json:"type"`
+	Sizes string `json:"sizes"`
+}
+
+// manifestCache is the manifest generated for a version of the wiki.
+type manifestCache struct {
+	mu       sync.Mutex // protects the following
+	etag     string     // ETag of the wiki the manifest was generated for
+	manifest []byte
+	icon     []byte // the wiki's favicon, if it has one
+	iconType string
+}
+
+// ManifestHandler returns a handler serving a web app manifest for the wiki,
+// at "/manifest.webmanifest", and the icon it names, at "/manifest-icon", so
+// that the wiki can be installed as an app on phones and tablets. The
+// manifest is named for the wiki's title and uses its favicon.
+func (s *Server) ManifestHandler() http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		s.refreshIfModified()
+		etag, manifest, icon, iconType, err := s.manifest()
+		if err != nil {
+			server.Logf(r.Context(), "failed to generate manifest: %v", err)
+			s.writeError(w, r, http.StatusInternalServerError, "")
+			return
+		}
+
+		var content []byte
+		switch r.URL.Path {
+		case manifestPath:
+			w.Header().Set(headerContentType, "application/manifest+json")
+			content = manifest
+		case manifestIconPath:
+			if icon == nil {
+				s.writeError(w, r, http.StatusNotFound, "The wiki has no favicon.")
+				return
+			}
+			w.Header().Set(headerContentType, iconType)
+			w.Header().Set(headerContentTypeOptions, "nosniff")
+			content = icon
+		default:
+			s.writeError(w, r, http.StatusNotFound, "")
+			return
+		}
+		// Both change with the wiki, so they are revalidated by its ETag
+		w.Header().Set(headerEtag, strings.TrimSuffix(etag, `"`)+"-"+strings.TrimPrefix(r.URL.Path, "/")+`"`)
+		w.Header().Set(headerCacheControl, "no-cache")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// manifest returns the manifest and icon for the live wiki, generating them
+// if it has changed since they were last generated.
+func (s *Server) manifest() (etag string, manifest, icon []byte, iconType string, err error) {
+	c := &s.manifestCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s.mu.RLock()
+	etag = s.etag
+	if etag == c.etag {
+		s.mu.RUnlock()
+		return etag, c.manifest, c.icon, c.iconType, nil
+	}
+	data := s.versions.find(etag, "")
+	if data == nil {
+		data, err = s.readFile(s.fileName)
+	}
+	s.mu.RUnlock()
+	if err == errTooLarge {
+		// Named after the file, without the wiki's title and icon
+		data, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	tiddlers := diff.Parse(data)
+	m := webManifest{
+		Name:        strings.TrimSpace(tiddlers[tiddlerSiteTitle]["text"]),
+		Description: strings.TrimSpace(tiddlers[tiddlerSiteSubtitle]["text"]),
+		StartURL:    s.wikiLink(),
+		Scope:       "./",
+		Display:     "standalone",
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(filepath.Base(s.fileName), filepath.Ext(s.fileName))
+	}
+	m.ShortName = m.Name
+	icon, iconType = favicon(tiddlers[tiddlerFavicon])
+	if icon != nil {
+		m.Icons = []manifestIcon{{
+			Src:   strings.TrimPrefix(manifestIconPath, "/"),
+			Type:  iconType,
+			Sizes: iconSizes(icon, iconType),
+		}}
+	}
+	manifest, err = json.Marshal(m)
+	if err != nil {
+		return
+	}
+	c.etag, c.manifest, c.icon, c.iconType = etag, manifest, icon, iconType
+
+	return
+}
+
+// favicon decodes the wiki's favicon tiddler, returning nil if there is none.
+// TiddlyWiki keeps images other than SVG base64-encoded.
+func favicon(tiddler diff.Tiddler) (icon []byte, iconType string) {
+	text := tiddler["text"]
+	if text == "" {
+		return
+	}
+	iconType = tiddler["type"]
+	if iconType == "" {
+		iconType = "image/x-icon"
+	}
+	if iconType == "image/svg+xml" {
+		return []byte(text), iconType
+	}
+	icon, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	if err != nil {
+		return nil, ""
+	}
+
+	return icon, iconType
+}
+
+// iconSizes lists the sizes of an icon as a manifest does, such as "32x32" or
+// "16x16 32x32" for an ICO holding two images, or "any" for SVG and images
+// whose size can't be told.
+func iconSizes(icon []byte, iconType string) string {
+	if iconType == "image/x-icon" || iconType == "image/vnd.microsoft.icon" {
+		// ICONDIR, then a 16-byte ICONDIRENTRY per image, starting with its
+		// width and height, where 0 means 256
+		if len(icon) < 6 || binary.LittleEndian.Uint16(icon[2:]) != 1 {
+			return "any"
+		}
+		var sizes []string
+		count := int(binary.LittleEndian.Uint16(icon[4:]))
+		for i := 0; i < count && 6+16*(i+1) <= len(icon); i++ {
+			width, height := int(icon[6+16*i]), int(icon[6+16*i+1])
+			if width == 0 {
+				width = 256
+			}
+			if height == 0 {
+				height = 256
+			}
+			sizes = append(sizes, fmt.Sprintf("%dx%d", width, height))
+		}
+		if len(sizes) == 0 {
+			return "any"
+		}
+		return strings.Join(sizes, " ")
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(icon))
+	if err != nil {
+		return "any"
+	}
+
+	return fmt.Sprintf("%dx%d", config.Width, config.Height)
+}
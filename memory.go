@@ -0,0 +1,99 @@
+package putter
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/djcrock/putter/internal/compress"
+)
+
+// memoryPerByte is roughly how much memory loading a version of the wiki
+// whole takes per byte of it, once it is parsed into tiddlers and compared
+// with another version.
+const memoryPerByte = 4
+
+// Bounds on the buffer through which uploads are copied to disk.
+const (
+	minCopyBuffer = 32 << 10
+	maxCopyBuffer = 1 << 20
+)
+
+// errTooLarge is returned when loading a version of the wiki whole would
+// take more memory than WithMaxMemory allows.
+var errTooLarge = errors.New("the wiki is too large to load within the memory limit")
+
+// memoryFile is a version of the wiki kept in memory, opened like a file.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+// Close does nothing.
+func (memoryFile) Close() error {
+	return nil
+}
+
+// loadLimit returns the size of the largest version of the wiki that may be
+// loaded whole, or 0 for any.
+func (s *Server) loadLimit() int64 {
+	return int64(s.maxMemory) / memoryPerByte
+}
+
+// readAll reads everything from r, or returns errTooLarge without reading
+// more than loadLimit if there is too much.
+func (s *Server) readAll(r io.Reader) (data []byte, err error) {
+	limit := s.loadLimit()
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	data, err = ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err == nil && int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+
+	return
+}
+
+// readFile reads the named file, subject to loadLimit like readAll.
+func (s *Server) readFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return s.readAll(f)
+}
+
+// compressProcs returns how many blocks of the wiki may be gzipped at once,
+// given a quarter of the memory limit, or 0 for one per core.
+func (s *Server) compressProcs() int {
+	if s.maxMemory <= 0 {
+		return 0
+	}
+	procs := int(int64(s.maxMemory) / 4 / compress.BlockMemory)
+	if procs < 1 {
+		procs = 1
+	}
+
+	return procs
+}
+
+// copyBuffer returns a buffer through which to copy an upload to disk, larger
+// the more memory there is to spare, or nil for io.Copy's default.
+func (s *Server) copyBuffer() []byte {
+	if s.maxMemory <= 0 {
+		return nil
+	}
+	size := int64(s.maxMemory) / 1024
+	if size < minCopyBuffer {
+		size = minCopyBuffer
+	}
+	if size > maxCopyBuffer {
+		size = maxCopyBuffer
+	}
+
+	return make([]byte, size)
+}
@@ -0,0 +1,206 @@
+package putter
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// ErrMirror is returned when changing a wiki that mirrors another.
+var ErrMirror = errors.New("wiki is a read-only mirror")
+
+// mirrorTimeout bounds how long fetching the wiki from upstream may take.
+const mirrorTimeout = 10 * time.Minute
+
+// MirrorStatus describes how up to date a mirror is.
+type MirrorStatus struct {
+	Upstream   string    `json:"upstream"`        // without any credentials
+	LastCheck  time.Time `json:"lastCheck"`       // zero if it hasn't been checked yet
+	LastChange time.Time `json:"lastChange"`      // zero if it hasn't changed since startup
+	Error      string    `json:"error,omitempty"` // why the last check failed, if it did
+}
+
+// mirror is the state of a mirror of an upstream wiki.
+type mirror struct {
+	upstream *url.URL
+	interval time.Duration
+	client   *http.Client
+	etag     string // upstream ETag of the wiki last fetched
+	status   MirrorStatus
+}
+
+// redactURL returns u as a string without its password.
+func redactURL(u *url.URL) string {
+	redacted := *u
+	if redacted.User != nil {
+		redacted.User = url.User(redacted.User.Username())
+	}
+
+	return redacted.String()
+}
+
+// mirrorLoop brings the mirror up to date every interval until stop is
+// closed.
+func (s *Server) mirrorLoop(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		_, err := s.SyncMirror(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// Only log changes in the upstream's health, rather than every
+		// failure while it is down
+		s.mu.Lock()
+		previous := s.mirror.status.Error
+		s.mirror.status.Error = ""
+		if err != nil {
+			s.mirror.status.Error = err.Error()
+		}
+		s.mu.Unlock()
+		switch {
+		case err != nil && previous == "":
+			log.Printf("failed to sync mirror: %v", err)
+		case err == nil && previous != "":
+			log.Printf("mirror synced again")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.mirror.interval):
+		}
+	}
+}
+
+// SyncMirror fetches the wiki from the upstream given to WithMirror if it
+// has changed since it was last fetched, replacing the local wiki with it,
+// and reports whether it had. This happens periodically anyway.
+func (s *Server) SyncMirror(ctx context.Context) (changed bool, err error) {
+	if s.mirror == nil {
+		return false, errors.New("mirroring is disabled")
+	}
+	s.mu.RLock()
+	previousETag := s.etag
+	upstreamETag := s.mirror.etag
+	s.mu.RUnlock()
+
+	upload, etag, upstreamETag, err := s.fetchMirror(ctx, upstreamETag)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mirror.status.LastCheck = time.Now()
+	if err != nil || upload == "" {
+		return
+	}
+	defer os.Remove(upload)
+	s.mirror.etag = upstreamETag
+	if etag == s.etag {
+		return
+	}
+
+	err = s.replaceWiki(ctx, upload, etag)
+	if err != nil {
+		return
+	}
+	s.mirror.status.LastChange = s.mirror.status.LastCheck
+	log.Printf("mirrored wiki from %s", s.mirror.status.Upstream)
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		ETag:         s.etag,
+		PreviousETag: previousETag,
+		Size:         s.fileInfo.Size(),
+	})
+
+	return true, nil
+}
+
+// fetchMirror downloads the wiki from upstream next to the local wiki unless
+// its ETag is still upstreamETag, returning the name of the download (empty
+// if it was unchanged), its ETag as the local wiki, and its ETag upstream.
+func (s *Server) fetchMirror(ctx context.Context, upstreamETag string) (upload, etag, newUpstreamETag string, err error) {
+	req, err := http.NewRequest(http.MethodGet, s.mirror.upstream.String(), nil)
+	if err != nil {
+		return
+	}
+	req = req.WithContext(ctx)
+	if upstreamETag != "" {
+		req.Header.Set(headerIfNoneMatch, upstreamETag)
+	}
+	res, err := s.mirror.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return "", "", upstreamETag, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", "", "", fmt.Errorf("upstream responded %s", res.Status)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.fileName), s.uploadPrefix()+"*")
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	defer f.Close()
+	hash := s.newHash()
+	written, err := io.Copy(io.MultiWriter(f, hash), storage.ContextReader{Ctx: ctx, R: res.Body})
+	if err != nil {
+		return
+	}
+	if written == 0 {
+		return "", "", "", errors.New("upstream sent an empty wiki")
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	return f.Name(), "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", res.Header.Get(headerEtag), nil
+}
+
+// initMirror fetches the wiki from upstream if there is no local copy yet,
+// nor a backup of one to recover.
+func (s *Server) initMirror() (err error) {
+	for _, name := range []string{s.fileName, s.fileName + extensionBackup} {
+		_, err = os.Stat(name)
+		if !os.IsNotExist(err) {
+			return
+		}
+	}
+	log.Printf("fetching wiki from %s...", s.mirror.status.Upstream)
+	upload, _, upstreamETag, err := s.fetchMirror(context.Background(), "")
+	if err != nil {
+		return
+	}
+	err = os.Rename(upload, s.fileName)
+	if err != nil {
+		os.Remove(upload)
+		return
+	}
+	s.mirror.etag = upstreamETag
+	s.mirror.status.LastCheck = time.Now()
+	s.mirror.status.LastChange = s.mirror.status.LastCheck
+
+	return os.Chmod(s.fileName, s.fileMode)
+}
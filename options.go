@@ -0,0 +1,488 @@
+package putter
+
+import (
+	"hash"
+	"os"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/trash"
+)
+
+// Option configures a Server created by NewServer.
+type Option func(*Server)
+
+// WithArchive preserves the previous version of the wiki in dir on each save,
+// naming archives by formatting the time of the save with format, a Go time
+// layout or a preset (see ArchiveFormatISO8601, etc.). A warning is logged if
+// format could give two archives the same name.
+func WithArchive(dir, format string) Option {
+	return func(s *Server) {
+		s.isArchive = true
+		s.archiver.Dir = dir
+		s.archiver.Format = format
+	}
+}
+
+// WithArchiveMode sets how archives are written (see ArchiveModeAuto, etc.).
+// By default archives are copied.
+func WithArchiveMode(mode string) Option {
+	return func(s *Server) {
+		s.archiver.Mode = mode
+	}
+}
+
+// WithArchiveLimits logs warnings once the archive directory grows past warn
+// bytes and stops archiving past max bytes. Zero disables either limit.
+func WithArchiveLimits(warn, max ByteSize) Option {
+	return func(s *Server) {
+		s.archiver.WarnSize = warn
+		s.archiver.MaxSize = max
+	}
+}
+
+// WithArchiveTimezone names archives for the time in loc, e.g. time.Local,
+// rather than UTC.
+func WithArchiveTimezone(loc *time.Location) Option {
+	return func(s *Server) {
+		s.archiveLocation = loc
+	}
+}
+
+// WithArchiveThreshold only archives the wiki once at least percent percent
+// of it has changed since the newest archive, counting the tiddlers added,
+// removed, or changed, so that autosaves that change little don't each add a
+// version. The live wiki is always saved, and is always archived before a
+// restore. Zero archives every version.
+func WithArchiveThreshold(percent float64) Option {
+	return func(s *Server) {
+		s.archiveThreshold = percent
+	}
+}
+
+// WithArchiveSequence begins archive names with a number that increases with
+// each archive, e.g. 000042_2024-05-01-13-45-06.000.html, so that archives
+// are ordered correctly and never collide even if the clock is changed. The
+// last number is kept in a .sequence file in the archive directory.
+func WithArchiveSequence() Option {
+	return func(s *Server) {
+		s.archiver.Sequence = true
+	}
+}
+
+// WithArchiveRecompression recompresses archives older than age every night
+// with algorithm (see RecompressXz, etc.), trading CPU time for storage while
+// keeping recent archives quick to open. Recompressed archives are still
+// browsed, compared, and restored as before.
+func WithArchiveRecompression(algorithm string, age time.Duration) Option {
+	return func(s *Server) {
+		s.recompressAlgo = algorithm
+		s.recompressAge = age
+	}
+}
+
+// WithArchiveRetention prunes the archive when the server starts and after
+// each archive is written, keeping only the newest count archives (0 keeps
+// any number) and those younger than age (0 keeps them for ever). With thin,
+// older archives are also thinned: every archive of the last day is kept,
+// only the newest of each day for the month before that, and only the newest
+// of each month beyond that. Pruned archives are moved to the trash given to
+// WithTrash, if any.
+func WithArchiveRetention(count int, age time.Duration, thin bool) Option {
+	return func(s *Server) {
+		s.retention = archive.Retention{Count: count, Age: age, Thin: thin}
+	}
+}
+
+// WithArchiveCompression compresses archives with algorithm (see
+// RecompressZstd, etc.) as they are written, instead of archiving the wiki as
+// it is with the mode given to WithArchiveMode. Compressed archives are
+// browsed, compared, and restored as before, and zstd archives are sent as
+// they are to clients that accept the zstd content coding.
+func WithArchiveCompression(algorithm string) Option {
+	return func(s *Server) {
+		s.archiver.Compression = algorithm
+	}
+}
+
+// WithArchiveExternal also archives the wiki when it is modified outside of
+// the server. It only has an effect alongside WithWatch.
+func WithArchiveExternal() Option {
+	return func(s *Server) {
+		s.isArchiveExternal = true
+	}
+}
+
+// WithCompression serves a gzipped copy of the wiki to clients that accept it,
+// compressed at the given level once per save and stored alongside the wiki.
+// Archived versions are gzipped on the fly.
+func WithCompression(level int) Option {
+	return func(s *Server) {
+		s.isCompress = true
+		s.isCompressCache = true
+		s.compressLevel = level
+	}
+}
+
+// WithDynamicCompression is like WithCompression, but compresses the wiki
+// every time it is served rather than storing a compressed copy on disk.
+func WithDynamicCompression(level int) Option {
+	return func(s *Server) {
+		s.isCompress = true
+		s.isCompressCache = false
+		s.compressLevel = level
+	}
+}
+
+// WithContentType serves the wiki and its archived versions with the given
+// Content-Type, e.g. "text/html; charset=windows-1252", rather than the one
+// their file extension implies.
+func WithContentType(contentType string) Option {
+	return func(s *Server) {
+		s.contentType = contentType
+	}
+}
+
+// WithCacheControl sends a Cache-Control header with the given policy with the
+// live wiki and with archived versions, which never change. An empty policy
+// sends no header, leaving caching up to clients and proxies.
+func WithCacheControl(wiki, archive string) Option {
+	return func(s *Server) {
+		s.cacheControl = wiki
+		s.archiveCacheControl = archive
+	}
+}
+
+// WithStatic serves the files in dir at path (e.g. "/static/"), such as
+// stylesheets, exported PDFs, or images that the wiki links to, with GET and
+// HEAD only. Hidden files aren't served, nor are directory listings.
+func WithStatic(dir, path string) Option {
+	return func(s *Server) {
+		s.staticDir = dir
+		s.staticPath = path
+	}
+}
+
+// WithLocks supports WebDAV LOCK and UNLOCK requests on the wiki, with which
+// clients can show that someone is editing it. mode (see LockModeAdvisory,
+// etc.) decides whether saves from other clients meanwhile are refused or
+// only warned about.
+func WithLocks(mode string) Option {
+	return func(s *Server) {
+		s.lockMode = mode
+	}
+}
+
+// WithVersionCache keeps the most recent versions of the wiki in memory, up
+// to budget bytes, so that restoring or comparing them doesn't read the
+// archive and conflicting saves are told which tiddlers changed meanwhile.
+func WithVersionCache(budget ByteSize) Option {
+	return func(s *Server) {
+		s.versions.budget = int64(budget)
+	}
+}
+
+// WithStallTimeout abandons uploads that have been received at no more than
+// rate bytes per second (0 meaning nothing at all) for timeout, responding
+// with 408 Request Timeout and closing the connection.
+func WithStallTimeout(timeout time.Duration, rate ByteSize) Option {
+	return func(s *Server) {
+		s.stallTimeout = timeout
+		s.stallRate = rate
+	}
+}
+
+// WithAnonymizedClients anonymizes the addresses of clients wherever they
+// are logged or shown, such as the log, the admin dashboard, and the status,
+// by truncating them to their network or replacing them with pseudonyms
+// (see AnonymizeTruncate, etc.). Requests themselves keep their addresses.
+func WithAnonymizedClients(mode string) Option {
+	return func(s *Server) {
+		s.anonymizeMode = mode
+	}
+}
+
+// WithMirror makes the wiki a read-only mirror of the wiki served at
+// upstream by another putter, fetched from it at startup if there is no local
+// copy and whenever it changes, checked every interval with its ETag. Saves
+// and restores are refused.
+func WithMirror(upstream string, interval time.Duration) Option {
+	return func(s *Server) {
+		s.mirrorUpstream = upstream
+		s.mirrorInterval = interval
+	}
+}
+
+// WithGit commits the wiki to a git repository after each save, with the
+// time and the client's address in the message and the user as the author if
+// the client authenticated, creating a repository in the wiki's directory if
+// it isn't in one already. Only the wiki is committed. Commits are made in
+// the background, so saves never wait for git, and saves made faster than
+// git commits them share a commit. If remote (the name or URL of a
+// repository) isn't empty, new commits are pushed to it. This can be used
+// instead of, or as well as, the archive.
+func WithGit(remote string) Option {
+	return func(s *Server) {
+		s.isGit = true
+		s.gitRemote = remote
+	}
+}
+
+// WithWikiFolder renders the wiki from dir, a TiddlyWiki wiki folder as used
+// by TiddlyWiki on Node.js (a tiddlywiki.info file and a tiddlers directory),
+// with command, the tiddlywiki command and any arguments preceding the folder
+// (DefaultFolderCommand if empty). It is rendered at startup if it has changed
+// since it was last rendered and whenever its files change, and saves are
+// split back into its tiddler files.
+func WithWikiFolder(dir, command string) Option {
+	return func(s *Server) {
+		s.folderDir = dir
+		s.folderCommand = command
+	}
+}
+
+// WithUpgradeSource sets the URL from which the latest TiddlyWiki release is
+// fetched when the wiki is upgraded from the admin dashboard, an empty wiki
+// such as DefaultUpgradeSource.
+func WithUpgradeSource(url string) Option {
+	return func(s *Server) {
+		s.upgradeSource = url
+	}
+}
+
+// WithSync serves the sync protocol at /sync/ to peers that present secret,
+// and, if peer (the URL of a wiki served by another putter with the same
+// secret) isn't empty, syncs with it every interval (see Server.Sync).
+// Archiving must be enabled, since the archive is what is replicated.
+func WithSync(peer, secret string, interval time.Duration) Option {
+	return func(s *Server) {
+		s.syncPeer = peer
+		s.syncSecret = secret
+		s.syncInterval = interval
+	}
+}
+
+// WithETagAlgorithm sets the hash used to compute ETags. The default is MD5.
+func WithETagAlgorithm(newHash func() hash.Hash) Option {
+	return func(s *Server) {
+		s.newHash = newHash
+	}
+}
+
+// WithWeakETags compares the ETag in a save's If-Match header with the wiki's
+// weakly, ignoring a W/ prefix and anything a proxy appended after a dash, so
+// that saves through proxies and CDNs that change the ETags of the responses
+// they compress don't all fail with 412 Precondition Failed.
+func WithWeakETags() Option {
+	return func(s *Server) {
+		s.isWeakETags = true
+	}
+}
+
+// WithVerification re-reads the wiki after each save and checks that it
+// matches the upload before reporting success, rolling the save back (and
+// emitting EventVerificationFailed) if it doesn't, to catch storage that
+// silently corrupts data. With compressed, the compressed copy kept by
+// WithCompression is checked too.
+func WithVerification(compressed bool) Option {
+	return func(s *Server) {
+		s.isVerify = true
+		s.isVerifyCompressed = compressed
+	}
+}
+
+// WithETagCache caches the wiki's ETag on disk so that it doesn't need to be
+// rehashed at startup.
+func WithETagCache() Option {
+	return func(s *Server) {
+		s.isEtagCache = true
+	}
+}
+
+// WithWatch detects modifications made to the wiki outside of the server and
+// refreshes the ETag and compressed copy accordingly.
+func WithWatch() Option {
+	return func(s *Server) {
+		s.isWatch = true
+	}
+}
+
+// WithFileModes sets the permissions of created files and directories.
+// The defaults are 0644 and 0755.
+func WithFileModes(file, dir os.FileMode) Option {
+	return func(s *Server) {
+		s.fileMode = file
+		s.dirMode = dir
+	}
+}
+
+// WithReadOnlyRetry refuses saves for the given duration after a storage
+// failure, rather than letting them repeatedly half-fail.
+func WithReadOnlyRetry(d time.Duration) Option {
+	return func(s *Server) {
+		s.readOnlyRetry = d
+	}
+}
+
+// WithAdmin serves the admin dashboard and upload form, protected by HTTP
+// basic authentication with the given user name and password.
+func WithAdmin(user, password string) Option {
+	return func(s *Server) {
+		s.adminUser = user
+		s.adminPassword = password
+	}
+}
+
+// WithErrorPages renders error responses to browsers with the html/template
+// files in dir, named for the status they are used for (e.g. "412.html"),
+// with "error.html" used for any other status. Built-in pages are used for
+// statuses without a template.
+func WithErrorPages(dir string) Option {
+	return func(s *Server) {
+		s.errorPagesDir = dir
+	}
+}
+
+// WithStatus serves the server's status as JSON at "/status".
+func WithStatus() Option {
+	return func(s *Server) {
+		s.isStatus = true
+	}
+}
+
+// WithCompanion serves companion, a server for another file used alongside
+// the wiki, such as tiddlywiki.info, a stylesheet, or a JSON settings file, at
+// its file's base name (see CompanionPath), so that it is saved with the
+// same ETag checks, archiving, and journaling as the wiki. It is served behind
+// the wiki's middleware, but is configured and closed on its own.
+func WithCompanion(companion *Server) Option {
+	return func(s *Server) {
+		s.companions = append(s.companions, companion)
+	}
+}
+
+// WithManifest serves a web app manifest for the wiki at
+// "/manifest.webmanifest", generated from its title and favicon, so that it
+// can be installed as an app (see Server.ManifestHandler).
+func WithManifest() Option {
+	return func(s *Server) {
+		s.isManifest = true
+	}
+}
+
+// WithShrinkLimit holds saves that would shrink the wiki by more than percent
+// percent for approval (see Server.ApproveHeld), unless they are confirmed
+// with the X-Putter-Confirm-Shrink header, as a sudden shrink almost always
+// means a damaged or blank save. Zero disables the limit.
+func WithShrinkLimit(percent int) Option {
+	return func(s *Server) {
+		s.shrinkLimit = percent
+	}
+}
+
+// WithTrash moves files that would otherwise be deleted into dir, where they
+// are kept for retention (or for ever if it is zero) and can be recovered
+// (see Server.RecoverTrash): pruned archives, uploads that weren't saved
+// (e.g. because they conflicted), and discarded held saves.
+func WithTrash(dir string, retention time.Duration) Option {
+	return func(s *Server) {
+		s.trash = &trash.Trash{Dir: dir, Retention: retention}
+	}
+}
+
+// WithQuota limits the storage that the wiki may use, its live file plus its
+// archived versions, to limit bytes, refusing saves that would take it over
+// with 507 Insufficient Storage. When several wikis share a host, each can
+// be given a quota so that none can starve the others. Zero is unlimited.
+func WithQuota(limit ByteSize) Option {
+	return func(s *Server) {
+		s.quota = limit
+	}
+}
+
+// WithStats keeps a history of saves, their sizes and durations, and
+// conflicts alongside the wiki, serving it as JSON at "/stats" and charting it
+// on the admin dashboard.
+func WithStats() Option {
+	return func(s *Server) {
+		s.isStats = true
+	}
+}
+
+// WithUploadLimiter limits how many uploads are received at once with
+// limiter, which may be shared by several servers to limit them all
+// together.
+func WithUploadLimiter(limiter *UploadLimiter) Option {
+	return func(s *Server) {
+		s.uploads = limiter
+	}
+}
+
+// WithWikiPath makes NewHandler serve the wiki at path rather than "/", so
+// that it can share a host with other handlers. If redirect is set, "/"
+// redirects to the wiki; otherwise it is not found, like any path that
+// putter doesn't serve.
+func WithWikiPath(path string, redirect bool) Option {
+	return func(s *Server) {
+		s.wikiPath = path
+		s.isWikiRedirect = redirect
+	}
+}
+
+// WithWikiAliases makes NewHandler answer for the wiki at the given paths as
+// well, such as "/index.html" for bookmarks and savers that name the file:
+// browsers are redirected to the wiki with 301 Moved Permanently, and other
+// requests, such as saves, are served as if made to the wiki.
+func WithWikiAliases(paths ...string) Option {
+	return func(s *Server) {
+		s.wikiAliases = append(s.wikiAliases, paths...)
+	}
+}
+
+// WithMaxMemory keeps the server's memory use within about limit, however
+// large the wiki: gzipping uses fewer cores, fewer versions are kept in
+// memory, and what needs a version of the wiki loaded whole, like comparing
+// versions, declines wikis larger than a quarter of limit. Uploads are copied
+// to disk through larger buffers the more memory there is.
+func WithMaxMemory(limit ByteSize) Option {
+	return func(s *Server) {
+		s.maxMemory = limit
+	}
+}
+
+// WithResumableUploads accepts resumable uploads of the wiki with the tus
+// protocol, abandoning any not completed within expiry of being created.
+func WithResumableUploads(expiry time.Duration) Option {
+	return func(s *Server) {
+		s.resumable = &resumable{expiry: expiry, busy: make(map[string]bool)}
+	}
+}
+
+// WithInjection inserts html, such as a script or a banner, into the wiki as
+// it is served, before its closing body tag, leaving the file and its ETag as
+// they are. TiddlyWiki 5 builds what it saves from its tiddlers, so never
+// saves html back.
+func WithInjection(html []byte) Option {
+	return func(s *Server) {
+		s.injection = html
+	}
+}
+
+// WithTimeTravel serves past versions of the wiki, read-only and sandboxed
+// like the archive, at its own URL with a version query parameter: a time
+// such as "/?version=2024-05-07T13:45" gets the version that was live then,
+// and an ETag the version that had it. OPTIONS requests for them leave out
+// the Dav header, so that TiddlyWiki doesn't offer to save them.
+func WithTimeTravel() Option {
+	return func(s *Server) {
+		s.isTimeTravel = true
+	}
+}
+
+// WithLogs shows the lines held by logs on the admin dashboard, streaming new
+// lines as they are written.
+func WithLogs(logs *LogBuffer) Option {
+	return func(s *Server) {
+		s.logs = logs
+	}
+}
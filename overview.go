@@ -0,0 +1,159 @@
+package putter
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// NewMultiHandler returns a handler serving each of the wikis as NewHandler
+// would, under "/<name>/", with an overview of them all at "/admin/"
+// protected by the given credentials. The overview is not served if
+// adminPassword is empty, and no wiki may be named "admin".
+func NewMultiHandler(wikis map[string]*Server, archivePath, adminUser, adminPassword string) http.Handler {
+	mux := http.NewServeMux()
+	for name, s := range wikis {
+		mux.Handle("/"+name+"/", http.StripPrefix("/"+name, NewHandler(s, archivePath)))
+	}
+	if adminPassword != "" {
+		overview := OverviewHandler(wikis)
+		mux.Handle(adminPath, http.StripPrefix(strings.TrimSuffix(adminPath, "/"), requireCredentials(adminUser, adminPassword, overview)))
+	}
+
+	return server.RequestID(server.Recover(mux, nil))
+}
+
+// OverviewHandler returns a handler serving a table of the wikis, by name,
+// with their size, last save, archive usage, and health, linking to each
+// wiki's own admin dashboard. It assumes that the wikis are served as
+// NewMultiHandler serves them, alongside the overview's mount point, and
+// leaves authentication to the caller.
+func OverviewHandler(wikis map[string]*Server) http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var rows []overviewRow
+		for name, s := range wikis {
+			status := s.Status()
+			base := "../" + url.PathEscape(name) + "/"
+			row := overviewRow{
+				Name:   name,
+				Link:   base + strings.TrimPrefix(s.wikiLink(), "./"),
+				Status: status,
+			}
+			row.Health, row.Healthy = health(status)
+			if status.Features.Admin {
+				row.AdminLink = base + strings.TrimPrefix(adminPath, "/")
+			}
+			rows = append(rows, row)
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+		err := overviewTemplate.Execute(w, rows)
+		if err != nil {
+			log.Printf("failed to render overview: %v", err)
+		}
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// overviewRow is a wiki in the table passed to overviewTemplate.
+type overviewRow struct {
+	Name      string
+	Link      string // relative URL of the wiki
+	AdminLink string // relative URL of the wiki's admin dashboard, if it is served
+	Status    Status
+	Health    string
+	Healthy   bool
+}
+
+// health summarizes the status, reporting whether anything needs attention.
+func health(status Status) (message string, ok bool) {
+	switch {
+	case status.ReadOnly:
+		return "read-only: " + status.ReadOnlyErr, false
+	case status.Maintenance:
+		return "maintenance mode", false
+	case status.Held:
+		return "save held for approval", false
+	case status.Archive != nil && status.Archive.Error != "":
+		return "archive unavailable: " + status.Archive.Error, false
+	case status.Quota != nil && status.Quota.Used >= status.Quota.Limit:
+		return "out of storage quota", false
+	case status.Quota != nil && status.Quota.IsNearlyFull():
+		return fmt.Sprintf("%.0f%% of storage quota used", status.Quota.Percent()), false
+	case status.Panics == 1:
+		return "1 request panicked, see the log", false
+	case status.Panics > 1:
+		return fmt.Sprintf("%d requests panicked, see the log", status.Panics), false
+	case status.RecentErrors == 1:
+		return "1 failed save", false
+	case status.RecentErrors > 1:
+		return fmt.Sprintf("%d failed saves", status.RecentErrors), false
+	}
+
+	return "ok", true
+}
+
+var overviewTemplate = template.Must(template.New("overview").Funcs(template.FuncMap{
+	"byteSize": func(size int64) ByteSize { return ByteSize(size) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 1em auto; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.2em 0.5em; }
+tr:nth-child(even) { background: #f4f4f4; }
+.size { text-align: right; }
+.warning { color: #a00; }
+</style>
+</head>
+<body>
+<h1>putter admin</h1>
+{{if .}}
+<table>
+<tr>
+<th>Wiki</th>
+<th class="size">Size</th>
+<th>Last save</th>
+<th class="size">Archive</th>
+<th class="size">Quota</th>
+<th>Health</th>
+<th></th>
+</tr>
+{{range .}}<tr>
+<td><a href="{{.Link}}">{{.Name}}</a></td>
+<td class="size">{{byteSize .Status.Size}}</td>
+<td>{{with .Status.LastSave}}{{.Format "2006-01-02 15:04:05"}}{{else}}none since startup{{end}}</td>
+<td class="size">{{with .Status.Archive}}{{.Count}} versions, {{byteSize .Size}}{{else}}disabled{{end}}</td>
+<td class="size">{{with .Status.Quota}}{{printf "%.0f" .Percent}}% of {{byteSize .Limit}}{{else}}none{{end}}</td>
+<td{{if not .Healthy}} class="warning"{{end}}>{{.Health}}</td>
+<td>{{if .AdminLink}}<a href="{{.AdminLink}}">details</a>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No wikis are being served.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -1,159 +1,670 @@
-// Simple HTTP server for the TiddlyWiki PUT saver
-
-package main
+// Package putter implements a simple HTTP server for the TiddlyWiki PUT saver.
+// The server can be embedded in other Go applications via NewHandler.
+package putter
 
 import (
-	"compress/gzip"
+	"context"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"flag"
+	"errors"
+	"fmt"
 	"hash"
+	"html/template"
 	"io"
 	"io/ioutil"
 	"log"
-	"net"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/compress"
+	"github.com/djcrock/putter/internal/config"
+	"github.com/djcrock/putter/internal/folder"
+	"github.com/djcrock/putter/internal/logbuf"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+	"github.com/djcrock/putter/internal/trash"
 )
 
 const (
-	headerAcceptEncoding  = "Accept-Encoding"
-	headerContentEncoding = "Content-Encoding"
-	headerContentLength   = "Content-Length"
-	headerDav             = "Dav"
-	headerEtag            = "ETag"
-	headerIfMatch         = "If-Match"
+	headerAcceptEncoding        = "Accept-Encoding"
+	headerContentEncoding       = "Content-Encoding"
+	headerContentLength         = "Content-Length"
+	headerDav                   = "Dav"
+	headerEtag                  = "ETag"
+	headerIfMatch               = "If-Match"
+	headerIfNoneMatch           = "If-None-Match"
+	headerIfModifiedSince       = "If-Modified-Since"
+	headerContentType           = "Content-Type"
+	headerLastModified          = "Last-Modified"
+	headerVary                  = "Vary"
+	headerSha256                = "X-Putter-SHA256"
+	headerRetryAfter            = "Retry-After"
+	headerAccept                = "Accept"
+	headerContentTypeOptions    = "X-Content-Type-Options"
+	headerLocation              = "Location"
+	headerOrigin                = "Origin"
+	headerWwwAuthenticate       = "WWW-Authenticate"
+	headerContentSecurityPolicy = "Content-Security-Policy"
+	headerContentDisposition    = "Content-Disposition"
+	headerCacheControl          = "Cache-Control"
+	headerDryRun                = "X-Putter-Dry-Run"
 
-	encodingGzip = "gzip"
+	extensionEtag   = ".etag"
+	extensionBackup = ".bak"
+	extensionLock   = ".lock"
 
-	extensionGzip = ".gz"
+	uploadPrefix = "tiddlywiki-upload-"
 )
 
-func main() {
-	bind := flag.String("bind", "127.0.0.1", "interface to which the server will bind")
-	port := flag.Int("port", 8080, "port on which the server will listen")
-	wiki := flag.String("wiki", "index.html", "wiki file to serve")
-	archive := flag.Bool("archive", true, "whether wiki edit history should be preserved in --archive-dir")
-	archiveDir := flag.String("archive-dir", "old", "directory in which edit history will be preserved")
-	archiveFormat := flag.String("archive-format", "2006-01-02-15-04-05.000.html", "format of archive filenames")
-	serveArchive := flag.Bool("serve-archive", true, "whether wiki edit history should be served over HTTP at --archive-path")
-	archivePath := flag.String("archive-path", "/old/", "path at which edit history will be served over HTTP")
-	compress := flag.Bool("compress", true, "whether a gzipped version of the wiki should also be served")
-	flag.Parse()
+// ErrLocked is returned by NewServer when the wiki is already being served.
+var ErrLocked = errors.New("wiki is locked by another process")
 
-	ip := net.ParseIP(*bind)
-	if ip == nil {
-		log.Fatal("invalid IP address provided to --bind")
-	}
+// Archive modes control how the previous version of the wiki is written to the
+// archive directory on each save.
+const (
+	ArchiveModeAuto    = archive.ModeAuto    // reflink where supported, otherwise copy
+	ArchiveModeCopy    = archive.ModeCopy    // always copy
+	ArchiveModeLink    = archive.ModeLink    // hard link, falling back to copy
+	ArchiveModeReflink = archive.ModeReflink // reflink, falling back to copy
+)
 
-	addr := ip.String() + ":" + strconv.Itoa(*port)
+// Archive format presets can be given to WithArchive instead of a Go time
+// layout.
+const (
+	ArchiveFormatISO8601   = archive.PresetISO8601   // e.g. 20240501T134506.000Z.html
+	ArchiveFormatRFC3339   = archive.PresetRFC3339   // e.g. 2024-05-01T13-45-06.000Z.html
+	ArchiveFormatUnixEpoch = archive.PresetUnixEpoch // e.g. 1714571106000.html
+	ArchiveFormatHuman     = archive.PresetHuman     // e.g. 1 May 2024 13.45.06.000.html
+)
 
-	s := newServer(
-		*wiki,
-		*archiveDir,
-		*archiveFormat,
-		*archive,
-		*compress,
-	)
-	http.Handle("/", s)
-	log.Printf("serving wiki \"%s\" at http://%s/", *wiki, addr)
+// ByteSize is a size in bytes that can be set from a flag using binary unit
+// suffixes, e.g. "512", "64K", "1.5GB", or "2GiB".
+type ByteSize = config.ByteSize
 
-	if *archive && *serveArchive {
-		path := fixPath(*archivePath)
-		dir := http.FileServer(http.Dir(*archiveDir))
-		// TiddlyWiki sends an OPTIONS request that, unless blocked,
-		// will re-download the file and waste bandwidth.
-		dir = whitelistMethods(dir, http.MethodGet, http.MethodHead)
-		http.Handle(path, http.StripPrefix(path, dir))
-		log.Printf("serving archive \"%s\" at http://%s%s", *archiveDir, addr, path)
-	}
+// ArchiveEntry describes a version of the wiki in the archive.
+type ArchiveEntry = archive.Entry
+
+// LogBuffer holds the most recent lines of a log, for the admin dashboard.
+// Make it (part of) the output of the logs it should show, e.g. with
+// log.SetOutput(io.MultiWriter(os.Stderr, logs)).
+type LogBuffer = logbuf.Buffer
 
-	log.Fatal(http.ListenAndServe(addr, nil))
+// NewLogBuffer returns a LogBuffer holding the last size lines.
+func NewLogBuffer(size int) *LogBuffer {
+	return logbuf.New(size)
 }
 
-// fixPath ensures that the given string begins and ends with '/'
-func fixPath(p string) string {
-	if p[0] != '/' {
-		p = "/" + p
+// NewHandler returns a handler serving the wiki at "/", or the path given to
+// WithWikiPath, and at any aliases given to WithWikiAliases, and, if
+// archivePath is not empty and archiving is enabled, its edit history at
+// archivePath. If WithAdmin was given, the admin dashboard is served at
+// "/admin/" and the upload form at "/upload", if WithStatus was given, the
+// server's status is served at "/status", and if WithStats was given, its
+// statistics are served at "/stats". If WithStatic was given, its files are
+// served at its path, if WithSync was given, the sync protocol is served at
+// "/sync/", and if WithResumableUploads was given, resumable uploads are
+// served at "/resumable/".
+//
+// Every request is given an ID, sent in the X-Request-ID response header and
+// prefixed to the log lines about it, and a request whose handling panics is
+// logged and answered with 500 Internal Server Error rather than crashing the
+// process.
+func NewHandler(s *Server, archivePath string) http.Handler {
+	mux := http.NewServeMux()
+	if s.wikiPath == "/" {
+		mux.Handle("/", s)
+	} else {
+		mux.HandleFunc("/", s.handleRoot)
+		mux.Handle(s.wikiPath, s.wikiHandler())
 	}
-	if p[len(p)-1] != '/' {
-		p = p + "/"
+	for _, alias := range s.wikiAliases {
+		mux.Handle(alias, s.aliasHandler(alias))
+	}
+	if s.isArchive && archivePath != "" {
+		path := server.FixPath(archivePath)
+		adminLink := ""
+		if s.adminPassword != "" {
+			adminLink = strings.Repeat("../", strings.Count(path, "/")-1) + strings.TrimPrefix(adminPath, "/")
+		}
+		mux.Handle(path, http.StripPrefix(path, s.archiveHandler(adminLink)))
+		mux.Handle(versionsPath, http.StripPrefix(strings.TrimSuffix(versionsPath, "/"), s.VersionsHandler()))
 	}
+	if s.isStatus {
+		mux.Handle(statusPath, s.StatusHandler())
+	}
+	if s.isStats {
+		mux.Handle(statsPath, s.StatsHandler())
+	}
+	if s.isManifest {
+		mux.Handle(manifestPath, s.ManifestHandler())
+		mux.Handle(manifestIconPath, s.ManifestHandler())
+	}
+	if s.adminPassword != "" {
+		mux.Handle(adminPath, http.StripPrefix(strings.TrimSuffix(adminPath, "/"), s.AdminHandler()))
+		mux.Handle(uploadPath, s.UploadHandler())
+	}
+	if s.resumable != nil {
+		mux.Handle(resumablePath, http.StripPrefix(strings.TrimSuffix(resumablePath, "/"), s.ResumableHandler()))
+	}
+	if s.sync != nil {
+		mux.Handle(syncPath, http.StripPrefix(strings.TrimSuffix(syncPath, "/"), s.SyncHandler()))
+	}
+	for _, companion := range s.companions {
+		mux.Handle(CompanionPath(companion), companionHandler(companion))
+	}
+	if s.staticDir != "" {
+		path := server.FixPath(s.staticPath)
+		mux.Handle(path, http.StripPrefix(strings.TrimSuffix(path, "/"), s.staticHandler()))
+	}
+
+	return server.RequestID(server.Recover(s.wrap(mux), s.recordPanic))
+}
 
-	return p
+// recordPanic counts a request whose handling panicked.
+func (s *Server) recordPanic(r *http.Request) {
+	s.activity.mu.Lock()
+	s.activity.panics++
+	s.activity.mu.Unlock()
 }
 
-// whitelistMethods decorates an http.Handler to only allow certain methods
-func whitelistMethods(h http.Handler, methods ...string) http.Handler {
-	allow := make(map[string]bool)
-	for _, method := range methods {
-		allow[method] = true
+// Server for providing safe concurrent reads and writes to a TiddlyWiki
+type Server struct {
+	mu                  sync.RWMutex                  // protects the following
+	etag                string                        // ETag for the live wiki
+	readOnlyErr         error                         // storage failure that made the wiki read-only
+	readOnlySince       time.Time                     // when the wiki became read-only
+	isMaintenance       bool                          // whether saves are refused for maintenance
+	fileInfo            os.FileInfo                   // last known state of the live wiki
+	archiver            archive.Archiver              // writes previous versions to the archive
+	archiveLocation     *time.Location                // time zone in which archives are named
+	archiveThreshold    float64                       // percentage the wiki must change by to be archived, or 0
+	fileName            string                        // name of the wiki file
+	readOnlyRetry       time.Duration                 // how long to stay read-only before retrying
+	compressLevel       int                           // gzip compression level
+	newHash             func() hash.Hash              // hash used to compute ETags
+	fileMode            os.FileMode                   // permissions for created files
+	dirMode             os.FileMode                   // permissions for created directories
+	isArchive           bool                          // whether archiving should be performed
+	isCompress          bool                          // whether compression is enabled
+	isCompressCache     bool                          // whether the compressed wiki is kept on disk
+	isVerify            bool                          // whether saves are re-read and checked against the upload
+	isVerifyCompressed  bool                          // whether the compressed wiki is also checked
+	isEtagCache         bool                          // whether the ETag is cached on disk
+	isWeakETags         bool                          // whether If-Match is compared weakly
+	isWatch             bool                          // whether external modifications are detected
+	isArchiveExternal   bool                          // whether external modifications are archived
+	lockFile            *os.File                      // held open to lock the wiki against other processes
+	middleware          []Middleware                  // wraps the handler returned by NewHandler
+	hooks               []Hooks                       // called during saves
+	events              eventBus                      // subscribers to events
+	adminUser           string                        // user name for the admin dashboard
+	adminPassword       string                        // password for the admin dashboard, if enabled
+	activity            activity                      // recent saves and failures
+	started             time.Time                     // when the server was created
+	isStatus            bool                          // whether the status is served
+	isStats             bool                          // whether statistics are kept
+	isManifest          bool                          // whether a web app manifest is served
+	manifestCache       manifestCache                 // manifest generated for the live wiki
+	shrinkLimit         int                           // percentage by which a save may shrink the wiki, or 0
+	held                *HeldSave                     // save held for approval, if any
+	quota               ByteSize                      // storage the live wiki and its archive may use, or 0 for unlimited
+	trash               *trash.Trash                  // where discarded files are moved, or nil to delete them
+	davLock             *davLock                      // WebDAV lock on the wiki, if any
+	recompressAlgo      string                        // algorithm with which old archives are recompressed, if any
+	recompressAge       time.Duration                 // age past which archives are recompressed
+	retention           archive.Retention             // which archives are kept when pruning automatically
+	stop                chan struct{}                 // closed to stop background jobs
+	closeOnce           sync.Once                     // closes stop
+	stats               stats                         // history of saves
+	errorPagesDir       string                        // directory of custom error page templates
+	errorPages          map[string]*template.Template // custom error pages by status
+	logs                *LogBuffer                    // recent log lines shown on the admin dashboard
+	uploads             *UploadLimiter                // limit on uploads received at once, or nil for none
+	maxMemory           ByteSize                      // memory the server should stay within, or 0 for no limit
+	wikiPath            string                        // path at which NewHandler serves the wiki
+	isWikiRedirect      bool                          // whether "/" redirects to the wiki if it is served elsewhere
+	wikiAliases         []string                      // other paths at which the wiki is found
+	resumable           *resumable                    // resumable uploads, if they are accepted
+	injection           []byte                        // HTML inserted into the wiki as it is served, if any
+	isTimeTravel        bool                          // whether past versions are served at the wiki's URL
+	contentType         string                        // Content-Type of the wiki and archives, if not by extension
+	cacheControl        string                        // Cache-Control of the live wiki, if any
+	archiveCacheControl string                        // Cache-Control of archived versions, if any
+	staticDir           string                        // directory of static files served alongside the wiki, if any
+	staticPath          string                        // path at which the static files are served
+	companions          []*Server                     // servers for files saved alongside the wiki
+	versions            versionCache                  // recent versions of the wiki, kept in memory
+	etags               etagIndex                     // ETags of archived versions
+	authors             authors                       // who saved each version
+	stallTimeout        time.Duration                 // how long an upload may be received slower than stallRate, or 0 for ever
+	stallRate           ByteSize                      // bytes per second below which an upload is stalled
+	lockMode            string                        // what WebDAV locks do to saves, or empty if they aren't supported
+	anonymizeMode       string                        // how clients' addresses are anonymized, or empty if they aren't
+	anonymizeKey        []byte                        // key with which addresses are hashed
+	mirrorUpstream      string                        // URL of the wiki that this one mirrors, if any
+	mirrorInterval      time.Duration                 // how often the upstream wiki is checked for changes
+	mirror              *mirror                       // state of the mirror, if the wiki is one; protected by mu
+	folderDir           string                        // wiki folder rendered to the wiki, if any
+	folderCommand       string                        // tiddlywiki command with which the folder is rendered
+	folder              *wikiFolder                   // state of the wiki folder, if the wiki is rendered from one
+	upgradeSource       string                        // URL of the TiddlyWiki release to which the wiki is upgraded
+	upgrade             *pendingUpgrade               // upgrade awaiting confirmation, if any; protected by mu
+	syncPeer            string                        // URL of the wiki with which this one syncs, if any
+	syncSecret          string                        // secret that peers present to sync
+	syncInterval        time.Duration                 // how often the wiki syncs with the peer
+	sync                *syncState                    // state of synchronization, if the sync protocol is served
+	isGit               bool                          // whether saves are committed to a git repository
+	gitRemote           string                        // git remote to which commits are pushed, if any
+	git                 *gitHistory                   // git repository of the wiki, if saves are committed
+}
+
+// NewServer creates a new instance of Server for the named wiki file, computing
+// the initial ETag. Archiving, compression, and other features are disabled
+// unless enabled by options. If another Server (in any process) is serving the
+// wiki, the returned error wraps ErrLocked.
+func NewServer(fileName string, options ...Option) (_ *Server, err error) {
+	s := &Server{
+		started:         time.Now(),
+		fileName:        fileName,
+		newHash:         md5.New,
+		fileMode:        0644,
+		dirMode:         0755,
+		archiveLocation: time.UTC,
+		upgradeSource:   DefaultUpgradeSource,
+		wikiPath:        "/",
+	}
+	for _, option := range options {
+		option(s)
 	}
 
-	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
-		if allow[r.Method] {
-			h.ServeHTTP(w, r)
+	if !archive.ValidMode(s.archiver.Mode) {
+		return nil, fmt.Errorf("invalid archive mode %q", s.archiver.Mode)
+	}
+	if s.isCompress && !compress.ValidLevel(s.compressLevel) {
+		return nil, fmt.Errorf("invalid compression level %d", s.compressLevel)
+	}
+	if s.archiveLocation == nil {
+		return nil, errors.New("invalid archive time zone")
+	}
+	if s.recompressAlgo != "" && !archive.ValidAlgorithm(s.recompressAlgo) {
+		return nil, fmt.Errorf("invalid recompression algorithm %q", s.recompressAlgo)
+	}
+	if algorithm := s.archiver.Compression; algorithm != "" && !archive.ValidAlgorithm(algorithm) {
+		return nil, fmt.Errorf("invalid archive compression algorithm %q", algorithm)
+	}
+	if algorithm := s.archiver.Compression; algorithm != "" && !archive.Available(algorithm) {
+		return nil, fmt.Errorf("%s is not installed, but archives are compressed with it", algorithm)
+	}
+	if s.shrinkLimit < 0 || s.shrinkLimit >= 100 {
+		return nil, fmt.Errorf("invalid shrink limit %d%%", s.shrinkLimit)
+	}
+	if s.quota < 0 {
+		return nil, fmt.Errorf("invalid quota %v", s.quota)
+	}
+	switch s.lockMode {
+	case "", LockModeAdvisory, LockModeEnforce:
+	default:
+		return nil, fmt.Errorf("invalid lock mode %q", s.lockMode)
+	}
+	if s.stallTimeout < 0 || s.stallRate < 0 {
+		return nil, fmt.Errorf("invalid stall timeout %v at %v/s", s.stallTimeout, s.stallRate)
+	}
+	switch s.anonymizeMode {
+	case "", AnonymizeTruncate:
+	case AnonymizeHash:
+		// Random, so that pseudonyms can't be reversed by hashing every
+		// address, and change with each run
+		s.anonymizeKey = make([]byte, 32)
+		_, err = rand.Read(s.anonymizeKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate anonymization key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid anonymization mode %q", s.anonymizeMode)
+	}
+	if s.mirrorUpstream != "" {
+		upstream, err := url.Parse(s.mirrorUpstream)
+		if err != nil || upstream.Scheme != "http" && upstream.Scheme != "https" || upstream.Host == "" {
+			return nil, fmt.Errorf("invalid mirror upstream %q", s.mirrorUpstream)
+		}
+		if s.mirrorInterval <= 0 {
+			return nil, fmt.Errorf("invalid mirror interval %v", s.mirrorInterval)
+		}
+		s.mirror = &mirror{
+			upstream: upstream,
+			interval: s.mirrorInterval,
+			client:   &http.Client{Timeout: mirrorTimeout},
+			status:   MirrorStatus{Upstream: redactURL(upstream)},
+		}
+	}
+	if s.folderDir != "" {
+		if s.mirrorUpstream != "" {
+			return nil, errors.New("a mirror can't also be rendered from a wiki folder")
+		}
+		if !folder.IsFolder(s.folderDir) {
+			return nil, fmt.Errorf("\"%s\" is not a wiki folder, as it has no %s", s.folderDir, folder.InfoFile)
+		}
+		s.folder = &wikiFolder{dir: s.folderDir, command: s.folderCommand}
+		if s.folder.command == "" {
+			s.folder.command = DefaultFolderCommand
+		}
+	}
+	if s.isArchive && !s.archiver.Sequence {
+		// Sequence numbers keep names apart however coarse the time is
+		if err := archive.CheckFormat(s.archiver.Format); err != nil {
+			log.Printf("warning: %v", err)
+		}
+	}
+	if s.syncSecret != "" {
+		if !s.isArchive {
+			return nil, errors.New("sync requires archiving")
+		}
+		if s.mirrorUpstream != "" {
+			return nil, errors.New("a mirror can't also sync")
+		}
+		s.sync = &syncState{
+			secret:   s.syncSecret,
+			interval: s.syncInterval,
+			client:   &http.Client{Timeout: mirrorTimeout},
+			hashes:   make(map[string]syncHashed),
+		}
+		if s.syncPeer != "" {
+			peer, err := url.Parse(s.syncPeer)
+			if err != nil || peer.Scheme != "http" && peer.Scheme != "https" || peer.Host == "" {
+				return nil, fmt.Errorf("invalid sync peer %q", s.syncPeer)
+			}
+			if s.syncInterval <= 0 {
+				return nil, fmt.Errorf("invalid sync interval %v", s.syncInterval)
+			}
+			s.sync.peer = peer
+			s.sync.status.Peer = redactURL(peer)
+		}
+	}
+	err = s.checkWikiPaths()
+	if err != nil {
+		return nil, err
+	}
+	if s.staticDir != "" && server.FixPath(s.staticPath) == "/" {
+		return nil, errors.New("static files can't be served at /, where the wiki is")
+	}
+	if s.contentType != "" {
+		_, _, err = mime.ParseMediaType(s.contentType)
+		if err != nil {
+			return nil, fmt.Errorf("invalid content type %q: %v", s.contentType, err)
+		}
+	}
+	s.archiver.FileMode = s.fileMode
+	s.archiver.DirMode = s.dirMode
+	s.archiver.Procs = s.compressProcs()
+	if s.maxMemory > 0 && s.versions.budget > int64(s.maxMemory)/memoryPerByte {
+		s.versions.budget = int64(s.maxMemory) / memoryPerByte
+		log.Printf("keeping at most %v of versions in memory, within the memory limit", ByteSize(s.versions.budget))
+	}
+	if s.trash != nil {
+		s.trash.FileMode = s.fileMode
+		s.trash.DirMode = s.dirMode
+		s.archiver.Trash = s.trash
+		purged, err := s.trash.Purge(time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("failed to empty expired trash: %w", err)
+		}
+		if len(purged) > 0 {
+			log.Printf("emptied %d expired files from trash", len(purged))
+		}
+	}
+	if s.errorPagesDir != "" {
+		s.errorPages, err = loadErrorPages(s.errorPagesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load error pages: %w", err)
+		}
+	}
+
+	// Operate on the real file so that saves replace the target of a symlink
+	// rather than the symlink itself
+	realName, err := filepath.EvalSymlinks(s.fileName)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err == nil && realName != filepath.Clean(s.fileName) {
+		log.Printf("wiki \"%s\" is a symlink to \"%s\"", s.fileName, realName)
+		s.fileName = realName
+	}
+
+	if s.folder != nil {
+		// The wiki is rendered into a directory of its own
+		err = storage.Mkdir(filepath.Dir(s.fileName), s.dirMode)
+		if err != nil {
 			return
 		}
-		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 
-	return http.HandlerFunc(handlerFunc)
+	// The wiki itself is replaced on every save, so lock a stable sidecar file
+	lock, err := storage.LockFile(s.fileName + extensionLock)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrLocked, err)
+	}
+	s.lockFile = lock
+	defer func() {
+		if err != nil {
+			lock.Close()
+		}
+	}()
+
+	err = s.recoverJournal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover interrupted save: %w", err)
+	}
+	if s.resumable != nil {
+		s.resumable.dir = s.fileName + extensionResumable
+		s.resumable.purge()
+	}
+	if s.mirror != nil {
+		err = s.initMirror()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch wiki to mirror: %w", err)
+		}
+	}
+	if s.folder != nil {
+		err = s.initFolder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render wiki folder: %w", err)
+		}
+	}
+	err = s.recoverOrphans()
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean up after previous run: %w", err)
+	}
+	s.loadHeld()
+	if s.sync != nil {
+		s.loadDivergence()
+	}
+
+	fileInfo, err := os.Stat(s.fileName)
+	if err != nil {
+		return
+	}
+
+	if s.loadEtagCache(fileInfo) {
+		log.Printf("using cached ETag %s", s.etag)
+	} else {
+		err = s.hashWiki()
+		if err != nil {
+			return
+		}
+		s.saveEtagCache()
+	}
+	s.fileInfo = fileInfo
+	s.cacheLive()
+
+	err = s.compressWiki(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress wiki: %w", err)
+	}
+	s.Subscribe(func(e Event) {
+		s.activity.record(e, s.clientAddr(e.Request))
+	})
+	if s.versions.budget > 0 {
+		s.Subscribe(s.versions.record)
+	}
+	if s.isArchive {
+		s.Subscribe(s.etags.record)
+		s.authors.load(s.fileName+extensionAuthors, s.fileMode)
+		s.Subscribe(func(e Event) {
+			s.authors.record(e, authenticatedName(e.Request))
+		})
+	}
+	if s.isStats {
+		s.stats.load(s.fileName+extensionStats, s.fileMode)
+		s.Subscribe(func(e Event) {
+			s.stats.record(e, s.clientName(e.Request))
+		})
+	}
+	if s.isGit {
+		err = s.initGit()
+		if err != nil {
+			return nil, fmt.Errorf("failed to commit wiki to git: %w", err)
+		}
+		s.Subscribe(s.commitGit)
+	}
+	// Prune what piled up before the retention policy was set
+	s.retainArchive(context.Background())
+	s.stop = make(chan struct{})
+	if s.isArchive && s.recompressAlgo != "" {
+		go s.recompressLoop(s.stop)
+	}
+	if s.mirror != nil {
+		go s.mirrorLoop(s.stop)
+	}
+	if s.sync != nil && s.sync.peer != nil {
+		go s.syncLoop(s.stop)
+	}
+	if s.git != nil {
+		go s.gitLoop(s.stop)
+	}
+
+	return s, nil
 }
 
-// Server for providing safe concurrent reads and writes to a TiddlyWiki
-type Server struct {
-	mu             sync.RWMutex // protects the following
-	etag           string       // ETag for the live wiki
-	fileName       string       // name of the wiki file
-	archiveDirName string       // name of the directory to archive to
-	archiveFormat  string       // format of archive filenames
-	isArchive      bool         // whether archiving should be performed
-	isCompress     bool         // whether compression is enabled
-}
-
-// newServer creates a new instance of Server, computing the initial ETag.
-func newServer(
-	fileName, archiveDirName, archiveFormat string,
-	isArchive, isCompress bool,
-) *Server {
-	s := &Server{
-		fileName:       fileName,
-		archiveDirName: archiveDirName,
-		archiveFormat:  archiveFormat,
-		isArchive:      isArchive,
-		isCompress:     isCompress,
+// Close writes any pending statistics and releases the lock on the wiki,
+// allowing another Server to serve it.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.stop) })
+	if s.isStats {
+		s.stats.close()
+	}
+
+	return s.lockFile.Close()
+}
+
+// hashWiki computes the ETag of the live wiki from its contents.
+func (s *Server) hashWiki() (err error) {
+	etag, err := s.hashFile(s.fileName)
+	if err != nil {
+		return
 	}
-	f, err := os.Open(s.fileName)
+	s.etag = etag
+
+	return
+}
+
+// hashFile computes the ETag of the named file from its contents.
+func (s *Server) hashFile(name string) (etag string, err error) {
+	f, err := os.Open(name)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 	defer f.Close()
 
-	hash := md5.New()
+	hash := s.newHash()
 	_, err = io.Copy(hash, f)
 	if err != nil {
-		log.Fatal(err)
+		return
+	}
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", nil
+}
+
+// isModified reports whether the wiki described by fileInfo differs from the
+// last version known to the server. The caller must hold the lock.
+func (s *Server) isModified(fileInfo os.FileInfo) bool {
+	return !os.SameFile(fileInfo, s.fileInfo) ||
+		fileInfo.Size() != s.fileInfo.Size() ||
+		!fileInfo.ModTime().Equal(s.fileInfo.ModTime())
+}
+
+// refreshIfModified checks whether the wiki has been modified outside of putter
+// (e.g., by a sync client) and, if so, recomputes the ETag and compressed copy.
+func (s *Server) refreshIfModified() {
+	// A wiki folder is rendered to the wiki whenever it changes
+	if !s.isWatch && s.folder == nil {
+		return
+	}
+	fileInfo, err := os.Stat(s.fileName)
+	if err != nil {
+		log.Printf("failed to stat wiki: %v", err)
+		return
+	}
+	s.mu.RLock()
+	modified := s.isModified(fileInfo)
+	s.mu.RUnlock()
+	if !modified {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Another request may have gotten here first
+	fileInfo, err = os.Stat(s.fileName)
+	if err != nil || !s.isModified(fileInfo) {
+		return
 	}
+	log.Println("wiki modified externally, refreshing...")
+	previousEtag := s.etag
 
-	s.setEtagFromHash(hash)
+	err = s.hashWiki()
+	if err != nil {
+		log.Printf("failed to hash modified wiki: %v", err)
+		return
+	}
+	s.saveEtagCache()
+	s.fileInfo = fileInfo
+	s.cacheLive()
 
-	err = s.compressWiki()
+	err = s.compressWiki(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed compress modified wiki: %v", err)
 	}
 
-	return s
+	if s.isArchiveExternal {
+		err = s.archiveWiki(context.Background())
+		if err != nil {
+			log.Printf("failed to archive modified wiki: %v", err)
+		}
+	}
+	log.Printf("wiki refreshed with ETag %s", s.etag)
+	s.emit(Event{Type: EventExternalChange, ETag: s.etag, PreviousETag: previousEtag})
 }
 
 // ServeHTTP handles all requests for the live wiki
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
-		w.WriteHeader(http.StatusNotFound)
+		s.writeError(w, r, http.StatusNotFound, "")
+		return
+	}
+	s.refreshFolder()
+	s.refreshIfModified()
+	if version, ok := s.timeTravel(r); ok {
+		s.handleVersion(w, r, version)
 		return
 	}
 	switch r.Method {
@@ -165,6 +676,10 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleGet(w, r)
 	case http.MethodPut:
 		s.handlePut(w, r)
+	case methodLock:
+		s.handleLock(w, r)
+	case methodUnlock:
+		s.handleUnlock(w, r)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -177,13 +692,24 @@ func (s *Server) handleHead(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	w.Header().Set(headerEtag, s.etag)
+	if s.cacheControl != "" {
+		w.Header().Set(headerCacheControl, s.cacheControl)
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
 // handleOptions responds to an OPTIONS request to signal to TiddlyWiki that
 // the server accepts PUT requests. This enables the PUT saver.
 func (s *Server) handleOptions(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set(headerDav, "putter")
+	switch {
+	case s.mirror != nil:
+		// Leave TiddlyWiki to fall back to another saver
+	case s.lockMode != "":
+		// Class 2 advertises locking to WebDAV clients
+		w.Header().Set(headerDav, "putter, 2")
+	default:
+		w.Header().Set(headerDav, "putter")
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -193,170 +719,662 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 	s.mu.RLock()
 	etag := s.etag
 	acceptEncoding := r.Header.Get(headerAcceptEncoding)
-	extension := ""
 	// Not _technically_ the right way to check this, but...
-	if s.isCompress && strings.Contains(acceptEncoding, encodingGzip) {
-		extension = extensionGzip
-		w.Header().Set(headerContentEncoding, encodingGzip)
+	isGzip := s.isCompress && strings.Contains(acceptEncoding, compress.Encoding)
+	extension := ""
+	if isGzip {
+		w.Header().Set(headerVary, headerAcceptEncoding)
+		// What is injected isn't in the compressed wiki
+		if s.isCompressCache && s.injection == nil {
+			extension = compress.Extension
+		}
 	}
 	f, err := os.Open(s.fileName + extension)
 	if err != nil {
-		log.Printf("failed to open wiki file to serve: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.mu.RUnlock()
+		server.Logf(r.Context(), "failed to open wiki file to serve: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
 		return
 	}
 	defer f.Close()
 	fileInfo, err := f.Stat()
 	if err != nil {
-		log.Printf("failed to stat wiki file to serve: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		s.mu.RUnlock()
+		server.Logf(r.Context(), "failed to stat wiki file to serve: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
 		return
 	}
 	// Now that we have the ETag and file handle, nothing can change under us
 	s.mu.RUnlock()
 
-	// http.ServeContent won't automatically add this if Content-Encoding is set
-	w.Header().Set(headerContentLength, strconv.FormatInt(fileInfo.Size(), 10))
 	w.Header().Set(headerEtag, etag)
-	http.ServeContent(w, r, s.fileName, fileInfo.ModTime(), f)
+	if s.isManifest {
+		w.Header().Set(headerLink, `<`+s.rootLink()+strings.TrimPrefix(manifestPath, "/")+`>; rel="manifest"`)
+	}
+	if s.contentType != "" {
+		w.Header().Set(headerContentType, s.contentType)
+	}
+	if s.cacheControl != "" {
+		w = &cacheControlWriter{ResponseWriter: w, cacheControl: s.cacheControl}
+	}
+	var content io.ReadSeeker = f
+	size := fileInfo.Size()
+	if s.injection != nil {
+		content, size, err = s.inject(r.Context(), f, size)
+		if err != nil {
+			server.Logf(r.Context(), "failed to read wiki file to serve: %v", err)
+			s.writeError(w, r, http.StatusInternalServerError, "")
+			return
+		}
+	}
+	w, done := s.countDownload(w, r)
+	defer done()
+	if isGzip && (!s.isCompressCache || s.injection != nil) {
+		s.serveCompressed(w, r, etag, fileInfo, content)
+		return
+	}
+	if isGzip {
+		w.Header().Set(headerContentEncoding, compress.Encoding)
+	}
+
+	// http.ServeContent won't automatically add this if Content-Encoding is set
+	w.Header().Set(headerContentLength, strconv.FormatInt(size, 10))
+	http.ServeContent(w, r, s.fileName, fileInfo.ModTime(), content)
+}
+
+// serveCompressed gzips the wiki on the fly as it is served. Since the length
+// isn't known up front, range requests are not supported in this mode.
+func (s *Server) serveCompressed(
+	w http.ResponseWriter, r *http.Request,
+	etag string, fileInfo os.FileInfo, f io.Reader,
+) {
+	if r.Header.Get(headerIfNoneMatch) == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set(headerContentEncoding, compress.Encoding)
+	if w.Header().Get(headerContentType) == "" {
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	}
+	w.Header().Set(headerLastModified, fileInfo.ModTime().UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+
+	err := compress.Stream(w, f, s.compressLevel)
+	if err != nil {
+		server.Logf(r.Context(), "failed to serve compressed wiki: %v", err)
+	}
+}
+
+// cacheControlWriter sets a Cache-Control header on successful responses,
+// leaving errors to be fetched afresh.
+type cacheControlWriter struct {
+	http.ResponseWriter
+	cacheControl string
+	wroteHeader  bool
+}
+
+// WriteHeader sets the header if status isn't an error before passing it on.
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status < http.StatusBadRequest {
+		w.Header().Set(headerCacheControl, w.cacheControl)
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sets the header for an implicit 200 OK before passing the data on.
+func (w *cacheControlWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(p)
 }
 
 // handlePut receives a new version of the wiki, archives the live version,
 // and replaces it with the uploaded version.
 func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
-	log.Println("receiving PUT request...")
-	f, err := ioutil.TempFile(os.TempDir(), "tiddlywiki-upload-*.html")
+	etag, ok := s.save(w, r, r.Body)
+	if !ok {
+		return
+	}
+	w.Header().Set(headerEtag, etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// save runs the save pipeline for a new version of the wiki read from body,
+// on behalf of r, emitting events as it goes. Failures are written to w; on
+// success nothing is written and the new ETag is returned.
+func (s *Server) save(w http.ResponseWriter, r *http.Request, body io.Reader) (etag string, ok bool) {
+	started := time.Now()
+	if isDryRun(r) {
+		return s.saveWiki(w, r, body, started)
+	}
+	s.emit(Event{Type: EventSaveStarted, Request: r})
+	rec := &server.StatusRecorder{ResponseWriter: w}
+	etag, ok = s.saveWiki(rec, r, body, started)
+	if rec.Status >= http.StatusBadRequest && rec.Status != http.StatusPreconditionFailed {
+		s.emit(Event{Type: EventSaveFailed, Request: r, Status: rec.Status, Duration: time.Since(started)})
+	}
+
+	return
+}
+
+// isDryRun reports whether r only checks whether a save would succeed, with
+// an X-Putter-Dry-Run header or a dry-run query parameter.
+func isDryRun(r *http.Request) bool {
+	_, ok := r.URL.Query()["dry-run"]
+
+	return ok || r.Header.Get(headerDryRun) != ""
+}
+
+// ifMatch reports whether r's If-Match header (RFC 9110), "*" or a list of
+// ETags, is absent or matches the wiki's, returning the ETag it matched or
+// else the first it lists, for reporting the conflict. ETags are compared
+// strongly unless WithWeakETags was given. The caller must hold the lock.
+func (s *Server) ifMatch(r *http.Request) (etag string, ok bool) {
+	header := strings.TrimSpace(strings.Join(r.Header[headerIfMatch], ","))
+	switch header {
+	case "":
+		return "", true
+	case "*":
+		return s.etag, true
+	}
+	for i, tag := range splitETags(header) {
+		if s.isWeakETags {
+			tag = weakETag(tag)
+		}
+		if tag == s.etag {
+			return tag, true
+		}
+		if i == 0 {
+			etag = tag
+		}
+	}
+
+	return etag, false
+}
+
+// splitETags splits a comma-separated list of ETags, which may contain commas
+// within their quotes.
+func splitETags(list string) (etags []string) {
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return
+		}
+		n := strings.IndexByte(list, ',')
+		quoted := strings.TrimPrefix(list, "W/")
+		if strings.HasPrefix(quoted, `"`) {
+			if end := strings.IndexByte(quoted[1:], '"'); end >= 0 {
+				n = len(list) - len(quoted) + end + 2
+			}
+		}
+		if n < 0 {
+			n = len(list)
+		}
+		etags = append(etags, strings.TrimSpace(list[:n]))
+		list = list[n:]
+	}
+}
+
+// weakETag undoes what proxies do to ETags: those that compress responses
+// weaken their ETags, like nginx, or append to them, like Apache's "-gzip".
+// putter's own ETags never hold a dash.
+func weakETag(etag string) string {
+	etag = strings.TrimPrefix(etag, "W/")
+	if i := strings.IndexByte(etag, '-'); i >= 0 && strings.HasPrefix(etag, `"`) {
+		etag = etag[:i] + `"`
+	}
+
+	return etag
+}
+
+// saveWiki receives a new version of the wiki from body and, if it passes
+// validation, archives the live version and replaces it. started is when the
+// save began, for timing it. A dry run stops short of changing anything,
+// writing the outcome to w, including on success.
+func (s *Server) saveWiki(w http.ResponseWriter, r *http.Request, body io.Reader, started time.Time) (_ string, ok bool) {
+	ctx := r.Context()
+	s.mu.RLock()
+	retryAfter, isReadOnly := s.readOnlyRemaining()
+	readOnlyErr := s.readOnlyErr
+	isMaintenance := s.isMaintenance
+	s.mu.RUnlock()
+	if s.mirror != nil {
+		server.Logf(ctx, "refusing save, wiki is a mirror")
+		s.writeError(w, r, http.StatusForbidden, "This wiki is a read-only mirror of "+s.mirror.status.Upstream+", where it should be saved instead.")
+		return
+	}
+	if isMaintenance {
+		server.Logf(ctx, "refusing save, wiki is in maintenance mode")
+		s.writeError(w, r, http.StatusServiceUnavailable, "The wiki is in maintenance mode.")
+		return
+	}
+	if isReadOnly {
+		server.Logf(ctx, "refusing save, wiki is read-only: %v", readOnlyErr)
+		w.Header().Set(headerRetryAfter, strconv.Itoa(int(retryAfter.Seconds())+1))
+		s.writeError(w, r, http.StatusServiceUnavailable, "The wiki is temporarily read-only due to a storage failure on the server.")
+		return
+	}
+	// The upload form is the admin's, who may override locks
+	if lock := s.otherLock(r); lock != nil && r.Method == http.MethodPut {
+		detail := "locked by " + lock.holder() + " until " + lock.Expires.Format(time.RFC1123)
+		if s.lockMode == LockModeEnforce {
+			server.Logf(ctx, "refusing save, wiki is %s", detail)
+			s.writeError(w, r, http.StatusLocked, detail)
+			return
+		}
+		server.Logf(ctx, "warning: saving wiki %s", detail)
+		w.Header().Set(headerWarning, "199 putter "+strconv.Quote("the wiki is "+detail))
+	}
+
+	release, err := s.uploads.acquire(ctx)
+	if err == errTooManyUploads {
+		server.Logf(ctx, "refusing save, too many uploads in progress")
+		w.Header().Set(headerRetryAfter, strconv.Itoa(uploadRetryAfter))
+		s.writeError(w, r, http.StatusTooManyRequests, "")
+		return
+	}
 	if err != nil {
-		log.Printf("failed to open temporary file for upload: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		server.Logf(ctx, "abandoning save while waiting to upload: %v", err)
+		s.writeError(w, r, http.StatusServiceUnavailable, "")
+		return
+	}
+	// Released as soon as the upload is received, or on any failure before
+	defer release()
+	server.Logf(ctx, "receiving wiki...")
+	// Upload next to the wiki so that it survives a crash and can be renamed
+	// into place without crossing filesystems
+	f, err := ioutil.TempFile(filepath.Dir(s.fileName), s.uploadPrefix()+"*")
+	if err != nil {
+		server.Logf(ctx, "failed to open temporary file for upload: %v", err)
+		s.mu.Lock()
+		s.setReadOnly(err)
+		s.mu.Unlock()
+		s.writeError(w, r, http.StatusInternalServerError, "")
 		return
 	}
 	defer os.Remove(f.Name())
 	defer f.Close()
 
-	hash := md5.New()
-	written, err := io.Copy(io.MultiWriter(f, hash), r.Body)
+	hash := s.newHash()
+	digest := sha256.New()
+	size := int64(-1)
+	if body == io.Reader(r.Body) {
+		size = r.ContentLength
+	}
+	watched, stopWatching := s.watchTransfer(ctx, body, size)
+	defer stopWatching()
+	// Stop receiving if the client goes away or the request's deadline passes
+	written, err := io.CopyBuffer(io.MultiWriter(f, hash, digest), storage.ContextReader{Ctx: ctx, R: watched}, s.copyBuffer())
+	release()
+	if err == errStalled {
+		server.Abort(w, http.StatusRequestTimeout)
+		return
+	}
 	if err != nil {
-		log.Printf("failed to save request body: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		server.Logf(ctx, "failed to save request body: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	server.Logf(ctx, "received %d bytes", written)
+	uploadEtag := "\"" + hex.EncodeToString(hash.Sum(nil)) + "\""
+
+	// Let clients verify the upload end-to-end, whether or not they asked to
+	sum := hex.EncodeToString(digest.Sum(nil))
+	w.Header().Set(headerSha256, sum)
+	expected := r.Header.Get(headerSha256)
+	if expected != "" && !strings.EqualFold(expected, sum) {
+		server.Logf(ctx, "mismatched SHA-256 (client : %s, server : %s)", expected, sum)
+		s.writeError(w, r, http.StatusBadRequest, "")
 		return
 	}
-	log.Printf("received %d bytes", written)
 
 	err = f.Close()
 	if err != nil {
-		log.Printf("failed to close temporary file: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		server.Logf(ctx, "failed to close temporary file: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
 		return
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	etag := r.Header.Get(headerIfMatch)
-	if etag != "" && etag != s.etag {
-		log.Printf("conflicting ETag (client : %s, server : %s)", etag, s.etag)
-		w.WriteHeader(http.StatusPreconditionFailed)
+	if ctx.Err() != nil {
+		server.Logf(ctx, "abandoning save: %v", ctx.Err())
+		s.writeError(w, r, http.StatusServiceUnavailable, "")
 		return
 	}
 
-	err = s.archiveWiki()
-	if err != nil {
-		log.Printf("failed to archive wiki: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	dryRun := isDryRun(r)
+	if !dryRun {
+		defer s.discardUpload(ctx, f.Name())
+	}
+	etag, isMatch := s.ifMatch(r)
+	if !isMatch && dryRun {
+		server.Logf(ctx, "dry run would conflict (client : %s, server : %s)", etag, s.etag)
+		s.writeError(w, r, http.StatusPreconditionFailed, "")
+		return
+	}
+	if !isMatch {
+		server.Logf(ctx, "conflicting ETag (client : %s, server : %s)", etag, s.etag)
+		s.onConflict(&ConflictContext{
+			Request:    r,
+			ClientETag: etag,
+			ServerETag: s.etag,
+		})
+		s.emit(Event{
+			Type:         EventConflict,
+			Request:      r,
+			ETag:         s.etag,
+			PreviousETag: etag,
+			Status:       http.StatusPreconditionFailed,
+			Duration:     time.Since(started),
+		})
+		s.writeError(w, r, http.StatusPreconditionFailed, s.conflictDetail(etag))
 		return
 	}
 
-	err = os.Rename(f.Name(), s.fileName)
-	if err != nil {
-		log.Printf("failed replace live wiki: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	if s.isTooSmall(written) && r.Header.Get(headerConfirmShrink) == "" && dryRun {
+		server.Logf(ctx, "dry run would be held, it would shrink the wiki from %d to %d bytes", s.fileInfo.Size(), written)
+		s.writeError(w, r, http.StatusConflict, fmt.Sprintf("The new version would be %s, down from %s.", ByteSize(written), ByteSize(s.fileInfo.Size())))
+		return
+	}
+	if s.isTooSmall(written) && r.Header.Get(headerConfirmShrink) == "" {
+		previousSize := s.fileInfo.Size()
+		server.Logf(ctx, "holding save from %s for approval, it would shrink the wiki from %d to %d bytes", s.clientAddr(r), previousSize, written)
+		err = s.holdSave(s.clientAddr(r), f.Name(), written)
+		if err != nil {
+			server.Logf(ctx, "failed to hold save: %v", err)
+			s.writeError(w, r, http.StatusInternalServerError, "")
+			return
+		}
+		s.emit(Event{Type: EventSaveHeld, Request: r, Size: written, Status: http.StatusConflict})
+		detail := fmt.Sprintf("The new version is %s, down from %s.", ByteSize(written), ByteSize(previousSize))
+		if s.adminPassword != "" {
+			detail += " It can be approved on the admin dashboard."
+		}
+		s.writeError(w, r, http.StatusConflict, detail)
 		return
 	}
 
-	err = os.Chmod(s.fileName, 0644)
+	projected, withinQuota, err := s.checkQuota(written)
 	if err != nil {
-		log.Printf("failed make wiki readable: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		server.Logf(ctx, "failed to measure storage used by wiki: %v", err)
+	}
+	if !withinQuota {
+		server.Logf(ctx, "refusing save, the wiki would use %d bytes, over its quota of %d", projected, int64(s.quota))
+		s.writeError(w, r, http.StatusInsufficientStorage, fmt.Sprintf("With this save, the wiki and its archive would use %s, over its quota of %s.", ByteSize(projected), s.quota))
 		return
 	}
 
-	err = s.compressWiki()
+	saveCtx := &SaveContext{
+		Request:      r,
+		Upload:       f.Name(),
+		Size:         written,
+		PreviousETag: s.etag,
+		ETag:         uploadEtag,
+		DryRun:       dryRun,
+	}
+	err = s.beforeSave(saveCtx)
 	if err != nil {
-		log.Printf("failed compress wiki: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		server.Logf(ctx, "save rejected by hook: %v", err)
+		code := http.StatusForbidden
+		if statusErr, ok := err.(*StatusError); ok {
+			code = statusErr.Code
+		}
+		s.writeError(w, r, code, err.Error())
 		return
 	}
 
-	s.setEtagFromHash(hash)
-	w.Header().Set(headerEtag, s.etag)
-	w.WriteHeader(http.StatusOK)
+	if dryRun {
+		server.Logf(ctx, "dry run would save %d bytes", written)
+		w.Header().Set(headerContentType, "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "The save would succeed, replacing the wiki with %s with ETag %s.\n", ByteSize(written), uploadEtag)
+		return
+	}
 
-	log.Println("wiki saved successfully")
+	err = s.replaceWiki(ctx, f.Name(), uploadEtag)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	server.Logf(ctx, "wiki saved successfully")
+	saveCtx.Upload = s.fileName
+	s.afterSave(saveCtx)
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		Request:      r,
+		ETag:         s.etag,
+		PreviousETag: saveCtx.PreviousETag,
+		Size:         written,
+		Duration:     time.Since(started),
+	})
+
+	return s.etag, true
 }
 
-// compressWiki saves a compressed version of the wiki. This allows compression
-// to happen once at time of write rather than every time the file is served.
-func (s *Server) compressWiki() (err error) {
-	if !s.isCompress {
+// replaceWiki archives the live wiki and replaces it with upload, whose ETag
+// is etag. Failures are logged and put the wiki into read-only mode. The
+// caller must hold the write lock.
+func (s *Server) replaceWiki(ctx context.Context, upload, etag string) (err error) {
+	journal, err := s.writeJournal(journalEntry{
+		Upload: upload,
+		Etag:   etag,
+	})
+	if err != nil {
+		server.Logf(ctx, "failed to write journal: %v", err)
+		s.setReadOnly(err)
 		return
 	}
-	log.Println("compressing wiki...")
-	src, err := os.Open(s.fileName)
+	defer os.Remove(journal)
+
+	err = s.archiveWiki(ctx)
 	if err != nil {
+		server.Logf(ctx, "failed to archive wiki: %v", err)
+		s.setReadOnly(err)
 		return
 	}
-	defer src.Close()
 
-	dst, err := os.Create(s.fileName + extensionGzip)
+	backup, err := s.backupWiki(ctx)
 	if err != nil {
+		server.Logf(ctx, "failed to back up live wiki: %v", err)
+		s.setReadOnly(err)
 		return
 	}
-	defer dst.Close()
+	defer os.Remove(backup)
 
-	dstz, err := gzip.NewWriterLevel(dst, gzip.BestCompression)
+	err = os.Rename(upload, s.fileName)
 	if err != nil {
+		server.Logf(ctx, "failed replace live wiki: %v", err)
+		s.setReadOnly(err)
 		return
 	}
-	defer dstz.Close()
 
-	_, err = io.Copy(dstz, src)
+	err = os.Chmod(s.fileName, s.fileMode)
 	if err != nil {
+		server.Logf(ctx, "failed make wiki readable: %v", err)
+		s.setReadOnly(err)
+		s.rollbackWiki(ctx, backup)
 		return
 	}
-	log.Println("wiki compressed")
 
-	return
+	err = s.compressWiki(ctx)
+	if err != nil {
+		server.Logf(ctx, "failed compress wiki: %v", err)
+		s.setReadOnly(err)
+		s.rollbackWiki(ctx, backup)
+		return
+	}
+
+	err = s.verifyWiki(etag)
+	if err != nil {
+		server.Logf(ctx, "failed to verify saved wiki, storage may be corrupting data: %v", err)
+		s.emit(Event{Type: EventVerificationFailed, ETag: s.etag})
+		s.setReadOnly(err)
+		s.rollbackWiki(ctx, backup)
+		return
+	}
+
+	s.etag = etag
+	s.saveEtagCache()
+	if s.readOnlyErr != nil {
+		server.Logf(ctx, "storage recovered, wiki is writable again")
+		s.readOnlyErr = nil
+	}
+	fileInfo, err := os.Stat(s.fileName)
+	if err == nil {
+		s.fileInfo = fileInfo
+		s.cacheLive()
+	}
+	if s.folder != nil {
+		s.writeFolder(ctx, backup)
+	}
+
+	return nil
 }
 
-// archiveWiki copies the live version of the wiki into the archive directory.
-func (s *Server) archiveWiki() (err error) {
+// setReadOnly puts the wiki into read-only mode after a storage failure, so
+// that clients get a clear refusal instead of repeatedly half-failing saves.
+// The caller must hold the write lock.
+func (s *Server) setReadOnly(err error) {
+	// An abandoned request says nothing about the health of the storage
+	if s.readOnlyRetry <= 0 || err == context.Canceled || err == context.DeadlineExceeded {
+		return
+	}
+	if s.readOnlyErr == nil {
+		log.Printf("storage failure, wiki is read-only for %v: %v", s.readOnlyRetry, err)
+	}
+	s.readOnlyErr = err
+	s.readOnlySince = time.Now()
+}
+
+// readOnlyRemaining reports whether the wiki is currently read-only and, if so,
+// how long until saves will be attempted again. The caller must hold the lock.
+func (s *Server) readOnlyRemaining() (time.Duration, bool) {
+	if s.readOnlyErr == nil {
+		return 0, false
+	}
+	remaining := s.readOnlyRetry - time.Since(s.readOnlySince)
+
+	return remaining, remaining > 0
+}
+
+// compressWiki saves a compressed version of the wiki. This allows compression
+// to happen once at time of write rather than every time the file is served.
+func (s *Server) compressWiki(ctx context.Context) error {
+	if !s.isCompress || !s.isCompressCache {
+		return nil
+	}
+
+	return compress.File(ctx, s.fileName, s.fileName+compress.Extension, s.compressLevel, s.compressProcs(), s.fileMode)
+}
+
+// archiveWiki copies the live version of the wiki into the archive directory,
+// unless it has changed too little since the newest archive for
+// WithArchiveThreshold.
+func (s *Server) archiveWiki(ctx context.Context) (err error) {
 	if !s.isArchive {
 		return
 	}
-	os.Mkdir(s.archiveDirName, 755)
+	if always, _ := ctx.Value(alwaysArchiveKey{}).(bool); s.archiveThreshold > 0 && !always {
+		percent, err := s.archiveChange()
+		if err != nil {
+			server.Logf(ctx, "failed to measure change since the newest archive: %v", err)
+		} else if percent < s.archiveThreshold {
+			server.Logf(ctx, "not archiving wiki, %.2f%% changed since the newest archive", percent)
+			return nil
+		}
+	}
+	name, err := s.archiver.Archive(ctx, s.fileName, time.Now().In(s.archiveLocation))
+	if err != nil || name == "" {
+		return
+	}
+	s.emit(Event{Type: EventArchived, ETag: s.etag, Archive: name})
+	s.retainArchive(ctx)
 
-	src, err := os.Open(s.fileName)
+	return
+}
+
+// backupWiki preserves the live wiki under a temporary name so that a failed
+// save can be rolled back, returning the name of the backup.
+func (s *Server) backupWiki(ctx context.Context) (backup string, err error) {
+	backup = s.fileName + extensionBackup
+	os.Remove(backup)
+	// The live wiki is replaced by rename, so a hard link is a safe backup
+	err = os.Link(s.fileName, backup)
 	if err != nil {
-		return
+		err = storage.CopyFile(ctx, s.fileName, backup, s.fileMode)
 	}
-	defer src.Close()
 
-	t := time.Now().UTC()
-	filename := s.archiveDirName + "/" + t.Format(s.archiveFormat)
-	dst, err := os.Create(filename)
+	return
+}
+
+// rollbackWiki restores the live wiki (and its compressed copy) from the
+// backup made at the start of a save. Failures can only be logged.
+func (s *Server) rollbackWiki(ctx context.Context, backup string) {
+	server.Logf(ctx, "rolling back to previous wiki...")
+	err := os.Rename(backup, s.fileName)
+	if err != nil {
+		server.Logf(ctx, "failed to restore previous wiki: %v", err)
+		return
+	}
+	// The save's context may well be cancelled, but the rollback must finish
+	err = s.compressWiki(context.Background())
 	if err != nil {
+		server.Logf(ctx, "failed to restore previous compressed wiki: %v", err)
 		return
 	}
-	defer dst.Close()
+	server.Logf(ctx, "previous wiki restored")
+}
 
-	_, err = io.Copy(dst, src)
-	log.Printf("archived wiki to %s", dst.Name())
+// loadEtagCache reads the ETag sidecar file and, if it was recorded for a wiki
+// of the same size and modification time, sets it as the current ETag.
+// It reports whether the cached ETag was used.
+func (s *Server) loadEtagCache(fileInfo os.FileInfo) bool {
+	if !s.isEtagCache {
+		return false
+	}
+	data, err := ioutil.ReadFile(s.fileName + extensionEtag)
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 {
+		return false
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size != fileInfo.Size() {
+		return false
+	}
+	modTime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || modTime != fileInfo.ModTime().UnixNano() {
+		return false
+	}
+	s.etag = fields[0]
 
-	return
+	return true
 }
 
-// setEtagFromHash gets the sum of the hash and sets it as the current ETag
-func (s *Server) setEtagFromHash(h hash.Hash) {
-	s.etag = "\"" + hex.EncodeToString(h.Sum(nil)) + "\""
+// saveEtagCache records the current ETag alongside the wiki's size and
+// modification time so that it can be reused on the next startup.
+// Failure is logged but otherwise harmless; the wiki will just be rehashed.
+func (s *Server) saveEtagCache() {
+	if !s.isEtagCache {
+		return
+	}
+	fileInfo, err := os.Stat(s.fileName)
+	if err != nil {
+		log.Printf("failed to stat wiki for ETag cache: %v", err)
+		return
+	}
+	data := s.etag + "\n" +
+		strconv.FormatInt(fileInfo.Size(), 10) + "\n" +
+		strconv.FormatInt(fileInfo.ModTime().UnixNano(), 10) + "\n"
+	err = storage.WriteFile(s.fileName+extensionEtag, []byte(data), s.fileMode)
+	if err != nil {
+		log.Printf("failed to write ETag cache: %v", err)
+	}
 }
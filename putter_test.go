@@ -0,0 +1,2167 @@
+package putter_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djcrock/putter"
+	"github.com/djcrock/putter/puttertest"
+)
+
+const (
+	testContent       = "<html>original</html>"
+	testUpdated       = "<html>updated</html>"
+	testArchiveFormat = "2006-01-02-15-04-05.000000000.html"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// fixture is a putter server for a temporary wiki, served over HTTP.
+type fixture struct {
+	t      *testing.T
+	wiki   *puttertest.TempWiki
+	server *putter.Server
+	http   *httptest.Server
+}
+
+func newFixture(t *testing.T, options ...putter.Option) *fixture {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	return newFixtureForWiki(t, wiki, options...)
+}
+
+func newFixtureForWiki(t *testing.T, wiki *puttertest.TempWiki, options ...putter.Option) *fixture {
+	s, err := putter.NewServer(wiki.FileName, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fixture{
+		t:      t,
+		wiki:   wiki,
+		server: s,
+		http:   httptest.NewServer(putter.NewHandler(s, "/old/")),
+	}
+}
+
+// authenticate puts middleware in front of the server that names clients
+// giving the password "password" as the users they give, as an
+// authenticating proxy would, and lets others through anonymously. It must
+// be called before any requests are made.
+func (f *fixture) authenticate() {
+	h := f.http.Config.Handler
+	f.http.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); ok && password == "password" {
+			r = r.WithContext(putter.ContextWithClient(r.Context(), user))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (f *fixture) close() {
+	f.http.Close()
+	f.server.Close()
+	f.wiki.Close()
+}
+
+// do makes a request to the server, returning the response and its body.
+func (f *fixture) do(method, path, body string, header http.Header) (*http.Response, string) {
+	f.t.Helper()
+	req, err := http.NewRequest(method, f.http.URL+path, strings.NewReader(body))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+
+	return res, string(data)
+}
+
+// etag gets the current ETag via a HEAD request.
+func (f *fixture) etag() string {
+	f.t.Helper()
+	res, _ := f.do(http.MethodHead, "/", "", nil)
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		f.t.Fatal("HEAD response has no ETag")
+	}
+
+	return etag
+}
+
+// put saves content, expecting the given status.
+func (f *fixture) put(content string, header http.Header, status int) *http.Response {
+	f.t.Helper()
+	res, _ := f.do(http.MethodPut, "/", content, header)
+	if res.StatusCode != status {
+		f.t.Fatalf("PUT status = %d, want %d", res.StatusCode, status)
+	}
+
+	return res
+}
+
+func TestGet(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, body := f.do(http.MethodGet, "/", "", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if body != testContent {
+		t.Errorf("body = %q, want %q", body, testContent)
+	}
+	if etag := res.Header.Get("ETag"); etag != f.etag() {
+		t.Errorf("GET ETag %s doesn't match HEAD ETag %s", etag, f.etag())
+	}
+}
+
+func TestGetNotModified(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/", "", http.Header{"If-None-Match": {f.etag()}})
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotModified)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do(http.MethodOptions, "/", "", nil)
+	if res.Header.Get("Dav") == "" {
+		t.Error("OPTIONS response has no Dav header, so the PUT saver won't be enabled")
+	}
+}
+
+func TestUnknownRequests(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/other.html", http.StatusNotFound},
+		{http.MethodDelete, "/", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/", http.StatusMethodNotAllowed},
+	}
+	for _, test := range tests {
+		res, _ := f.do(test.method, test.path, "", nil)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s status = %d, want %d", test.method, test.path, res.StatusCode, test.status)
+		}
+	}
+}
+
+func TestPut(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	oldEtag := f.etag()
+	res := f.put(testUpdated, http.Header{"If-Match": {oldEtag}}, http.StatusOK)
+	newEtag := res.Header.Get("ETag")
+	if newEtag == "" || newEtag == oldEtag {
+		t.Errorf("PUT ETag = %q, want a new ETag", newEtag)
+	}
+	if f.etag() != newEtag {
+		t.Errorf("HEAD ETag = %s, want %s", f.etag(), newEtag)
+	}
+	if content := f.wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q, want %q", content, testUpdated)
+	}
+	if _, body := f.do(http.MethodGet, "/", "", nil); body != testUpdated {
+		t.Errorf("GET body = %q, want %q", body, testUpdated)
+	}
+}
+
+func TestPutWithoutIfMatch(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	if content := f.wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q, want %q", content, testUpdated)
+	}
+}
+
+func TestPutDryRun(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+	var mu sync.Mutex
+	var events []putter.EventType
+	defer f.server.Subscribe(func(e putter.Event) {
+		mu.Lock()
+		events = append(events, e.Type)
+		mu.Unlock()
+	})()
+
+	etag := f.etag()
+	res, body := f.do(http.MethodPut, "/", testUpdated, http.Header{"If-Match": {etag}, "X-Putter-Dry-Run": {"1"}})
+	if res.StatusCode != http.StatusOK || !strings.Contains(body, "would succeed") {
+		t.Errorf("dry run = %d %q, want %d and the outcome", res.StatusCode, body, http.StatusOK)
+	}
+	if res.Header.Get("ETag") != "" {
+		t.Errorf("dry run returned ETag %s", res.Header.Get("ETag"))
+	}
+	res, _ = f.do(http.MethodPut, "/?dry-run", testUpdated, http.Header{"If-Match": {`"stale"`}})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("conflicting dry run status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+
+	if content := f.wiki.Read(); content != testContent || f.etag() != etag {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+	if archives := wiki.List("old"); len(archives) != 0 {
+		t.Errorf("archives = %v, want none", archives)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 0 {
+		t.Errorf("dry runs emitted events %v", events)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	const lockInfo = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype><D:owner><D:href>alice</D:href></D:owner></D:lockinfo>`
+	for _, mode := range []string{putter.LockModeAdvisory, putter.LockModeEnforce} {
+		t.Run(mode, func(t *testing.T) {
+			f := newFixture(t, putter.WithLocks(mode))
+			defer f.close()
+
+			res, body := f.do("LOCK", "/", lockInfo, http.Header{"Timeout": {"Second-300"}})
+			token := res.Header.Get("Lock-Token")
+			if res.StatusCode != http.StatusOK || !strings.HasPrefix(token, "<urn:uuid:") || !strings.Contains(body, "Second-300") {
+				t.Fatalf("LOCK = %d %q %q, want a lock for 300 seconds", res.StatusCode, token, body)
+			}
+			if lock := f.server.Status().Lock; lock == nil || lock.Owner != "alice" {
+				t.Errorf("status lock = %+v, want one owned by alice", lock)
+			}
+			res, _ = f.do("LOCK", "/", lockInfo, nil)
+			if res.StatusCode != http.StatusLocked {
+				t.Errorf("second LOCK status = %d, want %d", res.StatusCode, http.StatusLocked)
+			}
+			res, _ = f.do("LOCK", "/", "", http.Header{"If": {"(" + token + ")"}, "Timeout": {"Second-600"}})
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("refreshing LOCK status = %d, want %d", res.StatusCode, http.StatusOK)
+			}
+
+			// Saves by the lock's holder always succeed
+			f.put(testUpdated, http.Header{"If-Match": {f.etag()}, "If": {"(" + token + ")"}}, http.StatusOK)
+			if mode == putter.LockModeEnforce {
+				f.put(testContent, http.Header{"If-Match": {f.etag()}}, http.StatusLocked)
+			} else {
+				res = f.put(testContent, http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+				if warning := res.Header.Get("Warning"); !strings.Contains(warning, "alice") {
+					t.Errorf("Warning = %q, want the lock's owner", warning)
+				}
+			}
+
+			res, _ = f.do("UNLOCK", "/", "", http.Header{"Lock-Token": {"<urn:uuid:wrong>"}})
+			if res.StatusCode != http.StatusConflict {
+				t.Errorf("UNLOCK with the wrong token status = %d, want %d", res.StatusCode, http.StatusConflict)
+			}
+			res, _ = f.do("UNLOCK", "/", "", http.Header{"Lock-Token": {token}})
+			if res.StatusCode != http.StatusNoContent {
+				t.Errorf("UNLOCK status = %d, want %d", res.StatusCode, http.StatusNoContent)
+			}
+			if lock := f.server.Status().Lock; lock != nil {
+				t.Errorf("status lock = %+v after UNLOCK, want none", lock)
+			}
+			f.put(testContent, http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+		})
+	}
+}
+
+func TestLocksDisabled(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do("LOCK", "/", "", nil)
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("LOCK status = %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAnonymizedClients(t *testing.T) {
+	const lockInfo = `<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`
+	for mode, want := range map[string]string{
+		"":                       "127.0.0.1:",
+		putter.AnonymizeTruncate: "127.0.0.0",
+		putter.AnonymizeHash:     "client-",
+	} {
+		f := newFixture(t, putter.WithLocks(putter.LockModeAdvisory), putter.WithAnonymizedClients(mode))
+		res, _ := f.do("LOCK", "/", lockInfo, nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("LOCK status = %d, want %d", res.StatusCode, http.StatusOK)
+		}
+		lock := f.server.Status().Lock
+		if lock == nil || !strings.HasPrefix(lock.Client, want) || mode != "" && strings.Contains(lock.Client, "127.0.0.1") {
+			t.Errorf("with %q, status lock = %+v, want a client like %s", mode, lock, want)
+		}
+		f.close()
+	}
+
+	if _, err := putter.NewServer("wiki.html", putter.WithAnonymizedClients("scramble")); err == nil {
+		t.Error("NewServer accepted an invalid anonymization mode")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	upstream := newFixture(t)
+	defer upstream.close()
+	wiki := puttertest.NewTempWiki(t, "")
+	if err := os.Remove(wiki.FileName); err != nil {
+		t.Fatal(err)
+	}
+	f := newFixtureForWiki(t, wiki, putter.WithMirror(upstream.http.URL+"/", time.Hour))
+	defer f.close()
+
+	if content := wiki.Read(); content != testContent {
+		t.Fatalf("mirror = %q, want the upstream wiki", content)
+	}
+	f.put(testUpdated, http.Header{"If-Match": {f.etag()}}, http.StatusForbidden)
+	res, _ := f.do(http.MethodOptions, "/", "", nil)
+	if res.Header.Get("Dav") != "" {
+		t.Error("mirror offered the PUT saver")
+	}
+
+	ctx := context.Background()
+	upstream.put(testUpdated, http.Header{"If-Match": {upstream.etag()}}, http.StatusOK)
+	changed, err := f.server.SyncMirror(ctx)
+	if err != nil || !changed {
+		t.Fatalf("SyncMirror = %v, %v, want a change", changed, err)
+	}
+	if content := wiki.Read(); content != testUpdated {
+		t.Errorf("mirror = %q after sync, want %q", content, testUpdated)
+	}
+	changed, err = f.server.SyncMirror(ctx)
+	if err != nil || changed {
+		t.Errorf("second SyncMirror = %v, %v, want no change", changed, err)
+	}
+	if status := f.server.Status().Mirror; status == nil || status.LastChange.IsZero() {
+		t.Errorf("status mirror = %+v, want the last change", status)
+	}
+}
+
+func TestSync(t *testing.T) {
+	const secret = "s3cret"
+	primaryWiki := puttertest.NewTempWiki(t, testContent)
+	primary := newFixtureForWiki(t, primaryWiki,
+		putter.WithArchive(primaryWiki.Path("old"), testArchiveFormat),
+		putter.WithSync("", secret, 0),
+	)
+	defer primary.close()
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithSync(primary.http.URL+"/", secret, time.Hour),
+	)
+	defer f.close()
+	ctx := context.Background()
+	sync := func(want string) {
+		t.Helper()
+		if err := f.server.Sync(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if status := f.server.Status().Sync; status == nil || status.State != want {
+			t.Fatalf("status sync = %+v, want %s", status, want)
+		}
+	}
+
+	sync(putter.SyncInSync)
+	primary.put(testUpdated, http.Header{"If-Match": {primary.etag()}}, http.StatusOK)
+	sync(putter.SyncInSync)
+	if content := wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q after sync, want the primary's %q", content, testUpdated)
+	}
+
+	// Both are saved, so the primary's save is only archived
+	primary.put("primary", http.Header{"If-Match": {primary.etag()}}, http.StatusOK)
+	f.put("replica", http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+	sync(putter.SyncDiverged)
+	if content := wiki.Read(); content != "replica" {
+		t.Errorf("wiki = %q after diverging, want it unchanged", content)
+	}
+	found := false
+	for _, name := range wiki.List("old") {
+		content, err := ioutil.ReadFile(wiki.Path("old", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		found = found || string(content) == "primary"
+	}
+	if !found {
+		t.Errorf("archive = %v, want the primary's live wiki", wiki.List("old"))
+	}
+
+	// Saving resolves the divergence
+	f.put("resolved", http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+	sync(putter.SyncAhead)
+
+	res, _ := f.do(http.MethodGet, "/sync/manifest", "", http.Header{"Authorization": {"Bearer wrong"}})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("manifest with the wrong secret = %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+	res, body := f.do(http.MethodGet, "/sync/manifest", "", http.Header{"Authorization": {"Bearer " + secret}})
+	if res.StatusCode != http.StatusOK || strings.Contains(body, `"diverged"`) {
+		t.Errorf("manifest = %d %s, want no diverged versions once resolved", res.StatusCode, body)
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	f := newFixture(t, putter.WithStallTimeout(time.Second, 0))
+	defer f.close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(f.http.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Send part of the wiki, then nothing
+	_, err = fmt.Fprintf(conn, "PUT / HTTP/1.1\r\nHost: wiki\r\nContent-Length: 1000\r\n\r\n%s", testUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusRequestTimeout {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusRequestTimeout)
+	}
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+
+	// Uploads that keep coming aren't abandoned
+	f.put(testUpdated, nil, http.StatusOK)
+}
+
+func TestUploadLimiter(t *testing.T) {
+	limiter := putter.NewUploadLimiter(1, 0)
+	f := newFixture(t, putter.WithUploadLimiter(limiter))
+	defer f.close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(f.http.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Begin an upload, which holds the only slot until it is finished
+	_, err = fmt.Fprintf(conn, "PUT / HTTP/1.1\r\nHost: wiki\r\nContent-Length: %d\r\n\r\n", len(testUpdated))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; limiter.Status().Active == 0; i++ {
+		if i == 100 {
+			t.Fatal("upload never began")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	res := f.put(testUpdated+"!", nil, http.StatusTooManyRequests)
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("refused upload has no Retry-After header")
+	}
+	if uploads := f.server.Status().Uploads; uploads == nil || uploads.Limit != 1 || uploads.Active != 1 {
+		t.Errorf("status uploads = %+v, want 1 of 1 active", uploads)
+	}
+
+	_, err = fmt.Fprint(conn, testUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	res, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("first upload status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	if active := limiter.Status().Active; active != 0 {
+		t.Errorf("%d uploads active after they finished", active)
+	}
+	f.put(testContent, nil, http.StatusOK)
+}
+
+func TestResumableUpload(t *testing.T) {
+	f := newFixture(t, putter.WithResumableUploads(time.Hour))
+	defer f.close()
+
+	tus := func(header http.Header) http.Header {
+		header.Set("Tus-Resumable", "1.0.0")
+		return header
+	}
+	res, _ := f.do(http.MethodPost, "/resumable/", "", http.Header{"Upload-Length": {"100"}})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("POST without Tus-Resumable status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+
+	create := func() string {
+		t.Helper()
+		res, _ := f.do(http.MethodPost, "/resumable/", "", tus(http.Header{
+			"Upload-Length": {strconv.Itoa(len(testUpdated))},
+			"If-Match":      {f.etag()},
+		}))
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("POST status = %d, want %d", res.StatusCode, http.StatusCreated)
+		}
+		return "/resumable/" + res.Header.Get("Location")
+	}
+	patch := func(location string, offset int, body string, status int) *http.Response {
+		t.Helper()
+		res, _ := f.do(http.MethodPatch, location, body, tus(http.Header{
+			"Content-Type":  {"application/offset+octet-stream"},
+			"Upload-Offset": {strconv.Itoa(offset)},
+		}))
+		if res.StatusCode != status {
+			t.Fatalf("PATCH status = %d, want %d", res.StatusCode, status)
+		}
+		return res
+	}
+
+	// Send part of the upload, then resume from where the server says it is
+	location := create()
+	patch(location, 0, testUpdated[:5], http.StatusNoContent)
+	patch(location, 0, testUpdated, http.StatusConflict)
+	res, _ = f.do(http.MethodHead, location, "", tus(http.Header{}))
+	if offset := res.Header.Get("Upload-Offset"); offset != "5" {
+		t.Fatalf("HEAD Upload-Offset = %q, want 5", offset)
+	}
+	if content := f.wiki.Read(); content != testContent {
+		t.Fatalf("wiki saved before the upload was complete: %q", content)
+	}
+	res = patch(location, 5, testUpdated[5:], http.StatusNoContent)
+	if content := f.wiki.Read(); content != testUpdated {
+		t.Fatalf("wiki = %q, want %q", content, testUpdated)
+	}
+	if etag := res.Header.Get("ETag"); etag != f.etag() {
+		t.Errorf("completed upload ETag = %q, want %q", etag, f.etag())
+	}
+	res, _ = f.do(http.MethodHead, location, "", tus(http.Header{}))
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("HEAD of saved upload status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+
+	// An upload that conflicts with a save made meanwhile is refused
+	location = create()
+	f.put(testContent, http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+	patch(location, 0, testUpdated, http.StatusPreconditionFailed)
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("conflicting upload was saved: %q", content)
+	}
+
+	location = create()
+	res, _ = f.do(http.MethodDelete, location, "", tus(http.Header{}))
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("DELETE status = %d, want %d", res.StatusCode, http.StatusNoContent)
+	}
+	patch(location, 0, testUpdated, http.StatusNotFound)
+}
+
+func TestWikiPath(t *testing.T) {
+	f := newFixture(t, putter.WithWikiPath("/notes/", false), putter.WithManifest())
+	defer f.close()
+
+	res, body := f.do(http.MethodGet, "/notes", "", nil)
+	if res.StatusCode != http.StatusOK || body != testContent {
+		t.Errorf("GET /notes = %d %q, want %q", res.StatusCode, body, testContent)
+	}
+	res, _ = f.do(http.MethodPut, "/notes", testUpdated, http.Header{"If-Match": {res.Header.Get("ETag")}})
+	if res.StatusCode != http.StatusOK || f.wiki.Read() != testUpdated {
+		t.Errorf("PUT /notes status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	for _, path := range []string{"/", "/notes/", "/other"} {
+		res, _ = f.do(http.MethodGet, path, "", nil)
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want %d", path, res.StatusCode, http.StatusNotFound)
+		}
+	}
+	_, body = f.do(http.MethodGet, "/manifest.webmanifest", "", nil)
+	if !strings.Contains(body, `"start_url":"notes"`) {
+		t.Errorf("manifest = %s, want it to start at notes", body)
+	}
+
+	f = newFixture(t, putter.WithWikiPath("/wiki/notes", true))
+	defer f.close()
+	res, _ = f.do(http.MethodGet, "/", "", nil)
+	if location := res.Header.Get("Location"); res.StatusCode != http.StatusFound || location != "wiki/notes" {
+		t.Errorf("GET / = %d to %q, want a redirect to wiki/notes", res.StatusCode, location)
+	}
+
+	_, err := putter.NewServer(f.wiki.FileName, putter.WithWikiPath("/status", false))
+	if err == nil {
+		t.Error("NewServer accepted a wiki path putter serves itself")
+	}
+}
+
+func TestWikiAliases(t *testing.T) {
+	f := newFixture(t, putter.WithWikiAliases("/index.html", "/old/wiki/"))
+	defer f.close()
+
+	for path, want := range map[string]string{"/index.html": "./", "/old/wiki?x=1": "../?x=1"} {
+		res, _ := f.do(http.MethodGet, path, "", nil)
+		if location := res.Header.Get("Location"); res.StatusCode != http.StatusMovedPermanently || location != want {
+			t.Errorf("GET %s = %d to %q, want a redirect to %q", path, res.StatusCode, location, want)
+		}
+	}
+	res, _ := f.do(http.MethodPut, "/index.html", testUpdated, http.Header{"If-Match": {f.etag()}})
+	if res.StatusCode != http.StatusOK || f.wiki.Read() != testUpdated {
+		t.Errorf("PUT /index.html status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	_, err := putter.NewServer(f.wiki.FileName, putter.WithWikiAliases("/stats"))
+	if err == nil {
+		t.Error("NewServer accepted an alias putter serves itself")
+	}
+}
+
+func TestInjection(t *testing.T) {
+	const banner = `<div class="banner">staging</div>`
+	f := newFixture(t, putter.WithInjection([]byte(banner)), putter.WithCompression(gzip.BestSpeed))
+	defer f.close()
+	f.put("<html><body>wiki</body></html>", nil, http.StatusOK)
+
+	want := "<html><body>wiki" + banner + "</body></html>"
+	res, body := f.do(http.MethodGet, "/", "", nil)
+	if body != want || res.Header.Get("ETag") != f.etag() {
+		t.Errorf("GET / = %q with ETag %s, want %q with the wiki's ETag %s", body, res.Header.Get("ETag"), want, f.etag())
+	}
+	res, body = f.do(http.MethodGet, "/", "", http.Header{"Accept-Encoding": {"gzip"}})
+	r, err := gzip.NewReader(bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil || string(data) != want {
+		t.Errorf("gzipped GET / = %q, %v, want %q", data, err, want)
+	}
+	if content := f.wiki.Read(); content != "<html><body>wiki</body></html>" {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+
+	// Saved back by TiddlyWiki Classic, it isn't injected twice
+	f.put(want, nil, http.StatusOK)
+	_, body = f.do(http.MethodGet, "/", "", nil)
+	if body != want {
+		t.Errorf("GET / = %q, want %q", body, want)
+	}
+}
+
+func TestIfMatchList(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	f.put(testUpdated, http.Header{"If-Match": {`"stale", W/"weak,ish", ` + f.etag()}}, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {`"stale"`, f.etag()}}, http.StatusOK)
+	f.put(testUpdated, http.Header{"If-Match": {"*"}}, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {`"stale", W/` + f.etag()}}, http.StatusPreconditionFailed)
+}
+
+func TestWeakETags(t *testing.T) {
+	f := newFixture(t, putter.WithWeakETags())
+	defer f.close()
+
+	etag := f.etag()
+	f.put(testUpdated, http.Header{"If-Match": {"W/" + etag}}, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {strings.TrimSuffix(f.etag(), `"`) + `-gzip"`}}, http.StatusOK)
+	f.put(testUpdated, http.Header{"If-Match": {`W/"stale"`}}, http.StatusPreconditionFailed)
+
+	f = newFixture(t)
+	defer f.close()
+	f.put(testUpdated, http.Header{"If-Match": {"W/" + f.etag()}}, http.StatusPreconditionFailed)
+}
+
+func TestPutConflict(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	var conflict *putter.ConflictContext
+	f.server.AddHooks(putter.Hooks{
+		OnConflict: func(ctx *putter.ConflictContext) { conflict = ctx },
+	})
+
+	f.put(testUpdated, http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed)
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+	if conflict == nil {
+		t.Fatal("OnConflict hook wasn't called")
+	}
+	if conflict.ClientETag != `"stale"` || conflict.ServerETag != f.etag() {
+		t.Errorf("conflict = %+v, want client \"stale\" and server %s", conflict, f.etag())
+	}
+}
+
+func TestPutSha256(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	sum := sha256.Sum256([]byte(testUpdated))
+	digest := hex.EncodeToString(sum[:])
+
+	f.put(testUpdated, http.Header{"X-Putter-Sha256": {strings.Repeat("0", 64)}}, http.StatusBadRequest)
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+
+	res := f.put(testUpdated, http.Header{"X-Putter-Sha256": {strings.ToUpper(digest)}}, http.StatusOK)
+	if got := res.Header.Get("X-Putter-Sha256"); got != digest {
+		t.Errorf("response digest = %s, want %s", got, digest)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := f.wiki.List("old")
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want exactly one", archives)
+	}
+
+	res, body := f.do(http.MethodGet, "/old/"+archives[0], "", nil)
+	if res.StatusCode != http.StatusOK || body != testContent {
+		t.Errorf("GET archive = %d %q, want %d %q", res.StatusCode, body, http.StatusOK, testContent)
+	}
+	res, _ = f.do(http.MethodOptions, "/old/", "", nil)
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("OPTIONS archive status = %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestArchiveBrowser(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+
+	for i := 0; i < 3; i++ {
+		f.put(testUpdated+strings.Repeat(" ", i), nil, http.StatusOK)
+	}
+	archives := f.wiki.List("old")
+	if len(archives) != 3 {
+		t.Fatalf("archives = %v, want three", archives)
+	}
+
+	_, body := f.do(http.MethodGet, "/old/?sort=name&order=asc&per=2", "", nil)
+	if !strings.Contains(body, archives[0]) || !strings.Contains(body, archives[1]) || strings.Contains(body, archives[2]) {
+		t.Errorf("first page doesn't list exactly the first two archives: %s", body)
+	}
+	if !strings.Contains(body, "page 1 of 2") {
+		t.Errorf("first page doesn't show the page count: %s", body)
+	}
+	_, body = f.do(http.MethodGet, "/old/?sort=name&order=asc&per=2&page=2", "", nil)
+	if strings.Contains(body, archives[0]) || !strings.Contains(body, archives[2]) {
+		t.Errorf("second page doesn't list exactly the last archive: %s", body)
+	}
+
+	res, _ := f.do(http.MethodGet, "/old/"+archives[0], "", nil)
+	if csp := res.Header.Get("Content-Security-Policy"); !strings.Contains(csp, "sandbox") {
+		t.Errorf("archive served with Content-Security-Policy %q, want a sandbox", csp)
+	}
+	res, _ = f.do(http.MethodGet, "/old/"+archives[0]+"?download", "", nil)
+	if disposition := res.Header.Get("Content-Disposition"); !strings.HasPrefix(disposition, "attachment") {
+		t.Errorf("download served with Content-Disposition %q", disposition)
+	}
+}
+
+func TestArchiveConditional(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithCompression(gzip.BestSpeed),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := f.wiki.List("old")
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want one", archives)
+	}
+	path := "/old/" + archives[0]
+	for _, encoding := range []string{"", "gzip"} {
+		res, body := f.do(http.MethodGet, path, "", http.Header{"Accept-Encoding": {encoding}})
+		etag := res.Header.Get("ETag")
+		if res.StatusCode != http.StatusOK || etag == "" || res.Header.Get("Last-Modified") == "" {
+			t.Fatalf("GET %s with %q = %d, ETag %q, want validators", path, encoding, res.StatusCode, etag)
+		}
+		if res.Header.Get("Content-Encoding") != encoding {
+			t.Errorf("GET %s with %q Content-Encoding = %q", path, encoding, res.Header.Get("Content-Encoding"))
+		}
+		if encoding == "gzip" {
+			gz, err := gzip.NewReader(strings.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := ioutil.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body = string(content)
+		}
+		if body != testContent {
+			t.Errorf("GET %s with %q = %q, want %q", path, encoding, body, testContent)
+		}
+
+		for _, header := range []http.Header{
+			{"If-None-Match": {etag}},
+			{"If-Modified-Since": {res.Header.Get("Last-Modified")}},
+		} {
+			header.Set("Accept-Encoding", encoding)
+			res, body = f.do(http.MethodGet, path, "", header)
+			if res.StatusCode != http.StatusNotModified || body != "" {
+				t.Errorf("GET %s with %v = %d %q, want %d", path, header, res.StatusCode, body, http.StatusNotModified)
+			}
+		}
+	}
+}
+
+func TestContentTypeAndCacheControl(t *testing.T) {
+	const contentType = "text/html; charset=windows-1252"
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithContentType(contentType),
+		putter.WithCacheControl("no-cache", "max-age=31536000, immutable"),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := f.wiki.List("old")
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want one", archives)
+	}
+	tests := []struct {
+		path         string
+		status       int
+		contentType  string
+		cacheControl string
+	}{
+		{"/", http.StatusOK, contentType, "no-cache"},
+		{"/old/" + archives[0], http.StatusOK, contentType, "max-age=31536000, immutable"},
+		{"/old/missing.html", http.StatusNotFound, "", ""},
+		{"/old/", http.StatusOK, "text/html; charset=utf-8", ""},
+	}
+	for _, test := range tests {
+		res, _ := f.do(http.MethodGet, test.path, "", nil)
+		if res.StatusCode != test.status {
+			t.Errorf("GET %s status = %d, want %d", test.path, res.StatusCode, test.status)
+		}
+		if test.contentType != "" && res.Header.Get("Content-Type") != test.contentType {
+			t.Errorf("GET %s Content-Type = %q, want %q", test.path, res.Header.Get("Content-Type"), test.contentType)
+		}
+		if res.Header.Get("Cache-Control") != test.cacheControl {
+			t.Errorf("GET %s Cache-Control = %q, want %q", test.path, res.Header.Get("Cache-Control"), test.cacheControl)
+		}
+	}
+}
+
+func TestStatic(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	for _, dir := range []string{"static/css", "static/.git", "static/docs"} {
+		if err := os.MkdirAll(wiki.Path(dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		"static/css/style.css":   "body {}",
+		"static/.git/config":     "secret",
+		"static/docs/index.html": "docs",
+	} {
+		if err := ioutil.WriteFile(wiki.Path(name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFixtureForWiki(t, wiki, putter.WithStatic(wiki.Path("static"), "/static/"))
+	defer f.close()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		body   string
+	}{
+		{http.MethodGet, "/static/css/style.css", http.StatusOK, "body {}"},
+		{http.MethodHead, "/static/css/style.css", http.StatusOK, ""},
+		{http.MethodGet, "/static/docs/", http.StatusOK, "docs"},
+		{http.MethodGet, "/static/.git/config", http.StatusNotFound, ""},
+		{http.MethodGet, "/static/", http.StatusNotFound, ""},
+		{http.MethodGet, "/static/css/", http.StatusNotFound, ""},
+		{http.MethodPut, "/static/css/style.css", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		res, body := f.do(test.method, test.path, "", nil)
+		if res.StatusCode != test.status {
+			t.Errorf("%s %s status = %d, want %d", test.method, test.path, res.StatusCode, test.status)
+		}
+		if test.body != "" && body != test.body {
+			t.Errorf("%s %s body = %q, want %q", test.method, test.path, body, test.body)
+		}
+	}
+	if wiki.Read() != testContent {
+		t.Error("wiki changed")
+	}
+}
+
+func TestArchiveModes(t *testing.T) {
+	modes := []string{
+		putter.ArchiveModeAuto,
+		putter.ArchiveModeCopy,
+		putter.ArchiveModeLink,
+		putter.ArchiveModeReflink,
+	}
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			wiki := puttertest.NewTempWiki(t, testContent)
+			f := newFixtureForWiki(t, wiki,
+				putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+				putter.WithArchiveMode(mode),
+			)
+			defer f.close()
+
+			f.put(testUpdated, nil, http.StatusOK)
+			f.put(testContent, nil, http.StatusOK)
+			archives := wiki.List("old")
+			if len(archives) != 2 {
+				t.Fatalf("archives = %v, want two", archives)
+			}
+			for i, want := range []string{testContent, testUpdated} {
+				data, err := ioutil.ReadFile(wiki.Path("old", archives[i]))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != want {
+					t.Errorf("archive %d = %q, want %q", i, data, want)
+				}
+			}
+		})
+	}
+}
+
+func TestArchiveTimezone(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), "2006-01-02-15-04-05.000-0700.html"),
+		putter.WithArchiveTimezone(time.FixedZone("Kiribati", 14*60*60)),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := wiki.List("old")
+	if len(archives) != 1 || !strings.HasSuffix(archives[0], "+1400.html") {
+		t.Errorf("archives = %v, want one named in UTC+14", archives)
+	}
+}
+
+func TestNoArchive(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	if archives := f.wiki.List("old"); archives != nil {
+		t.Errorf("archives = %v, want none", archives)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		option putter.Option
+	}{
+		{"cached", putter.WithCompression(gzip.BestCompression)},
+		{"dynamic", putter.WithDynamicCompression(gzip.BestSpeed)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFixture(t, test.option)
+			defer f.close()
+			f.put(testUpdated, nil, http.StatusOK)
+
+			res, body := f.do(http.MethodGet, "/", "", http.Header{"Accept-Encoding": {"gzip"}})
+			if encoding := res.Header.Get("Content-Encoding"); encoding != "gzip" {
+				t.Fatalf("Content-Encoding = %q, want gzip", encoding)
+			}
+			if res.Header.Get("ETag") != f.etag() {
+				t.Errorf("gzipped ETag = %s, want %s", res.Header.Get("ETag"), f.etag())
+			}
+			r, err := gzip.NewReader(bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != testUpdated {
+				t.Errorf("decompressed body = %q, want %q", data, testUpdated)
+			}
+
+			res, body = f.do(http.MethodGet, "/", "", nil)
+			if res.Header.Get("Content-Encoding") != "" || body != testUpdated {
+				t.Errorf("uncompressed GET = %q, want %q", body, testUpdated)
+			}
+		})
+	}
+}
+
+func TestNoCompression(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, body := f.do(http.MethodGet, "/", "", http.Header{"Accept-Encoding": {"gzip"}})
+	if res.Header.Get("Content-Encoding") != "" || body != testContent {
+		t.Errorf("GET = %q (Content-Encoding %q), want it uncompressed",
+			body, res.Header.Get("Content-Encoding"))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	var saved *putter.SaveContext
+	reject := true
+	f.server.AddHooks(putter.Hooks{
+		BeforeSave: func(ctx *putter.SaveContext) error {
+			if reject {
+				return &putter.StatusError{Code: http.StatusUnauthorized, Message: "nope"}
+			}
+			return nil
+		},
+		AfterSave: func(ctx *putter.SaveContext) { saved = ctx },
+	})
+
+	f.put(testUpdated, nil, http.StatusUnauthorized)
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want it unchanged", content)
+	}
+	if saved != nil {
+		t.Error("AfterSave hook was called for a rejected save")
+	}
+
+	reject = false
+	oldEtag := f.etag()
+	res := f.put(testUpdated, nil, http.StatusOK)
+	if saved == nil {
+		t.Fatal("AfterSave hook wasn't called")
+	}
+	if saved.ETag != res.Header.Get("ETag") || saved.PreviousETag != oldEtag {
+		t.Errorf("save = %+v, want ETag %s and previous %s", saved, res.Header.Get("ETag"), oldEtag)
+	}
+	if saved.Size != int64(len(testUpdated)) {
+		t.Errorf("save size = %d, want %d", saved.Size, len(testUpdated))
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+	s, err := putter.NewServer(wiki.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "wrapped")
+			h.ServeHTTP(w, r)
+		})
+	})
+
+	w := httptest.NewRecorder()
+	putter.NewHandler(s, "").ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Header().Get("X-Test") != "wrapped" {
+		t.Error("middleware wasn't applied")
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+	s, err := putter.NewServer(wiki.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Use(func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("panic") != "" {
+				panic("buggy middleware")
+			}
+			h.ServeHTTP(w, r)
+		})
+	})
+	h := putter.NewHandler(s, "")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?panic=1", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("X-Request-ID") == "" {
+		t.Errorf("panicking request = %d (X-Request-ID %q), want %d with an ID", w.Code, w.Header().Get("X-Request-ID"), http.StatusInternalServerError)
+	}
+	if panics := s.Status().Panics; panics != 1 {
+		t.Errorf("status counted %d panics, want 1", panics)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != testContent {
+		t.Errorf("GET after a panic = %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+	var mu sync.Mutex
+	var ids []string
+	defer f.server.Subscribe(func(e putter.Event) {
+		mu.Lock()
+		ids = append(ids, e.RequestID)
+		mu.Unlock()
+	})()
+
+	res := f.put(testUpdated, http.Header{"X-Request-ID": {"save-1"}}, http.StatusOK)
+	if id := res.Header.Get("X-Request-ID"); id != "save-1" {
+		t.Errorf("X-Request-ID = %q, want the client's", id)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != "save-1" || ids[1] != "save-1" {
+		t.Errorf("event request IDs = %q, want the client's for SaveStarted and SaveCompleted", ids)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	f := newFixture(t, putter.WithWatch())
+	defer f.close()
+
+	oldEtag := f.etag()
+	f.wiki.Write(testUpdated + "externally")
+	if f.etag() == oldEtag {
+		t.Error("ETag didn't change after external modification")
+	}
+	f.put(testUpdated, http.Header{"If-Match": {oldEtag}}, http.StatusPreconditionFailed)
+}
+
+func TestETagCache(t *testing.T) {
+	f := newFixture(t, putter.WithETagCache())
+	defer f.close()
+	f.put(testUpdated, nil, http.StatusOK)
+	etag := f.etag()
+
+	// Doctor the cache to prove that a restarted server trusts it
+	data, err := ioutil.ReadFile(f.wiki.FileName + ".etag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doctored := strings.Replace(string(data), etag, `"cached"`, 1)
+	err = ioutil.WriteFile(f.wiki.FileName+".etag", []byte(doctored), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.http.Close()
+	f.server.Close()
+	*f = *newFixtureForWiki(t, f.wiki, putter.WithETagCache())
+	if f.etag() != `"cached"` {
+		t.Errorf("ETag = %s, want the cached ETag", f.etag())
+	}
+}
+
+func TestRecoverJournal(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+
+	upload := wiki.Path("tiddlywiki-upload-index.html-1")
+	err := ioutil.WriteFile(upload, []byte(testUpdated), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The ETag of the upload, computed with the default MD5 algorithm
+	sum := md5.Sum([]byte(testUpdated))
+	journal := `{"upload":"` + upload + `","etag":"\"` + hex.EncodeToString(sum[:]) + `\""}`
+	err = ioutil.WriteFile(wiki.FileName+".journal", []byte(journal), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+	if content := wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q, want the interrupted upload", content)
+	}
+	if archives := wiki.List("old"); len(archives) != 1 {
+		t.Errorf("archives = %v, want the previous wiki", archives)
+	}
+	if _, err := os.Stat(wiki.FileName + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("journal wasn't removed: %v", err)
+	}
+}
+
+func TestRecoverOrphans(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+
+	orphans := []string{
+		wiki.Path("tiddlywiki-upload-index.html-1"),
+		wiki.FileName + ".gz.tmp",
+		wiki.FileName + ".bak",
+	}
+	for _, orphan := range orphans {
+		err := ioutil.WriteFile(orphan, []byte("orphan"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := wiki.Path("tiddlywiki-upload-other.html-1")
+	err := ioutil.WriteFile(other, []byte("other"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFixtureForWiki(t, wiki)
+	defer f.close()
+	for _, orphan := range orphans {
+		if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+			t.Errorf("orphan %s wasn't removed: %v", orphan, err)
+		}
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("another wiki's upload was removed: %v", err)
+	}
+}
+
+func TestNewServerErrors(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+
+	tests := []struct {
+		name     string
+		fileName string
+		options  []putter.Option
+	}{
+		{"missing wiki", wiki.Path("missing.html"), nil},
+		{"archive mode", wiki.FileName, []putter.Option{putter.WithArchiveMode("bogus")}},
+		{"compression level", wiki.FileName, []putter.Option{putter.WithCompression(42)}},
+		{"error pages", wiki.FileName, []putter.Option{putter.WithErrorPages(wiki.Path("missing"))}},
+	}
+	for _, test := range tests {
+		s, err := putter.NewServer(test.fileName, test.options...)
+		if err == nil {
+			s.Close()
+			t.Errorf("%s: NewServer succeeded, want an error", test.name)
+		}
+	}
+}
+
+func TestNewServerLocked(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	s, err := putter.NewServer(f.wiki.FileName)
+	if !errors.Is(err, putter.ErrLocked) {
+		if err == nil {
+			s.Close()
+		}
+		t.Fatalf("second NewServer error = %v, want ErrLocked", err)
+	}
+
+	f.server.Close()
+	s, err = putter.NewServer(f.wiki.FileName)
+	if err != nil {
+		t.Fatalf("NewServer after Close failed: %v", err)
+	}
+	s.Close()
+}
+
+func TestErrorPages(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, body := f.do(http.MethodPut, "/", testUpdated, http.Header{"If-Match": {`"stale"`}})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") || !strings.Contains(body, "Reload") {
+		t.Errorf("saver got %q (%s), want a plain-text explanation", body, res.Header.Get("Content-Type"))
+	}
+
+	res, body = f.do(http.MethodGet, "/missing", "", http.Header{"Accept": {"text/html"}})
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+	if !strings.Contains(body, "<h1>Not Found</h1>") {
+		t.Errorf("browser got %q, want the built-in page", body)
+	}
+}
+
+func TestCustomErrorPages(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	err := os.Mkdir(wiki.Path("errors"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"404.html":   "custom {{.Status}}: {{.Message}}",
+		"error.html": "fallback {{.Status}}",
+	} {
+		err = ioutil.WriteFile(wiki.Path("errors", name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFixtureForWiki(t, wiki, putter.WithErrorPages(wiki.Path("errors")))
+	defer f.close()
+
+	html := http.Header{"Accept": {"text/html"}}
+	_, body := f.do(http.MethodGet, "/missing", "", html)
+	if !strings.HasPrefix(body, "custom 404: There is nothing here.") {
+		t.Errorf("404 page = %q", body)
+	}
+	html.Set("If-Match", `"stale"`)
+	_, body = f.do(http.MethodPut, "/", testUpdated, html)
+	if body != "fallback 412" {
+		t.Errorf("412 page = %q", body)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithStatus(),
+	)
+	defer f.close()
+
+	var status putter.Status
+	getStatus := func() {
+		res, body := f.do(http.MethodGet, "/status", "", nil)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+		}
+		status = putter.Status{}
+		err := json.Unmarshal([]byte(body), &status)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	getStatus()
+	if status.ETag != f.etag() || status.Size != int64(len(testContent)) || status.LastSave != nil {
+		t.Errorf("initial status = %+v", status)
+	}
+	if !status.Features.Archive || status.Features.Compress {
+		t.Errorf("features = %+v", status.Features)
+	}
+
+	f.put(testUpdated, nil, http.StatusOK)
+	getStatus()
+	if status.ETag != f.etag() || status.Size != int64(len(testUpdated)) || status.LastSave == nil {
+		t.Errorf("status after save = %+v", status)
+	}
+	if status.Archive == nil || status.Archive.Count != 1 || status.Archive.Size != int64(len(testContent)) {
+		t.Errorf("archive status = %+v", status.Archive)
+	}
+}
+
+func TestNoStatus(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/status", "", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestStats(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	defer wiki.Close()
+	f := newFixtureForWiki(t, wiki, putter.WithStats())
+
+	f.put(testUpdated, nil, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed)
+	res, body := f.do(http.MethodGet, "/stats", "", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	var stats putter.Stats
+	err := json.Unmarshal([]byte(body), &stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := stats.Total
+	if total.Saves != 1 || total.Conflicts != 1 || total.Failures != 0 || total.Size != int64(len(testUpdated)) {
+		t.Errorf("total = %+v", total)
+	}
+	if stats.ConflictRate != 0.5 {
+		t.Errorf("conflict rate = %v, want 0.5", stats.ConflictRate)
+	}
+	if len(stats.Hourly) != 1 || len(stats.Daily) != 1 || stats.Hourly[0].Saves != 1 {
+		t.Errorf("history = %+v, %+v", stats.Hourly, stats.Daily)
+	}
+
+	// The history survives a restart
+	f.http.Close()
+	f.server.Close()
+	s, err := putter.NewServer(wiki.FileName, putter.WithStats())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got := s.Stats().Total; got.Saves != total.Saves || got.Conflicts != total.Conflicts {
+		t.Errorf("total after restart = %+v, want %+v", got, total)
+	}
+}
+
+func TestClientStats(t *testing.T) {
+	f := newFixture(t, putter.WithStats())
+	defer f.close()
+	f.authenticate()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	f.put(testContent, http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed)
+	f.do(http.MethodGet, "/", "", nil)
+	req, err := http.NewRequest(http.MethodPut, f.http.URL+"/", strings.NewReader(testContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("alice", "password")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	clients := f.server.Stats().Clients
+	want := map[string]putter.ClientStats{
+		"127.0.0.1": {Saves: 1, Conflicts: 1, Uploaded: int64(len(testUpdated)), Downloaded: int64(len(testUpdated))},
+		"alice":     {Saves: 1, Uploaded: int64(len(testContent))},
+	}
+	if len(clients) != len(want) {
+		t.Errorf("clients = %+v, want %+v", clients, want)
+	}
+	for name, w := range want {
+		got := clients[name]
+		if got.LastSeen.IsZero() {
+			t.Errorf("client %s wasn't seen", name)
+		}
+		got.LastSeen = time.Time{}
+		if got != w {
+			t.Errorf("client %s = %+v, want %+v", name, got, w)
+		}
+	}
+}
+
+func TestNoStats(t *testing.T) {
+	f := newFixture(t)
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/stats", "", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestShrinkLimit(t *testing.T) {
+	f := newFixture(t, putter.WithShrinkLimit(50))
+	defer f.close()
+	events, unsubscribe := f.server.Events(10)
+	defer unsubscribe()
+
+	f.put("<html></html>", nil, http.StatusOK)
+	f.put("<html>much smaller than before</html>", nil, http.StatusOK)
+	f.put("<p></p>", nil, http.StatusConflict)
+	_, body := f.do(http.MethodGet, "/", "", nil)
+	if body != "<html>much smaller than before</html>" {
+		t.Errorf("body = %q after a held save", body)
+	}
+	held := f.server.Held()
+	if held == nil || held.Size != int64(len("<p></p>")) || held.PreviousSize != int64(len(body)) {
+		t.Errorf("held save = %+v", held)
+	}
+	var types []string
+	for len(events) > 0 {
+		types = append(types, (<-events).Type.String())
+	}
+	if !strings.Contains(strings.Join(types, " "), "SaveHeld SaveFailed") {
+		t.Errorf("events = %v, want SaveHeld", types)
+	}
+
+	// Approving the held save would undo a save made since
+	f.put("<html>saved while the other was held</html>", nil, http.StatusOK)
+	if err := f.server.ApproveHeld(context.Background()); err != putter.ErrHeldConflict {
+		t.Errorf("ApproveHeld after another save = %v, want %v", err, putter.ErrHeldConflict)
+	}
+	if err := f.server.DiscardHeld(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.put("<p></p>", http.Header{"X-Putter-Confirm-Shrink": {"true"}}, http.StatusOK)
+	_, body = f.do(http.MethodGet, "/", "", nil)
+	if body != "<p></p>" {
+		t.Errorf("body = %q after a confirmed save", body)
+	}
+
+	// A save approved before the wiki changes replaces it
+	f.put("<html>much smaller than before</html>", nil, http.StatusOK)
+	f.put("<p>held</p>", nil, http.StatusConflict)
+	if err := f.server.ApproveHeld(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, body = f.do(http.MethodGet, "/", "", nil); body != "<p>held</p>" {
+		t.Errorf("body = %q after approving the held save", body)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat), putter.WithQuota(64))
+	defer f.close()
+
+	// Each save adds the version it replaces to the archive
+	f.put(testUpdated, nil, http.StatusOK)
+	f.put(testUpdated+"!", nil, http.StatusOK)
+	res, body := f.do(http.MethodPut, "/", testUpdated, nil)
+	if res.StatusCode != http.StatusInsufficientStorage || !strings.Contains(body, "over its quota of 64B") {
+		t.Errorf("save over quota = %d %q, want %d", res.StatusCode, body, http.StatusInsufficientStorage)
+	}
+	if content := wiki.Read(); content != testUpdated+"!" {
+		t.Errorf("wiki = %q after a save over quota", content)
+	}
+	quota := f.server.Status().Quota
+	if quota == nil || quota.Used != int64(len(testContent+testUpdated+testUpdated+"!")) || quota.Limit != 64 {
+		t.Errorf("quota status = %+v", quota)
+	}
+}
+
+func TestVerification(t *testing.T) {
+	f := newFixture(t, putter.WithCompression(1), putter.WithVerification(true), putter.WithReadOnlyRetry(0))
+	defer f.close()
+
+	corrupt := true
+	f.server.AddHooks(putter.Hooks{
+		// Stands in for storage that changes the upload after it was hashed
+		BeforeSave: func(ctx *putter.SaveContext) error {
+			if corrupt {
+				return ioutil.WriteFile(ctx.Upload, []byte(testUpdated+"?"), 0644)
+			}
+			return nil
+		},
+	})
+	events, unsubscribe := f.server.Events(16)
+	oldEtag := f.etag()
+	f.put(testUpdated, nil, http.StatusInternalServerError)
+	unsubscribe()
+	if content := f.wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q after failed verification, want it rolled back", content)
+	}
+	if etag := f.etag(); etag != oldEtag {
+		t.Errorf("ETag = %s after failed verification, want %s", etag, oldEtag)
+	}
+	var failed bool
+	for e := range events {
+		failed = failed || e.Type == putter.EventVerificationFailed
+	}
+	if !failed {
+		t.Error("no VerificationFailed event")
+	}
+
+	corrupt = false
+	f.put(testUpdated, nil, http.StatusOK)
+	if content := f.wiki.Read(); content != testUpdated {
+		t.Errorf("wiki = %q, want %q", content, testUpdated)
+	}
+}
+
+func TestVersionCache(t *testing.T) {
+	tiddlers := func(edited string) string {
+		return `<html><div id="storeArea"><div title="Kept"><pre>same</pre></div><div title="Edited"><pre>` + edited + `</pre></div></div></html>`
+	}
+	wiki := puttertest.NewTempWiki(t, tiddlers("before"))
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithVersionCache(1<<20),
+	)
+	defer f.close()
+
+	oldEtag := f.etag()
+	f.put(tiddlers("after"), nil, http.StatusOK)
+	res, body := f.do(http.MethodPut, "/", tiddlers("mine"), http.Header{"If-Match": {oldEtag}})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("conflicting save status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+	if !strings.Contains(body, "Changed since your copy: Edited.") {
+		t.Errorf("conflict = %q, want the changed tiddler named", body)
+	}
+
+	// The archived version is restored from memory, not the doctored file
+	archives := wiki.List("old")
+	if len(archives) != 1 {
+		t.Fatalf("archives = %v, want one", archives)
+	}
+	err := ioutil.WriteFile(wiki.Path("old", archives[0]), []byte("doctored"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.server.Restore(context.Background(), archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := wiki.Read(); content != tiddlers("before") {
+		t.Errorf("wiki = %q, want the version kept in memory", content)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	var icon bytes.Buffer
+	err := png.Encode(&icon, image.NewRGBA(image.Rect(0, 0, 192, 192)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	titled := func(title string) string {
+		store, _ := json.Marshal([]map[string]string{
+			{"title": "$:/SiteTitle", "text": title},
+			{"title": "$:/favicon.ico", "type": "image/png", "text": base64.StdEncoding.EncodeToString(icon.Bytes())},
+		})
+		return `<html><script class="tiddlywiki-tiddler-store" type="application/json">` + string(store) + `</script></html>`
+	}
+	wiki := puttertest.NewTempWiki(t, titled("My Wiki"))
+	f := newFixtureForWiki(t, wiki, putter.WithManifest())
+	defer f.close()
+
+	res, _ := f.do(http.MethodGet, "/", "", nil)
+	if link := res.Header.Get("Link"); link != `<manifest.webmanifest>; rel="manifest"` {
+		t.Errorf("Link = %q, want the manifest", link)
+	}
+
+	res, body := f.do(http.MethodGet, "/manifest.webmanifest", "", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/manifest+json" {
+		t.Fatalf("manifest = %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	var manifest struct {
+		Name    string
+		Display string
+		Icons   []struct{ Src, Type, Sizes string }
+	}
+	err = json.Unmarshal([]byte(body), &manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ Src, Type, Sizes string }{{"manifest-icon", "image/png", "192x192"}}
+	if manifest.Name != "My Wiki" || manifest.Display != "standalone" || !reflect.DeepEqual(manifest.Icons, want) {
+		t.Errorf("manifest = %s", body)
+	}
+	res, _ = f.do(http.MethodGet, "/manifest.webmanifest", "", http.Header{"If-None-Match": {res.Header.Get("ETag")}})
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("revalidation status = %d, want %d", res.StatusCode, http.StatusNotModified)
+	}
+
+	res, body = f.do(http.MethodGet, "/manifest-icon", "", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/png" || body != icon.String() {
+		t.Errorf("icon = %d %s, want the favicon", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	f.put(titled("Renamed"), nil, http.StatusOK)
+	_, body = f.do(http.MethodGet, "/manifest.webmanifest", "", nil)
+	if !strings.Contains(body, `"name":"Renamed"`) {
+		t.Errorf("manifest = %s after the title was changed", body)
+	}
+}
+
+func TestArchiveThreshold(t *testing.T) {
+	tiddlers := func(texts ...string) string {
+		var b strings.Builder
+		b.WriteString(`<html><div id="storeArea">`)
+		for i, text := range texts {
+			fmt.Fprintf(&b, `<div title="Tiddler %d"><pre>%s</pre></div>`, i, strings.Repeat(text[:1], 20)+text[1:])
+		}
+		b.WriteString(`</div></html>`)
+		return b.String()
+	}
+	wiki := puttertest.NewTempWiki(t, tiddlers("a", "b", "c", "d", "e"))
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveThreshold(10),
+	)
+	defer f.close()
+
+	saves := []struct {
+		content  string
+		archives int
+	}{
+		// There is no archive yet
+		{tiddlers("a", "b", "x", "y", "z"), 1},
+		// Three of five tiddlers changed since the newest archive
+		{tiddlers("a", "b", "x", "y", "z!"), 2},
+		// Only a byte or two changed since the newest archive
+		{tiddlers("a", "b", "x", "y", "z!!"), 2},
+		{tiddlers("a", "b", "x", "y", "z!!!"), 2},
+	}
+	for i, save := range saves {
+		f.put(save.content, nil, http.StatusOK)
+		if content := wiki.Read(); content != save.content {
+			t.Errorf("save %d: wiki wasn't updated", i)
+		}
+		if archives := wiki.List("old"); len(archives) != save.archives {
+			t.Errorf("save %d: archives = %v, want %d", i, archives, save.archives)
+		}
+	}
+
+	// A restore can always be undone
+	archives := wiki.List("old")
+	err := f.server.Restore(context.Background(), archives[len(archives)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archives := wiki.List("old"); len(archives) != 3 {
+		t.Errorf("archives = %v after a restore, want 3", archives)
+	}
+}
+
+func TestArchiveRetention(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, "<html>v0</html>")
+	// Archives from before the policy was set, one of them too old to keep
+	if err := os.MkdirAll(wiki.Path("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i, age := range []time.Duration{10 * 24 * time.Hour, time.Hour} {
+		name := wiki.Path("old", fmt.Sprintf("imported-%d.html", i))
+		if err := ioutil.WriteFile(name, []byte("<html>old</html>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveRetention(2, 7*24*time.Hour, false),
+	)
+	defer f.close()
+
+	if archives := wiki.List("old"); len(archives) != 1 || archives[0] != "imported-1.html" {
+		t.Errorf("archives at startup = %v, want only imported-1.html", archives)
+	}
+	for i := 1; i <= 3; i++ {
+		f.put(fmt.Sprintf("<html>v%d</html>", i), nil, http.StatusOK)
+	}
+	archives := wiki.List("old")
+	if len(archives) != 2 {
+		t.Fatalf("archives after 3 saves = %v, want 2", archives)
+	}
+	for _, name := range archives {
+		if name == "imported-1.html" {
+			t.Errorf("oldest archive %s was kept over newer ones", name)
+		}
+	}
+}
+
+func TestGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git isn't installed")
+	}
+	f := newFixture(t, putter.WithGit(""))
+	defer f.close()
+	f.authenticate()
+	git := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("git", append([]string{"-C", filepath.Dir(f.wiki.FileName)}, args...)...).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	// Commits are made in the background
+	waitForCommits := func(count int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if git("rev-list", "--count", "HEAD") == strconv.Itoa(count) {
+				return
+			}
+		}
+		t.Fatalf("commits = %s, want %d", git("rev-list", "--count", "HEAD"), count)
+	}
+
+	f.put("<html>v1</html>", nil, http.StatusOK)
+	waitForCommits(2)
+	f.put("<html>v2</html>", http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("alice:password"))}}, http.StatusOK)
+	waitForCommits(3)
+	subjects := strings.Split(git("log", "--format=%s"), "\n")
+	if len(subjects) != 3 || !strings.HasPrefix(subjects[0], "Save from 127.0.0.1 at ") || !strings.HasPrefix(subjects[2], "Wiki as found at startup") {
+		t.Errorf("commits = %q, want the wiki at startup and two saves", subjects)
+	}
+	if author := git("log", "-1", "--format=%an"); author != "alice" {
+		t.Errorf("author = %q, want alice", author)
+	}
+	if content := git("show", "HEAD:"+filepath.Base(f.wiki.FileName)); content != "<html>v2</html>" {
+		t.Errorf("committed wiki = %q, want the latest save", content)
+	}
+}
+
+func TestTimeTravel(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	err := os.Mkdir(wiki.Path("old"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each archived when the next version was saved
+	for name, content := range map[string]string{
+		"2024-05-01-12-00-00.000000000.html": "first",
+		"2024-05-03-12-00-00.000000000.html": "second",
+	} {
+		err = ioutil.WriteFile(wiki.Path("old", name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat), putter.WithTimeTravel())
+	defer f.close()
+
+	for version, want := range map[string]string{
+		"2024-04-30":           "first",
+		"2024-05-01T11:59":     "first",
+		"2024-05-01":           "second",
+		"2024-05-03T11:59:59":  "second",
+		"2024-05-03T12:00:01Z": testContent,
+		f.etag():               testContent,
+	} {
+		res, body := f.do(http.MethodGet, "/?version="+url.QueryEscape(version), "", nil)
+		if res.StatusCode != http.StatusOK || body != want {
+			t.Errorf("GET /?version=%s = %d %q, want %q", version, res.StatusCode, body, want)
+		}
+		if res.Header.Get("Content-Security-Policy") == "" {
+			t.Errorf("GET /?version=%s isn't sandboxed", version)
+		}
+	}
+
+	res, _ := f.do(http.MethodOptions, "/?version=2024-05-02", "", nil)
+	if dav := res.Header.Get("Dav"); dav != "" {
+		t.Errorf("OPTIONS of a past version Dav = %q, want none", dav)
+	}
+	res, _ = f.do(http.MethodPut, "/?version=2024-05-02", testUpdated, nil)
+	if res.StatusCode != http.StatusMethodNotAllowed || f.wiki.Read() != testContent {
+		t.Errorf("PUT of a past version = %d, want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+	res, _ = f.do(http.MethodGet, "/?version=yesterday", "", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("GET of an unknown version = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestVersions(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	originalEtag := f.etag()
+	f.put(testUpdated, nil, http.StatusOK)
+	updatedEtag := f.etag()
+	f.put(testUpdated+"!", nil, http.StatusOK)
+	f.http.Close()
+	f.server.Close()
+
+	// Archives written before the server started are hashed to be found
+	f = newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+	f.put(testUpdated+"!!", nil, http.StatusOK)
+	for _, version := range []struct{ etag, content string }{
+		{originalEtag, testContent},
+		{updatedEtag, testUpdated},
+		{f.etag(), testUpdated + "!!"},
+	} {
+		// With or without quotes
+		for _, path := range []string{strings.Trim(version.etag, `"`), url.PathEscape(version.etag)} {
+			res, body := f.do(http.MethodGet, "/versions/"+path, "", nil)
+			if res.StatusCode != http.StatusOK || body != version.content || res.Header.Get("ETag") != version.etag {
+				t.Errorf("GET /versions/%s = %d %q, want %q", path, res.StatusCode, body, version.content)
+			}
+		}
+	}
+	res, _ := f.do(http.MethodGet, "/versions/"+strings.Trim(originalEtag, `"`), "", nil)
+	if name := res.Header.Get("X-Putter-Archive"); name != wiki.List("old")[0] {
+		t.Errorf("X-Putter-Archive = %q, want the oldest archive", name)
+	}
+
+	res, _ = f.do(http.MethodGet, "/versions/unknown", "", nil)
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown version status = %d, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func TestAuthors(t *testing.T) {
+	as := func(user, password string) http.Header {
+		req, _ := http.NewRequest(http.MethodPut, "/", nil)
+		req.SetBasicAuth(user, password)
+		return req.Header
+	}
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	f.authenticate()
+	f.put(testUpdated, as("alice", "password"), http.StatusOK)
+	f.put(testUpdated+"!", as("bob", "password"), http.StatusOK)
+	// A user name that wasn't checked is no author
+	f.put(testUpdated+"!!", as("mallory", "wrong"), http.StatusOK)
+	f.http.Close()
+	f.server.Close()
+
+	// Authors are remembered across restarts
+	f = newFixtureForWiki(t, wiki, putter.WithArchive(wiki.Path("old"), testArchiveFormat))
+	defer f.close()
+	archives, err := f.server.Archives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var savedBy []string
+	for _, entry := range archives {
+		savedBy = append(savedBy, entry.SavedBy)
+	}
+	if want := []string{"bob", "alice", ""}; !reflect.DeepEqual(savedBy, want) {
+		t.Errorf("archives saved by %q, want %q", savedBy, want)
+	}
+
+	_, body := f.do(http.MethodGet, "/old/", "", nil)
+	if !strings.Contains(body, "Saved by") || !strings.Contains(body, "<td>alice</td>") {
+		t.Errorf("archive listing doesn't name who saved versions: %s", body)
+	}
+}
+
+func TestArchiveCompression(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip(err)
+	}
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveCompression(putter.RecompressZstd),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := wiki.List("old")
+	if len(archives) != 1 || !strings.HasSuffix(archives[0], ".zst") {
+		t.Fatalf("archives = %v, want one compressed with zstd", archives)
+	}
+
+	// Clients that can't decompress zstd get the archive decompressed
+	res, body := f.do(http.MethodGet, "/old/"+archives[0], "", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" || body != testContent {
+		t.Errorf("GET = %d (Content-Encoding %q) %q, want %q", res.StatusCode, res.Header.Get("Content-Encoding"), body, testContent)
+	}
+	res, body = f.do(http.MethodGet, "/old/"+archives[0], "", http.Header{"Accept-Encoding": {"gzip, zstd"}})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "zstd" || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET accepting zstd = %d, Content-Encoding %q, Content-Type %q", res.StatusCode, res.Header.Get("Content-Encoding"), res.Header.Get("Content-Type"))
+	}
+	cmd := exec.Command(zstd, "-dqc")
+	cmd.Stdin = strings.NewReader(body)
+	content, err := cmd.Output()
+	if err != nil || string(content) != testContent {
+		t.Errorf("decompressed archive = %q, %v, want %q", content, err, testContent)
+	}
+
+	err = f.server.Restore(context.Background(), archives[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content := wiki.Read(); content != testContent {
+		t.Errorf("restored wiki = %q, want %q", content, testContent)
+	}
+}
+
+func TestArchiveCompressionGzip(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	f := newFixtureForWiki(t, wiki,
+		putter.WithArchive(wiki.Path("old"), testArchiveFormat),
+		putter.WithArchiveCompression(putter.RecompressGzip),
+	)
+	defer f.close()
+
+	f.put(testUpdated, nil, http.StatusOK)
+	archives := wiki.List("old")
+	if len(archives) != 1 || !strings.HasSuffix(archives[0], ".gz") {
+		t.Fatalf("archives = %v, want one compressed with gzip", archives)
+	}
+
+	// The archive is sent as it is to clients that accept gzip
+	res, body := f.do(http.MethodGet, "/old/"+archives[0], "", http.Header{"Accept-Encoding": {"gzip"}})
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "gzip" || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/html") {
+		t.Fatalf("GET accepting gzip = %d, Content-Encoding %q, Content-Type %q", res.StatusCode, res.Header.Get("Content-Encoding"), res.Header.Get("Content-Type"))
+	}
+	if stored, err := ioutil.ReadFile(wiki.Path("old", archives[0])); err != nil || body != string(stored) {
+		t.Errorf("GET accepting gzip didn't send the archive as it is stored")
+	}
+	gz, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(gz)
+	if err != nil || string(content) != testContent {
+		t.Errorf("decompressed archive = %q, %v, want %q", content, err, testContent)
+	}
+
+	// Others get it decompressed
+	res, body = f.do(http.MethodGet, "/old/"+archives[0], "", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" || body != testContent {
+		t.Errorf("GET = %d (Content-Encoding %q) %q, want %q", res.StatusCode, res.Header.Get("Content-Encoding"), body, testContent)
+	}
+}
+
+func TestCompanion(t *testing.T) {
+	wiki := puttertest.NewTempWiki(t, testContent)
+	err := ioutil.WriteFile(wiki.Path("settings.json"), []byte(`{"theme":"light"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	companion, err := putter.NewServer(wiki.Path("settings.json"), putter.WithArchive(wiki.Path("old", "settings.json"), testArchiveFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer companion.Close()
+	f := newFixtureForWiki(t, wiki, putter.WithCompanion(companion))
+	defer f.close()
+
+	res, body := f.do(http.MethodGet, "/settings.json", "", nil)
+	if res.StatusCode != http.StatusOK || body != `{"theme":"light"}` || res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("GET = %d %s %q", res.StatusCode, res.Header.Get("Content-Type"), body)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" || etag == f.etag() {
+		t.Errorf("companion ETag = %q, want its own", etag)
+	}
+
+	res, _ = f.do(http.MethodPut, "/settings.json", `{"theme":"dark"}`, http.Header{"If-Match": {`"stale"`}})
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale save status = %d, want %d", res.StatusCode, http.StatusPreconditionFailed)
+	}
+	res, _ = f.do(http.MethodPut, "/settings.json", `{"theme":"dark"}`, http.Header{"If-Match": {etag}})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("save status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	data, err := ioutil.ReadFile(wiki.Path("settings.json"))
+	if err != nil || string(data) != `{"theme":"dark"}` {
+		t.Errorf("companion = %q, %v", data, err)
+	}
+	if archives := wiki.List(filepath.Join("old", "settings.json")); len(archives) != 1 {
+		t.Errorf("companion archives = %v, want one", archives)
+	}
+	if content := wiki.Read(); content != testContent {
+		t.Errorf("wiki = %q, want it untouched", content)
+	}
+}
+
+// testFolderCommand stands in for tiddlywiki, rendering a wiki folder to a
+// wiki whose only tiddler counts its tiddler files.
+const testFolderCommand = `n=$(ls "$1/tiddlers" | wc -l | tr -d ' ')
+printf '<script class="tiddlywiki-tiddler-store" type="application/json">[{"title":"Count","text":"%s"}]</script>' "$n" > "$3/$6"`
+
+func TestWikiFolder(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip(err)
+	}
+	wiki := puttertest.NewTempWiki(t, testContent)
+	dir := wiki.Path("folder")
+	for name, data := range map[string]string{
+		"tiddlywiki.info":  `{"plugins":[]}`,
+		"tiddlers/A.tid":   "title: A\n\nbefore",
+		"../tiddlywiki.sh": testFolderCommand,
+	} {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wiki.FileName = filepath.Join(dir, "output", "index.html")
+	f := newFixtureForWiki(t, wiki, putter.WithWikiFolder(dir, "sh "+wiki.Path("tiddlywiki.sh")))
+	defer f.close()
+
+	_, body := f.do(http.MethodGet, "/", "", nil)
+	if !strings.Contains(body, `"text":"1"`) {
+		t.Fatalf("rendered wiki = %q, want 1 tiddler counted", body)
+	}
+
+	saved := `<script class="tiddlywiki-tiddler-store" type="application/json">[{"title":"A","text":"after"},{"title":"B","text":"new"}]</script>`
+	f.put(saved, http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+	for name, want := range map[string]string{
+		"A.tid": "title: A\n\nafter",
+		"B.tid": "title: B\n\nnew",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "tiddlers", name))
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", name, data, err, want)
+		}
+	}
+	saved = `<script class="tiddlywiki-tiddler-store" type="application/json">[{"title":"A","text":"after"}]</script>`
+	f.put(saved, http.Header{"If-Match": {f.etag()}}, http.StatusOK)
+	if _, err := os.Stat(filepath.Join(dir, "tiddlers", "B.tid")); !os.IsNotExist(err) {
+		t.Errorf("deleted tiddler's file remains: %v", err)
+	}
+
+	// Its own writes to the folder don't make it render the wiki again
+	time.Sleep(1100 * time.Millisecond)
+	if _, body := f.do(http.MethodGet, "/", "", nil); body != saved {
+		t.Errorf("wiki = %q after save, want it as saved", body)
+	}
+
+	later := time.Now().Add(time.Hour)
+	err := ioutil.WriteFile(filepath.Join(dir, "tiddlers", "C.tid"), []byte("title: C\n\nexternal"), 0644)
+	if err == nil {
+		err = os.Chtimes(filepath.Join(dir, "tiddlers", "C.tid"), later, later)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, body := f.do(http.MethodGet, "/", "", nil); !strings.Contains(body, `"text":"2"`) {
+		t.Errorf("wiki = %q after the folder changed, want it rendered again", body)
+	}
+}
@@ -0,0 +1,84 @@
+// Package puttertest provides utilities for testing code that embeds putter.
+package puttertest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TempWiki is a wiki file in its own temporary directory.
+type TempWiki struct {
+	Dir      string // temporary directory holding the wiki
+	FileName string // name of the wiki file, within Dir
+
+	t testing.TB
+}
+
+// NewTempWiki creates a temporary directory holding a wiki file with the given
+// content, failing the test if it can't. Call Close to remove it.
+func NewTempWiki(t testing.TB, content string) *TempWiki {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "puttertest-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+	w := &TempWiki{
+		Dir:      dir,
+		FileName: filepath.Join(dir, "index.html"),
+		t:        t,
+	}
+	w.Write(content)
+
+	return w
+}
+
+// Path joins elem to the wiki's temporary directory.
+func (w *TempWiki) Path(elem ...string) string {
+	return filepath.Join(append([]string{w.Dir}, elem...)...)
+}
+
+// Read returns the current content of the wiki file.
+func (w *TempWiki) Read() string {
+	w.t.Helper()
+	data, err := ioutil.ReadFile(w.FileName)
+	if err != nil {
+		w.t.Fatalf("failed to read wiki: %v", err)
+	}
+
+	return string(data)
+}
+
+// Write replaces the content of the wiki file, as an external editor might.
+func (w *TempWiki) Write(content string) {
+	w.t.Helper()
+	err := ioutil.WriteFile(w.FileName, []byte(content), 0644)
+	if err != nil {
+		w.t.Fatalf("failed to write wiki: %v", err)
+	}
+}
+
+// List returns the names of the files in the given subdirectory of the wiki's
+// temporary directory, or nil if it doesn't exist.
+func (w *TempWiki) List(dir string) []string {
+	w.t.Helper()
+	fileInfos, err := ioutil.ReadDir(w.Path(dir))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		w.t.Fatalf("failed to list %s: %v", dir, err)
+	}
+	var names []string
+	for _, fileInfo := range fileInfos {
+		names = append(names, fileInfo.Name())
+	}
+
+	return names
+}
+
+// Close removes the temporary directory and everything in it.
+func (w *TempWiki) Close() {
+	os.RemoveAll(w.Dir)
+}
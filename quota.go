@@ -0,0 +1,56 @@
+package putter
+
+// quotaWarnPercent is how full the quota may get before the wiki is reported
+// as needing attention.
+const quotaWarnPercent = 90
+
+// QuotaStatus describes how much of its storage quota the wiki uses.
+type QuotaStatus struct {
+	Limit int64 `json:"limit"`
+	Used  int64 `json:"used"` // by the live wiki and its archive
+}
+
+// Percent returns how much of the quota is used, as a percentage.
+func (q QuotaStatus) Percent() float64 {
+	return float64(q.Used) * 100 / float64(q.Limit)
+}
+
+// IsNearlyFull reports whether the quota is nearly or entirely used up.
+func (q QuotaStatus) IsNearlyFull() bool {
+	return q.Percent() >= quotaWarnPercent
+}
+
+// usage returns the storage that the wiki uses: its live file plus its
+// archived versions. The caller must hold the lock.
+func (s *Server) usage() (used int64, err error) {
+	used = s.fileInfo.Size()
+	if !s.isArchive {
+		return
+	}
+	entries, err := s.archiver.List()
+	for _, entry := range entries {
+		used += entry.Size
+	}
+
+	return
+}
+
+// checkQuota returns how much storage the wiki would use after a save of size
+// bytes, which archives the live wiki (if archiving is enabled) and replaces
+// it, and whether that is within its quota. The caller must hold the lock.
+func (s *Server) checkQuota(size int64) (projected int64, ok bool, err error) {
+	if s.quota <= 0 {
+		return 0, true, nil
+	}
+	used, err := s.usage()
+	if err != nil {
+		// Failing to measure is no reason to refuse saves
+		return 0, true, err
+	}
+	projected = used + size
+	if !s.isArchive {
+		projected -= s.fileInfo.Size()
+	}
+
+	return projected, projected <= int64(s.quota), nil
+}
@@ -0,0 +1,91 @@
+package putter
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+)
+
+// Algorithms with which old archives can be recompressed (see
+// WithArchiveRecompression).
+const (
+	RecompressGzip = archive.AlgorithmGzip // built in
+	RecompressXz   = archive.AlgorithmXz   // requires the xz program
+	RecompressZstd = archive.AlgorithmZstd // requires the zstd program
+)
+
+// recompressHour is the local hour at which old archives are recompressed,
+// when the wiki is least likely to be in use.
+const recompressHour = 3
+
+// recompressLoop recompresses old archives every night until stop is closed.
+func (s *Server) recompressLoop(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), recompressHour, 0, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+		err := s.RecompressArchives(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to recompress archives: %v", err)
+		}
+	}
+}
+
+// RecompressArchives recompresses the archives older than the age given to
+// WithArchiveRecompression with its algorithm. This happens every night at
+// 3am anyway, but can be done sooner, e.g. after enabling it.
+func (s *Server) RecompressArchives(ctx context.Context) (err error) {
+	if !s.isArchive || s.recompressAlgo == "" {
+		return ErrNoArchive
+	}
+	entries, err := s.archiver.List()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-s.recompressAge)
+	ext := archive.Extension(s.recompressAlgo)
+	var before, after int64
+	count := 0
+	for _, entry := range entries {
+		if entry.ModTime.After(cutoff) || filepath.Ext(entry.Name) == ext {
+			continue
+		}
+		var name string
+		name, err = s.archiver.Recompress(ctx, entry.Name, s.recompressAlgo)
+		if os.IsNotExist(err) {
+			// Pruned in the meantime
+			continue
+		}
+		if err != nil {
+			return
+		}
+		fileInfo, statErr := os.Stat(filepath.Join(s.archiver.Dir, name))
+		if statErr == nil {
+			before += entry.Size
+			after += fileInfo.Size()
+		}
+		count++
+	}
+	if count > 0 {
+		log.Printf("recompressed %d archives with %s, from %v to %v", count, s.recompressAlgo, ByteSize(before), ByteSize(after))
+	}
+
+	return nil
+}
@@ -0,0 +1,243 @@
+package putter
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/diff"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// ErrNoArchive is returned for archive operations when archiving is disabled.
+var ErrNoArchive = errors.New("archiving is disabled")
+
+// Archives lists the versions of the wiki in the archive, newest first.
+func (s *Server) Archives() ([]ArchiveEntry, error) {
+	if !s.isArchive {
+		return nil, ErrNoArchive
+	}
+
+	entries, err := s.archiver.List()
+	s.authors.attribute(entries)
+
+	return entries, err
+}
+
+// Restore replaces the live wiki with the named archive. The live wiki is
+// archived first, so a restore can itself be undone.
+func (s *Server) Restore(ctx context.Context, name string) (err error) {
+	if !s.isArchive {
+		return ErrNoArchive
+	}
+	if s.mirror != nil {
+		return ErrMirror
+	}
+	in, err := s.openVersion(name)
+	if err != nil {
+		return
+	}
+	defer in.Close()
+
+	server.Logf(ctx, "restoring wiki from %s...", name)
+	f, err := ioutil.TempFile(filepath.Dir(s.fileName), s.uploadPrefix()+"*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := s.newHash()
+	_, err = io.Copy(io.MultiWriter(f, hash), storage.ContextReader{Ctx: ctx, R: in})
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	if err != nil {
+		return
+	}
+	etag := "\"" + hex.EncodeToString(hash.Sum(nil)) + "\""
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previousETag := s.etag
+	err = s.replaceWiki(withAlwaysArchive(ctx), f.Name(), etag)
+	if err != nil {
+		return
+	}
+	server.Logf(ctx, "wiki restored from %s", name)
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		ETag:         s.etag,
+		PreviousETag: previousETag,
+		Size:         s.fileInfo.Size(),
+		Archive:      name,
+	})
+
+	return
+}
+
+// pruneArchive removes all but the newest keep archives.
+func (s *Server) pruneArchive(keep int) (err error) {
+	if !s.isArchive {
+		return ErrNoArchive
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed, err := s.archiver.Prune(keep)
+	for _, name := range removed {
+		s.emit(Event{Type: EventArchivePruned, Archive: name})
+	}
+
+	return
+}
+
+// retainArchive prunes the archives that WithArchiveRetention doesn't keep.
+// Failures are only logged. The caller must hold the write lock.
+func (s *Server) retainArchive(ctx context.Context) {
+	if !s.isArchive || s.retention == (archive.Retention{}) {
+		return
+	}
+
+	removed, err := s.archiver.Retain(s.retention, time.Now(), s.archiveLocation)
+	for _, name := range removed {
+		s.emit(Event{Type: EventArchivePruned, Archive: name})
+	}
+	if err != nil {
+		server.Logf(ctx, "failed to prune archive: %v", err)
+	}
+}
+
+// restoreData is passed to restoreTemplate.
+type restoreData struct {
+	Live    ArchiveEntry // the live wiki, which will be archived
+	ETag    string       // ETag of the live wiki
+	Version ArchiveEntry // the version to restore
+	Changes map[string]int
+}
+
+// handleAdminRestore restores the archive named by the "name" form value, or
+// the most recent archive if it is empty. A GET request shows what will be
+// overwritten and asks for confirmation; a POST request restores.
+func (s *Server) handleAdminRestore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	entries, err := s.Archives()
+	if err != nil {
+		server.Logf(r.Context(), "failed to list archives: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	name := r.FormValue("name")
+	var version *ArchiveEntry
+	for i := range entries {
+		if name == "" || entries[i].Name == name {
+			version = &entries[i]
+			break
+		}
+	}
+	if version == nil {
+		s.writeError(w, r, http.StatusNotFound, "There is no such version in the archive.")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		s.mu.RLock()
+		etag := s.etag
+		s.mu.RUnlock()
+		if confirmed := r.FormValue("etag"); confirmed != "" && confirmed != etag {
+			s.writeError(w, r, http.StatusPreconditionFailed, "The live wiki has been saved since the restore was confirmed.")
+			return
+		}
+		err = s.Restore(r.Context(), version.Name)
+		if errors.Is(err, ErrMirror) {
+			s.writeError(w, r, http.StatusForbidden, "This wiki is a read-only mirror, so it can't be restored.")
+			return
+		}
+		if err != nil {
+			server.Logf(r.Context(), "failed to restore %s: %v", version.Name, err)
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		redirectToAdmin(w)
+		return
+	}
+
+	data := restoreData{Version: *version, Changes: make(map[string]int)}
+	s.mu.RLock()
+	data.Live = ArchiveEntry{
+		Name:    filepath.Base(s.fileName),
+		Size:    s.fileInfo.Size(),
+		ModTime: s.fileInfo.ModTime(),
+	}
+	data.ETag = s.etag
+	s.mu.RUnlock()
+	data.Live.SavedBy = s.authors.live(data.ETag)
+	live, err := s.readVersion("")
+	if err == nil {
+		var old []byte
+		old, err = s.readVersion(version.Name)
+		for _, change := range diff.Wikis(live, old) {
+			data.Changes[change.Kind]++
+		}
+	}
+	if err != nil {
+		server.Logf(r.Context(), "failed to compare %s with the live wiki: %v", version.Name, err)
+	}
+
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err = restoreTemplate.Execute(w, data)
+	if err != nil {
+		server.Logf(r.Context(), "failed to render restore confirmation: %v", err)
+	}
+}
+
+var restoreTemplate = template.Must(template.New("restore").Funcs(template.FuncMap{
+	"byteSize": func(size int64) ByteSize { return ByteSize(size) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin - restore</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+th { text-align: left; padding-right: 1em; }
+</style>
+</head>
+<body>
+<p><a href="./">&larr; admin</a></p>
+<h1>Restore {{.Version.Name}}?</h1>
+<table>
+<tr><th></th><th>Live wiki (will be replaced)</th><th>Version to restore</th></tr>
+<tr><th>Name</th><td>{{.Live.Name}}</td><td>{{.Version.Name}}</td></tr>
+<tr><th>Size</th><td>{{byteSize .Live.Size}}</td><td>{{byteSize .Version.Size}}</td></tr>
+<tr><th>Date</th><td>{{.Live.ModTime.Format "2006-01-02 15:04:05"}}</td><td>{{.Version.ModTime.Format "2006-01-02 15:04:05"}}</td></tr>
+{{if or .Live.SavedBy .Version.SavedBy}}<tr><th>Saved by</th><td>{{.Live.SavedBy}}</td><td>{{.Version.SavedBy}}</td></tr>
+{{end}}</table>
+<p>Restoring will add {{index .Changes "added"}}, remove {{index .Changes "removed"}}, and change {{index .Changes "changed"}} tiddlers.
+<a href="diff?from=&amp;to={{.Version.Name}}">See the changes</a></p>
+<p>The live wiki will be archived first, so the restore can be undone.</p>
+<form method="post" action="restore">
+<input type="hidden" name="name" value="{{.Version.Name}}">
+<input type="hidden" name="etag" value="{{.ETag}}">
+<button>Restore</button>
+<a href="./">Cancel</a>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,390 @@
+package putter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// resumablePath is where NewHandler serves resumable uploads.
+const resumablePath = "/resumable/"
+
+// The tus protocol's headers and values (https://tus.io/protocols/resumable-upload).
+const (
+	headerTusResumable = "Tus-Resumable"
+	headerTusVersion   = "Tus-Version"
+	headerTusExtension = "Tus-Extension"
+	headerUploadOffset = "Upload-Offset"
+	headerUploadLength = "Upload-Length"
+	headerUploadExpire = "Upload-Expires"
+
+	tusVersion     = "1.0.0"
+	tusExtensions  = "creation,termination,expiration"
+	tusContentType = "application/offset+octet-stream"
+)
+
+// extensionResumable is the extension of the directory alongside the wiki
+// holding resumable uploads, each as a file of the data received so far and
+// a JSON file describing it.
+const extensionResumable = ".resumable"
+
+// resumableHeaders are the headers of the request creating a resumable
+// upload that apply to the save it ends in.
+var resumableHeaders = []string{headerIfMatch, headerSha256, headerConfirmShrink}
+
+// resumable tracks resumable uploads.
+type resumable struct {
+	dir    string          // directory holding the uploads
+	expiry time.Duration   // how long an upload may take to complete
+	mu     sync.Mutex      // protects the following
+	busy   map[string]bool // uploads being written to
+}
+
+// resumableUpload describes a resumable upload, stored as JSON beside it.
+type resumableUpload struct {
+	Length  int64       `json:"length"`
+	Header  http.Header `json:"header"` // the resumableHeaders of its creation
+	Created time.Time   `json:"created"`
+}
+
+// ResumableHandler returns a handler serving resumable uploads of the wiki
+// with the core of the tus protocol and its creation, termination, and
+// expiration extensions, for clients on flaky connections to resume an
+// interrupted save rather than starting it again. An upload is created with
+// a POST giving its Upload-Length (and any If-Match, X-Putter-SHA256, or
+// X-Putter-Confirm-Shrink headers for the save), then sent with PATCH
+// requests, resuming from the Upload-Offset reported by HEAD. The PATCH that
+// completes it saves it as a PUT would, answering with its status and, on
+// success, the new ETag.
+func (s *Server) ResumableHandler() http.Handler {
+	return http.HandlerFunc(s.handleResumable)
+}
+
+// handleResumable routes requests for resumable uploads.
+func (s *Server) handleResumable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerTusResumable, tusVersion)
+	if s.resumable == nil {
+		s.writeError(w, r, http.StatusNotFound, "")
+		return
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set(headerTusVersion, tusVersion)
+		w.Header().Set(headerTusExtension, tusExtensions)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get(headerTusResumable) != tusVersion {
+		w.Header().Set(headerTusVersion, tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		s.handleResumableCreate(w, r)
+	case id == "" || !validResumableID(id):
+		s.writeError(w, r, http.StatusNotFound, "")
+	case r.Method == http.MethodHead:
+		s.handleResumableHead(w, r, id)
+	case r.Method == http.MethodPatch:
+		s.handleResumablePatch(w, r, id)
+	case r.Method == http.MethodDelete:
+		s.handleResumableDelete(w, r, id)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleResumableCreate creates a resumable upload, answering with its
+// location.
+func (s *Server) handleResumableCreate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if s.mirror != nil {
+		s.writeError(w, r, http.StatusForbidden, "This wiki is a read-only mirror of "+s.mirror.status.Upstream+", where it should be saved instead.")
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get(headerUploadLength), 10, 64)
+	if err != nil || length < 0 {
+		s.writeError(w, r, http.StatusBadRequest, "A resumable upload needs an Upload-Length.")
+		return
+	}
+	s.resumable.purge()
+
+	upload := resumableUpload{Length: length, Header: make(http.Header), Created: time.Now()}
+	for _, key := range resumableHeaders {
+		if value := r.Header.Get(key); value != "" {
+			upload.Header.Set(key, value)
+		}
+	}
+	id, err := s.resumable.create(upload, s.fileMode, s.dirMode)
+	if err != nil {
+		server.Logf(ctx, "failed to create resumable upload: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	server.Logf(ctx, "created resumable upload %s of %d bytes", id, length)
+
+	// Relative to the request, so that it holds under any base path
+	w.Header().Set(headerLocation, strings.TrimPrefix(r.URL.Path, "/")+id)
+	w.Header().Set(headerUploadExpire, upload.Created.Add(s.resumable.expiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleResumableHead reports how much of an upload has been received.
+func (s *Server) handleResumableHead(w http.ResponseWriter, r *http.Request, id string) {
+	upload, offset, err := s.resumable.stat(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set(headerCacheControl, "no-store")
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+	w.Header().Set(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	w.Header().Set(headerUploadExpire, upload.Created.Add(s.resumable.expiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleResumablePatch appends to an upload at the offset given by the
+// client, saving the wiki once the upload is complete.
+func (s *Server) handleResumablePatch(w http.ResponseWriter, r *http.Request, id string) {
+	ctx := r.Context()
+	if r.Header.Get(headerContentType) != tusContentType {
+		s.writeError(w, r, http.StatusUnsupportedMediaType, "")
+		return
+	}
+	if !s.resumable.lock(id) {
+		s.writeError(w, r, http.StatusConflict, "The upload is already being written to.")
+		return
+	}
+	defer s.resumable.unlock(id)
+	upload, offset, err := s.resumable.stat(id)
+	if err != nil {
+		s.writeError(w, r, http.StatusNotFound, "")
+		return
+	}
+	requested, err := strconv.ParseInt(r.Header.Get(headerUploadOffset), 10, 64)
+	if err != nil || requested != offset {
+		w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+		s.writeError(w, r, http.StatusConflict, "The upload continues from offset "+strconv.FormatInt(offset, 10)+".")
+		return
+	}
+
+	release, err := s.uploads.acquire(ctx)
+	if err == errTooManyUploads {
+		w.Header().Set(headerRetryAfter, strconv.Itoa(uploadRetryAfter))
+		s.writeError(w, r, http.StatusTooManyRequests, "")
+		return
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusServiceUnavailable, "")
+		return
+	}
+	// What arrives before the connection drops is kept, to resume from
+	written, err := s.resumable.append(id, io.LimitReader(r.Body, upload.Length-offset), s.fileMode)
+	release()
+	offset += written
+	w.Header().Set(headerUploadOffset, strconv.FormatInt(offset, 10))
+	if err != nil {
+		server.Logf(ctx, "resumable upload %s interrupted at %d of %d bytes: %v", id, offset, upload.Length, err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if offset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.commitResumable(w, r, id, upload)
+}
+
+// commitResumable saves a complete upload as a PUT with the headers it was
+// created with would, removing it unless the save failed for a reason that
+// may pass, so that a PATCH of no more data can retry it.
+func (s *Server) commitResumable(w http.ResponseWriter, r *http.Request, id string, upload resumableUpload) {
+	ctx := r.Context()
+	f, err := os.Open(s.resumable.path(id))
+	if err != nil {
+		server.Logf(ctx, "failed to open resumable upload %s: %v", id, err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	defer f.Close()
+
+	server.Logf(ctx, "resumable upload %s is complete, saving it", id)
+	// Saved as a PUT, so that it is subject to locks like one
+	put := r.Clone(ctx)
+	put.Method = http.MethodPut
+	put.Header = upload.Header.Clone()
+	put.Header.Set(server.HeaderRequestID, r.Header.Get(server.HeaderRequestID))
+	rec := &server.StatusRecorder{ResponseWriter: w}
+	etag, ok := s.save(rec, put, f)
+	f.Close()
+	if ok || rec.Status < http.StatusInternalServerError && rec.Status != http.StatusTooManyRequests {
+		s.resumable.remove(id)
+	}
+	if !ok {
+		return
+	}
+	w.Header().Set(headerEtag, etag)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleResumableDelete abandons an upload.
+func (s *Server) handleResumableDelete(w http.ResponseWriter, r *http.Request, id string) {
+	if !s.resumable.lock(id) {
+		s.writeError(w, r, http.StatusConflict, "The upload is being written to.")
+		return
+	}
+	defer s.resumable.unlock(id)
+	if _, _, err := s.resumable.stat(id); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.resumable.remove(id)
+	server.Logf(r.Context(), "abandoned resumable upload %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validResumableID reports whether id could be that of an upload.
+func validResumableID(id string) bool {
+	_, err := hex.DecodeString(id)
+
+	return err == nil && len(id) == 32
+}
+
+// path returns the path of the data of the upload with the given ID.
+func (u *resumable) path(id string) string {
+	return filepath.Join(u.dir, id)
+}
+
+// create creates an empty upload, returning its ID.
+func (u *resumable) create(upload resumableUpload, fileMode, dirMode os.FileMode) (id string, err error) {
+	err = storage.Mkdir(u.dir, dirMode)
+	if err != nil {
+		return
+	}
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	id = hex.EncodeToString(b)
+	info, err := json.Marshal(upload)
+	if err != nil {
+		return
+	}
+	err = ioutil.WriteFile(u.path(id), nil, fileMode)
+	if err == nil {
+		err = ioutil.WriteFile(u.path(id)+".json", info, fileMode)
+	}
+	if err != nil {
+		u.remove(id)
+	}
+
+	return
+}
+
+// stat returns the description of an upload that hasn't expired and how
+// much of it has been received.
+func (u *resumable) stat(id string) (upload resumableUpload, offset int64, err error) {
+	info, err := ioutil.ReadFile(u.path(id) + ".json")
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(info, &upload)
+	if err != nil {
+		return
+	}
+	if time.Since(upload.Created) > u.expiry {
+		return upload, 0, os.ErrNotExist
+	}
+	fileInfo, err := os.Stat(u.path(id))
+	if err != nil {
+		return
+	}
+
+	return upload, fileInfo.Size(), nil
+}
+
+// append appends data read from r to an upload, returning how much was
+// appended, which is kept even if reading fails.
+func (u *resumable) append(id string, r io.Reader, fileMode os.FileMode) (written int64, err error) {
+	f, err := os.OpenFile(u.path(id), os.O_WRONLY|os.O_APPEND, fileMode)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	written, err = io.Copy(f, r)
+	if syncErr := f.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return
+}
+
+// remove deletes an upload.
+func (u *resumable) remove(id string) {
+	os.Remove(u.path(id))
+	os.Remove(u.path(id) + ".json")
+}
+
+// lock marks an upload as being written to, reporting false if it already
+// was.
+func (u *resumable) lock(id string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.busy[id] {
+		return false
+	}
+	u.busy[id] = true
+
+	return true
+}
+
+// unlock marks an upload as no longer being written to.
+func (u *resumable) unlock(id string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.busy, id)
+}
+
+// purge removes expired uploads that aren't being written to.
+func (u *resumable) purge() {
+	fileInfos, err := ioutil.ReadDir(u.dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to list resumable uploads: %v", err)
+		}
+		return
+	}
+	for _, fileInfo := range fileInfos {
+		id := fileInfo.Name()
+		if !validResumableID(id) || !u.lock(id) {
+			continue
+		}
+		_, _, err := u.stat(id)
+		if errors.Is(err, os.ErrNotExist) || err != nil && time.Since(fileInfo.ModTime()) > u.expiry {
+			u.remove(id)
+			log.Printf("removed expired resumable upload %s", id)
+		}
+		u.unlock(id)
+	}
+}
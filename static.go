@@ -0,0 +1,50 @@
+package putter
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// staticHandler serves the static files directory given to WithStatic.
+func (s *Server) staticHandler() http.Handler {
+	return server.WhitelistMethods(http.FileServer(staticDir{http.Dir(s.staticDir)}), http.MethodGet, http.MethodHead)
+}
+
+// staticDir is a directory of static files that hides hidden files, such as
+// .git, and doesn't list the contents of subdirectories without an
+// index.html.
+type staticDir struct {
+	http.Dir
+}
+
+// Open opens the named file, if it is served.
+func (d staticDir) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, os.ErrNotExist
+		}
+	}
+	f, err := d.Dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := d.Dir.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		index.Close()
+	}
+
+	return f, nil
+}
@@ -0,0 +1,370 @@
+package putter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// statsPath is where NewHandler serves the wiki's statistics.
+const statsPath = "/stats"
+
+const extensionStats = ".stats"
+
+// How far back the statistics history goes.
+const (
+	statsHours = 48
+	statsDays  = 366
+)
+
+// maxStatsClients bounds the number of clients whose usage is kept, dropping
+// those seen least recently.
+const maxStatsClients = 100
+
+// statsFlushDelay is how long new statistics may go unwritten, so that a
+// burst of saves is written to disk once.
+const statsFlushDelay = 10 * time.Second
+
+// StatsBucket summarizes the saves made in a period of time.
+type StatsBucket struct {
+	Start       time.Time `json:"start"`
+	Saves       int       `json:"saves"`
+	Failures    int       `json:"failures"`
+	Conflicts   int       `json:"conflicts"`
+	Bytes       int64     `json:"bytes"`       // total size of the saved wikis
+	Size        int64     `json:"size"`        // size of the wiki after the last save, or 0 if there was none
+	SaveTime    float64   `json:"saveTime"`    // total seconds taken by saves of any outcome
+	MaxSaveTime float64   `json:"maxSaveTime"` // seconds taken by the slowest save
+}
+
+// ClientStats summarizes a client's use of the wiki.
+type ClientStats struct {
+	Saves      int       `json:"saves"`
+	Failures   int       `json:"failures"`
+	Conflicts  int       `json:"conflicts"`
+	Uploaded   int64     `json:"uploaded"`   // total size of the wikis it saved
+	Downloaded int64     `json:"downloaded"` // bytes of the wiki and its archive served to it
+	LastSeen   time.Time `json:"lastSeen"`
+}
+
+// Stats is the wiki's history of saves, served as JSON by StatsHandler.
+type Stats struct {
+	Since        time.Time              `json:"since"`        // when statistics were first recorded
+	Total        StatsBucket            `json:"total"`        // every save since then
+	ConflictRate float64                `json:"conflictRate"` // fraction of attempted saves that conflicted
+	Hourly       []StatsBucket          `json:"hourly"`       // hours with saves in the last two days, oldest first
+	Daily        []StatsBucket          `json:"daily"`        // days with saves in the last year, oldest first
+	Clients      map[string]ClientStats `json:"clients"`      // by user name or address, for the most recently seen clients
+}
+
+// stats records the history of saves, fed by the server's events, and keeps
+// it on disk alongside the wiki.
+type stats struct {
+	mu       sync.Mutex  // protects the following
+	data     Stats       // history so far
+	fileName string      // where the history is kept
+	fileMode os.FileMode // permissions for the file
+	flush    *time.Timer // pending write of the history, if any
+}
+
+// load reads the history from fileName, starting afresh if there is none.
+func (st *stats) load(fileName string, fileMode os.FileMode) {
+	st.fileName = fileName
+	st.fileMode = fileMode
+	data, err := ioutil.ReadFile(fileName)
+	if err == nil {
+		err = json.Unmarshal(data, &st.data)
+		if err == nil {
+			return
+		}
+	}
+	if !os.IsNotExist(err) {
+		log.Printf("failed to read statistics, starting afresh: %v", err)
+	}
+	now := time.Now()
+	st.data = Stats{Since: now, Total: StatsBucket{Start: now}}
+}
+
+// record adds a save to the history, made by client if it was a request.
+func (st *stats) record(e Event, client string) {
+	var add func(b *StatsBucket)
+	var addClient func(c *ClientStats)
+	switch e.Type {
+	case EventSaveCompleted:
+		add = func(b *StatsBucket) {
+			b.Saves++
+			b.Bytes += e.Size
+			b.Size = e.Size
+		}
+		addClient = func(c *ClientStats) {
+			c.Saves++
+			c.Uploaded += e.Size
+		}
+	case EventSaveFailed:
+		add = func(b *StatsBucket) { b.Failures++ }
+		addClient = func(c *ClientStats) { c.Failures++ }
+	case EventConflict:
+		add = func(b *StatsBucket) { b.Conflicts++ }
+		addClient = func(c *ClientStats) { c.Conflicts++ }
+	default:
+		return
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if client != "" {
+		st.addClient(client, e.Time, addClient)
+	}
+	hour := e.Time.Truncate(time.Hour)
+	year, month, day := e.Time.Date()
+	for _, b := range []*StatsBucket{
+		&st.data.Total,
+		statsBucket(&st.data.Hourly, hour, hour.Add(-statsHours*time.Hour)),
+		statsBucket(&st.data.Daily, time.Date(year, month, day, 0, 0, 0, 0, e.Time.Location()), e.Time.AddDate(0, 0, -statsDays)),
+	} {
+		add(b)
+		seconds := e.Duration.Seconds()
+		b.SaveTime += seconds
+		if seconds > b.MaxSaveTime {
+			b.MaxSaveTime = seconds
+		}
+	}
+	st.scheduleWrite()
+}
+
+// recordDownload adds n bytes served to client at t to the history.
+func (st *stats) recordDownload(client string, n int64, t time.Time) {
+	if client == "" || n == 0 {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.addClient(client, t, func(c *ClientStats) { c.Downloaded += n })
+	st.scheduleWrite()
+}
+
+// addClient updates the usage of client, seen at t, with add, dropping the
+// client seen least recently if there are too many. The caller must hold
+// st.mu.
+func (st *stats) addClient(client string, t time.Time, add func(c *ClientStats)) {
+	if st.data.Clients == nil {
+		st.data.Clients = make(map[string]ClientStats)
+	}
+	c, ok := st.data.Clients[client]
+	if !ok && len(st.data.Clients) >= maxStatsClients {
+		oldest := ""
+		for name, other := range st.data.Clients {
+			if oldest == "" || other.LastSeen.Before(st.data.Clients[oldest].LastSeen) {
+				oldest = name
+			}
+		}
+		delete(st.data.Clients, oldest)
+	}
+	add(&c)
+	if t.After(c.LastSeen) {
+		c.LastSeen = t
+	}
+	st.data.Clients[client] = c
+}
+
+// scheduleWrite writes the history to disk soon, unless that is already
+// scheduled. The caller must hold st.mu.
+func (st *stats) scheduleWrite() {
+	if st.flush == nil {
+		st.flush = time.AfterFunc(statsFlushDelay, st.write)
+	}
+}
+
+// statsBucket returns the bucket starting at start, adding it if it is new
+// and dropping buckets that started before cutoff.
+func statsBucket(buckets *[]StatsBucket, start, cutoff time.Time) *StatsBucket {
+	b := *buckets
+	if len(b) > 0 && b[len(b)-1].Start.Equal(start) {
+		return &b[len(b)-1]
+	}
+	b = append(statsSince(b, cutoff), StatsBucket{Start: start})
+	*buckets = b
+
+	return &b[len(b)-1]
+}
+
+// statsSince returns the buckets that started after cutoff.
+func statsSince(buckets []StatsBucket, cutoff time.Time) []StatsBucket {
+	for i, b := range buckets {
+		if b.Start.After(cutoff) {
+			return buckets[i:]
+		}
+	}
+
+	return nil
+}
+
+// write writes the history to disk. Failure is logged but otherwise harmless.
+func (st *stats) write() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.flush = nil
+	data, err := json.Marshal(&st.data)
+	if err == nil {
+		err = storage.WriteFile(st.fileName, data, st.fileMode)
+	}
+	if err != nil {
+		log.Printf("failed to write statistics: %v", err)
+	}
+}
+
+// close writes any statistics that have yet to be written.
+func (st *stats) close() {
+	st.mu.Lock()
+	pending := st.flush != nil && st.flush.Stop()
+	st.mu.Unlock()
+	if pending {
+		st.write()
+	}
+}
+
+// Stats returns the wiki's history of saves, or an empty history if WithStats
+// wasn't given.
+func (s *Server) Stats() Stats {
+	st := &s.stats
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	stats := st.data
+	stats.Hourly = append([]StatsBucket(nil), statsSince(stats.Hourly, now.Add(-statsHours*time.Hour))...)
+	stats.Daily = append([]StatsBucket(nil), statsSince(stats.Daily, now.AddDate(0, 0, -statsDays))...)
+	stats.Clients = make(map[string]ClientStats, len(st.data.Clients))
+	for name, c := range st.data.Clients {
+		stats.Clients[name] = c
+	}
+	attempts := stats.Total.Saves + stats.Total.Failures + stats.Total.Conflicts
+	if attempts > 0 {
+		stats.ConflictRate = float64(stats.Total.Conflicts) / float64(attempts)
+	}
+
+	return stats
+}
+
+// StatsHandler returns a handler serving the wiki's history of saves as JSON.
+func (s *Server) StatsHandler() http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set(headerContentType, "application/json")
+		w.Header().Set(headerCacheControl, "no-store")
+		err := json.NewEncoder(w).Encode(s.Stats())
+		if err != nil {
+			log.Printf("failed to write statistics: %v", err)
+		}
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// statsChart is a bar chart of statistics on the admin dashboard.
+type statsChart struct {
+	Title string
+	Bars  []statsBar
+}
+
+// statsBar is a bar of a statsChart, with heights as percentages of the
+// chart's. Alert is the height of the bar's problematic part, if any.
+type statsBar struct {
+	Label  string
+	Height float64
+	Alert  float64
+}
+
+// statsCharts charts saves over the last two days, to spot runaway autosaves,
+// and the wiki's size over the last three months, to show its growth.
+func statsCharts(stats Stats, now time.Time) []statsChart {
+	saves := statsChart{Title: "Saves per hour, last 48 hours"}
+	hour := now.Truncate(time.Hour).Add(-(statsHours - 1) * time.Hour)
+	hourly := stats.Hourly
+	counts := make([]StatsBucket, statsHours)
+	for i := range counts {
+		counts[i].Start = hour.Add(time.Duration(i) * time.Hour)
+		for len(hourly) > 0 && !hourly[0].Start.After(counts[i].Start) {
+			if hourly[0].Start.Equal(counts[i].Start) {
+				counts[i] = hourly[0]
+			}
+			hourly = hourly[1:]
+		}
+	}
+	max := 0
+	for _, b := range counts {
+		if n := b.Saves + b.Failures + b.Conflicts; n > max {
+			max = n
+		}
+	}
+	for _, b := range counts {
+		bar := statsBar{Label: fmt.Sprintf("%s: %d saves, %d conflicts, %d failures",
+			b.Start.Format("Jan 2 15:04"), b.Saves, b.Conflicts, b.Failures)}
+		if max > 0 {
+			bar.Height = float64(b.Saves+b.Failures+b.Conflicts) * 100 / float64(max)
+			bar.Alert = float64(b.Failures+b.Conflicts) * 100 / float64(max)
+		}
+		saves.Bars = append(saves.Bars, bar)
+	}
+
+	// Days without saves have the size of the last day with them
+	size := statsChart{Title: "Size by day, last 90 days"}
+	const days = 90
+	year, month, day := now.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, now.Location()).AddDate(0, 0, 1-days)
+	daily := stats.Daily
+	sizes := make([]int64, days)
+	var last, largest int64
+	for i := range sizes {
+		end := start.AddDate(0, 0, i+1)
+		for len(daily) > 0 && daily[0].Start.Before(end) {
+			if daily[0].Size > 0 {
+				last = daily[0].Size
+			}
+			daily = daily[1:]
+		}
+		sizes[i] = last
+		if last > largest {
+			largest = last
+		}
+	}
+	for i, n := range sizes {
+		bar := statsBar{Label: fmt.Sprintf("%s: %s", start.AddDate(0, 0, i).Format("Jan 2"), ByteSize(n))}
+		if largest > 0 {
+			bar.Height = float64(n) * 100 / float64(largest)
+		}
+		size.Bars = append(size.Bars, bar)
+	}
+
+	return []statsChart{saves, size}
+}
+
+// statsClient is a client's usage, on the admin dashboard.
+type statsClient struct {
+	Name string
+	ClientStats
+}
+
+// statsClients lists the usage of clients, most recently seen first, to spot
+// the device making a flood of saves.
+func statsClients(stats Stats) []statsClient {
+	clients := make([]statsClient, 0, len(stats.Clients))
+	for name, c := range stats.Clients {
+		clients = append(clients, statsClient{name, c})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].LastSeen.After(clients[j].LastSeen)
+	})
+
+	return clients
+}
@@ -0,0 +1,159 @@
+package putter
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// statusPath is where NewHandler serves the server's status.
+const statusPath = "/status"
+
+// Status is a snapshot of the server's state, served as JSON by
+// StatusHandler.
+type Status struct {
+	Started      time.Time      `json:"started"`
+	Uptime       float64        `json:"uptime"` // seconds
+	File         string         `json:"file"`
+	Size         int64          `json:"size"`
+	ETag         string         `json:"etag"`
+	Modified     time.Time      `json:"modified"`
+	LastSave     *time.Time     `json:"lastSave"` // nil if there has been no save since startup
+	ReadOnly     bool           `json:"readOnly"`
+	ReadOnlyErr  string         `json:"readOnlyError,omitempty"`
+	Maintenance  bool           `json:"maintenance"`
+	RecentErrors int            `json:"recentErrors"`
+	Panics       int            `json:"panics"`  // requests whose handling panicked since startup
+	Held         bool           `json:"held"`    // whether a save is held for approval
+	Lock         *LockStatus    `json:"lock"`    // nil if the wiki isn't locked
+	Mirror       *MirrorStatus  `json:"mirror"`  // nil if the wiki isn't a mirror
+	Sync         *SyncStatus    `json:"sync"`    // nil if the wiki doesn't sync with a peer
+	Archive      *ArchiveStatus `json:"archive"` // nil if archiving is disabled
+	Quota        *QuotaStatus   `json:"quota"`   // nil if the wiki has no quota
+	Uploads      *UploadStatus  `json:"uploads"` // nil if uploads aren't limited
+	Features     Features       `json:"features"`
+}
+
+// ArchiveStatus summarizes the archive.
+type ArchiveStatus struct {
+	Dir   string `json:"dir"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"` // why the archive couldn't be listed
+}
+
+// Features lists the optional features that are enabled.
+type Features struct {
+	Archive         bool `json:"archive"`
+	ArchiveExternal bool `json:"archiveExternal"`
+	Compress        bool `json:"compress"`
+	CompressCache   bool `json:"compressCache"`
+	ETagCache       bool `json:"etagCache"`
+	Watch           bool `json:"watch"`
+	Admin           bool `json:"admin"`
+	ErrorPages      bool `json:"errorPages"`
+	Stats           bool `json:"stats"`
+	Locks           bool `json:"locks"`
+	Mirror          bool `json:"mirror"`
+	Sync            bool `json:"sync"`
+	Manifest        bool `json:"manifest"`
+	Resumable       bool `json:"resumable"`
+}
+
+// Status returns a snapshot of the server's state.
+func (s *Server) Status() Status {
+	s.mu.RLock()
+	status := Status{
+		Started:     s.started,
+		Uptime:      time.Since(s.started).Seconds(),
+		File:        s.fileName,
+		Size:        s.fileInfo.Size(),
+		ETag:        s.etag,
+		Modified:    s.fileInfo.ModTime(),
+		ReadOnly:    s.readOnlyErr != nil,
+		Maintenance: s.isMaintenance,
+		Held:        s.held != nil,
+		Features: Features{
+			Archive:         s.isArchive,
+			ArchiveExternal: s.isArchiveExternal,
+			Compress:        s.isCompress,
+			CompressCache:   s.isCompressCache,
+			ETagCache:       s.isEtagCache,
+			Watch:           s.isWatch,
+			Admin:           s.adminPassword != "",
+			ErrorPages:      s.errorPages != nil,
+			Stats:           s.isStats,
+			Locks:           s.lockMode != "",
+			Mirror:          s.mirror != nil,
+			Sync:            s.sync != nil,
+			Manifest:        s.isManifest,
+			Resumable:       s.resumable != nil,
+		},
+	}
+	if s.mirror != nil {
+		mirrorStatus := s.mirror.status
+		status.Mirror = &mirrorStatus
+	}
+	if lock := s.activeLock(); lock != nil {
+		lockStatus := lock.LockStatus
+		status.Lock = &lockStatus
+	}
+	if s.readOnlyErr != nil {
+		status.ReadOnlyErr = s.readOnlyErr.Error()
+	}
+	s.mu.RUnlock()
+	status.Sync = s.SyncStatus()
+
+	s.activity.mu.Lock()
+	if !s.activity.lastSave.IsZero() {
+		lastSave := s.activity.lastSave
+		status.LastSave = &lastSave
+	}
+	status.RecentErrors = len(s.activity.errors)
+	status.Panics = s.activity.panics
+	s.activity.mu.Unlock()
+
+	if s.isArchive {
+		status.Archive = &ArchiveStatus{Dir: s.archiver.Dir}
+		entries, err := s.archiver.List()
+		if err != nil {
+			status.Archive.Error = err.Error()
+		}
+		status.Archive.Count = len(entries)
+		for _, entry := range entries {
+			status.Archive.Size += entry.Size
+		}
+	}
+	if s.uploads != nil {
+		uploads := s.uploads.Status()
+		status.Uploads = &uploads
+	}
+	if s.quota > 0 {
+		status.Quota = &QuotaStatus{Limit: int64(s.quota), Used: status.Size}
+		if status.Archive != nil {
+			status.Quota.Used += status.Archive.Size
+		}
+	}
+
+	return status
+}
+
+// StatusHandler returns a handler serving the server's status as JSON, for
+// dashboards and scripts.
+func (s *Server) StatusHandler() http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set(headerContentType, "application/json")
+		w.Header().Set(headerCacheControl, "no-store")
+		err := json.NewEncoder(w).Encode(s.Status())
+		if err != nil {
+			log.Printf("failed to write status: %v", err)
+		}
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
@@ -0,0 +1,562 @@
+package putter
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/storage"
+)
+
+// syncPath is where NewHandler serves the sync protocol.
+const syncPath = "/sync/"
+
+const headerAuthorization = "Authorization"
+
+// extensionSync is the extension of the record of a divergence from the
+// peer, kept alongside the wiki.
+const extensionSync = ".sync"
+
+// Sync states, describing how the live wiki relates to the peer's.
+const (
+	SyncInSync   = "in sync"  // the live wikis are the same
+	SyncAhead    = "ahead"    // the peer's live wiki is an older version of this one
+	SyncDiverged = "diverged" // both have been saved since the last version they shared
+)
+
+// SyncVersion is a version of the wiki, identified by the SHA-256 digest of
+// its contents so that peers can tell which versions they share, whatever
+// their ETags or archive names.
+type SyncVersion struct {
+	Name    string    `json:"name,omitempty"` // archive name, empty for the live wiki
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	// Diverged marks a peer's live wiki pulled when the wikis diverged,
+	// which isn't an older version of the live wiki
+	Diverged bool `json:"diverged,omitempty"`
+}
+
+// SyncManifest lists the versions of the wiki that a peer has, served at
+// /sync/manifest.
+type SyncManifest struct {
+	Live     SyncVersion   `json:"live"`
+	Versions []SyncVersion `json:"versions"` // archived, newest first
+}
+
+// SyncStatus describes the last sync with the peer.
+type SyncStatus struct {
+	Peer     string    `json:"peer"`            // without any credentials
+	LastSync time.Time `json:"lastSync"`        // zero if it hasn't synced yet
+	State    string    `json:"state,omitempty"` // e.g. SyncInSync, as of the last sync
+	Pulled   int       `json:"pulled"`          // versions pulled from the peer since startup
+	Error    string    `json:"error,omitempty"` // why the last sync failed, if it did
+}
+
+// syncState is the state of synchronization with a peer.
+type syncState struct {
+	secret   string
+	peer     *url.URL // nil if this server only serves the protocol
+	interval time.Duration
+	client   *http.Client
+	running  sync.Mutex            // held while syncing, so that syncs don't overlap
+	mu       sync.Mutex            // protects the following
+	hashes   map[string]syncHashed // digests of archives and the live wiki, by name
+	diverged *syncDivergence       // nil unless the wikis have diverged
+	status   SyncStatus
+}
+
+// syncDivergence records the peer's live wikis pulled since the wikis
+// diverged, kept alongside the wiki (as .sync) until the divergence is
+// resolved by saving or restoring the live wiki.
+type syncDivergence struct {
+	Live     string   `json:"live"`     // digest of the live wiki when they diverged
+	Versions []string `json:"versions"` // digests of the peer's live wikis pulled since
+}
+
+// loadDivergence loads the record of a divergence, if there is one.
+func (s *Server) loadDivergence() {
+	data, err := ioutil.ReadFile(s.fileName + extensionSync)
+	if os.IsNotExist(err) {
+		return
+	}
+	var diverged syncDivergence
+	if err == nil {
+		err = json.Unmarshal(data, &diverged)
+	}
+	if err != nil {
+		log.Printf("failed to load sync divergence: %v", err)
+		return
+	}
+	s.sync.diverged = &diverged
+}
+
+// divergedVersions returns the digests of the versions pulled since the wikis
+// diverged, forgetting them if the live wiki, whose digest is live, has
+// changed since, resolving the divergence.
+func (s *Server) divergedVersions(live string) map[string]bool {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	if s.sync.diverged == nil {
+		return nil
+	}
+	if s.sync.diverged.Live != live {
+		s.sync.diverged = nil
+		err := os.Remove(s.fileName + extensionSync)
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove sync divergence: %v", err)
+		}
+		return nil
+	}
+	versions := make(map[string]bool)
+	for _, version := range s.sync.diverged.Versions {
+		versions[version] = true
+	}
+
+	return versions
+}
+
+// recordDivergence records that the peer's live wiki, whose digest is peer,
+// has diverged from the live wiki, whose digest is live.
+func (s *Server) recordDivergence(live, peer string) error {
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	if s.sync.diverged == nil || s.sync.diverged.Live != live {
+		s.sync.diverged = &syncDivergence{Live: live}
+	}
+	for _, version := range s.sync.diverged.Versions {
+		if version == peer {
+			return nil
+		}
+	}
+	s.sync.diverged.Versions = append(s.sync.diverged.Versions, peer)
+	data, err := json.Marshal(s.sync.diverged)
+	if err != nil {
+		return err
+	}
+
+	return storage.WriteFile(s.fileName+extensionSync, data, s.fileMode)
+}
+
+// syncHashed is a digest of a file, valid while its size and modification
+// time are unchanged.
+type syncHashed struct {
+	size    int64
+	modTime time.Time
+	sha256  string
+}
+
+// hash returns the SHA-256 digest of a file, hashing it with hashFile only if
+// it has changed since it was last hashed.
+func (st *syncState) hash(key string, size int64, modTime time.Time, hashFile func() (string, error)) (string, error) {
+	st.mu.Lock()
+	hashed, ok := st.hashes[key]
+	st.mu.Unlock()
+	if ok && hashed.size == size && hashed.modTime.Equal(modTime) {
+		return hashed.sha256, nil
+	}
+	digest, err := hashFile()
+	if err != nil {
+		return "", err
+	}
+	st.mu.Lock()
+	st.hashes[key] = syncHashed{size, modTime, digest}
+	st.mu.Unlock()
+
+	return digest, nil
+}
+
+// SyncManifest returns the versions of the wiki that this server has.
+func (s *Server) SyncManifest() (manifest SyncManifest, err error) {
+	if s.sync == nil {
+		return manifest, ErrNoSync
+	}
+	s.mu.RLock()
+	fileName := s.fileName
+	fileInfo := s.fileInfo
+	s.mu.RUnlock()
+	manifest.Live = SyncVersion{Size: fileInfo.Size(), ModTime: fileInfo.ModTime()}
+	manifest.Live.SHA256, err = s.sync.hash("", fileInfo.Size(), fileInfo.ModTime(), func() (string, error) {
+		return archive.HashFile(fileName)
+	})
+	if err != nil {
+		return
+	}
+
+	diverged := s.divergedVersions(manifest.Live.SHA256)
+
+	entries, err := s.archiver.List()
+	if err != nil {
+		return
+	}
+	// Forget the digests of pruned archives
+	listed := map[string]bool{"": true}
+	for _, entry := range entries {
+		listed[entry.Name] = true
+	}
+	s.sync.mu.Lock()
+	for name := range s.sync.hashes {
+		if !listed[name] {
+			delete(s.sync.hashes, name)
+		}
+	}
+	s.sync.mu.Unlock()
+	manifest.Versions = make([]SyncVersion, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name
+		digest, err := s.sync.hash(name, entry.Size, entry.ModTime, func() (string, error) {
+			return s.archiver.Hash(name)
+		})
+		if os.IsNotExist(err) {
+			// Pruned since it was listed
+			continue
+		}
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Versions = append(manifest.Versions, SyncVersion{
+			Name:     name,
+			SHA256:   digest,
+			Size:     entry.Size,
+			ModTime:  entry.ModTime,
+			Diverged: diverged[digest],
+		})
+	}
+
+	return
+}
+
+// SyncHandler returns a handler serving the sync protocol to peers that
+// present the secret given to WithSync as a bearer token:
+//
+//	GET /manifest        the SyncManifest, as JSON
+//	GET /live            the live wiki
+//	GET /version/{name}  the named archived version, decompressed
+func (s *Server) SyncHandler() http.Handler {
+	handlerFunc := func(w http.ResponseWriter, r *http.Request) {
+		if s.sync == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get(headerAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.sync.secret)) != 1 {
+			w.Header().Set(headerWwwAuthenticate, `Bearer realm="putter sync"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/manifest":
+			manifest, err := s.SyncManifest()
+			if err != nil {
+				server.Logf(r.Context(), "failed to list versions for sync: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set(headerContentType, "application/json")
+			w.Header().Set(headerCacheControl, "no-store")
+			err = json.NewEncoder(w).Encode(manifest)
+			if err != nil {
+				server.Logf(r.Context(), "failed to write sync manifest: %v", err)
+			}
+		case r.URL.Path == "/live":
+			s.mu.RLock()
+			f, err := os.Open(s.fileName)
+			s.mu.RUnlock()
+			if err != nil {
+				server.Logf(r.Context(), "failed to open wiki for sync: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer f.Close()
+			w.Header().Set(headerContentType, "application/octet-stream")
+			io.Copy(w, f)
+		case strings.HasPrefix(r.URL.Path, "/version/"):
+			in, err := s.archiver.Open(strings.TrimPrefix(r.URL.Path, "/version/"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			defer in.Close()
+			w.Header().Set(headerContentType, "application/octet-stream")
+			io.Copy(w, in)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	return http.HandlerFunc(handlerFunc)
+}
+
+// ErrNoSync is returned when syncing a server without WithSync.
+var ErrNoSync = errors.New("sync is disabled")
+
+// syncLoop syncs with the peer every interval until stop is closed.
+func (s *Server) syncLoop(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+	for {
+		s.sync.mu.Lock()
+		previous := s.sync.status
+		s.sync.mu.Unlock()
+		err := s.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// Only log changes, rather than every failure while the peer is down
+		status := s.SyncStatus()
+		switch {
+		case err != nil && previous.Error == "":
+			log.Printf("failed to sync with peer: %v", err)
+		case err == nil && previous.Error != "":
+			log.Printf("synced with peer again")
+		}
+		if err == nil && status.State == SyncDiverged && previous.State != SyncDiverged {
+			log.Printf("warning: wiki has diverged from the peer's, both have been saved since they were last in sync; its live wiki has been archived for comparison")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.sync.interval):
+		}
+	}
+}
+
+// Sync pulls the versions of the wiki that the peer given to WithSync has
+// and this server doesn't into the archive, and, if the peer's live wiki is a
+// newer version of this one, makes it the live wiki. If both have been saved
+// since they last shared a version, they have diverged: the peer's live wiki
+// is only archived, to be compared and restored by hand. Peers that sync with
+// each other replicate their histories in both directions. This happens
+// periodically anyway.
+func (s *Server) Sync(ctx context.Context) (err error) {
+	if s.sync == nil || s.sync.peer == nil {
+		return ErrNoSync
+	}
+	s.sync.running.Lock()
+	defer s.sync.running.Unlock()
+	pulled := 0
+	state := ""
+	defer func() {
+		s.sync.mu.Lock()
+		defer s.sync.mu.Unlock()
+		s.sync.status.LastSync = time.Now()
+		s.sync.status.Pulled += pulled
+		s.sync.status.Error = ""
+		if err != nil {
+			s.sync.status.Error = err.Error()
+			return
+		}
+		s.sync.status.State = state
+	}()
+
+	var peer SyncManifest
+	err = s.syncGet(ctx, "manifest", func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&peer)
+	})
+	if err != nil {
+		return
+	}
+	local, err := s.SyncManifest()
+	if err != nil {
+		return
+	}
+	// Versions pulled when the wikis diverged are had, but aren't older
+	// versions of either live wiki
+	have := map[string]bool{local.Live.SHA256: true}
+	older := make(map[string]bool)
+	for _, version := range local.Versions {
+		have[version.SHA256] = true
+		older[version.SHA256] = !version.Diverged
+	}
+	peerOlder := make(map[string]bool)
+	for _, version := range peer.Versions {
+		peerOlder[version.SHA256] = !version.Diverged
+	}
+
+	// Oldest first, so that pulled versions are archived in order
+	for i := len(peer.Versions) - 1; i >= 0; i-- {
+		version := peer.Versions[i]
+		if have[version.SHA256] {
+			continue
+		}
+		err = s.pullVersion(ctx, "version/"+url.PathEscape(version.Name), version)
+		if err != nil {
+			return fmt.Errorf("failed to pull %s: %w", version.Name, err)
+		}
+		have[version.SHA256] = true
+		older[version.SHA256] = !version.Diverged
+		pulled++
+	}
+
+	switch {
+	case peer.Live.SHA256 == local.Live.SHA256:
+		state = SyncInSync
+	case older[peer.Live.SHA256]:
+		state = SyncAhead
+	case peerOlder[local.Live.SHA256]:
+		err = s.pullLive(ctx, peer.Live, local.Live)
+		if err != nil {
+			return fmt.Errorf("failed to pull live wiki: %w", err)
+		}
+		pulled++
+		state = SyncInSync
+	default:
+		if !have[peer.Live.SHA256] {
+			err = s.pullVersion(ctx, "live", peer.Live)
+			if err != nil {
+				return fmt.Errorf("failed to pull live wiki: %w", err)
+			}
+			pulled++
+		}
+		err = s.recordDivergence(local.Live.SHA256, peer.Live.SHA256)
+		if err != nil {
+			return fmt.Errorf("failed to record divergence: %w", err)
+		}
+		state = SyncDiverged
+	}
+
+	return nil
+}
+
+// syncGet gets path from the peer's sync protocol, passing the body to read.
+func (s *Server) syncGet(ctx context.Context, path string, read func(io.Reader) error) error {
+	u := *s.sync.peer
+	u.Path = strings.TrimSuffix(u.Path, "/") + syncPath + path
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(headerAuthorization, "Bearer "+s.sync.secret)
+	res, err := s.sync.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded %s", res.Status)
+	}
+
+	return read(res.Body)
+}
+
+// download gets path from the peer into a temporary file next to the wiki,
+// checking that its digest is version's. The caller must remove the file.
+func (s *Server) download(ctx context.Context, path string, version SyncVersion) (name string, err error) {
+	f, err := ioutil.TempFile(filepath.Dir(s.fileName), s.uploadPrefix()+"*")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	hash := sha256.New()
+	err = s.syncGet(ctx, path, func(body io.Reader) error {
+		_, err := io.Copy(io.MultiWriter(f, hash), storage.ContextReader{Ctx: ctx, R: body})
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if digest := hex.EncodeToString(hash.Sum(nil)); digest != version.SHA256 {
+		return "", fmt.Errorf("received a version with digest %s, want %s", digest, version.SHA256)
+	}
+
+	return f.Name(), f.Close()
+}
+
+// pullVersion downloads a version from the peer into the archive, dated as
+// the peer dated it.
+func (s *Server) pullVersion(ctx context.Context, path string, version SyncVersion) error {
+	download, err := s.download(ctx, path, version)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(download)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name, err := s.archiver.Import(ctx, download, version.ModTime.In(s.archiveLocation))
+	if err != nil {
+		return err
+	}
+	server.Logf(ctx, "pulled version %s from peer", name)
+
+	return nil
+}
+
+// pullLive downloads the peer's live wiki and makes it the live wiki, unless
+// the live wiki has changed from local meanwhile.
+func (s *Server) pullLive(ctx context.Context, peer, local SyncVersion) error {
+	download, err := s.download(ctx, "live", peer)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(download)
+	etag, err := s.hashFile(download)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fileInfo.Size() != local.Size || !s.fileInfo.ModTime().Equal(local.ModTime) {
+		return errors.New("the live wiki was saved during the sync")
+	}
+	previousETag := s.etag
+	err = s.replaceWiki(ctx, download, etag)
+	if err != nil {
+		return err
+	}
+	server.Logf(ctx, "updated wiki from peer")
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		ETag:         s.etag,
+		PreviousETag: previousETag,
+		Size:         s.fileInfo.Size(),
+	})
+
+	return nil
+}
+
+// SyncStatus returns the status of the last sync with the peer, or nil if
+// the server doesn't sync with one.
+func (s *Server) SyncStatus() *SyncStatus {
+	if s.sync == nil || s.sync.peer == nil {
+		return nil
+	}
+	s.sync.mu.Lock()
+	defer s.sync.mu.Unlock()
+	status := s.sync.status
+
+	return &status
+}
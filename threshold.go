@@ -0,0 +1,102 @@
+package putter
+
+import (
+	"context"
+
+	"github.com/djcrock/putter/internal/diff"
+)
+
+type alwaysArchiveKey struct{}
+
+// withAlwaysArchive returns a copy of ctx in which the live wiki is archived
+// however little it has changed, such as before a restore, which must be
+// possible to undo.
+func withAlwaysArchive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, alwaysArchiveKey{}, true)
+}
+
+// archiveChange returns the percentage of the live wiki that has changed
+// since the newest archive, or 100 if there is none. The caller must hold
+// s.mu.
+func (s *Server) archiveChange() (percent float64, err error) {
+	entries, err := s.archiver.List()
+	if err != nil || len(entries) == 0 {
+		return 100, err
+	}
+	live := s.versions.find(s.etag, "")
+	if live == nil {
+		live, err = s.readFile(s.fileName)
+		if err == errTooLarge {
+			// Archive it rather than run out of memory measuring
+			return 100, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	f, err := s.openVersion(entries[0].Name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	archived, err := s.readAll(f)
+	if err == errTooLarge {
+		return 100, nil
+	}
+	if err != nil {
+		return
+	}
+
+	return changeRatio(archived, live), nil
+}
+
+// changeRatio returns the percentage of a wiki that differs between two
+// versions: the bytes of the tiddlers that were added, removed, or changed,
+// or of the whole file if neither version contains tiddlers.
+func changeRatio(old, new []byte) float64 {
+	total := len(old)
+	if len(new) > total {
+		total = len(new)
+	}
+	if total == 0 {
+		return 0
+	}
+	oldTiddlers := diff.Parse(old)
+	newTiddlers := diff.Parse(new)
+	if len(oldTiddlers) == 0 && len(newTiddlers) == 0 {
+		return 100 * float64(differing(string(old), string(new))) / float64(total)
+	}
+
+	changed := 0
+	for title, oldTiddler := range oldTiddlers {
+		changed += differing(oldTiddler.String(), newTiddlers[title].String())
+	}
+	for title, newTiddler := range newTiddlers {
+		if _, ok := oldTiddlers[title]; !ok {
+			changed += len(newTiddler.String())
+		}
+	}
+	if changed > total {
+		changed = total
+	}
+
+	return 100 * float64(changed) / float64(total)
+}
+
+// differing returns roughly how many bytes differ between two texts: those
+// between their common prefix and suffix, in the longer one.
+func differing(a, b string) int {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	prefix := 0
+	for prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	return len(a) - prefix - suffix
+}
@@ -0,0 +1,146 @@
+package putter
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/djcrock/putter/internal/archive"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// versionParam is the query parameter with which WithTimeTravel serves a
+// past version of the wiki at its own URL, e.g. "/?version=2024-05-07".
+const versionParam = "version"
+
+// versionLayouts are the layouts of the times versionParam may give, tried in
+// order. Times without a zone are in the archive's time zone.
+var versionLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// timeTravel returns the version of the wiki that r asks for, if
+// WithTimeTravel was given and it asks for one.
+func (s *Server) timeTravel(r *http.Request) (version string, ok bool) {
+	if !s.isTimeTravel {
+		return "", false
+	}
+	values, ok := r.URL.Query()[versionParam]
+	if !ok {
+		return "", false
+	}
+
+	return strings.TrimSpace(values[0]), true
+}
+
+// handleVersion serves the past version of the wiki named by version, a time
+// or an ETag, read-only.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request, version string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		// Without a Dav header, TiddlyWiki leaves the PUT saver disabled
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		s.writeError(w, r, http.StatusMethodNotAllowed, "This is a past version of the wiki, which can't be saved. Restore it from the admin dashboard to carry on from it.")
+		return
+	}
+	if !s.isArchive {
+		s.writeError(w, r, http.StatusNotFound, "The wiki isn't archived, so it has no past versions.")
+		return
+	}
+
+	f, etag, name, err := s.openVersionAt(version)
+	if err != nil {
+		server.Logf(r.Context(), "failed to read version %s: %v", version, err)
+		s.writeError(w, r, http.StatusInternalServerError, "")
+		return
+	}
+	if f == nil {
+		s.writeError(w, r, http.StatusNotFound, "There is no version with that ETag in the archive, and it isn't a time such as 2024-05-07 or 2024-05-07T13:45.")
+		return
+	}
+	defer f.Close()
+	s.serveVersion(w, r, f, etag, name)
+}
+
+// openVersionAt opens the version of the wiki named by version: the one that
+// was live at a time, or, if version isn't a time, the one with that ETag.
+// It returns the version's ETag if it is known, the name of its archive if it
+// isn't the live wiki, or nil if there is no such version.
+func (s *Server) openVersionAt(version string) (f io.ReadCloser, etag, name string, err error) {
+	at, ok := parseVersionTime(version, s.archiveLocation)
+	if !ok {
+		etag = version
+		if !strings.HasPrefix(etag, `"`) {
+			etag = `"` + etag + `"`
+		}
+		f, name, err = s.openETagOrArchive(etag)
+		return
+	}
+
+	name, err = s.archiveAt(at)
+	if err != nil {
+		return
+	}
+	if name != "" {
+		f, err = s.openVersion(name)
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	etag = s.etag
+	// Saves rename over the wiki, so what is opened stays this version
+	f, err = os.Open(s.fileName)
+
+	return
+}
+
+// parseVersionTime parses a time given to versionParam, in loc unless it
+// gives a zone. A date alone stands for the end of that day.
+func parseVersionTime(version string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range versionLayouts {
+		t, err := time.ParseInLocation(layout, version, loc)
+		if err != nil {
+			continue
+		}
+		if len(version) == len("2006-01-02") {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// archiveAt returns the name of the archive of the version of the wiki that
+// was live at t, the oldest archived after it, or an empty name if it was
+// the live wiki. Archived times are read from the archives' names, or their
+// modification times where the names don't say. For a time before the oldest
+// archive, the oldest is the closest there is.
+func (s *Server) archiveAt(t time.Time) (name string, err error) {
+	entries, err := s.archiver.List()
+	if err != nil {
+		return
+	}
+	var oldest time.Time
+	for _, entry := range entries {
+		archived, ok := archive.ParseName(s.archiver.Format, entry.Name, s.archiveLocation)
+		if !ok {
+			archived = entry.ModTime
+		}
+		if archived.After(t) && (name == "" || archived.Before(oldest)) {
+			name, oldest = entry.Name, archived
+		}
+	}
+
+	return
+}
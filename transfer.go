@@ -0,0 +1,124 @@
+package putter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// transferProgressInterval is how often the progress of an upload still being
+// received is logged.
+const transferProgressInterval = 10 * time.Second
+
+// transferTick is how often uploads are checked for stalls.
+const transferTick = time.Second
+
+// errStalled is returned when reading an upload that has stalled.
+var errStalled = errors.New("upload stalled")
+
+// transferSample is how much of an upload had been received at a time.
+type transferSample struct {
+	time     time.Time
+	received int64
+}
+
+// watchTransfer returns body wrapped to log the progress of the upload, of
+// size bytes (or -1 if unknown), every transferProgressInterval while it is
+// received. If WithStallTimeout was given, reads fail with errStalled once it
+// has been received slower than the minimum rate for the timeout; a read
+// blocked on a client that stopped sending must then be abandoned with
+// server.Abort. stop must be called once the upload has been read.
+func (s *Server) watchTransfer(ctx context.Context, body io.Reader, size int64) (watched io.Reader, stop func()) {
+	var received int64
+	counted := readerFunc(func(p []byte) (int, error) {
+		n, err := body.Read(p)
+		atomic.AddInt64(&received, int64(n))
+		return n, err
+	})
+	watched = counted
+	var pr *io.PipeReader
+	var stalled int32
+	if s.stallTimeout > 0 {
+		// Read in the background, so that a read blocked on the client doesn't
+		// stop the stall from being noticed. Once the upload is abandoned, the
+		// blocked read is left to fail when the connection is closed.
+		var pw *io.PipeWriter
+		pr, pw = io.Pipe()
+		watched = readerFunc(func(p []byte) (int, error) {
+			n, err := pr.Read(p)
+			if err != nil && atomic.LoadInt32(&stalled) != 0 {
+				err = errStalled
+			}
+			return n, err
+		})
+		go func() {
+			_, err := io.Copy(pw, counted)
+			pw.CloseWithError(err)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		started := time.Now()
+		ticker := time.NewTicker(transferTick)
+		defer ticker.Stop()
+		samples := []transferSample{{started, 0}}
+		lastProgress := started
+		for {
+			var now time.Time
+			select {
+			case <-done:
+				return
+			case now = <-ticker.C:
+			}
+			n := atomic.LoadInt64(&received)
+			elapsed := now.Sub(started)
+			if now.Sub(lastProgress) >= transferProgressInterval {
+				lastProgress = now
+				rate := ByteSize(float64(n) / elapsed.Seconds())
+				if size >= 0 {
+					server.Logf(ctx, "received %s of %s in %v (%s/s)", ByteSize(n), ByteSize(size), elapsed.Round(time.Second), rate)
+				} else {
+					server.Logf(ctx, "received %s in %v (%s/s)", ByteSize(n), elapsed.Round(time.Second), rate)
+				}
+			}
+			if s.stallTimeout <= 0 {
+				continue
+			}
+
+			// Compare with how much had been received a timeout ago
+			samples = append(samples, transferSample{now, n})
+			for len(samples) > 1 && now.Sub(samples[1].time) >= s.stallTimeout {
+				samples = samples[1:]
+			}
+			window := now.Sub(samples[0].time)
+			if window < s.stallTimeout {
+				continue
+			}
+			if float64(n-samples[0].received) <= float64(s.stallRate)*window.Seconds() {
+				server.Logf(ctx, "upload stalled, received %s in the last %v (%s so far), abandoning it", ByteSize(n-samples[0].received), window.Round(time.Second), ByteSize(n))
+				atomic.StoreInt32(&stalled, 1)
+				pr.Close()
+				return
+			}
+		}
+	}()
+
+	return watched, func() {
+		close(done)
+		if pr != nil {
+			pr.Close()
+		}
+	}
+}
+
+// readerFunc is an io.Reader calling a function.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,208 @@
+package putter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/djcrock/putter/internal/server"
+	"github.com/djcrock/putter/internal/trash"
+)
+
+// ErrNoTrash is returned for trash operations when the trash is disabled.
+var ErrNoTrash = errors.New("the trash is disabled")
+
+// TrashItem is a discarded file in the trash.
+type TrashItem = trash.Item
+
+// Trash lists the files in the trash, most recently discarded first.
+func (s *Server) Trash() ([]TrashItem, error) {
+	if s.trash == nil {
+		return nil, ErrNoTrash
+	}
+
+	return s.trash.List()
+}
+
+// RecoverTrash moves the named file out of the trash into the archive,
+// returning its name there: a pruned archive gets its name back, and a
+// discarded upload or held save becomes a version archived when it was
+// discarded, so that it can be compared and restored like any other.
+func (s *Server) RecoverTrash(ctx context.Context, name string) (archiveName string, err error) {
+	if s.trash == nil {
+		return "", ErrNoTrash
+	}
+	if !s.isArchive {
+		return "", ErrNoArchive
+	}
+	items, err := s.trash.List()
+	if err != nil {
+		return
+	}
+	var item *TrashItem
+	for i := range items {
+		if items[i].Name == name {
+			item = &items[i]
+		}
+	}
+	if item == nil {
+		return "", os.ErrNotExist
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item.Reason == trash.ReasonPruned {
+		var dst string
+		dst, err = s.archiver.Path(item.Original)
+		if err != nil {
+			return
+		}
+		err = s.trash.Recover(name, dst)
+		if err != nil {
+			return
+		}
+		server.Logf(ctx, "recovered %s from trash", item.Original)
+		return item.Original, nil
+	}
+
+	path, err := s.trash.Path(name)
+	if err != nil {
+		return
+	}
+	archived, err := s.archiver.Archive(ctx, path, item.Time.In(s.archiveLocation))
+	if err != nil {
+		return
+	}
+	if archived == "" {
+		return "", errors.New("the archive is over its size limit")
+	}
+	err = os.Remove(path)
+	if err != nil {
+		log.Printf("failed to remove %s from trash after recovering it: %v", name, err)
+	}
+	server.Logf(ctx, "recovered %s from trash as %s", name, filepath.Base(archived))
+
+	return filepath.Base(archived), nil
+}
+
+// discardUpload moves an upload that wasn't saved, e.g. because it
+// conflicted, into the trash if it is still there, so that the edits it
+// holds can be recovered.
+func (s *Server) discardUpload(ctx context.Context, upload string) {
+	if s.trash == nil {
+		return
+	}
+	if _, err := os.Stat(upload); err != nil {
+		return
+	}
+	name, err := s.trash.Discard(upload, filepath.Base(s.fileName), trash.ReasonRejected)
+	if err != nil {
+		server.Logf(ctx, "failed to move unsaved upload to trash: %v", err)
+		return
+	}
+	server.Logf(ctx, "moved unsaved upload to trash as %s", name)
+}
+
+// handleAdminTrash lists the files in the trash. A POST request recovers the
+// one named by the "name" form value into the archive.
+func (s *Server) handleAdminTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.trash == nil {
+		s.writeError(w, r, http.StatusNotFound, "The trash is disabled.")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		_, err := s.RecoverTrash(r.Context(), r.FormValue("name"))
+		switch {
+		case os.IsNotExist(err):
+			s.writeError(w, r, http.StatusNotFound, "There is no such file in the trash.")
+		case err == ErrNoArchive:
+			s.writeError(w, r, http.StatusForbidden, "Archiving is disabled, so there is nowhere to recover files to.")
+		case err != nil:
+			server.Logf(r.Context(), "failed to recover %s from trash: %v", r.FormValue("name"), err)
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		default:
+			redirectToAdmin(w)
+		}
+		return
+	}
+
+	items, err := s.Trash()
+	if err != nil {
+		server.Logf(r.Context(), "failed to list trash: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err = trashTemplate.Execute(w, struct {
+		Items     []TrashItem
+		IsArchive bool
+	}{items, s.isArchive})
+	if err != nil {
+		server.Logf(r.Context(), "failed to render trash: %v", err)
+	}
+}
+
+// handleAdminTrashJSON lists the files in the trash as JSON.
+func (s *Server) handleAdminTrashJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	items, err := s.Trash()
+	if err == ErrNoTrash {
+		s.writeError(w, r, http.StatusNotFound, "The trash is disabled.")
+		return
+	}
+	if err != nil {
+		server.Logf(r.Context(), "failed to list trash: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if items == nil {
+		items = []TrashItem{}
+	}
+	w.Header().Set(headerContentType, "application/json")
+	err = json.NewEncoder(w).Encode(items)
+	if err != nil {
+		server.Logf(r.Context(), "failed to write trash: %v", err)
+	}
+}
+
+var trashTemplate = template.Must(template.New("trash").Funcs(template.FuncMap{
+	"byteSize": func(size int64) ByteSize { return ByteSize(size) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin - trash</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+th, td { text-align: left; padding-right: 1em; }
+</style>
+</head>
+<body>
+<p><a href="./">&larr; admin</a></p>
+<h1>Trash</h1>
+{{if .Items}}
+<table>
+<tr><th>Discarded</th><th>File</th><th>Why</th><th>Size</th><th></th></tr>
+{{range .Items}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Original}}</td><td>{{.Reason}}</td><td>{{byteSize .Size}}</td>
+<td>{{if $.IsArchive}}<form method="post" action="trash"><input type="hidden" name="name" value="{{.Name}}"><button>Recover to archive</button></form>{{end}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>The trash is empty.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,391 @@
+package putter
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/djcrock/putter/internal/diff"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// DefaultUpgradeSource is where the latest TiddlyWiki release is fetched from
+// to upgrade the wiki, unless WithUpgradeSource says otherwise.
+const DefaultUpgradeSource = "https://tiddlywiki.com/empty.html"
+
+// upgradeMaxSize bounds the size of a release fetched to upgrade the wiki.
+const upgradeMaxSize = 64 << 20
+
+var (
+	// errUpgradeExpired is returned when confirming an upgrade that is no
+	// longer the one awaiting confirmation.
+	errUpgradeExpired = errors.New("upgrade has expired")
+	// errUpgradeStale is returned when confirming an upgrade of a version of
+	// the wiki that has since been replaced.
+	errUpgradeStale = errors.New("wiki has been saved since the upgrade was prepared")
+)
+
+// upgradeSkipped are tiddlers that TiddlyWiki's upgrader leaves behind,
+// since the release brings its own or they are transient.
+var upgradeSkipped = map[string]bool{
+	"$:/core":                       true,
+	"$:/boot/boot.css":              true,
+	"$:/boot/boot.js":               true,
+	"$:/boot/bootprefix.js":         true,
+	"$:/library/sjcl.js":            true,
+	"$:/isEncrypted":                true,
+	"$:/Import":                     true,
+	"$:/StoryList":                  true,
+	"$:/HistoryList":                true,
+	"$:/plugins/tiddlywiki/upgrade": true,
+}
+
+// upgradeSkippedPrefixes are prefixes of the titles of transient tiddlers,
+// which TiddlyWiki's upgrader leaves behind.
+var upgradeSkippedPrefixes = []string{"$:/temp/", "$:/state/"}
+
+// tiddlerStore matches the scripts in which TiddlyWiki 5.2 and later store
+// tiddlers. Those later in the file override those earlier.
+var tiddlerStore = regexp.MustCompile(`(?s)<script[^>]*class="tiddlywiki-tiddler-store"[^>]*>.*?</script>`)
+
+// pendingUpgrade is an upgrade of the wiki awaiting the admin's confirmation.
+type pendingUpgrade struct {
+	ID      string           // identifies the upgrade being confirmed
+	ETag    string           // ETag of the live wiki that it upgrades
+	From    string           // TiddlyWiki version of the live wiki
+	To      string           // TiddlyWiki version of the release
+	Plugins []upgradedPlugin // plugins replaced by the release's
+	Kept    int              // number of tiddlers carried over
+	Size    ByteSize         // size of the upgraded wiki
+	data    []byte
+}
+
+// upgradedPlugin is a plugin of the wiki replaced by the release's.
+type upgradedPlugin struct {
+	Title string
+	From  string
+	To    string
+}
+
+// IsUpgrade reports whether the release is newer than the live wiki.
+func (u *pendingUpgrade) IsUpgrade() bool {
+	return compareVersions(u.To, u.From) > 0
+}
+
+// compareVersions compares TiddlyWiki versions such as "5.3.0" and
+// "5.3.0-prerelease", returning -1, 0, or 1 if a is older than, the same as,
+// or newer than b.
+func compareVersions(a, b string) int {
+	aRelease, aPre := splitVersion(a)
+	bRelease, bPre := splitVersion(b)
+	for i := 0; i < len(aRelease) || i < len(bRelease); i++ {
+		var x, y int
+		if i < len(aRelease) {
+			x = aRelease[i]
+		}
+		if i < len(bRelease) {
+			y = bRelease[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case aPre == bPre:
+		return 0
+	case aPre == "":
+		// A release is newer than its prereleases
+		return 1
+	case bPre == "" || aPre < bPre:
+		return -1
+	}
+
+	return 1
+}
+
+// splitVersion splits a version into its numbered parts and prerelease
+// label, if any.
+func splitVersion(version string) (numbers []int, pre string) {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		version, pre = version[:i], version[i+1:]
+	}
+	for _, part := range strings.Split(version, ".") {
+		n, _ := strconv.Atoi(part)
+		numbers = append(numbers, n)
+	}
+
+	return
+}
+
+// upgradeWiki carries the tiddlers of live, a wiki, over to release, an
+// empty wiki of a later TiddlyWiki, as TiddlyWiki's own upgrader does:
+// everything but the core and transient state is carried over, except
+// plugins of which the release has the same or a later version. The
+// tiddlers are added to the release in a tiddler store of their own, which
+// TiddlyWiki merges into its single store when the wiki is next saved.
+func upgradeWiki(live, release []byte) (u *pendingUpgrade, err error) {
+	releaseTiddlers := diff.Parse(release)
+	u = &pendingUpgrade{To: releaseTiddlers["$:/core"]["version"]}
+	if u.To == "" {
+		return nil, errors.New("the release isn't a TiddlyWiki 5 wiki")
+	}
+	stores := tiddlerStore.FindAllIndex(release, -1)
+	if len(stores) == 0 {
+		return nil, errors.New("the release doesn't store its tiddlers as TiddlyWiki 5.2 and later do")
+	}
+	liveTiddlers := diff.Parse(live)
+	u.From = liveTiddlers["$:/core"]["version"]
+	if u.From == "" {
+		return nil, errors.New("the wiki's TiddlyWiki version can't be read; it may be encrypted, or not a TiddlyWiki 5 wiki")
+	}
+
+	titles := make([]string, 0, len(liveTiddlers))
+	for title := range liveTiddlers {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+	kept := make([]diff.Tiddler, 0, len(titles))
+	for _, title := range titles {
+		tiddler := liveTiddlers[title]
+		if upgradeSkipped[title] || hasAnyPrefix(title, upgradeSkippedPrefixes) {
+			continue
+		}
+		if replacement, ok := releaseTiddlers[title]; ok && tiddler["plugin-type"] != "" &&
+			compareVersions(replacement["version"], tiddler["version"]) >= 0 {
+			u.Plugins = append(u.Plugins, upgradedPlugin{title, tiddler["version"], replacement["version"]})
+			continue
+		}
+		kept = append(kept, tiddler)
+	}
+	u.Kept = len(kept)
+
+	// The encoding escapes "<", so that no tiddler can end the script early
+	store, err := json.Marshal(kept)
+	if err != nil {
+		return
+	}
+	end := stores[len(stores)-1][1]
+	var b strings.Builder
+	b.Write(release[:end])
+	b.WriteString("\n<script class=\"tiddlywiki-tiddler-store\" type=\"application/json\">")
+	b.Write(store)
+	b.WriteString("</script>")
+	b.Write(release[end:])
+	u.data = []byte(b.String())
+	u.Size = ByteSize(len(u.data))
+
+	return u, nil
+}
+
+// hasAnyPrefix reports whether s begins with any of prefixes.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fetchRelease fetches the latest TiddlyWiki release from the upgrade source.
+func (s *Server) fetchRelease(ctx context.Context) (release []byte, err error) {
+	ctx, cancel := context.WithTimeout(ctx, mirrorTimeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, s.upgradeSource, nil)
+	if err != nil {
+		return
+	}
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", s.upgradeSource, res.Status)
+	}
+	release, err = ioutil.ReadAll(io.LimitReader(res.Body, upgradeMaxSize+1))
+	if err == nil && len(release) > upgradeMaxSize {
+		err = fmt.Errorf("%s is larger than %v", s.upgradeSource, ByteSize(upgradeMaxSize))
+	}
+
+	return
+}
+
+// prepareUpgrade upgrades the live wiki to release, an empty wiki of the
+// latest TiddlyWiki, keeping the result to await confirmation.
+func (s *Server) prepareUpgrade(release []byte) (u *pendingUpgrade, err error) {
+	s.mu.RLock()
+	etag := s.etag
+	live, err := s.readFile(s.fileName)
+	s.mu.RUnlock()
+	if err != nil {
+		return
+	}
+	u, err = upgradeWiki(live, release)
+	if err != nil {
+		return
+	}
+	u.ETag = etag
+	hash := s.newHash()
+	hash.Write(u.data)
+	u.ID = hex.EncodeToString(hash.Sum(nil))
+
+	s.mu.Lock()
+	s.upgrade = u
+	s.mu.Unlock()
+
+	return
+}
+
+// applyUpgrade replaces the live wiki with the upgrade identified by id,
+// archiving the live wiki first so that the upgrade can be undone.
+func (s *Server) applyUpgrade(ctx context.Context, id string) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.upgrade
+	if u == nil || u.ID != id {
+		return errUpgradeExpired
+	}
+	if u.ETag != s.etag {
+		return errUpgradeStale
+	}
+
+	server.Logf(ctx, "upgrading wiki from TiddlyWiki %s to %s...", u.From, u.To)
+	f, err := ioutil.TempFile(filepath.Dir(s.fileName), s.uploadPrefix()+"*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = f.Write(u.data)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		return
+	}
+
+	previousETag := s.etag
+	err = s.replaceWiki(withAlwaysArchive(ctx), f.Name(), "\""+u.ID+"\"")
+	if err != nil {
+		return
+	}
+	s.upgrade = nil
+	server.Logf(ctx, "wiki upgraded to TiddlyWiki %s", u.To)
+	s.emit(Event{
+		Type:         EventSaveCompleted,
+		ETag:         s.etag,
+		PreviousETag: previousETag,
+		Size:         s.fileInfo.Size(),
+	})
+
+	return
+}
+
+// handleAdminUpgrade upgrades the wiki to the latest TiddlyWiki release. A
+// GET request fetches the release and shows how the core and plugins would
+// change, asking for confirmation; a POST request swaps in the upgraded wiki
+// that was shown.
+func (s *Server) handleAdminUpgrade(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case !s.isArchive:
+		s.writeError(w, r, http.StatusForbidden, "Archiving is disabled, so the wiki can't be upgraded safely.")
+		return
+	case s.mirror != nil:
+		s.writeError(w, r, http.StatusForbidden, "This wiki is a read-only mirror, so it can't be upgraded.")
+		return
+	case s.folder != nil:
+		s.writeError(w, r, http.StatusForbidden, "This wiki is rendered from a wiki folder, so upgrade the TiddlyWiki that renders it instead.")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		err := s.applyUpgrade(r.Context(), r.FormValue("id"))
+		switch {
+		case err == errUpgradeExpired:
+			s.writeError(w, r, http.StatusConflict, "The upgrade has expired, so review it again.")
+		case err == errUpgradeStale:
+			s.writeError(w, r, http.StatusPreconditionFailed, "The live wiki has been saved since the upgrade was reviewed.")
+		case err != nil:
+			server.Logf(r.Context(), "failed to upgrade wiki: %v", err)
+			s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		default:
+			redirectToAdmin(w)
+		}
+		return
+	}
+
+	release, err := s.fetchRelease(r.Context())
+	if err != nil {
+		server.Logf(r.Context(), "failed to fetch the latest TiddlyWiki release: %v", err)
+		s.writeError(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	u, err := s.prepareUpgrade(release)
+	if err != nil {
+		server.Logf(r.Context(), "failed to prepare upgrade: %v", err)
+		s.writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set(headerContentType, "text/html; charset=utf-8")
+	err = upgradeTemplate.Execute(w, u)
+	if err != nil {
+		server.Logf(r.Context(), "failed to render upgrade confirmation: %v", err)
+	}
+}
+
+var upgradeTemplate = template.Must(template.New("upgrade").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter admin - upgrade</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; padding: 0 1em; }
+th { text-align: left; padding-right: 1em; }
+</style>
+</head>
+<body>
+<p><a href="./">&larr; admin</a></p>
+{{if .IsUpgrade}}
+<h1>Upgrade TiddlyWiki from {{.From}} to {{.To}}?</h1>
+<table>
+<tr><th></th><th>Live wiki</th><th>Upgraded wiki</th></tr>
+<tr><th>Core</th><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{range .Plugins}}<tr><th>{{.Title}}</th><td>{{.From}}</td><td>{{.To}}</td></tr>
+{{end}}</table>
+<p>{{.Kept}} tiddlers will be carried over, making the upgraded wiki {{.Size}}.</p>
+<p>The live wiki will be archived first, so the upgrade can be undone by restoring it.</p>
+<form method="post" action="upgrade">
+<input type="hidden" name="id" value="{{.ID}}">
+<button>Upgrade</button>
+<a href="./">Cancel</a>
+</form>
+{{else}}
+<h1>TiddlyWiki is up to date</h1>
+<p>The wiki is on TiddlyWiki {{.From}}, and the latest release is {{.To}}.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,109 @@
+package putter
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+
+	"github.com/djcrock/putter/internal/server"
+)
+
+// uploadPath is where NewHandler serves the upload form.
+const uploadPath = "/upload"
+
+// uploadField is the name of the upload form's file input.
+const uploadField = "wiki"
+
+// UploadHandler returns a handler serving a form for replacing the wiki with
+// a file uploaded from the browser, protected by the credentials given to
+// WithAdmin. Uploads go through the same pipeline as PUT requests, except
+// that they unconditionally replace the live wiki.
+func (s *Server) UploadHandler() http.Handler {
+	return s.requireAdmin(http.HandlerFunc(s.handleUpload))
+}
+
+// handleUpload serves the upload form and receives its submissions.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.mu.RLock()
+		etag := s.etag
+		s.mu.RUnlock()
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+		err := uploadTemplate.Execute(w, uploadData{ETag: etag, WikiLink: s.wikiLink()})
+		if err != nil {
+			log.Printf("failed to render upload form: %v", err)
+		}
+	case http.MethodPost:
+		s.handleUploadPost(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUploadPost saves the file submitted with the upload form.
+func (s *Server) handleUploadPost(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected a multipart/form-data upload", http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			http.Error(w, "no wiki file was uploaded", http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != uploadField || part.FileName() == "" {
+			part.Close()
+			continue
+		}
+
+		server.Logf(r.Context(), "receiving upload of %s from %s", part.FileName(), s.clientAddr(r))
+		// The admin has confirmed the upload, however small it is
+		r.Header.Set(headerConfirmShrink, "true")
+		etag, ok := s.save(w, r, part)
+		part.Close()
+		if !ok {
+			return
+		}
+		w.Header().Set(headerEtag, etag)
+		w.Header().Set(headerContentType, "text/html; charset=utf-8")
+		err = uploadTemplate.Execute(w, uploadData{ETag: etag, FileName: part.FileName(), WikiLink: s.wikiLink()})
+		if err != nil {
+			log.Printf("failed to render upload form: %v", err)
+		}
+		return
+	}
+}
+
+// uploadData is passed to uploadTemplate.
+type uploadData struct {
+	ETag     string // ETag of the live wiki
+	FileName string // name of the file just uploaded, if any
+	WikiLink string // relative URL of the wiki
+}
+
+var uploadTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>putter - upload wiki</title>
+<style>
+body { font-family: sans-serif; max-width: 40em; margin: 1em auto; padding: 0 1em; }
+.saved { color: #070; }
+</style>
+</head>
+<body>
+<h1>Upload wiki</h1>
+{{if .FileName}}<p class="saved">Saved {{.FileName}}. <a href="{{.WikiLink}}">Open the wiki</a></p>{{end}}
+<p>Replace the live wiki with a copy from this device. The current version will be archived first.</p>
+<form method="post" enctype="multipart/form-data" onsubmit="return confirm('Replace the live wiki with this file?')">
+<p><input type="file" name="wiki" accept=".html,.htm,text/html" required></p>
+<p><button>Upload</button></p>
+</form>
+<p><small>Current ETag: <code>{{.ETag}}</code></small></p>
+</body>
+</html>
+`))
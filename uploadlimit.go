@@ -0,0 +1,88 @@
+package putter
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// uploadRetryAfter is how many seconds clients refused for too many uploads
+// are told to wait before trying again.
+const uploadRetryAfter = 5
+
+// errTooManyUploads is returned when no upload slot comes free in time.
+var errTooManyUploads = errors.New("too many uploads in progress")
+
+// UploadLimiter limits how many uploads are received at once, so that
+// several devices autosaving together can't exhaust the memory, temporary
+// space, or disk bandwidth of a small server. Uploads beyond the limit wait
+// their turn for a while, and are then refused with 429 Too Many Requests.
+// One limiter can be shared by several servers (see WithUploadLimiter).
+type UploadLimiter struct {
+	slots   chan struct{} // holds a value for each upload being received
+	wait    time.Duration // how long an upload waits for a slot
+	mu      sync.Mutex    // protects the following
+	waiting int           // uploads waiting for a slot
+}
+
+// UploadStatus describes the uploads being received and waiting their turn.
+type UploadStatus struct {
+	Limit   int `json:"limit"`
+	Active  int `json:"active"`
+	Waiting int `json:"waiting"`
+}
+
+// NewUploadLimiter returns a limiter receiving at most max uploads at once,
+// which must be at least 1, with the rest waiting up to wait for their turn
+// (or refused at once if wait is zero).
+func NewUploadLimiter(max int, wait time.Duration) *UploadLimiter {
+	return &UploadLimiter{slots: make(chan struct{}, max), wait: wait}
+}
+
+// acquire waits for a slot in which to receive an upload, returning a
+// function releasing it, which may be called more than once. It fails with
+// errTooManyUploads if none comes free in time, or with the error of ctx if
+// it is done first. A nil limiter always has a slot.
+func (l *UploadLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	var once sync.Once
+	release = func() { once.Do(func() { <-l.slots }) }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.wait <= 0 {
+		return nil, errTooManyUploads
+	}
+
+	l.mu.Lock()
+	l.waiting++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.waiting--
+		l.mu.Unlock()
+	}()
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, errTooManyUploads
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Status returns the uploads being received and waiting.
+func (l *UploadLimiter) Status() UploadStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return UploadStatus{Limit: cap(l.slots), Active: len(l.slots), Waiting: l.waiting}
+}
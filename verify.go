@@ -0,0 +1,61 @@
+package putter
+
+import (
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/djcrock/putter/internal/compress"
+)
+
+// verifyWiki re-reads the live wiki after a save and checks that it still
+// hashes to etag, the ETag of the upload, and with WithVerification(true)
+// that its compressed copy decompresses to the same. The caller must hold the
+// write lock.
+func (s *Server) verifyWiki(etag string) (err error) {
+	if !s.isVerify {
+		return
+	}
+	written, err := s.hashFile(s.fileName)
+	if err != nil {
+		return
+	}
+	if written != etag {
+		return fmt.Errorf("the saved wiki has ETag %s, but the upload had %s", written, etag)
+	}
+	if !s.isVerifyCompressed || !s.isCompress || !s.isCompressCache {
+		return
+	}
+	compressed, err := s.hashCompressed(s.fileName + compress.Extension)
+	if err != nil {
+		return fmt.Errorf("failed to decompress the compressed wiki: %w", err)
+	}
+	if compressed != etag {
+		return fmt.Errorf("the compressed wiki decompresses to ETag %s, but the upload had %s", compressed, etag)
+	}
+
+	return
+}
+
+// hashCompressed computes the ETag of the contents of the named gzip file.
+func (s *Server) hashCompressed(name string) (etag string, err error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return
+	}
+
+	hash := s.newHash()
+	_, err = io.Copy(hash, gz)
+	if err != nil {
+		return
+	}
+
+	return "\"" + hex.EncodeToString(hash.Sum(nil)) + "\"", nil
+}
@@ -0,0 +1,152 @@
+package putter
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/djcrock/putter/internal/diff"
+)
+
+// maxConflictTitles is the number of changed tiddlers named on a conflict's
+// error page.
+const maxConflictTitles = 10
+
+// versionCache keeps the most recent versions of the wiki in memory, up to a
+// budget of bytes, so that undoing a save, comparing with recent versions, and
+// explaining conflicts don't have to read the archive.
+type versionCache struct {
+	mu       sync.Mutex      // protects the following
+	budget   int64           // bytes that may be kept, or 0 to keep none
+	size     int64           // bytes kept
+	versions []cachedVersion // oldest first
+}
+
+// cachedVersion is a version of the wiki kept in memory.
+type cachedVersion struct {
+	etag string
+	name string // name of its archive, once it has been archived
+	data []byte
+}
+
+// add keeps a version, forgetting the oldest versions to stay within budget.
+func (c *versionCache) add(etag string, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if int64(len(data)) > c.budget {
+		return
+	}
+	name := ""
+	for i, v := range c.versions {
+		if v.etag == etag {
+			// e.g. a restored version, which is still archived
+			name = v.name
+			c.versions = append(c.versions[:i], c.versions[i+1:]...)
+			c.size -= int64(len(v.data))
+			break
+		}
+	}
+	for c.size+int64(len(data)) > c.budget {
+		c.size -= int64(len(c.versions[0].data))
+		c.versions[0] = cachedVersion{}
+		c.versions = c.versions[1:]
+	}
+	c.versions = append(c.versions, cachedVersion{etag: etag, name: name, data: data})
+	c.size += int64(len(data))
+}
+
+// find returns the newest version kept with the given ETag, or archive name
+// if etag is empty, or nil if there is none.
+func (c *versionCache) find(etag, name string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.versions) - 1; i >= 0; i-- {
+		v := c.versions[i]
+		if etag != "" && v.etag == etag || etag == "" && name != "" && v.name == name {
+			return v.data
+		}
+	}
+
+	return nil
+}
+
+// rename records that the version with the given ETag was archived as name,
+// or, if etag is empty, that the archive name no longer exists.
+func (c *versionCache) rename(etag, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.versions) - 1; i >= 0; i-- {
+		v := &c.versions[i]
+		switch {
+		case etag != "" && v.etag == etag:
+			v.name = name
+			return
+		case etag == "" && v.name == name:
+			v.name = ""
+		}
+	}
+}
+
+// record follows archiving and pruning, fed by the server's events.
+func (c *versionCache) record(e Event) {
+	switch e.Type {
+	case EventArchived:
+		// Archived events give the archive's path
+		c.rename(e.ETag, filepath.Base(e.Archive))
+	case EventArchivePruned:
+		c.rename("", e.Archive)
+	}
+}
+
+// cacheLive keeps the live wiki in memory, if it fits. The caller must hold
+// s.mu.
+func (s *Server) cacheLive() {
+	if s.versions.budget <= 0 || s.fileInfo.Size() > s.versions.budget {
+		return
+	}
+	data, err := ioutil.ReadFile(s.fileName)
+	if err != nil {
+		log.Printf("failed to keep wiki in memory: %v", err)
+		return
+	}
+	s.versions.add(s.etag, data)
+}
+
+// openVersion opens the named archive, from memory if it is kept there.
+func (s *Server) openVersion(name string) (io.ReadCloser, error) {
+	if data := s.versions.find("", name); data != nil {
+		return memoryFile{bytes.NewReader(data)}, nil
+	}
+
+	return s.archiver.Open(name)
+}
+
+// conflictDetail names the tiddlers changed between the version of the wiki
+// whose ETag is clientETag, which a conflicting save was based on, and the
+// live wiki, if both are kept in memory. The caller must hold s.mu.
+func (s *Server) conflictDetail(clientETag string) string {
+	old := s.versions.find(clientETag, "")
+	live := s.versions.find(s.etag, "")
+	if old == nil || live == nil {
+		return ""
+	}
+	var titles []string
+	for _, change := range diff.Wikis(old, live) {
+		if change.Title != "" {
+			titles = append(titles, change.Title)
+		}
+	}
+	switch {
+	case len(titles) == 0:
+		return ""
+	case len(titles) > maxConflictTitles:
+		return fmt.Sprintf("Changed since your copy: %s, and %d more.", strings.Join(titles[:maxConflictTitles], ", "), len(titles)-maxConflictTitles)
+	default:
+		return fmt.Sprintf("Changed since your copy: %s.", strings.Join(titles, ", "))
+	}
+}
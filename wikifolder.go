@@ -0,0 +1,130 @@
+package putter
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/djcrock/putter/internal/folder"
+	"github.com/djcrock/putter/internal/server"
+)
+
+// DefaultFolderCommand is the command with which wiki folders are rendered by
+// default.
+const DefaultFolderCommand = "tiddlywiki"
+
+// folderCheckInterval bounds how often the wiki folder is checked for changes
+// made outside of putter, e.g. by editing its tiddler files.
+const folderCheckInterval = time.Second
+
+// wikiFolder is the state of the wiki folder rendered to the live wiki.
+type wikiFolder struct {
+	dir      string
+	command  string
+	rendered time.Time  // the folder's modification time when last rendered or written; protected by the server's mu
+	mu       sync.Mutex // protects the following
+	checked  time.Time  // when the folder was last checked for changes
+}
+
+// initFolder renders the wiki folder to the wiki if it has changed since it
+// was last rendered, or never has been.
+func (s *Server) initFolder() (err error) {
+	modified, err := folder.Modified(s.folder.dir)
+	if err != nil {
+		return
+	}
+	s.folder.rendered = modified
+	fileInfo, err := os.Stat(s.fileName)
+	if err == nil && !modified.After(fileInfo.ModTime()) {
+		return
+	}
+	log.Printf("rendering wiki folder \"%s\"...", s.folder.dir)
+
+	return s.renderFolder(context.Background())
+}
+
+// renderFolder renders the wiki folder to the wiki. The caller must hold the
+// write lock, once the server is running.
+func (s *Server) renderFolder(ctx context.Context) error {
+	err := folder.Render(ctx, s.folder.command, s.folder.dir, s.fileName)
+	if err != nil {
+		return err
+	}
+
+	return os.Chmod(s.fileName, s.fileMode)
+}
+
+// refreshFolder renders the wiki folder to the wiki if its files have changed
+// since it was last rendered or written, at most every folderCheckInterval.
+// The new wiki is then picked up like any other external change.
+func (s *Server) refreshFolder() {
+	if s.folder == nil {
+		return
+	}
+	s.folder.mu.Lock()
+	due := time.Since(s.folder.checked) >= folderCheckInterval
+	if due {
+		s.folder.checked = time.Now()
+	}
+	s.folder.mu.Unlock()
+	if !due {
+		return
+	}
+	modified, err := folder.Modified(s.folder.dir)
+	if err != nil {
+		log.Printf("failed to check wiki folder for changes: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !modified.After(s.folder.rendered) {
+		return
+	}
+	log.Println("wiki folder modified, rendering...")
+	err = s.renderFolder(context.Background())
+	if err != nil {
+		log.Printf("failed to render wiki folder: %v", err)
+		return
+	}
+	s.folder.rendered = modified
+}
+
+// writeFolder splits the wiki just saved into the wiki folder's tiddler
+// files, writing those that changed since previous, a copy of the wiki it
+// replaced. Failures are logged and put the wiki into read-only mode, since
+// the folder no longer matches it. The caller must hold the write lock.
+func (s *Server) writeFolder(ctx context.Context, previous string) {
+	old, err := s.readFile(previous)
+	if err != nil {
+		server.Logf(ctx, "failed to read previous wiki to write wiki folder: %v", err)
+		s.setReadOnly(err)
+		return
+	}
+	new, err := s.readFile(s.fileName)
+	if err != nil {
+		server.Logf(ctx, "failed to read wiki to write wiki folder: %v", err)
+		s.setReadOnly(err)
+		return
+	}
+	changed, skipped, err := folder.Split(s.folder.dir, old, new, s.fileMode, s.dirMode)
+	for _, title := range skipped {
+		server.Logf(ctx, "not writing tiddler \"%s\" to wiki folder, as it shares its file or is binary", title)
+	}
+	if err != nil {
+		server.Logf(ctx, "failed to write wiki folder: %v", err)
+		s.setReadOnly(err)
+		return
+	}
+	if changed > 0 {
+		server.Logf(ctx, "wrote %d tiddlers to wiki folder", changed)
+	}
+
+	// The folder now matches the wiki, so needn't be rendered again
+	modified, err := folder.Modified(s.folder.dir)
+	if err == nil {
+		s.folder.rendered = modified
+	}
+}
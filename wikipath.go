@@ -0,0 +1,101 @@
+package putter
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// wikiHandler serves the wiki at its path, as ServeHTTP serves it at "/".
+func (s *Server) wikiHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = "/"
+		r2.URL.RawPath = ""
+		s.ServeHTTP(w, r2)
+	})
+}
+
+// handleRoot handles requests for "/" and paths served by nothing else, when
+// the wiki is served elsewhere, redirecting "/" to the wiki if WithWikiPath
+// asked to.
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" || !s.isWikiRedirect {
+		s.writeError(w, r, http.StatusNotFound, "")
+		return
+	}
+	// Relative, so that it holds under any base path
+	w.Header().Set(headerLocation, s.wikiLink())
+	w.WriteHeader(http.StatusFound)
+}
+
+// aliasHandler redirects browsers from an alias of the wiki, such as a
+// bookmarked "/index.html", to the wiki itself, and serves other requests,
+// such as saves to the alias, as the wiki.
+func (s *Server) aliasHandler(alias string) http.Handler {
+	wiki := s.wikiHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			wiki.ServeHTTP(w, r)
+			return
+		}
+		location := s.linkFrom(alias)
+		if r.URL.RawQuery != "" {
+			location += "?" + r.URL.RawQuery
+		}
+		// Relative, so that it holds under any base path
+		w.Header().Set(headerLocation, location)
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+}
+
+// wikiLink returns the URL of the wiki relative to "/".
+func (s *Server) wikiLink() string {
+	return s.linkFrom("/")
+}
+
+// linkFrom returns the URL of the wiki relative to the path from.
+func (s *Server) linkFrom(from string) string {
+	link := strings.Repeat("../", strings.Count(from, "/")-1) + strings.TrimPrefix(s.wikiPath, "/")
+	if link == "" {
+		return "./"
+	}
+
+	return link
+}
+
+// rootLink returns the URL of "/" relative to the wiki.
+func (s *Server) rootLink() string {
+	return strings.Repeat("../", strings.Count(s.wikiPath, "/")-1)
+}
+
+// checkWikiPaths cleans the wiki's path and aliases, returning an error if
+// any of them is served by something else.
+func (s *Server) checkWikiPaths() error {
+	s.wikiPath = path.Clean("/" + s.wikiPath)
+	reserved := map[string]bool{
+		statusPath: true, statsPath: true, uploadPath: true, manifestPath: true, manifestIconPath: true,
+	}
+	if reserved[s.wikiPath] {
+		return fmt.Errorf("the wiki can't be served at %s, where putter serves something else", s.wikiPath)
+	}
+	reserved[s.wikiPath] = true
+	for i, alias := range s.wikiAliases {
+		alias = path.Clean("/" + alias)
+		if alias == "/" {
+			return fmt.Errorf("/ can't be an alias of the wiki served at %s, but can redirect to it", s.wikiPath)
+		}
+		if reserved[alias] {
+			return fmt.Errorf("the wiki can't have the alias %s, where putter serves something else", alias)
+		}
+		reserved[alias] = true
+		s.wikiAliases[i] = alias
+	}
+
+	return nil
+}